@@ -0,0 +1,62 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// RunResolver resolves a run alias, i.e., a product spec such as
+// "chrome[stable]", to the ID of the run that it refers to (typically the
+// latest run matching the spec). It allows run aliases to be resolved without
+// this package depending on a particular store of runs.
+type RunResolver func(shared.ProductSpec) (int64, error)
+
+// NewStoreRunResolver produces a RunResolver that resolves each alias to the
+// latest run in store matching it.
+func NewStoreRunResolver(store shared.Datastore) RunResolver {
+	return func(spec shared.ProductSpec) (int64, error) {
+		one := 1
+		runs, err := store.TestRunQuery().LoadTestRuns(shared.ProductSpecs{spec}, nil, shared.SHAs{spec.Revision}, nil, nil, &one, nil)
+		if err != nil {
+			return 0, err
+		}
+		run := runs.First()
+		if run == nil {
+			return 0, errors.New("No matching run")
+		}
+		return run.ID, nil
+	}
+}
+
+// ResolveRuns produces a copy of the RunQuery in which each of its RunAliases
+// has been resolved to a run ID by resolve, and appended to its RunIDs (unless
+// already present). Resolving any alias fails if resolve fails for that alias.
+func (rq RunQuery) ResolveRuns(resolve RunResolver) (RunQuery, error) {
+	if len(rq.RunAliases) == 0 {
+		return rq, nil
+	}
+
+	runIDs := make([]int64, len(rq.RunIDs), len(rq.RunIDs)+len(rq.RunAliases))
+	copy(runIDs, rq.RunIDs)
+	seen := make(map[int64]bool)
+	for _, id := range runIDs {
+		seen[id] = true
+	}
+	for _, alias := range rq.RunAliases {
+		id, err := resolve(alias)
+		if err != nil {
+			return RunQuery{}, fmt.Errorf(`Failed to resolve run "%s": %v`, alias.String(), err)
+		}
+		if !seen[id] {
+			seen[id] = true
+			runIDs = append(runIDs, id)
+		}
+	}
+	return RunQuery{RunIDs: runIDs, Columns: rq.Columns, AbstractQuery: rq.AbstractQuery}, nil
+}
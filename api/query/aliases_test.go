@@ -0,0 +1,94 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func stubRunResolver(ids map[string]int64) RunResolver {
+	return func(spec shared.ProductSpec) (int64, error) {
+		id, ok := ids[spec.String()]
+		if !ok {
+			return 0, errors.New("No such run")
+		}
+		return id, nil
+	}
+}
+
+func TestRunQuery_runAliases(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"runs": ["chrome[stable]", "firefox[experimental]"],
+		"query": {"status": "PASS"}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunAliases: []shared.ProductSpec{
+			shared.ParseProductSpecUnsafe("chrome[stable]"),
+			shared.ParseProductSpecUnsafe("firefox[experimental]"),
+		},
+		AbstractQuery: TestStatusEq{Status: shared.TestStatusPass},
+	}, rq)
+
+	b, err := json.Marshal(rq)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"runs":["chrome[stable]","firefox[experimental]"],"query":{"status":"PASS"}}`, string(b))
+
+	// Numeric IDs continue to work, alongside aliases.
+	err = json.Unmarshal([]byte(`{"run_ids": [1], "runs": ["safari"]}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1}, rq.RunIDs)
+	assert.Equal(t, []shared.ProductSpec{shared.ParseProductSpecUnsafe("safari")}, rq.RunAliases)
+
+	err = json.Unmarshal([]byte(`{"runs": ["not-a-browser"]}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"runs": []}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestRunQuery_ResolveRuns(t *testing.T) {
+	resolve := stubRunResolver(map[string]int64{
+		"chrome[stable]":        1,
+		"firefox[experimental]": 2,
+	})
+	rq := RunQuery{
+		RunIDs: []int64{2, 3},
+		RunAliases: []shared.ProductSpec{
+			shared.ParseProductSpecUnsafe("chrome[stable]"),
+			shared.ParseProductSpecUnsafe("firefox[experimental]"),
+		},
+		AbstractQuery: TestNamePattern{Pattern: "/dom/"},
+	}
+	resolved, err := rq.ResolveRuns(resolve)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{2, 3, 1},
+		AbstractQuery: TestNamePattern{Pattern: "/dom/"},
+	}, resolved)
+	assert.Equal(t, []int64{2, 3}, rq.RunIDs)
+
+	// Queries without aliases are unchanged.
+	plain := RunQuery{RunIDs: []int64{4}, AbstractQuery: True{}}
+	resolved, err = plain.ResolveRuns(resolve)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, resolved)
+}
+
+func TestRunQuery_ResolveRunsUnknownAlias(t *testing.T) {
+	rq := RunQuery{
+		RunAliases:    []shared.ProductSpec{shared.ParseProductSpecUnsafe("safari[beta]")},
+		AbstractQuery: True{},
+	}
+	_, err := rq.ResolveRuns(stubRunResolver(nil))
+	assert.EqualError(t, err, `Failed to resolve run "safari[beta]": No such run`)
+}
@@ -5,10 +5,15 @@
 package query
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/web-platform-tests/wpt.fyi/shared"
 )
@@ -23,9 +28,14 @@ type AbstractQuery interface {
 
 // RunQuery is the internal representation of a query recieved from an HTTP
 // client, including the IDs of the test runs to query, and the structured query
-// to run.
+// to run. Runs may also be referenced by RunAliases, product specs (e.g.
+// "chrome[stable]") that must be resolved to run IDs, with ResolveRuns, before
+// the query is bound. Columns, when non-empty, are the (canonical) names of the
+// browsers whose results are to be returned; see SelectColumns.
 type RunQuery struct {
-	RunIDs []int64
+	RunIDs     []int64
+	RunAliases []shared.ProductSpec
+	Columns    []string
 	AbstractQuery
 }
 
@@ -46,8 +56,14 @@ func (f False) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 }
 
 // TestNamePattern is a query atom that matches test names to a pattern string.
+// When Patterns is non-empty, it is used in place of Pattern: test names must
+// contain all of the Patterns when MatchAll is set, or else any of them. When
+// IgnoreCase is set, patterns are matched using Unicode case folding.
 type TestNamePattern struct {
-	Pattern string
+	Pattern    string
+	Patterns   []string
+	MatchAll   bool
+	IgnoreCase bool
 }
 
 // BindToRuns for TestNamePattern is a no-op; it is independent of test runs.
@@ -66,6 +82,114 @@ func (tp TestPath) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 	return tp
 }
 
+// TestNameIn is a query atom that matches tests whose name is exactly one of
+// the given names.
+type TestNameIn struct {
+	Names []string
+}
+
+// BindToRuns for TestNameIn is a no-op; it is independent of test runs.
+func (tni TestNameIn) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return tni
+}
+
+// ManifestPaths is a query atom that matches tests whose name is exactly one of
+// the given paths (e.g., the tests touched by a changeset) or, when Prefix is
+// set, begins with any of them.
+type ManifestPaths struct {
+	Paths  []string
+	Prefix bool
+}
+
+// BindToRuns for ManifestPaths is a no-op; it is independent of test runs.
+func (mp ManifestPaths) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return mp
+}
+
+// Shard is a query atom that matches the tests in one of Total shards, such as
+// those that a CI system runs in one of its shards. Each test is assigned to a
+// shard deterministically by TestShard; subtests belong to their test's shard.
+type Shard struct {
+	Index int
+	Total int
+}
+
+// BindToRuns for Shard is a no-op; it is independent of test runs.
+func (s Shard) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return s
+}
+
+// TestShard is the index of the shard, of total shards, to which the test of
+// the given name is assigned: its name's FNV-1a hash modulo total. The result
+// depends only on the name, so the assignment is stable as tests are added or
+// removed. When total is not positive, no shard exists and -1 is returned.
+func TestShard(testName string, total int) int {
+	if total <= 0 {
+		return -1
+	}
+	h := fnv.New64a()
+	h.Write([]byte(testName))
+	return int(h.Sum64() % uint64(total))
+}
+
+// Matches reports whether the test of the given name is in the shard. A shard
+// of a non-positive total (which the parser rejects) matches nothing.
+func (s Shard) Matches(testName string) bool {
+	return s.Total > 0 && TestShard(testName, s.Total) == s.Index
+}
+
+// FocusArea is a query atom that matches tests under any of the path prefixes
+// of a named focus area (e.g., the tests of an Interop focus area). Paths are
+// resolved from the parser's focus area mapping when the atom is parsed.
+type FocusArea struct {
+	Area  string
+	Paths []string
+}
+
+// BindToRuns for FocusArea produces a TestPath for each of the area's paths; it
+// is independent of test runs.
+func (fa FocusArea) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(fa.Paths) == 0 {
+		return False{}
+	}
+	if len(fa.Paths) == 1 {
+		return TestPath{fa.Paths[0]}
+	}
+
+	q := Or{make([]ConcreteQuery, len(fa.Paths))}
+	for i := range fa.Paths {
+		q.Args[i] = TestPath{fa.Paths[i]}
+	}
+	return q
+}
+
+// InteropYear is a query atom that matches tests under any of the path prefixes
+// of the focus areas of a year's Interop effort. Paths are resolved from the
+// parser's Interop year and focus area mappings when the atom is parsed.
+type InteropYear struct {
+	Year  int
+	Paths []string
+}
+
+// BindToRuns for InteropYear produces a TestPath for each of the year's paths,
+// as for FocusArea; it is independent of test runs.
+func (iy InteropYear) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return FocusArea{Paths: iy.Paths}.BindToRuns(runs...)
+}
+
+// Subtest is a query atom that matches subtest names containing a substring
+// or, when Exact is set, equal to the given name. Top-level test results (i.e.,
+// the test harness status) never match.
+type Subtest struct {
+	Name  string
+	Exact bool
+}
+
+// BindToRuns for Subtest is a no-op; it is independent of test runs.
+func (s Subtest) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return s
+}
+
 // AbstractExists represents an array of abstract queries, each of which must be
 // satifisfied by some run. It represents the root of a structured query.
 type AbstractExists struct {
@@ -73,8 +197,14 @@ type AbstractExists struct {
 }
 
 // BindToRuns binds each abstract query to an or-combo of that query against
-// each specific/individual run.
+// each specific/individual run. A Subtest argument is matched, in each run, only
+// by subtests for which the run has a result. When there is only one run, each
+// abstract query is bound to it directly, without the or-combo, and a single
+// abstract query is bound without the enclosing and.
 func (e AbstractExists) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 1 {
+		return e.bindToRun(runs[0])
+	}
 	queries := make([]ConcreteQuery, len(e.Args))
 	for i, arg := range e.Args {
 		var query ConcreteQuery
@@ -86,8 +216,17 @@ func (e AbstractExists) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 		} else {
 			// Everything else is split, one run must satisfy the whole tree.
 			byRun := make([]ConcreteQuery, 0, len(runs))
+			_, isSubtest := arg.(Subtest)
 			for _, run := range runs {
 				bound := arg.BindToRuns(run)
+				if isSubtest {
+					// Subtest names are independent of runs; a run only has the
+					// subtest if it has a result for it.
+					bound = And{Args: []ConcreteQuery{
+						bound,
+						RunTestStatusNeq{Run: run.ID, Status: shared.TestStatusUnknown},
+					}}
+				}
 				if _, ok := bound.(False); !ok {
 					byRun = append(byRun, bound)
 				}
@@ -102,6 +241,67 @@ func (e AbstractExists) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 	}
 }
 
+// bindToRun binds an AbstractExists to a single run, for which a query exists
+// in some run exactly when it holds in that run.
+func (e AbstractExists) bindToRun(run shared.TestRun) ConcreteQuery {
+	queries := make([]ConcreteQuery, len(e.Args))
+	for i, arg := range e.Args {
+		queries[i] = arg.BindToRuns(run)
+		if _, isSubtest := arg.(Subtest); isSubtest {
+			queries[i] = And{Args: []ConcreteQuery{
+				queries[i],
+				RunTestStatusNeq{Run: run.ID, Status: shared.TestStatusUnknown},
+			}}
+		}
+	}
+	if len(queries) == 1 {
+		return queries[0]
+	}
+	return And{Args: queries}
+}
+
+// AbstractAll represents an array of abstract queries, each of which must be
+// satisfied by every run. It is the universal counterpart of AbstractExists.
+type AbstractAll struct {
+	Args []AbstractQuery
+}
+
+// BindToRuns binds each abstract query to an and-combo of that query against
+// each specific/individual run. Runs to which a query does not apply (i.e., for
+// which it binds to False, such as a status constraint for a different product)
+// do not participate; a query that applies to no runs at all is False.
+func (a AbstractAll) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	queries := make([]ConcreteQuery, len(a.Args))
+	for i, arg := range a.Args {
+		var query ConcreteQuery
+		// For sequential + count, we pass all runs.
+		if _, isSeq := arg.(AbstractSequential); isSeq {
+			query = arg.BindToRuns(runs...)
+		} else if _, isCount := arg.(AbstractCount); isCount {
+			query = arg.BindToRuns(runs...)
+		} else {
+			// Everything else is split, every run must satisfy the whole tree.
+			byRun := make([]ConcreteQuery, 0, len(runs))
+			for _, run := range runs {
+				bound := arg.BindToRuns(run)
+				if _, ok := bound.(False); !ok {
+					byRun = append(byRun, bound)
+				}
+			}
+			if len(byRun) == 0 {
+				query = False{}
+			} else {
+				query = And{Args: byRun}
+			}
+		}
+		queries[i] = query
+	}
+	// And the overall node is true if all its universal queries are true.
+	return And{
+		Args: queries,
+	}
+}
+
 // AbstractSequential represents the root of a sequential queries, where the first
 // query must be satisfied by some run such that the next run, sequentially, also
 // satisfies the next query, and so on.
@@ -148,18 +348,76 @@ func (c AbstractCount) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 
 // TestStatusEq is a query atom that matches tests where the test status/result
 // from at least one test run matches the given status value, optionally filtered
-// to a specific browser name.
+// to a specific browser name. When Products is non-empty, it is used in place of
+// Product: runs matching any of the Products are considered. When OS is
+// non-empty, only runs on that OS (one of OSNames) are considered. When Revision
+// is non-empty, only runs whose WPT revision begins with it are considered. When
+// FullRun is set, only full runs (see isFullRun) are considered when it is
+// true, and only partial runs when it is false.
 type TestStatusEq struct {
-	Product *shared.ProductSpec
-	Status  shared.TestStatus
+	Product  *shared.ProductSpec
+	Status   shared.TestStatus
+	Products []shared.ProductSpec
+	OS       string
+	Revision string
+	FullRun  *bool
 }
 
 // TestStatusNeq is a query atom that matches tests where the test status/result
 // from at least one test run does not match the given status value, optionally
-// filtered to a specific browser name.
+// filtered to a specific browser name. When Products is non-empty, it is used in
+// place of Product, and OS, Revision and FullRun restrict runs, as for
+// TestStatusEq.
 type TestStatusNeq struct {
-	Product *shared.ProductSpec
-	Status  shared.TestStatus
+	Product  *shared.ProductSpec
+	Status   shared.TestStatus
+	Products []shared.ProductSpec
+	OS       string
+	Revision string
+	FullRun  *bool
+}
+
+// matchesProducts reports whether a run is constrained by the product (or
+// products), OS, revision and full-run flag of a status atom.
+func matchesProducts(product *shared.ProductSpec, products []shared.ProductSpec, os, revision string, fullRun *bool, run shared.TestRun) bool {
+	if os != "" && canonicalizeStr(run.OSName) != os {
+		return false
+	}
+	if !matchesRevision(revision, run) {
+		return false
+	}
+	if fullRun != nil && isFullRun(run) != *fullRun {
+		return false
+	}
+	if len(products) > 0 {
+		for _, p := range products {
+			if p.Matches(run) {
+				return true
+			}
+		}
+		return false
+	}
+	return product == nil || product.Matches(run)
+}
+
+// matchesRevision reports whether a run's WPT revision begins with the given
+// (lowercase) revision, which may be a full SHA or a prefix of one. The run's
+// full revision hash is used when it is known; otherwise its (short) revision.
+// Every run matches an empty revision.
+func matchesRevision(revision string, run shared.TestRun) bool {
+	if revision == "" {
+		return true
+	}
+	if run.FullRevisionHash != "" {
+		return strings.HasPrefix(strings.ToLower(run.FullRevisionHash), revision)
+	}
+	return strings.HasPrefix(strings.ToLower(run.Revision), revision)
+}
+
+// isFullRun reports whether a run covers the whole test suite. Runs labelled
+// pr_base or pr_head run only the tests affected by a PR, and are partial.
+func isFullRun(run shared.TestRun) bool {
+	return !run.IsPRBase() && !shared.StringSliceContains(run.Labels, shared.PRHeadLabel)
 }
 
 // BindToRuns for TestStatusEq expands to a disjunction of RunTestStatusEq
@@ -167,7 +425,7 @@ type TestStatusNeq struct {
 func (tse TestStatusEq) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 	ids := make([]int64, 0, len(runs))
 	for _, run := range runs {
-		if tse.Product == nil || tse.Product.Matches(run) {
+		if matchesProducts(tse.Product, tse.Products, tse.OS, tse.Revision, tse.FullRun, run) {
 			ids = append(ids, run.ID)
 		}
 	}
@@ -190,7 +448,7 @@ func (tse TestStatusEq) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 func (tsn TestStatusNeq) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 	ids := make([]int64, 0, len(runs))
 	for _, run := range runs {
-		if tsn.Product == nil || tsn.Product.Matches(run) {
+		if matchesProducts(tsn.Product, tsn.Products, tsn.OS, tsn.Revision, tsn.FullRun, run) {
 			ids = append(ids, run.ID)
 		}
 	}
@@ -208,229 +466,2534 @@ func (tsn TestStatusNeq) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
 	return q
 }
 
-// AbstractNot is the AbstractQuery for negation.
-type AbstractNot struct {
-	Arg AbstractQuery
+// AnyStatus is a query atom that matches tests where the test status/result
+// from at least one test run, regardless of browser, matches the given status
+// value.
+type AnyStatus struct {
+	Status shared.TestStatus
 }
 
-// BindToRuns for AbstractNot produces a Not with a bound argument.
-func (n AbstractNot) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
-	return Not{n.Arg.BindToRuns(runs...)}
+// BindToRuns for AnyStatus produces an AnyRunTestStatusEq over all runs.
+func (as AnyStatus) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
+	}
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
+	}
+	return AnyRunTestStatusEq{Runs: ids, Status: as.Status}
 }
 
-// AbstractOr is the AbstractQuery for disjunction.
-type AbstractOr struct {
-	Args []AbstractQuery
+// HasScreenshot is a query atom that matches tests that have a screenshot
+// artifact in a run of the given browser. Matching depends on screenshot
+// artifact metadata being available to the query service at execution time;
+// tests are never matched in runs for which no such metadata is available.
+type HasScreenshot struct {
+	BrowserName string
 }
 
-// BindToRuns for AbstractOr produces an Or with bound arguments.
-func (o AbstractOr) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
-	args := make([]ConcreteQuery, 0, len(o.Args))
-	for i := range o.Args {
-		sub := o.Args[i].BindToRuns(runs...)
-		if t, ok := sub.(True); ok {
-			return t
-		}
-		if _, ok := sub.(False); ok {
-			continue
+// BindToRuns for HasScreenshot expands to a disjunction of RunHasScreenshot
+// values over runs of the given browser.
+func (hs HasScreenshot) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == hs.BrowserName {
+			ids = append(ids, run.ID)
 		}
-		args = append(args, sub)
 	}
-	if len(args) == 0 {
+	if len(ids) == 0 {
 		return False{}
 	}
-	if len(args) == 1 {
-		return args[0]
+	if len(ids) == 1 {
+		return RunHasScreenshot{ids[0]}
 	}
-	return Or{
-		Args: args,
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunHasScreenshot{ids[i]}
 	}
+	return q
 }
 
-// AbstractAnd is the AbstractQuery for conjunction.
-type AbstractAnd struct {
-	Args []AbstractQuery
+// SubtestTotal is a query atom that matches tests whose total number of
+// subtests, in at least one test run, is within the range [Min, Max]. A
+// negative Max imposes no upper bound.
+type SubtestTotal struct {
+	Min int
+	Max int
 }
 
-// BindToRuns for AbstractAnd produces an And with bound arguments.
-func (a AbstractAnd) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
-	args := make([]ConcreteQuery, 0, len(a.Args))
-	for i := range a.Args {
-		sub := a.Args[i].BindToRuns(runs...)
-		if _, ok := sub.(False); ok {
-			return False{}
-		}
-		if _, ok := sub.(True); ok {
-			continue
-		}
-		args = append(args, sub)
-	}
-	if len(args) == 0 {
+// BindToRuns for SubtestTotal produces an AnyRunSubtestTotal over all runs.
+func (st SubtestTotal) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
 		return False{}
 	}
-	if len(args) == 1 {
-		return args[0]
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
 	}
-	return And{
-		Args: args,
+	return AnyRunSubtestTotal{Runs: ids, Min: st.Min, Max: st.Max}
+}
+
+// LongTimeout is a query atom that matches tests that are marked as having a
+// long timeout in the WPT manifest (for the revision of at least one test run).
+// Matching depends on manifest metadata being available to the query service at
+// execution time.
+type LongTimeout struct{}
+
+// BindToRuns for LongTimeout produces an AnyRunLongTimeout over all runs.
+func (lt LongTimeout) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
 	}
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
+	}
+	return AnyRunLongTimeout{Runs: ids}
 }
 
-// UnmarshalJSON interprets the JSON representation of a RunQuery, instantiating
-// (an) appropriate Query implementation(s) according to the JSON structure.
-func (rq *RunQuery) UnmarshalJSON(b []byte) error {
-	var data struct {
-		RunIDs []int64         `json:"run_ids"`
-		Query  json.RawMessage `json:"query"`
+// MetadataFields are the fields of triage metadata (i.e., of the links in
+// wpt-metadata META.yml files) that can be queried with HasMetadataField. A
+// "bug" is the URL of a link.
+var MetadataFields = []string{"bug", "label", "results"}
+
+// HasMetadataField is a query atom that matches tests whose triage metadata
+// defines the given field (for the revision of at least one test run). Matching
+// depends on triage metadata being available to the query service at execution
+// time.
+type HasMetadataField struct {
+	Field string
+}
+
+// BindToRuns for HasMetadataField produces an AnyRunHasMetadataField over all
+// runs.
+func (hmf HasMetadataField) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
 	}
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
 	}
-	if len(data.RunIDs) == 0 {
-		return errors.New(`Missing run query property: "run_ids"`)
+	return AnyRunHasMetadataField{Runs: ids, Field: hmf.Field}
+}
+
+// PresentInAll is a query atom that matches tests that have a result (i.e., a
+// status other than UNKNOWN) in at least one run of every distinct browser
+// among the runs being queried.
+type PresentInAll struct{}
+
+// BindToRuns for PresentInAll groups runs by browser, producing a
+// PresentInAllBrowsers.
+func (pia PresentInAll) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
 	}
-	rq.RunIDs = data.RunIDs
+	return PresentInAllBrowsers{RunsByBrowser: runsByBrowser(runs)}
+}
 
-	if len(data.Query) > 0 {
-		q, err := unmarshalQ(data.Query)
-		if err != nil {
-			return err
+// runsByBrowser groups the IDs of runs by (canonical) browser name, in order of
+// each browser's first run.
+func runsByBrowser(runs []shared.TestRun) [][]int64 {
+	var byBrowser [][]int64
+	browserIdx := make(map[string]int)
+	for _, run := range runs {
+		browser := canonicalizeStr(run.BrowserName)
+		i, ok := browserIdx[browser]
+		if !ok {
+			i = len(byBrowser)
+			browserIdx[browser] = i
+			byBrowser = append(byBrowser, nil)
 		}
-		rq.AbstractQuery = q
-	} else {
-		rq.AbstractQuery = True{}
+		byBrowser[i] = append(byBrowser[i], run.ID)
 	}
-	return nil
+	return byBrowser
 }
 
-// UnmarshalJSON for TestNamePattern attempts to interpret a query atom as
-// {"pattern":<test name pattern string>}.
-func (tnp *TestNamePattern) UnmarshalJSON(b []byte) error {
-	var data map[string]*json.RawMessage
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
-	}
-	patternMsg, ok := data["pattern"]
-	if !ok {
-		return errors.New(`Missing test name pattern property: "pattern"`)
-	}
-	var pattern string
-	if err := json.Unmarshal(*patternMsg, &pattern); err != nil {
-		return errors.New(`Missing test name pattern property "pattern" is not a string`)
+// WorseThanMedian is a query atom that matches tests for which the given
+// browser's result is more severe (see StatusSeverity) than the median result
+// across all of the browsers among the runs being queried, including the given
+// browser. A browser's result for a test is the least severe of the results in
+// its runs; browsers without a result for the test do not participate. For an
+// even number of participating browsers, the median is the less severe of the
+// two middle results, so that the browser matches when its result is worse
+// than that of at least half of the browsers.
+type WorseThanMedian struct {
+	BrowserName string
+}
+
+// BindToRuns for WorseThanMedian groups runs by browser, producing a
+// BrowserWorseThanMedian, or False if no run is of the given browser.
+func (wtm WorseThanMedian) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) != wtm.BrowserName {
+			continue
+		}
+		// The browser's group is the one that begins with its first run.
+		byBrowser := runsByBrowser(runs)
+		for i, ids := range byBrowser {
+			if ids[0] == run.ID {
+				return BrowserWorseThanMedian{Browser: i, RunsByBrowser: byBrowser}
+			}
+		}
 	}
+	return False{}
+}
 
-	tnp.Pattern = pattern
-	return nil
+// SubtestOnlyIn is a query atom that matches subtests of the given (exact) name
+// that have a result in a run of the given browser, but in none of the runs of
+// the other browsers being queried, such as when a harness difference makes a
+// subtest appear in one browser's results only.
+type SubtestOnlyIn struct {
+	BrowserName string
+	Subtest     string
 }
 
-// UnmarshalJSON for TestPath attempts to interpret a query atom as
-// {"path":<test name pattern string>}.
-func (tp *TestPath) UnmarshalJSON(b []byte) error {
-	var data map[string]*json.RawMessage
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
+// BindToRuns for SubtestOnlyIn groups runs by browser, producing a
+// BrowserSubtestOnlyIn, or False if no run is of the given browser.
+func (soi SubtestOnlyIn) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) != soi.BrowserName {
+			continue
+		}
+		// The browser's group is the one that begins with its first run.
+		byBrowser := runsByBrowser(runs)
+		for i, ids := range byBrowser {
+			if ids[0] == run.ID {
+				return BrowserSubtestOnlyIn{Browser: i, RunsByBrowser: byBrowser, Subtest: soi.Subtest}
+			}
+		}
 	}
-	pathMsg, ok := data["path"]
-	if !ok {
-		return errors.New(`Missing test name path property: "path"`)
+	return False{}
+}
+
+// FirstSeen is a query atom that matches tests whose earliest appearance among
+// the runs being queried is after the given time: the test has a result in some
+// run that started after Since, and no result in any run that started at or
+// before Since. Tests that are older than the queried runs are not recognized
+// as such.
+type FirstSeen struct {
+	Since time.Time
+}
+
+// BindToRuns for FirstSeen partitions runs by their start time, producing a
+// FirstSeenAfter, or False if no run started after the given time.
+func (fs FirstSeen) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	var before, after []int64
+	for _, run := range runs {
+		if run.TimeStart.After(fs.Since) {
+			after = append(after, run.ID)
+		} else {
+			before = append(before, run.ID)
+		}
 	}
-	var path string
-	if err := json.Unmarshal(*pathMsg, &path); err != nil {
-		return errors.New(`Missing test name path property "path" is not a string`)
+	if len(after) == 0 {
+		return False{}
 	}
+	return FirstSeenAfter{Before: before, After: after}
+}
 
-	tp.Path = path
-	return nil
+// CoverageCount is a query atom that matches tests with a result (i.e., that
+// are not missing) in at least Min of the runs being queried.
+type CoverageCount struct {
+	Min int
 }
 
-// UnmarshalJSON for TestStatusEq attempts to interpret a query atom as
-// {"product": <browser name>, "status": <status string>}.
-func (tse *TestStatusEq) UnmarshalJSON(b []byte) error {
-	var data struct {
-		BrowserName string `json:"browser_name"` // Legacy
-		Product     string `json:"product"`
-		Status      string `json:"status"`
+// BindToRuns for CoverageCount produces a RunsWithResults over all runs, or
+// False if there are fewer than Min runs.
+func (cc CoverageCount) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if cc.Min > len(runs) {
+		return False{}
 	}
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
 	}
-	if data.Product == "" && data.BrowserName != "" {
-		data.Product = data.BrowserName
+	return RunsWithResults{Runs: ids, Min: cc.Min}
+}
+
+// Comparisons of a count against a bound, as in BrowsersFailing.
+const (
+	ComparisonEq  = "eq"
+	ComparisonGte = "gte"
+	ComparisonLte = "lte"
+)
+
+// BrowsersFailing is a query atom that matches tests according to the number of
+// distinct browsers, among the runs being queried, in which the test fails: a
+// browser fails a test when at least one of its runs has a result for the test
+// other than PASS or OK. The number of failing browsers is compared to Count
+// by Comparison, one of ComparisonEq, ComparisonGte or ComparisonLte.
+type BrowsersFailing struct {
+	Count      int
+	Comparison string
+}
+
+// BindToRuns for BrowsersFailing groups runs by browser, producing a
+// BrowsersFailingCount.
+func (bf BrowsersFailing) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
 	}
-	if len(data.Status) == 0 {
-		return errors.New(`Missing test status constraint property: "status"`)
+	return BrowsersFailingCount{
+		RunsByBrowser: runsByBrowser(runs),
+		Count:         bf.Count,
+		Comparison:    bf.Comparison,
 	}
+}
 
-	var product *shared.ProductSpec
-	if data.Product != "" {
-		p, err := shared.ParseProductSpec(data.Product)
-		if err != nil {
-			return err
+// Skipped is a query atom that matches tests that were skipped in a run of the
+// given browser: either the result of the test is SKIP, or the test is marked
+// as disabled in the run's metadata, and so was not run at all. Skipped is
+// distinct from missing, i.e., tests for which a run has no result and that
+// are not known to be disabled.
+type Skipped struct {
+	BrowserName string
+}
+
+// BindToRuns for Skipped expands to a disjunction of RunSkipped values over runs
+// of the given browser.
+func (s Skipped) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == s.BrowserName {
+			ids = append(ids, run.ID)
 		}
-		product = &p
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunSkipped{ids[0]}
 	}
 
-	statusStr := strings.ToUpper(data.Status)
-	status := shared.TestStatusValueFromString(statusStr)
-	statusStr2 := status.String()
-	if statusStr != statusStr2 {
-		return fmt.Errorf(`Invalid test status: "%s"`, data.Status)
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunSkipped{ids[i]}
 	}
+	return q
+}
 
-	tse.Product = product
-	tse.Status = status
-	return nil
+// Intermittent is a query atom that matches tests whose result, in a run of the
+// given browser, is flagged as known to be intermittent by the run's metadata.
+type Intermittent struct {
+	BrowserName string
 }
 
-// UnmarshalJSON for TestStatusNeq attempts to interpret a query atom as
-// {"product": <browser name>, "status": {"not": <status string>}}.
+// BindToRuns for Intermittent expands to a disjunction of RunIntermittent
+// values over runs of the given browser.
+func (i Intermittent) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == i.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunIntermittent{ids[0]}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for j := range ids {
+		q.Args[j] = RunIntermittent{ids[j]}
+	}
+	return q
+}
+
+// AssertCount is a query atom that matches tests whose number of assertions
+// run, in a run of the given browser, is within the range [Min, Max], such as
+// tests that bailed out before running any assertion. A negative Max imposes no
+// upper bound. Tests without a recorded assertion count match nothing.
+type AssertCount struct {
+	BrowserName string
+	Min         int
+	Max         int
+}
+
+// BindToRuns for AssertCount expands to a disjunction of RunAssertCount values
+// over runs of the given browser.
+func (ac AssertCount) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == ac.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunAssertCount{Run: ids[0], Min: ac.Min, Max: ac.Max}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for j := range ids {
+		q.Args[j] = RunAssertCount{Run: ids[j], Min: ac.Min, Max: ac.Max}
+	}
+	return q
+}
+
+// Unexpected is a query atom that matches tests whose result in a run of the
+// given browser differs from the status expected by the run's metadata. Tests
+// without a recorded expectation are expected to pass (PASS or OK). Tests with
+// no result in the run are not matched.
+type Unexpected struct {
+	BrowserName string
+}
+
+// BindToRuns for Unexpected expands to a disjunction of RunUnexpected values
+// over runs of the given browser.
+func (u Unexpected) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == u.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunUnexpected{ids[0]}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunUnexpected{ids[i]}
+	}
+	return q
+}
+
+// SubtestMajority is a query atom that matches tests whose subtests, in a run of
+// the given browser, have the given status more often than any other status
+// (i.e., a strict plurality; ties do not match). Tests without subtests are
+// not matched.
+type SubtestMajority struct {
+	BrowserName string
+	Status      shared.TestStatus
+}
+
+// BindToRuns for SubtestMajority expands to a disjunction of
+// RunSubtestPlurality values over runs of the given browser.
+func (sm SubtestMajority) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == sm.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunSubtestPlurality{Run: ids[0], Status: sm.Status}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunSubtestPlurality{Run: ids[i], Status: sm.Status}
+	}
+	return q
+}
+
+// HarnessMessage is a query atom that matches tests whose harness-level (i.e.,
+// not subtest) message, in a run of the given browser, contains Pattern.
+type HarnessMessage struct {
+	BrowserName string
+	Pattern     string
+}
+
+// BindToRuns for HarnessMessage expands to a disjunction of RunHarnessMessage
+// values over runs of the given browser.
+func (hm HarnessMessage) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == hm.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunHarnessMessage{Run: ids[0], Pattern: hm.Pattern}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunHarnessMessage{Run: ids[i], Pattern: hm.Pattern}
+	}
+	return q
+}
+
+// AllSubtestsPass is a query atom that matches tests that fully pass in a run of
+// the given browser: every subtest passes or, for tests without subtests, the
+// test itself passes. A test whose harness status is OK may still have failing
+// subtests, and so not match.
+type AllSubtestsPass struct {
+	BrowserName string
+}
+
+// BindToRuns for AllSubtestsPass expands to a disjunction of RunAllSubtestsPass
+// values over runs of the given browser.
+func (asp AllSubtestsPass) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == asp.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunAllSubtestsPass{ids[0]}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunAllSubtestsPass{ids[i]}
+	}
+	return q
+}
+
+// FlakinessRate is a query atom that matches tests that are flaky across the
+// runs of the given browser: the fraction of those runs in which the test's
+// status differs from its most common status is greater than Above.
+type FlakinessRate struct {
+	BrowserName string
+	Above       float64
+}
+
+// BindToRuns for FlakinessRate produces a RunsFlakinessRate over the runs of the
+// given browser.
+func (fr FlakinessRate) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == fr.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	return RunsFlakinessRate{Runs: ids, Above: fr.Above}
+}
+
+// SubtestPassRatio is a query atom that matches tests whose fraction of passing
+// subtests, in a run of the given browser, is below Below and above Above. A
+// negative Below or Above imposes no bound. Tests without subtests are not
+// matched.
+type SubtestPassRatio struct {
+	BrowserName string
+	Below       float64
+	Above       float64
+}
+
+// BindToRuns for SubtestPassRatio expands to a disjunction of
+// RunSubtestPassRatio values over runs of the given browser.
+func (spr SubtestPassRatio) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == spr.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunSubtestPassRatio{Run: ids[0], Below: spr.Below, Above: spr.Above}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunSubtestPassRatio{Run: ids[i], Below: spr.Below, Above: spr.Above}
+	}
+	return q
+}
+
+// RefExpectMatch and RefExpectMismatch are the expectations of RefMatch: that
+// a reftest's rendering matches (==) or does not match (!=) its reference.
+const (
+	RefExpectMatch    = "match"
+	RefExpectMismatch = "mismatch"
+)
+
+// RefMatch is a query atom that matches reftests, in a run of the given
+// browser, whose reference comparison is of the given expectation: either
+// RefExpectMatch (==) or RefExpectMismatch (!=).
+type RefMatch struct {
+	BrowserName string
+	Expected    string
+}
+
+// BindToRuns for RefMatch expands to a disjunction of RunRefMatch over the runs
+// of the given browser.
+func (rm RefMatch) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == rm.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunRefMatch{Run: ids[0], Expected: rm.Expected}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunRefMatch{Run: ids[i], Expected: rm.Expected}
+	}
+	return q
+}
+
+// RegressedSince is a query atom that matches tests whose status, in a run of
+// the same browser other than the baseline run, is more severe (see
+// StatusSeverity) than in the baseline run: the first run with the given label
+// (e.g., "last_stable"). Tests without a result in either run are not matched.
+type RegressedSince struct {
+	Label string
+}
+
+// BindToRuns for RegressedSince resolves the baseline run, expanding to a
+// disjunction of RunRegressed over the other runs of the baseline's browser.
+// When no run has the label, the query matches nothing; Validate reports such
+// queries as errors.
+func (rs RegressedSince) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	baseline, ok := baselineRun(rs.Label, runs)
+	if !ok {
+		return False{}
+	}
+	ids := make([]int64, 0, len(runs))
+	for _, run := range runs {
+		if run.ID != baseline.ID && run.BrowserName == baseline.BrowserName {
+			ids = append(ids, run.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return False{}
+	}
+	if len(ids) == 1 {
+		return RunRegressed{Baseline: baseline.ID, Run: ids[0]}
+	}
+
+	q := Or{make([]ConcreteQuery, len(ids))}
+	for i := range ids {
+		q.Args[i] = RunRegressed{Baseline: baseline.ID, Run: ids[i]}
+	}
+	return q
+}
+
+// ChangedInPR is a query atom that matches tests whose status differs between
+// the head run of a pull request and its base run: the first runs labeled
+// shared.PRHeadLabel and shared.PRBaseLabel, respectively. Tests with a result
+// in only one of the runs (e.g., tests added by the pull request) are matched.
+type ChangedInPR struct{}
+
+// BindToRuns for ChangedInPR resolves the head and base runs, producing a
+// RunStatusChanged. When either run is missing, the query matches nothing;
+// Validate reports such queries as errors.
+func (c ChangedInPR) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	base, ok := baselineRun(shared.PRBaseLabel, runs)
+	if !ok {
+		return False{}
+	}
+	head, ok := baselineRun(shared.PRHeadLabel, runs)
+	if !ok || head.ID == base.ID {
+		return False{}
+	}
+	return RunStatusChanged{Base: base.ID, Head: head.ID}
+}
+
+// AnyChanged is a query atom that matches tests whose status changed, in at
+// least one browser, relative to a baseline: the first run of each browser with
+// the given Label (e.g., "last_stable") or, when Run is non-zero, the run with
+// that ID (for its browser only). Within each browser, the status is compared
+// with that of the first run of the browser other than its baseline. As for
+// ChangedInPR, tests with a result in only one of the runs are matched.
+type AnyChanged struct {
+	Label string
+	Run   int64
+}
+
+// BindToRuns for AnyChanged resolves the baseline (and compared) run of each
+// browser, producing an AnyRunStatusChanged. When no browser has both runs, the
+// query matches nothing; Validate reports queries without any baseline run as
+// errors.
+func (ac AnyChanged) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	var browsers []string
+	baselines := make(map[string]int64)
+	for _, run := range runs {
+		if ac.isBaseline(run) {
+			browserName := canonicalizeStr(run.BrowserName)
+			if _, ok := baselines[browserName]; !ok {
+				browsers = append(browsers, browserName)
+				baselines[browserName] = run.ID
+			}
+		}
+	}
+
+	var q AnyRunStatusChanged
+	for _, browserName := range browsers {
+		baseline := baselines[browserName]
+		for _, run := range runs {
+			if run.ID != baseline && canonicalizeStr(run.BrowserName) == browserName {
+				q.Bases = append(q.Bases, baseline)
+				q.Heads = append(q.Heads, run.ID)
+				break
+			}
+		}
+	}
+	if len(q.Heads) == 0 {
+		return False{}
+	}
+	return q
+}
+
+func (ac AnyChanged) isBaseline(run shared.TestRun) bool {
+	if ac.Run != 0 {
+		return run.ID == ac.Run
+	}
+	return shared.StringSliceContains(run.Labels, ac.Label)
+}
+
+// SubtestCountDelta is a query atom that matches tests whose number of
+// subtests differs (or, when Changed is false, does not differ) between the
+// first run of the From browser and the first other run of the To browser, such
+// as when a regression makes subtests disappear. A test with a result in only
+// one of the runs has changed; a test without a result in either run matches
+// neither.
+type SubtestCountDelta struct {
+	From    string
+	To      string
+	Changed bool
+}
+
+// BindToRuns for SubtestCountDelta resolves the From and To runs, producing a
+// RunsSubtestCountDelta. When either run is missing, the query matches nothing;
+// Validate reports such queries as errors.
+func (scd SubtestCountDelta) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	from, to, ok := resolveRunPair(runs, scd.From, scd.To)
+	if !ok {
+		return False{}
+	}
+	return RunsSubtestCountDelta{From: from.ID, To: to.ID, Changed: scd.Changed}
+}
+
+// SameRender is a query atom that matches tests whose screenshot hash is the
+// same in the first run of the LeftBrowser and the first other run of the
+// RightBrowser, i.e., reftests that the two browsers rendered identically.
+// Tests without a screenshot in either run match nothing.
+type SameRender struct {
+	LeftBrowser  string
+	RightBrowser string
+}
+
+// BindToRuns for SameRender resolves the left and right runs, producing a
+// RunsSameRender. When either run is missing, the query matches nothing;
+// Validate reports such queries as errors.
+func (sr SameRender) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	left, right, ok := resolveRunPair(runs, sr.LeftBrowser, sr.RightBrowser)
+	if !ok {
+		return False{}
+	}
+	return RunsSameRender{Left: left.ID, Right: right.ID}
+}
+
+// resolveRunPair finds the first run of the from browser, and the first other
+// run of the to browser (so that from and to may be the same browser).
+func resolveRunPair(runs []shared.TestRun, fromBrowser, toBrowser string) (from, to shared.TestRun, ok bool) {
+	fromOK := false
+	for _, run := range runs {
+		if canonicalizeStr(run.BrowserName) == fromBrowser {
+			from, fromOK = run, true
+			break
+		}
+	}
+	if !fromOK {
+		return from, to, false
+	}
+	for _, run := range runs {
+		if run.ID != from.ID && canonicalizeStr(run.BrowserName) == toBrowser {
+			return from, run, true
+		}
+	}
+	return from, to, false
+}
+
+func baselineRun(label string, runs []shared.TestRun) (shared.TestRun, bool) {
+	for _, run := range runs {
+		if shared.StringSliceContains(run.Labels, label) {
+			return run, true
+		}
+	}
+	return shared.TestRun{}, false
+}
+
+// StatusSeverity orders test statuses from best to worst, for comparing results
+// across runs: PASS and OK (0), SKIP and NOTRUN (1), ASSERT (2), FAIL (3),
+// TIMEOUT (4), ERROR (5), CRASH (6). UNKNOWN, i.e., no result, has a severity
+// of -1 and is not comparable with other statuses.
+func StatusSeverity(status shared.TestStatus) int {
+	switch status {
+	case shared.TestStatusPass, shared.TestStatusOK:
+		return 0
+	case shared.TestStatusSkip, shared.TestStatusNotRun:
+		return 1
+	case shared.TestStatusAssert:
+		return 2
+	case shared.TestStatusFail:
+		return 3
+	case shared.TestStatusTimeout:
+		return 4
+	case shared.TestStatusError:
+		return 5
+	case shared.TestStatusCrash:
+		return 6
+	default:
+		return -1
+	}
+}
+
+// AbstractNot is the AbstractQuery for negation.
+type AbstractNot struct {
+	Arg AbstractQuery
+}
+
+// BindToRuns for AbstractNot produces a Not with a bound argument.
+func (n AbstractNot) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	return Not{n.Arg.BindToRuns(runs...)}
+}
+
+// AbstractOr is the AbstractQuery for disjunction.
+type AbstractOr struct {
+	Args []AbstractQuery
+}
+
+// BindToRuns for AbstractOr produces an Or with bound arguments.
+func (o AbstractOr) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	args := make([]ConcreteQuery, 0, len(o.Args))
+	for i := range o.Args {
+		sub := o.Args[i].BindToRuns(runs...)
+		if t, ok := sub.(True); ok {
+			return t
+		}
+		if _, ok := sub.(False); ok {
+			continue
+		}
+		args = append(args, sub)
+	}
+	if len(args) == 0 {
+		return False{}
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return Or{
+		Args: args,
+	}
+}
+
+// AbstractAnd is the AbstractQuery for conjunction.
+type AbstractAnd struct {
+	Args []AbstractQuery
+}
+
+// BindToRuns for AbstractAnd produces an And with bound arguments.
+func (a AbstractAnd) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	args := make([]ConcreteQuery, 0, len(a.Args))
+	for i := range a.Args {
+		sub := a.Args[i].BindToRuns(runs...)
+		if _, ok := sub.(False); ok {
+			return False{}
+		}
+		if _, ok := sub.(True); ok {
+			continue
+		}
+		args = append(args, sub)
+	}
+	if len(args) == 0 {
+		return False{}
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return And{
+		Args: args,
+	}
+}
+
+// UnmarshalJSON interprets the JSON representation of a RunQuery, instantiating
+// (an) appropriate Query implementation(s) according to the JSON structure.
+func (rq *RunQuery) UnmarshalJSON(b []byte) error {
+	parsed, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	*rq = parsed.RunQuery
+	return nil
+}
+
+func (rq *RunQuery) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		RunIDs  []int64         `json:"run_ids"`
+		Runs    []string        `json:"runs"`
+		Columns []string        `json:"columns"`
+		Query   json.RawMessage `json:"query"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := p.checkRunQueryKeys(b); err != nil {
+		return err
+	}
+	if len(data.RunIDs) == 0 && len(data.Runs) == 0 {
+		return ErrMissingRunIDs
+	}
+	runIDs, duplicates := dedupRunIDs(data.RunIDs)
+	if duplicates {
+		p.warn("Duplicate run IDs in run query property \"run_ids\" ignored")
+	}
+	rq.RunIDs = runIDs
+	rq.RunAliases = nil
+	for _, alias := range data.Runs {
+		spec, err := p.parseProductSpec(alias)
+		if err != nil {
+			return err
+		}
+		rq.RunAliases = append(rq.RunAliases, spec)
+	}
+	rq.Columns = nil
+	for _, column := range data.Columns {
+		browserName := canonicalizeStr(column)
+		if err := p.checkBrowserName(browserName); err != nil {
+			return err
+		}
+		if !shared.StringSliceContains(rq.Columns, browserName) {
+			rq.Columns = append(rq.Columns, browserName)
+		}
+	}
+
+	if len(data.Query) > 0 {
+		q, err := p.unmarshalItem("query", data.Query)
+		if err != nil {
+			return err
+		}
+		rq.AbstractQuery = q
+	} else {
+		rq.AbstractQuery = True{}
+	}
+	return nil
+}
+
+// dedupRunIDs returns a copy of ids without duplicates, in the order of their
+// first occurrence, and whether ids contained any duplicates. The order of a
+// RunQuery's run IDs is kept, since results are reported in that order.
+func dedupRunIDs(ids []int64) (deduped []int64, duplicates bool) {
+	if ids == nil {
+		return nil, false
+	}
+	deduped = make([]int64, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			duplicates = true
+			continue
+		}
+		seen[id] = true
+		deduped = append(deduped, id)
+	}
+	return deduped, duplicates
+}
+
+// Normalize returns a copy of the RunQuery whose run IDs are normalized (see
+// NormalizeRunIDs). Queries that differ only in the order or repetition of
+// their run IDs normalize equally, and so, e.g., may share a cache key where the
+// order in which results are reported does not matter.
+func (rq RunQuery) Normalize() RunQuery {
+	rq.RunIDs, _ = NormalizeRunIDs(rq.RunIDs)
+	return rq
+}
+
+// NormalizeRunIDs returns a copy of ids sorted in ascending order, without
+// duplicates, and whether ids contained any duplicates.
+func NormalizeRunIDs(ids []int64) (normalized []int64, duplicates bool) {
+	if ids == nil {
+		return nil, false
+	}
+	sorted := make([]int64, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	normalized = sorted[:0]
+	for _, id := range sorted {
+		if len(normalized) > 0 && id == normalized[len(normalized)-1] {
+			duplicates = true
+			continue
+		}
+		normalized = append(normalized, id)
+	}
+	return normalized, duplicates
+}
+
+// runQueryKeys are the properties of the JSON representation of a RunQuery.
+var runQueryKeys = []string{"run_ids", "runs", "columns", "query"}
+
+// checkRunQueryKeys reports each unknown property of the RunQuery JSON object b
+// as a warning, or, with ParseOpts.RejectUnknownKeys, returns an error for the
+// first of them. Unknown properties are otherwise ignored, for forward
+// compatibility with clients that send properties added later.
+func (p *parser) checkRunQueryKeys(b []byte) error {
+	keys, _ := jsonObjectKeys(b, nil)
+	for _, key := range keys {
+		known := false
+		for _, k := range runQueryKeys {
+			if bytes.EqualFold(key, []byte(k)) {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		if p.opts.RejectUnknownKeys {
+			return withDetail(ErrUnknownProperty, `: "%s"`, key)
+		}
+		p.warn(fmt.Sprintf(`Unknown run query property "%s" ignored`, key))
+	}
+	return nil
+}
+
+// UnmarshalJSON for TestNamePattern attempts to interpret a query atom as
+// {"pattern":<test name pattern string>}, or as
+// {"pattern":[<test name pattern strings>], "match_all":<bool>}.
+func (tnp *TestNamePattern) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	patternMsg, ok := data["pattern"]
+	if !ok {
+		return errors.New(`Missing test name pattern property: "pattern"`)
+	}
+	if patternMsg == nil {
+		return errNullProperty("pattern")
+	}
+	var pattern string
+	var patterns []string
+	if err := json.Unmarshal(*patternMsg, &pattern); err != nil {
+		if err := json.Unmarshal(*patternMsg, &patterns); err != nil {
+			return errors.New(`Missing test name pattern property "pattern" is not a string or array of strings`)
+		}
+		if len(patterns) == 0 {
+			return errors.New(`Test name pattern property "pattern" is an empty array`)
+		}
+	}
+	var matchAll, ignoreCase bool
+	flags := []struct {
+		key   string
+		value *bool
+	}{
+		{"match_all", &matchAll},
+		{"ignore_case", &ignoreCase},
+	}
+	for _, flag := range flags {
+		msg, ok := data[flag.key]
+		if !ok {
+			continue
+		}
+		if msg == nil {
+			return errNullProperty(flag.key)
+		}
+		if err := json.Unmarshal(*msg, flag.value); err != nil {
+			return fmt.Errorf(`Test name pattern property "%s" is not a boolean`, flag.key)
+		}
+	}
+
+	tnp.Pattern = pattern
+	tnp.Patterns = patterns
+	tnp.MatchAll = matchAll
+	tnp.IgnoreCase = ignoreCase
+	return nil
+}
+
+// UnmarshalJSON for TestPath attempts to interpret a query atom as
+// {"path":<test name pattern string>}.
+func (tp *TestPath) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	pathMsg, ok := data["path"]
+	if !ok {
+		return errors.New(`Missing test name path property: "path"`)
+	}
+	if pathMsg == nil {
+		return errNullProperty("path")
+	}
+	var path string
+	if err := json.Unmarshal(*pathMsg, &path); err != nil {
+		return errors.New(`Missing test name path property "path" is not a string`)
+	}
+
+	tp.Path = path
+	return nil
+}
+
+// UnmarshalJSON for TestNameIn attempts to interpret a query atom as
+// {"test_name_in":[<test name string>, ...]}. The list must not be empty.
+func (tni *TestNameIn) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	namesMsg, ok := data["test_name_in"]
+	if !ok {
+		return errors.New(`Missing test name list property: "test_name_in"`)
+	}
+	if namesMsg == nil {
+		return errNullProperty("test_name_in")
+	}
+	var names []string
+	if err := json.Unmarshal(*namesMsg, &names); err != nil {
+		return errors.New(`Test name list property "test_name_in" is not an array of strings`)
+	}
+	if len(names) == 0 {
+		return errors.New(`Test name list property "test_name_in" must not be empty`)
+	}
+
+	tni.Names = names
+	return nil
+}
+
+// UnmarshalJSON for ManifestPaths attempts to interpret a query atom as
+// {"manifest_paths":[<path string>, ...], "prefix":<bool>}, where the prefix
+// property is optional. The list must not be empty.
+func (mp *ManifestPaths) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	pathsMsg, ok := data["manifest_paths"]
+	if !ok {
+		return errors.New(`Missing manifest path list property: "manifest_paths"`)
+	}
+	if pathsMsg == nil {
+		return errNullProperty("manifest_paths")
+	}
+	var paths []string
+	if err := json.Unmarshal(*pathsMsg, &paths); err != nil {
+		return errors.New(`Manifest path list property "manifest_paths" is not an array of strings`)
+	}
+	if len(paths) == 0 {
+		return errors.New(`Manifest path list property "manifest_paths" must not be empty`)
+	}
+	prefix := false
+	if prefixMsg, ok := data["prefix"]; ok {
+		if prefixMsg == nil {
+			return errNullProperty("prefix")
+		}
+		if err := json.Unmarshal(*prefixMsg, &prefix); err != nil {
+			return errors.New(`Manifest path property "prefix" is not a boolean`)
+		}
+	}
+
+	mp.Paths = paths
+	mp.Prefix = prefix
+	return nil
+}
+
+// UnmarshalJSON for Shard attempts to interpret a query atom as
+// {"shard":{"index":<int>, "total":<int>}}, where total is positive and index
+// is in [0, total).
+func (s *Shard) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	shardMsg, ok := data["shard"]
+	if !ok {
+		return errors.New(`Missing shard property: "shard"`)
+	}
+	if shardMsg == nil {
+		return errNullProperty("shard")
+	}
+	var shard struct {
+		Index *int `json:"index"`
+		Total *int `json:"total"`
+	}
+	if err := json.Unmarshal(*shardMsg, &shard); err != nil {
+		return fmt.Errorf(`Invalid shard property "shard": %v`, err)
+	}
+	if shard.Index == nil || shard.Total == nil {
+		return errors.New(`Invalid shard property "shard": requires "index" and "total"`)
+	}
+	if *shard.Total <= 0 {
+		return fmt.Errorf(`Invalid shard total %d: must be positive`, *shard.Total)
+	}
+	if *shard.Index < 0 || *shard.Index >= *shard.Total {
+		return fmt.Errorf(`Invalid shard index %d: must be at least 0 and less than the total, %d`, *shard.Index, *shard.Total)
+	}
+
+	s.Index = *shard.Index
+	s.Total = *shard.Total
+	return nil
+}
+
+// UnmarshalJSON for FocusArea attempts to interpret a query atom as
+// {"focus_area":<area name string>}, using the default focus areas.
+func (fa *FocusArea) UnmarshalJSON(b []byte) error {
+	return fa.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (fa *FocusArea) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		FocusArea string `json:"focus_area"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "focus_area"); err != nil {
+		return err
+	}
+	if len(data.FocusArea) == 0 {
+		return errors.New(`Missing focus area property: "focus_area"`)
+	}
+	paths, ok := p.focusAreas()[data.FocusArea]
+	if !ok {
+		return fmt.Errorf(`Unknown focus area: "%s"`, data.FocusArea)
+	}
+
+	fa.Area = data.FocusArea
+	fa.Paths = append([]string(nil), paths...)
+	return nil
+}
+
+// UnmarshalJSON for InteropYear attempts to interpret a query atom as
+// {"interop_year":<int>}, using the default Interop years and focus areas.
+func (iy *InteropYear) UnmarshalJSON(b []byte) error {
+	return iy.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (iy *InteropYear) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		InteropYear *int `json:"interop_year"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "interop_year"); err != nil {
+		return err
+	}
+	if data.InteropYear == nil {
+		return errors.New(`Missing interop year property: "interop_year"`)
+	}
+	year := *data.InteropYear
+	areas, ok := p.interopYears()[year]
+	if !ok {
+		return fmt.Errorf(`Unknown interop year: %d`, year)
+	}
+	var paths []string
+	for _, area := range areas {
+		areaPaths, ok := p.focusAreas()[area]
+		if !ok {
+			return fmt.Errorf(`Unknown focus area "%s" of interop year %d`, area, year)
+		}
+		paths = append(paths, areaPaths...)
+	}
+
+	iy.Year = year
+	iy.Paths = paths
+	return nil
+}
+
+// UnmarshalJSON for Subtest attempts to interpret a query atom as
+// {"subtest":<subtest name string>, "exact":<bool>}.
+func (s *Subtest) UnmarshalJSON(b []byte) error {
+	var data map[string]*json.RawMessage
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	nameMsg, ok := data["subtest"]
+	if !ok {
+		return errors.New(`Missing subtest name property: "subtest"`)
+	}
+	if nameMsg == nil {
+		return errNullProperty("subtest")
+	}
+	var name string
+	if err := json.Unmarshal(*nameMsg, &name); err != nil {
+		return errors.New(`Subtest name property "subtest" is not a string`)
+	}
+	var exact bool
+	if exactMsg, ok := data["exact"]; ok {
+		if exactMsg == nil {
+			return errNullProperty("exact")
+		}
+		if err := json.Unmarshal(*exactMsg, &exact); err != nil {
+			return errors.New(`Subtest property "exact" is not a boolean`)
+		}
+	}
+
+	s.Name = name
+	s.Exact = exact
+	return nil
+}
+
+// wildcardBrowserName is the browser name (or product spec) that status atoms
+// accept in place of a browser, matching runs of any browser. It is equivalent
+// to omitting the product.
+const wildcardBrowserName = "*"
+
+// productStrings is the JSON value of the product property of a status atom:
+// either a single product spec (or browser name), or an array of them.
+type productStrings struct {
+	value   string
+	values  []string
+	isArray bool
+}
+
+func (ps *productStrings) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		ps.isArray = true
+		return json.Unmarshal(b, &ps.values)
+	}
+	return json.Unmarshal(b, &ps.value)
+}
+
+// parseProducts parses the product property of a status atom, falling back to
+// the legacy browser_name property. A single product is returned as product,
+// and an array as products, each element of which must be a valid product
+// spec.
+func (p *parser) parseProducts(prod, browserName productStrings) (product *shared.ProductSpec, products []shared.ProductSpec, err error) {
+	if prod.value == "" && !prod.isArray {
+		prod = browserName
+	}
+	if prod.isArray {
+		if len(prod.values) == 0 {
+			return nil, nil, errors.New(`Empty array of products`)
+		}
+		products = make([]shared.ProductSpec, len(prod.values))
+		for i, value := range prod.values {
+			if products[i], err = p.parseProductSpec(value); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, products, nil
+	}
+	if prod.value == "" || prod.value == wildcardBrowserName {
+		return nil, nil, nil
+	}
+	spec, err := p.parseProductSpec(prod.value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &spec, nil, nil
+}
+
+// OSNames are the operating systems recognized by the os property of status
+// atoms.
+var OSNames = []string{"android", "ios", "linux", "macos", "windows"}
+
+// parseOS parses the (case-insensitive) os property of a status atom, which is
+// either omitted (i.e., empty) or one of OSNames.
+func parseOS(os string) (string, error) {
+	if os == "" {
+		return "", nil
+	}
+	name := canonicalizeStr(os)
+	for _, known := range OSNames {
+		if name == known {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf(`Invalid OS "%s": must be one of %s`, os, strings.Join(OSNames, ", "))
+}
+
+// parseRevision parses the (case-insensitive) revision property of a status
+// atom, which is either omitted (i.e., empty) or a WPT revision SHA, or prefix
+// of one, of 7 to 40 hexadecimal characters.
+func parseRevision(revision string) (string, error) {
+	if revision == "" {
+		return "", nil
+	}
+	if shared.SHARegex.FindString(revision) != revision {
+		return "", fmt.Errorf(`Invalid revision "%s": must be 7 to 40 hexadecimal characters`, revision)
+	}
+	return strings.ToLower(revision), nil
+}
+
+// UnmarshalJSON for TestStatusEq attempts to interpret a query atom as
+// {"product": <browser name>, "status": <status string>}. The product may be the
+// wildcard "*", or an array of product specs. An optional "os" property
+// restricts the runs considered to those on the given OS, an optional
+// "revision" property to those whose WPT revision begins with the given SHA, and
+// an optional "full_run" property to full runs when true, or to partial runs
+// when false.
+func (tse *TestStatusEq) UnmarshalJSON(b []byte) error {
+	return tse.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (tse *TestStatusEq) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		BrowserName productStrings `json:"browser_name"` // Legacy
+		Product     productStrings `json:"product"`
+		OS          string         `json:"os"`
+		Revision    string         `json:"revision"`
+		FullRun     *bool          `json:"full_run"`
+		Status      string         `json:"status"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "browser_name", "product", "os", "revision", "full_run", "status"); err != nil {
+		return err
+	}
+	if len(data.Status) == 0 {
+		return errors.New(`Missing test status constraint property: "status"`)
+	}
+
+	product, products, err := p.parseProducts(data.Product, data.BrowserName)
+	if err != nil {
+		return err
+	}
+
+	os, err := parseOS(data.OS)
+	if err != nil {
+		return err
+	}
+
+	revision, err := parseRevision(data.Revision)
+	if err != nil {
+		return err
+	}
+
+	status, err := p.parseTestStatus(data.Status)
+	if err != nil {
+		return err
+	}
+
+	tse.Product = product
+	tse.Products = products
+	tse.OS = os
+	tse.Revision = revision
+	tse.FullRun = data.FullRun
+	tse.Status = status
+	return nil
+}
+
+// UnmarshalJSON for TestStatusNeq attempts to interpret a query atom as
+// {"product": <browser name>, "status": {"not": <status string>}}. The product
+// and optional "os", "revision" and "full_run" properties are as for
+// TestStatusEq.
 func (tsn *TestStatusNeq) UnmarshalJSON(b []byte) error {
+	return tsn.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (tsn *TestStatusNeq) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		BrowserName productStrings `json:"browser_name"` // Legacy
+		Product     productStrings `json:"product"`
+		OS          string         `json:"os"`
+		Revision    string         `json:"revision"`
+		FullRun     *bool          `json:"full_run"`
+		Status      struct {
+			Not string `json:"not"`
+		} `json:"status"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "browser_name", "product", "os", "revision", "full_run", "status", "status.not"); err != nil {
+		return err
+	}
+	if len(data.Status.Not) == 0 {
+		return errors.New(`Missing test status constraint property: "status.not"`)
+	}
+
+	product, products, err := p.parseProducts(data.Product, data.BrowserName)
+	if err != nil {
+		return err
+	}
+
+	os, err := parseOS(data.OS)
+	if err != nil {
+		return err
+	}
+
+	revision, err := parseRevision(data.Revision)
+	if err != nil {
+		return err
+	}
+
+	status, err := p.parseTestStatus(data.Status.Not)
+	if err != nil {
+		return err
+	}
+
+	tsn.Product = product
+	tsn.Products = products
+	tsn.OS = os
+	tsn.Revision = revision
+	tsn.FullRun = data.FullRun
+	tsn.Status = status
+	return nil
+}
+
+// UnmarshalJSON for AnyStatus attempts to interpret a query atom as
+// {"any_status": <status string>}.
+func (as *AnyStatus) UnmarshalJSON(b []byte) error {
+	return as.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (as *AnyStatus) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		AnyStatus string `json:"any_status"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "any_status"); err != nil {
+		return err
+	}
+	if len(data.AnyStatus) == 0 {
+		return errors.New(`Missing test status constraint property: "any_status"`)
+	}
+
+	status, err := p.parseTestStatus(data.AnyStatus)
+	if err != nil {
+		return err
+	}
+
+	as.Status = status
+	return nil
+}
+
+// UnmarshalJSON for HasScreenshot attempts to interpret a query atom as
+// {"has_screenshot": <browser name>}.
+func (hs *HasScreenshot) UnmarshalJSON(b []byte) error {
+	return hs.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (hs *HasScreenshot) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		HasScreenshot string `json:"has_screenshot"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "has_screenshot"); err != nil {
+		return err
+	}
+	if len(data.HasScreenshot) == 0 {
+		return errors.New(`Missing screenshot property: "has_screenshot"`)
+	}
+	browserName := canonicalizeStr(data.HasScreenshot)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	hs.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for SubtestTotal attempts to interpret a query atom as
+// {"subtest_total": {"gte": <int>, "lte": <int>}}, where at least one of the
+// bounds is required.
+func (st *SubtestTotal) UnmarshalJSON(b []byte) error {
+	var data struct {
+		SubtestTotal json.RawMessage `json:"subtest_total"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "subtest_total", "subtest_total.gte", "subtest_total.lte"); err != nil {
+		return err
+	}
+	if len(data.SubtestTotal) == 0 {
+		return errors.New(`Missing subtest total property: "subtest_total"`)
+	}
+
+	var bounds struct {
+		Gte *int `json:"gte"`
+		Lte *int `json:"lte"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SubtestTotal))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bounds); err != nil {
+		return fmt.Errorf(`Invalid subtest total property "subtest_total": %v`, err)
+	}
+	if bounds.Gte == nil && bounds.Lte == nil {
+		return errors.New(`Missing subtest total bound: "gte" or "lte"`)
+	}
+
+	min, max := 0, -1
+	if bounds.Gte != nil {
+		if *bounds.Gte < 0 {
+			return fmt.Errorf(`Invalid subtest total bound "gte": %d`, *bounds.Gte)
+		}
+		min = *bounds.Gte
+	}
+	if bounds.Lte != nil {
+		if *bounds.Lte < 0 {
+			return fmt.Errorf(`Invalid subtest total bound "lte": %d`, *bounds.Lte)
+		}
+		if *bounds.Lte < min {
+			return fmt.Errorf(`Invalid subtest total bounds: "gte" (%d) exceeds "lte" (%d)`, min, *bounds.Lte)
+		}
+		max = *bounds.Lte
+	}
+
+	st.Min = min
+	st.Max = max
+	return nil
+}
+
+// UnmarshalJSON for FlakinessRate attempts to interpret a query atom as
+// {"flakiness": {"browser_name": <browser name>, "above": <rate>}}, where the
+// rate is at least 0 and less than 1.
+func (fr *FlakinessRate) UnmarshalJSON(b []byte) error {
+	return fr.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (fr *FlakinessRate) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Flakiness json.RawMessage `json:"flakiness"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "flakiness", "flakiness.browser_name", "flakiness.above"); err != nil {
+		return err
+	}
+	if len(data.Flakiness) == 0 {
+		return errors.New(`Missing flakiness property: "flakiness"`)
+	}
+
+	var params struct {
+		BrowserName string          `json:"browser_name"`
+		Above       json.RawMessage `json:"above"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.Flakiness))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&params); err != nil {
+		return fmt.Errorf(`Invalid flakiness property "flakiness": %v`, err)
+	}
+	if params.BrowserName == "" {
+		return errors.New(`Missing flakiness property: "browser_name"`)
+	}
+	if len(params.Above) == 0 {
+		return errors.New(`Missing flakiness property: "above"`)
+	}
+	above, err := parseThreshold(params.Above)
+	if err != nil {
+		return fmt.Errorf(`Invalid flakiness rate "above": %v`, err)
+	}
+	if above < 0 || above >= 1 {
+		return fmt.Errorf(`Invalid flakiness rate "above": %v`, above)
+	}
+	browserName := canonicalizeStr(params.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	fr.BrowserName = browserName
+	fr.Above = above
+	return nil
+}
+
+// UnmarshalJSON for SubtestPassRatio attempts to interpret a query atom as
+// {"subtest_pass_ratio": {"browser_name": <browser name>, "below": <ratio>,
+// "above": <ratio>}}, where at least one of the ratios is given, each ratio is
+// within [0, 1], and above is less than below.
+func (spr *SubtestPassRatio) UnmarshalJSON(b []byte) error {
+	return spr.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (spr *SubtestPassRatio) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		SubtestPassRatio json.RawMessage `json:"subtest_pass_ratio"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "subtest_pass_ratio", "subtest_pass_ratio.browser_name", "subtest_pass_ratio.below", "subtest_pass_ratio.above"); err != nil {
+		return err
+	}
+	if len(data.SubtestPassRatio) == 0 {
+		return errors.New(`Missing subtest pass ratio property: "subtest_pass_ratio"`)
+	}
+
+	var params struct {
+		BrowserName string          `json:"browser_name"`
+		Below       json.RawMessage `json:"below"`
+		Above       json.RawMessage `json:"above"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SubtestPassRatio))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&params); err != nil {
+		return fmt.Errorf(`Invalid subtest pass ratio property "subtest_pass_ratio": %v`, err)
+	}
+	if params.BrowserName == "" {
+		return errors.New(`Missing subtest pass ratio property: "browser_name"`)
+	}
+	if len(params.Below) == 0 && len(params.Above) == 0 {
+		return errors.New(`Missing subtest pass ratio property: "below" or "above"`)
+	}
+	below, above := -1.0, -1.0
+	if len(params.Below) > 0 {
+		if below, err = parseThreshold(params.Below); err != nil {
+			return fmt.Errorf(`Invalid subtest pass ratio "below": %v`, err)
+		}
+		if below < 0 || below > 1 {
+			return fmt.Errorf(`Invalid subtest pass ratio "below": %v`, below)
+		}
+	}
+	if len(params.Above) > 0 {
+		if above, err = parseThreshold(params.Above); err != nil {
+			return fmt.Errorf(`Invalid subtest pass ratio "above": %v`, err)
+		}
+		if above < 0 || above > 1 {
+			return fmt.Errorf(`Invalid subtest pass ratio "above": %v`, above)
+		}
+	}
+	if len(params.Below) > 0 && len(params.Above) > 0 && above >= below {
+		return fmt.Errorf(`Invalid subtest pass ratio range: "above" %v is not less than "below" %v`, above, below)
+	}
+	browserName := canonicalizeStr(params.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	spr.BrowserName = browserName
+	spr.Below = below
+	spr.Above = above
+	return nil
+}
+
+// UnmarshalJSON for LongTimeout attempts to interpret a query atom as
+// {"long_timeout": true}. Tests without a long timeout are matched by negation,
+// i.e., {"not": {"long_timeout": true}}.
+func (lt *LongTimeout) UnmarshalJSON(b []byte) error {
+	var data struct {
+		LongTimeout *bool `json:"long_timeout"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "long_timeout"); err != nil {
+		return err
+	}
+	if data.LongTimeout == nil {
+		return errors.New(`Missing long timeout property: "long_timeout"`)
+	}
+	if !*data.LongTimeout {
+		return errors.New(`Invalid long timeout property "long_timeout": must be true; use negation for tests without a long timeout`)
+	}
+	return nil
+}
+
+// UnmarshalJSON for HasMetadataField attempts to interpret a query atom as
+// {"has_metadata": <field name>}, where the field name is one of
+// MetadataFields.
+func (hmf *HasMetadataField) UnmarshalJSON(b []byte) error {
+	var data struct {
+		HasMetadata string `json:"has_metadata"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "has_metadata"); err != nil {
+		return err
+	}
+	if len(data.HasMetadata) == 0 {
+		return errors.New(`Missing metadata field property: "has_metadata"`)
+	}
+	field := canonicalizeStr(data.HasMetadata)
+	for _, known := range MetadataFields {
+		if field == known {
+			hmf.Field = field
+			return nil
+		}
+	}
+	return fmt.Errorf(`Invalid metadata field "%s": must be one of %s`, data.HasMetadata, strings.Join(MetadataFields, ", "))
+}
+
+// UnmarshalJSON for RegressedSince attempts to interpret a query atom as
+// {"regressed_since": <baseline run label>}.
+func (rs *RegressedSince) UnmarshalJSON(b []byte) error {
+	var data struct {
+		RegressedSince string `json:"regressed_since"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "regressed_since"); err != nil {
+		return err
+	}
+	if len(data.RegressedSince) == 0 {
+		return errors.New(`Missing baseline label property: "regressed_since"`)
+	}
+
+	rs.Label = data.RegressedSince
+	return nil
+}
+
+// UnmarshalJSON for AnyChanged attempts to interpret a query atom as
+// {"any_changed": <baseline run label>} or {"any_changed": <baseline run ID>}.
+func (ac *AnyChanged) UnmarshalJSON(b []byte) error {
+	var data struct {
+		AnyChanged json.RawMessage `json:"any_changed"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "any_changed"); err != nil {
+		return err
+	}
+	if len(data.AnyChanged) == 0 {
+		return errors.New(`Missing baseline property: "any_changed"`)
+	}
+
+	var label string
+	if err := json.Unmarshal(data.AnyChanged, &label); err == nil {
+		if len(label) == 0 {
+			return errors.New(`Missing baseline property: "any_changed"`)
+		}
+		ac.Label, ac.Run = label, 0
+		return nil
+	}
+	var run int64
+	if err := json.Unmarshal(data.AnyChanged, &run); err != nil || run <= 0 {
+		return errors.New(`Invalid baseline property "any_changed": must be a run label or a positive run ID`)
+	}
+	ac.Label, ac.Run = "", run
+	return nil
+}
+
+// UnmarshalJSON for SubtestCountDelta attempts to interpret a query atom as
+// {"subtest_delta": {"from": <browser name>, "to": <browser name>,
+// "changed": <bool>}}, where "changed" is optional and defaults to true.
+func (scd *SubtestCountDelta) UnmarshalJSON(b []byte) error {
+	return scd.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (scd *SubtestCountDelta) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		SubtestDelta json.RawMessage `json:"subtest_delta"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "subtest_delta", "subtest_delta.from", "subtest_delta.to", "subtest_delta.changed"); err != nil {
+		return err
+	}
+	if len(data.SubtestDelta) == 0 {
+		return errors.New(`Missing subtest delta property: "subtest_delta"`)
+	}
+
+	var props struct {
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Changed *bool  `json:"changed"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SubtestDelta))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid subtest delta property "subtest_delta": %v`, err)
+	}
+	if len(props.From) == 0 {
+		return errors.New(`Missing subtest delta property: "subtest_delta.from"`)
+	}
+	if len(props.To) == 0 {
+		return errors.New(`Missing subtest delta property: "subtest_delta.to"`)
+	}
+	from := canonicalizeStr(props.From)
+	if err := p.checkBrowserName(from); err != nil {
+		return err
+	}
+	to := canonicalizeStr(props.To)
+	if err := p.checkBrowserName(to); err != nil {
+		return err
+	}
+
+	scd.From = from
+	scd.To = to
+	scd.Changed = props.Changed == nil || *props.Changed
+	return nil
+}
+
+// UnmarshalJSON for SameRender attempts to interpret a query atom as
+// {"same_render": {"a": <browser name>, "b": <browser name>}}.
+func (sr *SameRender) UnmarshalJSON(b []byte) error {
+	return sr.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (sr *SameRender) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		SameRender json.RawMessage `json:"same_render"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "same_render", "same_render.a", "same_render.b"); err != nil {
+		return err
+	}
+	if len(data.SameRender) == 0 {
+		return errors.New(`Missing same render property: "same_render"`)
+	}
+
+	var props struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SameRender))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid same render property "same_render": %v`, err)
+	}
+	if len(props.A) == 0 {
+		return errors.New(`Missing same render property: "same_render.a"`)
+	}
+	if len(props.B) == 0 {
+		return errors.New(`Missing same render property: "same_render.b"`)
+	}
+	left := canonicalizeStr(props.A)
+	if err := p.checkBrowserName(left); err != nil {
+		return err
+	}
+	right := canonicalizeStr(props.B)
+	if err := p.checkBrowserName(right); err != nil {
+		return err
+	}
+
+	sr.LeftBrowser = left
+	sr.RightBrowser = right
+	return nil
+}
+
+// UnmarshalJSON for ChangedInPR attempts to interpret a query atom as
+// {"changed_in_pr": true}.
+func (c *ChangedInPR) UnmarshalJSON(b []byte) error {
+	var data struct {
+		ChangedInPR *bool `json:"changed_in_pr"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "changed_in_pr"); err != nil {
+		return err
+	}
+	if data.ChangedInPR == nil {
+		return errors.New(`Missing changed in PR property: "changed_in_pr"`)
+	}
+	if !*data.ChangedInPR {
+		return errors.New(`Invalid changed in PR property "changed_in_pr": must be true; use negation for unchanged tests`)
+	}
+	return nil
+}
+
+// UnmarshalJSON for BrowsersFailing attempts to interpret a query atom as
+// {"browsers_failing": {<"eq", "gte" or "lte">: <int>}}, with exactly one
+// comparison.
+func (bf *BrowsersFailing) UnmarshalJSON(b []byte) error {
+	var data struct {
+		BrowsersFailing json.RawMessage `json:"browsers_failing"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "browsers_failing", "browsers_failing.eq", "browsers_failing.gte", "browsers_failing.lte"); err != nil {
+		return err
+	}
+	if len(data.BrowsersFailing) == 0 {
+		return errors.New(`Missing browsers failing property: "browsers_failing"`)
+	}
+
+	var bounds struct {
+		Eq  *int `json:"eq"`
+		Gte *int `json:"gte"`
+		Lte *int `json:"lte"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.BrowsersFailing))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bounds); err != nil {
+		return fmt.Errorf(`Invalid browsers failing property "browsers_failing": %v`, err)
+	}
+	var comparisons []string
+	var count int
+	for _, c := range []struct {
+		comparison string
+		bound      *int
+	}{
+		{ComparisonEq, bounds.Eq},
+		{ComparisonGte, bounds.Gte},
+		{ComparisonLte, bounds.Lte},
+	} {
+		if c.bound != nil {
+			comparisons = append(comparisons, c.comparison)
+			count = *c.bound
+		}
+	}
+	if len(comparisons) != 1 {
+		return errors.New(`Invalid browsers failing property "browsers_failing": requires exactly one of "eq", "gte" or "lte"`)
+	}
+	if count < 0 {
+		return fmt.Errorf(`Invalid browsers failing bound "%s": %d`, comparisons[0], count)
+	}
+
+	bf.Count = count
+	bf.Comparison = comparisons[0]
+	return nil
+}
+
+// UnmarshalJSON for FirstSeen attempts to interpret a query atom as
+// {"first_seen": {"after": <RFC 3339 timestamp>}}.
+func (fs *FirstSeen) UnmarshalJSON(b []byte) error {
+	var data struct {
+		FirstSeen json.RawMessage `json:"first_seen"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "first_seen", "first_seen.after"); err != nil {
+		return err
+	}
+	if len(data.FirstSeen) == 0 {
+		return errors.New(`Missing first seen property: "first_seen"`)
+	}
+
+	var bounds struct {
+		After *string `json:"after"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.FirstSeen))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bounds); err != nil {
+		return fmt.Errorf(`Invalid first seen property "first_seen": %v`, err)
+	}
+	if bounds.After == nil {
+		return errors.New(`Missing first seen property: "first_seen.after"`)
+	}
+	since, err := time.Parse(time.RFC3339, *bounds.After)
+	if err != nil {
+		return fmt.Errorf(`Invalid first seen timestamp "%s": must be RFC 3339 (e.g., "2024-01-01T00:00:00Z")`, *bounds.After)
+	}
+
+	fs.Since = since
+	return nil
+}
+
+// UnmarshalJSON for CoverageCount attempts to interpret a query atom as
+// {"coverage": {"gte": <int>}}.
+func (cc *CoverageCount) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Coverage json.RawMessage `json:"coverage"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "coverage", "coverage.gte"); err != nil {
+		return err
+	}
+	if len(data.Coverage) == 0 {
+		return errors.New(`Missing coverage property: "coverage"`)
+	}
+
+	var bounds struct {
+		Gte *int `json:"gte"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.Coverage))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&bounds); err != nil {
+		return fmt.Errorf(`Invalid coverage property "coverage": %v`, err)
+	}
+	if bounds.Gte == nil {
+		return errors.New(`Missing coverage property: "coverage.gte"`)
+	}
+	if *bounds.Gte < 0 {
+		return fmt.Errorf(`Invalid coverage bound "gte": %d`, *bounds.Gte)
+	}
+
+	cc.Min = *bounds.Gte
+	return nil
+}
+
+// UnmarshalJSON for WorseThanMedian attempts to interpret a query atom as
+// {"worse_than_median": <browser name>}.
+func (wtm *WorseThanMedian) UnmarshalJSON(b []byte) error {
+	return wtm.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (wtm *WorseThanMedian) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		WorseThanMedian string `json:"worse_than_median"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "worse_than_median"); err != nil {
+		return err
+	}
+	if len(data.WorseThanMedian) == 0 {
+		return errors.New(`Missing browser property: "worse_than_median"`)
+	}
+	browserName := canonicalizeStr(data.WorseThanMedian)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	wtm.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for SubtestOnlyIn attempts to interpret a query atom as
+// {"subtest_only_in": {"browser_name": <browser name>, "subtest": <string>}}.
+func (soi *SubtestOnlyIn) UnmarshalJSON(b []byte) error {
+	return soi.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (soi *SubtestOnlyIn) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		SubtestOnlyIn json.RawMessage `json:"subtest_only_in"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "subtest_only_in", "subtest_only_in.browser_name", "subtest_only_in.subtest"); err != nil {
+		return err
+	}
+	if len(data.SubtestOnlyIn) == 0 {
+		return errors.New(`Missing subtest only in property: "subtest_only_in"`)
+	}
+
+	var props struct {
+		BrowserName string `json:"browser_name"`
+		Subtest     string `json:"subtest"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SubtestOnlyIn))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid subtest only in property "subtest_only_in": %v`, err)
+	}
+	if len(props.BrowserName) == 0 {
+		return errors.New(`Missing subtest only in property: "subtest_only_in.browser_name"`)
+	}
+	if len(props.Subtest) == 0 {
+		return errors.New(`Missing subtest only in property: "subtest_only_in.subtest"`)
+	}
+	browserName := canonicalizeStr(props.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	soi.BrowserName = browserName
+	soi.Subtest = props.Subtest
+	return nil
+}
+
+// UnmarshalJSON for PresentInAll attempts to interpret a query atom as
+// {"present_in_all": true}.
+func (pia *PresentInAll) UnmarshalJSON(b []byte) error {
+	var data struct {
+		PresentInAll *bool `json:"present_in_all"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "present_in_all"); err != nil {
+		return err
+	}
+	if data.PresentInAll == nil {
+		return errors.New(`Missing presence property: "present_in_all"`)
+	}
+	if !*data.PresentInAll {
+		return errors.New(`Invalid presence property "present_in_all": must be true; use negation for tests missing from some browser`)
+	}
+	return nil
+}
+
+// UnmarshalJSON for Skipped attempts to interpret a query atom as
+// {"skipped": <browser name>}.
+func (s *Skipped) UnmarshalJSON(b []byte) error {
+	return s.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (s *Skipped) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Skipped string `json:"skipped"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "skipped"); err != nil {
+		return err
+	}
+	if len(data.Skipped) == 0 {
+		return errors.New(`Missing skipped property: "skipped"`)
+	}
+	browserName := canonicalizeStr(data.Skipped)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	s.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for Intermittent attempts to interpret a query atom as
+// {"intermittent": <browser name>}.
+func (i *Intermittent) UnmarshalJSON(b []byte) error {
+	return i.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (i *Intermittent) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Intermittent string `json:"intermittent"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "intermittent"); err != nil {
+		return err
+	}
+	if len(data.Intermittent) == 0 {
+		return errors.New(`Missing intermittent property: "intermittent"`)
+	}
+	browserName := canonicalizeStr(data.Intermittent)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	i.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for AssertCount attempts to interpret a query atom as
+// {"asserts": {"browser_name": <browser name>, "eq": <int>}}, or with "gte"
+// and/or "lte" bounds in place of "eq".
+func (ac *AssertCount) UnmarshalJSON(b []byte) error {
+	return ac.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (ac *AssertCount) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Asserts json.RawMessage `json:"asserts"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "asserts", "asserts.browser_name", "asserts.eq", "asserts.gte", "asserts.lte"); err != nil {
+		return err
+	}
+	if len(data.Asserts) == 0 {
+		return errors.New(`Missing asserts property: "asserts"`)
+	}
+
+	var props struct {
+		BrowserName string `json:"browser_name"`
+		Eq          *int   `json:"eq"`
+		Gte         *int   `json:"gte"`
+		Lte         *int   `json:"lte"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.Asserts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid asserts property "asserts": %v`, err)
+	}
+	if len(props.BrowserName) == 0 {
+		return errors.New(`Missing asserts property: "asserts.browser_name"`)
+	}
+	browserName := canonicalizeStr(props.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	min, max := 0, -1
+	if props.Eq != nil {
+		if props.Gte != nil || props.Lte != nil {
+			return errors.New(`Invalid asserts bounds: "eq" cannot be combined with "gte" or "lte"`)
+		}
+		if *props.Eq < 0 {
+			return fmt.Errorf(`Invalid asserts bound "eq": %d`, *props.Eq)
+		}
+		min, max = *props.Eq, *props.Eq
+	} else {
+		if props.Gte == nil && props.Lte == nil {
+			return errors.New(`Missing asserts bound: "eq", "gte" or "lte"`)
+		}
+		if props.Gte != nil {
+			if *props.Gte < 0 {
+				return fmt.Errorf(`Invalid asserts bound "gte": %d`, *props.Gte)
+			}
+			min = *props.Gte
+		}
+		if props.Lte != nil {
+			if *props.Lte < 0 {
+				return fmt.Errorf(`Invalid asserts bound "lte": %d`, *props.Lte)
+			}
+			if *props.Lte < min {
+				return fmt.Errorf(`Invalid asserts bounds: "gte" (%d) exceeds "lte" (%d)`, min, *props.Lte)
+			}
+			max = *props.Lte
+		}
+	}
+
+	ac.BrowserName = browserName
+	ac.Min = min
+	ac.Max = max
+	return nil
+}
+
+// UnmarshalJSON for Unexpected attempts to interpret a query atom as
+// {"unexpected": <browser name>}.
+func (u *Unexpected) UnmarshalJSON(b []byte) error {
+	return u.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (u *Unexpected) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Unexpected string `json:"unexpected"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "unexpected"); err != nil {
+		return err
+	}
+	if len(data.Unexpected) == 0 {
+		return errors.New(`Missing unexpected property: "unexpected"`)
+	}
+	browserName := canonicalizeStr(data.Unexpected)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	u.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for SubtestMajority attempts to interpret a query atom as
+// {"subtest_majority": {"browser_name": <browser name>, "status": <status>}}.
+func (sm *SubtestMajority) UnmarshalJSON(b []byte) error {
+	return sm.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (sm *SubtestMajority) unmarshal(p *parser, b []byte) error {
 	var data struct {
-		BrowserName string `json:"browser_name"` // Legacy
-		Product     string `json:"product"`
-		Status      struct {
-			Not string `json:"not"`
-		} `json:"status"`
+		SubtestMajority json.RawMessage `json:"subtest_majority"`
 	}
 	err := json.Unmarshal(b, &data)
 	if err != nil {
 		return err
 	}
-	if data.Product == "" && data.BrowserName != "" {
-		data.Product = data.BrowserName
+	if err := checkNotNull(b, "subtest_majority", "subtest_majority.browser_name", "subtest_majority.status"); err != nil {
+		return err
 	}
-	if len(data.Status.Not) == 0 {
-		return errors.New(`Missing test status constraint property: "status.not"`)
+	if len(data.SubtestMajority) == 0 {
+		return errors.New(`Missing subtest majority property: "subtest_majority"`)
 	}
 
-	var product *shared.ProductSpec
-	if data.Product != "" {
-		p, err := shared.ParseProductSpec(data.Product)
-		if err != nil {
-			return err
-		}
-		product = &p
+	var props struct {
+		BrowserName string `json:"browser_name"`
+		Status      string `json:"status"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.SubtestMajority))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid subtest majority property "subtest_majority": %v`, err)
+	}
+	if len(props.BrowserName) == 0 {
+		return errors.New(`Missing subtest majority property: "subtest_majority.browser_name"`)
+	}
+	if len(props.Status) == 0 {
+		return errors.New(`Missing subtest majority property: "subtest_majority.status"`)
+	}
+	browserName := canonicalizeStr(props.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+	status, err := p.parseTestStatus(props.Status)
+	if err != nil {
+		return err
 	}
 
-	statusStr := strings.ToUpper(data.Status.Not)
-	status := shared.TestStatusValueFromString(statusStr)
-	statusStr2 := status.String()
-	if statusStr != statusStr2 {
-		return fmt.Errorf(`Invalid test status: "%s"`, data.Status)
+	sm.BrowserName = browserName
+	sm.Status = status
+	return nil
+}
+
+// UnmarshalJSON for HarnessMessage attempts to interpret a query atom as
+// {"harness_message": {"browser_name": <browser name>, "pattern": <string>}}.
+func (hm *HarnessMessage) UnmarshalJSON(b []byte) error {
+	return hm.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (hm *HarnessMessage) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		HarnessMessage json.RawMessage `json:"harness_message"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "harness_message", "harness_message.browser_name", "harness_message.pattern"); err != nil {
+		return err
+	}
+	if len(data.HarnessMessage) == 0 {
+		return errors.New(`Missing harness message property: "harness_message"`)
 	}
 
-	tsn.Product = product
-	tsn.Status = status
+	var props struct {
+		BrowserName string `json:"browser_name"`
+		Pattern     string `json:"pattern"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.HarnessMessage))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&props); err != nil {
+		return fmt.Errorf(`Invalid harness message property "harness_message": %v`, err)
+	}
+	if len(props.BrowserName) == 0 {
+		return errors.New(`Missing harness message property: "harness_message.browser_name"`)
+	}
+	if len(props.Pattern) == 0 {
+		return errors.New(`Missing harness message property: "harness_message.pattern"`)
+	}
+	browserName := canonicalizeStr(props.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	hm.BrowserName = browserName
+	hm.Pattern = props.Pattern
+	return nil
+}
+
+// UnmarshalJSON for AllSubtestsPass attempts to interpret a query atom as
+// {"all_subtests_pass": <browser name>}.
+func (asp *AllSubtestsPass) UnmarshalJSON(b []byte) error {
+	return asp.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (asp *AllSubtestsPass) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		AllSubtestsPass string `json:"all_subtests_pass"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "all_subtests_pass"); err != nil {
+		return err
+	}
+	if len(data.AllSubtestsPass) == 0 {
+		return errors.New(`Missing all subtests pass property: "all_subtests_pass"`)
+	}
+	browserName := canonicalizeStr(data.AllSubtestsPass)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	asp.BrowserName = browserName
+	return nil
+}
+
+// UnmarshalJSON for RefMatch attempts to interpret a query atom as
+// {"ref": {"browser_name": <browser name>, "expect": <"match" or "mismatch">}}.
+func (rm *RefMatch) UnmarshalJSON(b []byte) error {
+	return rm.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (rm *RefMatch) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Ref json.RawMessage `json:"ref"`
+	}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNull(b, "ref", "ref.browser_name", "ref.expect"); err != nil {
+		return err
+	}
+	if len(data.Ref) == 0 {
+		return errors.New(`Missing reftest property: "ref"`)
+	}
+
+	var params struct {
+		BrowserName string `json:"browser_name"`
+		Expect      string `json:"expect"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data.Ref))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&params); err != nil {
+		return fmt.Errorf(`Invalid reftest property "ref": %v`, err)
+	}
+	if params.BrowserName == "" {
+		return errors.New(`Missing reftest property: "browser_name"`)
+	}
+	if params.Expect == "" {
+		return errors.New(`Missing reftest property: "expect"`)
+	}
+	expected := canonicalizeStr(params.Expect)
+	if expected != RefExpectMatch && expected != RefExpectMismatch {
+		return fmt.Errorf(`Invalid reftest expectation "expect": "%s"`, params.Expect)
+	}
+	browserName := canonicalizeStr(params.BrowserName)
+	if err := p.checkBrowserName(browserName); err != nil {
+		return err
+	}
+
+	rm.BrowserName = browserName
+	rm.Expected = expected
 	return nil
 }
 
 // UnmarshalJSON for AbstractNot attempts to interpret a query atom as
-// {"not": <abstract query>}.
+// {"not": <abstract query>}, or as {"not": [<abstract queries>]}, shorthand for
+// the negation of their disjunction (i.e., none of the queries match).
 func (n *AbstractNot) UnmarshalJSON(b []byte) error {
+	return n.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (n *AbstractNot) unmarshal(p *parser, b []byte) error {
 	var data struct {
 		Not json.RawMessage `json:"not"`
 	}
@@ -438,11 +3001,34 @@ func (n *AbstractNot) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "not"); err != nil {
+		return err
+	}
 	if len(data.Not) == 0 {
-		return errors.New(`Missing negation property: "not"`)
+		return withDetail(ErrMissingQuery, `: "not"`)
+	}
+
+	if i := skipJSONSpace(data.Not, 0); i < len(data.Not) && data.Not[i] == '[' {
+		var msgs []json.RawMessage
+		if err := json.Unmarshal(data.Not, &msgs); err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return withDetail(ErrMissingQuery, `: "not"`)
+		}
+		qs := make([]AbstractQuery, 0, len(msgs))
+		for i, msg := range msgs {
+			q, err := p.unmarshalItem(fmt.Sprintf("not[%d]", i), msg)
+			if err != nil {
+				return err
+			}
+			qs = append(qs, q)
+		}
+		n.Arg = AbstractOr{Args: qs}
+		return nil
 	}
 
-	q, err := unmarshalQ(data.Not)
+	q, err := p.unmarshalItem("not", data.Not)
 	n.Arg = q
 	return err
 }
@@ -450,24 +3036,30 @@ func (n *AbstractNot) UnmarshalJSON(b []byte) error {
 // UnmarshalJSON for AbstractOr attempts to interpret a query atom as
 // {"or": [<abstract queries>]}.
 func (o *AbstractOr) UnmarshalJSON(b []byte) error {
-	var data struct {
-		Or []json.RawMessage `json:"or"`
-	}
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
-	}
-	if len(data.Or) == 0 {
-		return errors.New(`Missing disjunction property: "or"`)
-	}
+	return o.unmarshal(newParser(ParseOpts{}), b)
+}
 
-	qs := make([]AbstractQuery, 0, len(data.Or))
-	for _, msg := range data.Or {
-		q, err := unmarshalQ(msg)
+// unmarshal for AbstractOr decodes the disjunction array element by element,
+// rather than first unmarshalling it into a []json.RawMessage, so that very
+// large disjunctions need not be held in memory twice.
+func (o *AbstractOr) unmarshal(p *parser, b []byte) error {
+	var qs []AbstractQuery
+	n, err := streamArrayProperty(b, "or", func(i int, msg json.RawMessage) error {
+		if i == 0 {
+			qs = qs[:0]
+		}
+		q, err := p.unmarshalItem(fmt.Sprintf("or[%d]", i), msg)
 		if err != nil {
 			return err
 		}
 		qs = append(qs, q)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return withDetail(ErrMissingQuery, `: "or"`)
 	}
 	o.Args = qs
 	return nil
@@ -476,6 +3068,10 @@ func (o *AbstractOr) UnmarshalJSON(b []byte) error {
 // UnmarshalJSON for AbstractAnd attempts to interpret a query atom as
 // {"and": [<abstract queries>]}.
 func (a *AbstractAnd) UnmarshalJSON(b []byte) error {
+	return a.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (a *AbstractAnd) unmarshal(p *parser, b []byte) error {
 	var data struct {
 		And []json.RawMessage `json:"and"`
 	}
@@ -483,13 +3079,16 @@ func (a *AbstractAnd) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "and"); err != nil {
+		return err
+	}
 	if len(data.And) == 0 {
-		return errors.New(`Missing conjunction property: "and"`)
+		return withDetail(ErrMissingQuery, `: "and"`)
 	}
 
 	qs := make([]AbstractQuery, 0, len(data.And))
-	for _, msg := range data.And {
-		q, err := unmarshalQ(msg)
+	for i, msg := range data.And {
+		q, err := p.unmarshalItem(fmt.Sprintf("and[%d]", i), msg)
 		if err != nil {
 			return err
 		}
@@ -502,6 +3101,10 @@ func (a *AbstractAnd) UnmarshalJSON(b []byte) error {
 // UnmarshalJSON for AbstractExists attempts to interpret a query atom as
 // {"exists": [<abstract queries>]}.
 func (e *AbstractExists) UnmarshalJSON(b []byte) error {
+	return e.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (e *AbstractExists) unmarshal(p *parser, b []byte) error {
 	var data struct {
 		Exists []json.RawMessage `json:"exists"`
 	}
@@ -509,13 +3112,16 @@ func (e *AbstractExists) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "exists"); err != nil {
+		return err
+	}
 	if len(data.Exists) == 0 {
-		return errors.New(`Missing conjunction property: "exists"`)
+		return withDetail(ErrMissingQuery, `: "exists"`)
 	}
 
 	qs := make([]AbstractQuery, 0, len(data.Exists))
-	for _, msg := range data.Exists {
-		q, err := unmarshalQ(msg)
+	for i, msg := range data.Exists {
+		q, err := p.unmarshalItem(fmt.Sprintf("exists[%d]", i), msg)
 		if err != nil {
 			return err
 		}
@@ -528,6 +3134,10 @@ func (e *AbstractExists) UnmarshalJSON(b []byte) error {
 // UnmarshalJSON for AbstractSequential attempts to interpret a query atom as
 // {"exists": [<abstract queries>]}.
 func (e *AbstractSequential) UnmarshalJSON(b []byte) error {
+	return e.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (e *AbstractSequential) unmarshal(p *parser, b []byte) error {
 	var data struct {
 		Sequential []json.RawMessage `json:"sequential"`
 	}
@@ -535,13 +3145,16 @@ func (e *AbstractSequential) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "sequential"); err != nil {
+		return err
+	}
 	if len(data.Sequential) == 0 {
-		return errors.New(`Missing conjunction property: "sequential"`)
+		return withDetail(ErrMissingQuery, `: "sequential"`)
 	}
 
 	qs := make([]AbstractQuery, 0, len(data.Sequential))
-	for _, msg := range data.Sequential {
-		q, err := unmarshalQ(msg)
+	for i, msg := range data.Sequential {
+		q, err := p.unmarshalItem(fmt.Sprintf("sequential[%d]", i), msg)
 		if err != nil {
 			return err
 		}
@@ -554,6 +3167,10 @@ func (e *AbstractSequential) UnmarshalJSON(b []byte) error {
 // UnmarshalJSON for AbstractCount attempts to interpret a query atom as
 // {"count": int, "where": query}.
 func (c *AbstractCount) UnmarshalJSON(b []byte) error {
+	return c.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (c *AbstractCount) unmarshal(p *parser, b []byte) error {
 	var data struct {
 		Count json.RawMessage `json:"count"`
 		Where json.RawMessage `json:"where"`
@@ -562,74 +3179,835 @@ func (c *AbstractCount) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "count", "where"); err != nil {
+		return err
+	}
 	if len(data.Count) == 0 {
 		return errors.New(`Missing count property: "count"`)
 	}
 	if len(data.Where) == 0 {
-		return errors.New(`Missing count property: "where"`)
+		return withDetail(ErrMissingQuery, `: "where"`)
 	}
 
 	err = json.Unmarshal(data.Count, &c.Count)
 	if err != nil {
 		return err
 	}
-	c.Where, err = unmarshalQ(data.Where)
+	c.Where, err = p.unmarshalItem("where", data.Where)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// quantified is an intermediate representation for quantified query atoms; it
+// unmarshals to an AbstractExists or AbstractAll, depending on its quantifier.
+type quantified struct {
+	AbstractQuery
+}
+
+// UnmarshalJSON for quantified attempts to interpret a query atom as
+// {"quantifier": <"any" or "all">, "where": [<abstract queries>]}, producing an
+// AbstractExists or AbstractAll respectively. The quantifier defaults to "any".
+func (qq *quantified) UnmarshalJSON(b []byte) error {
+	return qq.unmarshal(newParser(ParseOpts{}), b)
+}
+
+func (qq *quantified) unmarshal(p *parser, b []byte) error {
+	var data struct {
+		Quantifier *string           `json:"quantifier"`
+		Where      []json.RawMessage `json:"where"`
+		Count      json.RawMessage   `json:"count"`
+	}
+	err := json.Unmarshal(b, &data)
 	if err != nil {
 		return err
 	}
+	if err := checkNotNull(b, "quantifier", "where"); err != nil {
+		return err
+	}
+	if len(data.Count) > 0 {
+		return errors.New(`Unexpected quantified query property: "count"`)
+	}
+	if len(data.Where) == 0 {
+		return withDetail(ErrMissingQuery, `: "where"`)
+	}
+
+	qs := make([]AbstractQuery, 0, len(data.Where))
+	for i, msg := range data.Where {
+		q, err := p.unmarshalItem(fmt.Sprintf("where[%d]", i), msg)
+		if err != nil {
+			return err
+		}
+		qs = append(qs, q)
+	}
+
+	quantifier := "any"
+	if data.Quantifier != nil {
+		quantifier = strings.ToLower(*data.Quantifier)
+	}
+	switch quantifier {
+	case "any":
+		qq.AbstractQuery = AbstractExists{Args: qs}
+	case "all":
+		qq.AbstractQuery = AbstractAll{Args: qs}
+	default:
+		return fmt.Errorf(`Invalid quantifier: "%s"`, *data.Quantifier)
+	}
 	return nil
 }
 
+// atomParser pairs the schema of a query atom with a function that attempts to
+// parse a query fragment as that atom.
+type atomParser struct {
+	schema AtomSchema
+	parse  func(*parser, []byte) (AbstractQuery, error)
+}
+
+// atomParsers is the ordered list of atoms that unmarshalQ attempts to parse a
+// query fragment as; the first successful parse wins.
+var atomParsers = []atomParser{
+	{
+		AtomSchema{"pattern", []string{"pattern"}, "Test name contains the given substring"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var tnp TestNamePattern
+			err := json.Unmarshal(b, &tnp)
+			return tnp, err
+		},
+	},
+	{
+		AtomSchema{"path", []string{"path"}, "Test name starts with the given path prefix"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var tp TestPath
+			err := json.Unmarshal(b, &tp)
+			return tp, err
+		},
+	},
+	{
+		AtomSchema{"test_name_in", []string{"test_name_in"}, "Test name is exactly one of the given names"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var tni TestNameIn
+			err := json.Unmarshal(b, &tni)
+			return tni, err
+		},
+	},
+	{
+		AtomSchema{"manifest_paths", []string{"manifest_paths", "prefix"}, "Test name is exactly one of the given paths or, with prefix, begins with one of them"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var mp ManifestPaths
+			err := json.Unmarshal(b, &mp)
+			return mp, err
+		},
+	},
+	{
+		AtomSchema{"shard", []string{"shard"}, "Test is assigned, by a hash of its name, to the given shard (index) of the given number (total) of shards"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var s Shard
+			err := json.Unmarshal(b, &s)
+			return s, err
+		},
+	},
+	{
+		AtomSchema{"status", []string{"status"}, "Test status equals the given status, optionally for a specific product"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var tse TestStatusEq
+			err := unmarshalWith(p, b, &tse)
+			return tse, err
+		},
+	},
+	{
+		AtomSchema{"status.not", []string{"status.not"}, "Test status does not equal the given status, optionally for a specific product"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var tsn TestStatusNeq
+			err := unmarshalWith(p, b, &tsn)
+			return tsn, err
+		},
+	},
+	{
+		AtomSchema{"any_status", []string{"any_status"}, "Test status equals the given status in at least one run"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var as AnyStatus
+			err := unmarshalWith(p, b, &as)
+			return as, err
+		},
+	},
+	{
+		AtomSchema{"has_screenshot", []string{"has_screenshot"}, "Test has a screenshot artifact in a run of the given browser"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var hs HasScreenshot
+			err := unmarshalWith(p, b, &hs)
+			return hs, err
+		},
+	},
+	{
+		AtomSchema{"regressed_since", []string{"regressed_since"}, "Test status is worse, in some run, than in the baseline run with the given label"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var rs RegressedSince
+			err := json.Unmarshal(b, &rs)
+			return rs, err
+		},
+	},
+	{
+		AtomSchema{"any_changed", []string{"any_changed"}, "Test status differs, in some browser, from the browser's baseline run with the given label (or the baseline run with the given ID)"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var ac AnyChanged
+			err := json.Unmarshal(b, &ac)
+			return ac, err
+		},
+	},
+	{
+		AtomSchema{"subtest_delta", []string{"subtest_delta.from", "subtest_delta.to", "subtest_delta.changed"}, "The number of subtests of the test differs (or, with changed false, does not differ) between runs of the from and to browsers"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var scd SubtestCountDelta
+			err := unmarshalWith(p, b, &scd)
+			return scd, err
+		},
+	},
+	{
+		AtomSchema{"same_render", []string{"same_render.a", "same_render.b"}, "The screenshot hash of the test is the same in runs of the a and b browsers"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var sr SameRender
+			err := unmarshalWith(p, b, &sr)
+			return sr, err
+		},
+	},
+	{
+		AtomSchema{"changed_in_pr", []string{"changed_in_pr"}, "Test status differs between the pr_head and pr_base runs"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var c ChangedInPR
+			err := json.Unmarshal(b, &c)
+			return c, err
+		},
+	},
+	{
+		AtomSchema{"subtest_total", []string{"subtest_total"}, "Total number of subtests, in at least one run, is within the given gte/lte bounds"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var st SubtestTotal
+			err := json.Unmarshal(b, &st)
+			return st, err
+		},
+	},
+	{
+		AtomSchema{"long_timeout", []string{"long_timeout"}, "Test is marked as having a long timeout in the WPT manifest"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var lt LongTimeout
+			err := json.Unmarshal(b, &lt)
+			return lt, err
+		},
+	},
+	{
+		AtomSchema{"has_metadata", []string{"has_metadata"}, "Test has triage metadata that defines the given field (bug, label or results)"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var hmf HasMetadataField
+			err := json.Unmarshal(b, &hmf)
+			return hmf, err
+		},
+	},
+	{
+		AtomSchema{"present_in_all", []string{"present_in_all"}, "Test has a result in every browser among the queried runs"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var pia PresentInAll
+			err := json.Unmarshal(b, &pia)
+			return pia, err
+		},
+	},
+	{
+		AtomSchema{"browsers_failing", []string{"browsers_failing"}, "Number of browsers, among the queried runs, in which the test fails compares (eq/gte/lte) to the given count"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var bf BrowsersFailing
+			err := json.Unmarshal(b, &bf)
+			return bf, err
+		},
+	},
+	{
+		AtomSchema{"worse_than_median", []string{"worse_than_median"}, "Result in the given browser is more severe than the median result across the queried browsers"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var wtm WorseThanMedian
+			err := unmarshalWith(p, b, &wtm)
+			return wtm, err
+		},
+	},
+	{
+		AtomSchema{"subtest_only_in", []string{"subtest_only_in.browser_name", "subtest_only_in.subtest"}, "The subtest of the given name has a result in a run of the given browser, but in no run of the other queried browsers"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var soi SubtestOnlyIn
+			err := unmarshalWith(p, b, &soi)
+			return soi, err
+		},
+	},
+	{
+		AtomSchema{"first_seen", []string{"first_seen"}, "Test first appears, among the queried runs, in a run that started after the given RFC 3339 timestamp"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var fs FirstSeen
+			err := json.Unmarshal(b, &fs)
+			return fs, err
+		},
+	},
+	{
+		AtomSchema{"coverage", []string{"coverage"}, "Test has a result in at least (gte) the given number of the queried runs"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var cc CoverageCount
+			err := json.Unmarshal(b, &cc)
+			return cc, err
+		},
+	},
+	{
+		AtomSchema{"skipped", []string{"skipped"}, "Test was skipped (SKIP result, or disabled in metadata) in a run of the given browser"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var s Skipped
+			err := unmarshalWith(p, b, &s)
+			return s, err
+		},
+	},
+	{
+		AtomSchema{"asserts", []string{"asserts.browser_name", "asserts.eq", "asserts.gte", "asserts.lte"}, "The number of assertions that the test ran, in a run of the given browser, is within the given bounds"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var ac AssertCount
+			err := unmarshalWith(p, b, &ac)
+			return ac, err
+		},
+	},
+	{
+		AtomSchema{"intermittent", []string{"intermittent"}, "Test result is flagged as known intermittent by the metadata of a run of the given browser"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var i Intermittent
+			err := unmarshalWith(p, b, &i)
+			return i, err
+		},
+	},
+	{
+		AtomSchema{"unexpected", []string{"unexpected"}, "Result in a run of the given browser differs from the status expected by its metadata"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var u Unexpected
+			err := unmarshalWith(p, b, &u)
+			return u, err
+		},
+	},
+	{
+		AtomSchema{"subtest_majority", []string{"subtest_majority.browser_name", "subtest_majority.status"}, "The given status is the most common (strictly) among the subtests of the test in a run of the given browser"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var sm SubtestMajority
+			err := unmarshalWith(p, b, &sm)
+			return sm, err
+		},
+	},
+	{
+		AtomSchema{"harness_message", []string{"harness_message.browser_name", "harness_message.pattern"}, "The harness-level message of the test in a run of the given browser contains the given pattern"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var hm HarnessMessage
+			err := unmarshalWith(p, b, &hm)
+			return hm, err
+		},
+	},
+	{
+		AtomSchema{"all_subtests_pass", []string{"all_subtests_pass"}, "Every subtest passes in a run of the given browser"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var asp AllSubtestsPass
+			err := unmarshalWith(p, b, &asp)
+			return asp, err
+		},
+	},
+	{
+		AtomSchema{"focus_area", []string{"focus_area"}, "Test path starts with any of the path prefixes of the given focus area"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var fa FocusArea
+			err := unmarshalWith(p, b, &fa)
+			return fa, err
+		},
+	},
+	{
+		AtomSchema{"interop_year", []string{"interop_year"}, "Test path starts with any of the path prefixes of the focus areas of the given Interop year"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var iy InteropYear
+			err := unmarshalWith(p, b, &iy)
+			return iy, err
+		},
+	},
+	{
+		AtomSchema{"subtest", []string{"subtest"}, "Test has a subtest whose name contains (or, with exact, equals) the given string"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var s Subtest
+			err := json.Unmarshal(b, &s)
+			return s, err
+		},
+	},
+	{
+		AtomSchema{"flakiness", []string{"flakiness.browser_name", "flakiness.above"}, "Fraction of runs of the given browser with a status other than the most common one is above the given rate"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var fr FlakinessRate
+			err := unmarshalWith(p, b, &fr)
+			return fr, err
+		},
+	},
+	{
+		AtomSchema{"subtest_pass_ratio", []string{"subtest_pass_ratio.browser_name"}, "Fraction of passing subtests, in a run of the given browser, is below and/or above the given ratios"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var spr SubtestPassRatio
+			err := unmarshalWith(p, b, &spr)
+			return spr, err
+		},
+	},
+	{
+		AtomSchema{"ref", []string{"ref.browser_name", "ref.expect"}, "Test is a reftest whose reference comparison, in a run of the given browser, is a match (==) or mismatch (!=)"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var rm RefMatch
+			err := unmarshalWith(p, b, &rm)
+			return rm, err
+		},
+	},
+	{
+		AtomSchema{"not", []string{"not"}, "Negation of the given query"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var n AbstractNot
+			err := unmarshalWith(p, b, &n)
+			return n, err
+		},
+	},
+	{
+		AtomSchema{"or", []string{"or"}, "Disjunction of the given queries"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var o AbstractOr
+			err := unmarshalWith(p, b, &o)
+			return o, err
+		},
+	},
+	{
+		AtomSchema{"and", []string{"and"}, "Conjunction of the given queries"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var a AbstractAnd
+			err := unmarshalWith(p, b, &a)
+			return a, err
+		},
+	},
+	{
+		AtomSchema{"exists", []string{"exists"}, "Each of the given queries is satisfied by some run"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var e AbstractExists
+			err := unmarshalWith(p, b, &e)
+			return e, err
+		},
+	},
+	{
+		AtomSchema{"sequential", []string{"sequential"}, "The given queries are satisfied by consecutive runs, in order"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var s AbstractSequential
+			err := unmarshalWith(p, b, &s)
+			return s, err
+		},
+	},
+	{
+		AtomSchema{"count", []string{"count", "where"}, "Exactly the given number of runs satisfy the given query"},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var c AbstractCount
+			err := unmarshalWith(p, b, &c)
+			return c, err
+		},
+	},
+	{
+		AtomSchema{"where", []string{"where"}, `Each of the given queries is satisfied by some run (quantifier "any", the default) or by every run (quantifier "all")`},
+		func(p *parser, b []byte) (AbstractQuery, error) {
+			var qq quantified
+			err := unmarshalWith(p, b, &qq)
+			return qq.AbstractQuery, err
+		},
+	},
+}
+
+// parseTestStatus parses a (case-insensitive) test status string, returning an
+// error if the string does not name a known status. With the LenientStatus
+// option, an unknown status is instead parsed as UNKNOWN, and a warning is
+// recorded.
+func (p *parser) parseTestStatus(str string) (shared.TestStatus, error) {
+	statusStr := strings.ToUpper(str)
+	status := shared.TestStatusValueFromString(statusStr)
+	if statusStr != status.String() {
+		if p.opts.LenientStatus {
+			p.warn(fmt.Sprintf(`Unknown test status "%s" treated as UNKNOWN`, str))
+			return shared.TestStatusUnknown, nil
+		}
+		return status, withDetail(ErrInvalidStatus, `: "%s"`, str)
+	}
+	return status, nil
+}
+
+// unmarshalQ parses a query fragment as the custom atom (see RegisterAtom)
+// whose key it has or, failing that, as the first atom in atomParsers that
+// accepts it. To avoid redundant decoding, the fragment's top-level keys are
+// scanned once up front, and only atoms whose identifying key is present are
+// attempted. If the scan fails, every atom is attempted, in order. When no atom
+// accepts the fragment, but one rejected it for an explicitly null property, or
+// with one of the errors that callers can distinguish with ParseErrorCause
+// (e.g., ErrInvalidBrowser), that error is returned in place of the generic
+// one. Exceeding the parser's maximum depth fails immediately.
 func unmarshalQ(b []byte) (AbstractQuery, error) {
-	var tnp TestNamePattern
-	err := json.Unmarshal(b, &tnp)
-	if err == nil {
-		return tnp, nil
-	}
-	var tp TestPath
-	err = json.Unmarshal(b, &tp)
-	if err == nil {
-		return tp, nil
-	}
-	var tse TestStatusEq
-	err = json.Unmarshal(b, &tse)
-	if err == nil {
-		return tse, nil
-	}
-	var tsn TestStatusNeq
-	err = json.Unmarshal(b, &tsn)
-	if err == nil {
-		return tsn, nil
-	}
-	var n AbstractNot
-	err = json.Unmarshal(b, &n)
-	if err == nil {
-		return n, nil
-	}
-	var o AbstractOr
-	err = json.Unmarshal(b, &o)
-	if err == nil {
-		return o, nil
-	}
-	var a AbstractAnd
-	err = json.Unmarshal(b, &a)
-	if err == nil {
-		return a, nil
-	}
-	var e AbstractExists
-	err = json.Unmarshal(b, &e)
-	if err == nil {
-		return e, nil
-	}
-	var s AbstractSequential
-	err = json.Unmarshal(b, &s)
-	if err == nil {
-		return s, nil
-	}
-	var c AbstractCount
-	err = json.Unmarshal(b, &c)
-	if err == nil {
-		return c, nil
+	return newParser(ParseOpts{}).unmarshalQ(b)
+}
+
+func (p *parser) unmarshalQ(b []byte) (AbstractQuery, error) {
+	if p.opts.MaxDepth > 0 && p.depth >= p.opts.MaxDepth {
+		return nil, p.positionError(errMaxDepth(p.opts.MaxDepth))
+	}
+	p.depth++
+	q, err := p.unmarshalAtom(b)
+	p.depth--
+	if err != nil {
+		return nil, p.positionError(err)
+	}
+	return q, nil
+}
+
+// unmarshalItem unmarshals the nested query b, found at the given segment of
+// the JSON path (e.g., "and[2]") of the query being parsed.
+func (p *parser) unmarshalItem(segment string, b []byte) (AbstractQuery, error) {
+	p.path = append(p.path, segment)
+	q, err := p.unmarshalQ(b)
+	p.path = p.path[:len(p.path)-1]
+	return q, err
+}
+
+func (p *parser) unmarshalAtom(b []byte) (AbstractQuery, error) {
+	if q, ok, err := unmarshalCustomAtom(b); ok {
+		return q, err
+	}
+
+	var keyBuf [4][]byte
+	keys, scanned := jsonObjectKeys(b, keyBuf[:0])
+	var specificErr error
+	for _, ap := range atomParsers {
+		if scanned && !hasJSONKey(keys, ap.schema.topLevelKey()) {
+			continue
+		}
+		// Discard any warnings from failed attempts.
+		numWarnings := len(p.warnings)
+		q, err := ap.parse(p, b)
+		if err == nil {
+			return q, nil
+		}
+		p.warnings = p.warnings[:numWarnings]
+		if _, ok := ParseErrorCause(err).(errMaxDepth); ok {
+			return nil, err
+		}
+		// Errors positioned by a nested unmarshalQ come from a parser whose key
+		// matched, so they are as specific as a null property or sentinel.
+		_, isNull := err.(errNullProperty)
+		_, isPositioned := err.(*QueryParseError)
+		if (isNull || isPositioned || isSentinel(err)) && specificErr == nil {
+			specificErr = err
+		}
+	}
+	if specificErr != nil {
+		return nil, specificErr
 	}
 	return nil, errors.New(`Failed to parse query fragment as test name pattern, test status constraint, negation, disjunction, conjunction, sequential or count`)
 }
+
+// errNullProperty is the error returned when a query atom property is
+// explicitly null, rather than missing or of the wrong type.
+type errNullProperty string
+
+func (e errNullProperty) Error() string {
+	return fmt.Sprintf(`Property "%s" must not be null`, string(e))
+}
+
+// checkNotNull returns an errNullProperty for the first of the given properties
+// that is explicitly null in the JSON object b. Nested properties are named by
+// dot-separated paths, e.g., "status.not".
+func checkNotNull(b []byte, properties ...string) error {
+	for _, property := range properties {
+		value, ok := b, true
+		for path := property; ok && path != ""; {
+			key := path
+			if i := strings.IndexByte(path, '.'); i >= 0 {
+				key, path = path[:i], path[i+1:]
+			} else {
+				path = ""
+			}
+			value, ok = jsonPropertyValue(value, key)
+		}
+		if ok && bytes.Equal(value, jsonNull) {
+			return errNullProperty(property)
+		}
+	}
+	return nil
+}
+
+var jsonNull = []byte("null")
+
+// parseThreshold parses the JSON number b as a float64 threshold. Numbers that
+// do not fit in a float64 (e.g., 1e400) are rejected rather than decoded as an
+// infinity, as are NaNs, since no threshold comparison against them is
+// meaningful.
+func parseThreshold(b json.RawMessage) (float64, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return 0, err
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", string(b))
+	}
+	// The syntax of n has been validated by the decoder, so the only possible
+	// error is a range error: overflow yields an infinity (rejected below) and
+	// underflow yields 0.
+	f, _ := n.Float64()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%s is not a finite number", n)
+	}
+	return f, nil
+}
+
+// streamArrayProperty decodes the array value of a property of the JSON object
+// b one element at a time, calling each with the index and JSON of every
+// element. The element JSON is only valid for the duration of the call. As for
+// json.Unmarshal into a struct, the property name is matched case-insensitively
+// and, when it is repeated, the last value wins: indices restart at 0 for each
+// value. It returns the number of elements of the (last) value, which is 0 when
+// the property is missing, or an errNullProperty when it is null.
+func streamArrayProperty(b []byte, property string, each func(int, json.RawMessage) error) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return 0, err
+	} else if t != json.Delim('{') {
+		return 0, fmt.Errorf("Expected JSON object, got %v", t)
+	}
+
+	var n int
+	var msg json.RawMessage
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return 0, err
+		}
+		if key, _ := t.(string); !strings.EqualFold(key, property) {
+			if err := dec.Decode(&msg); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if t, err = dec.Token(); err != nil {
+			return 0, err
+		} else if t == nil {
+			return 0, errNullProperty(property)
+		} else if t != json.Delim('[') {
+			return 0, fmt.Errorf(`Property "%s" is not an array`, property)
+		}
+		n = 0
+		for dec.More() {
+			if err := dec.Decode(&msg); err != nil {
+				return 0, err
+			}
+			if err := each(n, msg); err != nil {
+				return 0, err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// topLevelKey is the top-level JSON property that identifies an atom.
+func (s AtomSchema) topLevelKey() string {
+	if i := strings.IndexByte(s.Key, '.'); i >= 0 {
+		return s.Key[:i]
+	}
+	return s.Key
+}
+
+// hasJSONKey reports whether keys contains key. Keys are compared
+// case-insensitively, as they are when encoding/json decodes into a struct.
+func hasJSONKey(keys [][]byte, key string) bool {
+	for _, k := range keys {
+		if bytes.EqualFold(k, []byte(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonObjectKeys performs a lightweight scan of the top-level keys of the JSON
+// object encoded in b, without decoding any values, appending them to keys. The
+// second return value is false when b does not appear to be a JSON object. The
+// scan is not a full validation; malformed values are left for encoding/json to
+// reject.
+func jsonObjectKeys(b []byte, keys [][]byte) ([][]byte, bool) {
+	i := skipJSONSpace(b, 0)
+	if i >= len(b) || b[i] != '{' {
+		return nil, false
+	}
+	i = skipJSONSpace(b, i+1)
+	if i < len(b) && b[i] == '}' {
+		return keys, true
+	}
+	for {
+		if i >= len(b) || b[i] != '"' {
+			return nil, false
+		}
+		end, escaped := skipJSONString(b, i)
+		if end < 0 {
+			return nil, false
+		}
+		key := b[i+1 : end-1]
+		if escaped {
+			var str string
+			if err := json.Unmarshal(b[i:end], &str); err != nil {
+				return nil, false
+			}
+			key = []byte(str)
+		}
+		keys = append(keys, key)
+
+		i = skipJSONSpace(b, end)
+		if i >= len(b) || b[i] != ':' {
+			return nil, false
+		}
+		i = skipJSONValue(b, skipJSONSpace(b, i+1))
+		if i < 0 {
+			return nil, false
+		}
+		i = skipJSONSpace(b, i)
+		if i >= len(b) {
+			return nil, false
+		}
+		if b[i] == '}' {
+			return keys, true
+		}
+		if b[i] != ',' {
+			return nil, false
+		}
+		i = skipJSONSpace(b, i+1)
+	}
+}
+
+// jsonPropertyValue returns the raw value of the top-level property key in the
+// JSON object encoded in b, if any. As with encoding/json, keys are matched
+// case-insensitively, and the last of any duplicate keys wins.
+func jsonPropertyValue(b []byte, key string) ([]byte, bool) {
+	i := skipJSONSpace(b, 0)
+	if i >= len(b) || b[i] != '{' {
+		return nil, false
+	}
+	i = skipJSONSpace(b, i+1)
+	if i < len(b) && b[i] == '}' {
+		return nil, false
+	}
+	var value []byte
+	found := false
+	for {
+		if i >= len(b) || b[i] != '"' {
+			return nil, false
+		}
+		end, escaped := skipJSONString(b, i)
+		if end < 0 {
+			return nil, false
+		}
+		k := b[i+1 : end-1]
+		if escaped {
+			var str string
+			if err := json.Unmarshal(b[i:end], &str); err != nil {
+				return nil, false
+			}
+			k = []byte(str)
+		}
+
+		i = skipJSONSpace(b, end)
+		if i >= len(b) || b[i] != ':' {
+			return nil, false
+		}
+		start := skipJSONSpace(b, i+1)
+		i = skipJSONValue(b, start)
+		if i < 0 {
+			return nil, false
+		}
+		if bytes.EqualFold(k, []byte(key)) {
+			value, found = b[start:i], true
+		}
+		i = skipJSONSpace(b, i)
+		if i >= len(b) {
+			return nil, false
+		}
+		if b[i] == '}' {
+			return value, found
+		}
+		if b[i] != ',' {
+			return nil, false
+		}
+		i = skipJSONSpace(b, i+1)
+	}
+}
+
+func skipJSONSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipJSONString returns the index just past the string that starts at b[i],
+// and whether the string contains escape sequences; or -1 if the string is
+// unterminated.
+func skipJSONString(b []byte, i int) (int, bool) {
+	escaped := false
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			escaped = true
+			j++
+		case '"':
+			return j + 1, escaped
+		}
+	}
+	return -1, escaped
+}
+
+// skipJSONValue returns the index just past the value that starts at b[i], or
+// -1 if no value could be scanned.
+func skipJSONValue(b []byte, i int) int {
+	if i >= len(b) {
+		return -1
+	}
+	switch b[i] {
+	case '"':
+		end, _ := skipJSONString(b, i)
+		return end
+	case '{', '[':
+		depth := 0
+		for i < len(b) {
+			switch b[i] {
+			case '"':
+				end, _ := skipJSONString(b, i)
+				if end < 0 {
+					return -1
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return -1
+	default:
+		start := i
+		for i < len(b) && !strings.ContainsRune(",}] \t\n\r", rune(b[i])) {
+			i++
+		}
+		if i == start {
+			return -1
+		}
+		return i
+	}
+}
@@ -0,0 +1,129 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func largeQuery(op string, n int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"%s":[`, op)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&buf, `{"pattern":"/dir%d/"}`, i)
+		case 1:
+			buf.WriteString(`{"product":"chrome","status":"FAIL"}`)
+		case 2:
+			buf.WriteString(`{"status":{"not":"PASS"}}`)
+		case 3:
+			fmt.Fprintf(&buf, `{"not":{"path":"/dir%d/"}}`, i)
+		}
+	}
+	buf.WriteString("]}")
+	return buf.Bytes()
+}
+
+func benchmarkUnmarshalQ(b *testing.B, data []byte) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := unmarshalQ(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalQ_pattern(b *testing.B) {
+	benchmarkUnmarshalQ(b, []byte(`{"pattern":"/2dcontext/"}`))
+}
+
+func BenchmarkUnmarshalQ_statusNeq(b *testing.B) {
+	benchmarkUnmarshalQ(b, []byte(`{"product":"chrome","status":{"not":"PASS"}}`))
+}
+
+func BenchmarkUnmarshalQ_count(b *testing.B) {
+	benchmarkUnmarshalQ(b, []byte(`{"count":2,"where":{"or":[{"status":"PASS"},{"status":"OK"}]}}`))
+}
+
+func BenchmarkUnmarshalQ_largeOr(b *testing.B) {
+	benchmarkUnmarshalQ(b, largeQuery("or", 1000))
+}
+
+func BenchmarkUnmarshalQ_largeExists(b *testing.B) {
+	benchmarkUnmarshalQ(b, largeQuery("exists", 1000))
+}
+
+func BenchmarkUnmarshalQ_hugeOr(b *testing.B) {
+	benchmarkUnmarshalQ(b, largeQuery("or", 50000))
+}
+
+// browserNamesQuery is a query with n status atoms, cycling through the
+// products of the given browser names, as for a large query parsed with a
+// custom set of browser names.
+func browserNamesQuery(names []string, n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"run_ids":[1],"query":{"or":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"product":"%s","status":"FAIL"}`, names[i%len(names)])
+	}
+	buf.WriteString("]}}")
+	return buf.Bytes()
+}
+
+func benchmarkParseBrowserNames(b *testing.B, numNames int, parallel bool) {
+	names := make([]string, numNames)
+	for i := range names {
+		names[i] = fmt.Sprintf("browser%d", i)
+	}
+	const numAtoms = 1000
+	data := browserNamesQuery(names, numAtoms)
+	opt := WithBrowserNames(names...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	if parallel {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := Parse(data, opt); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	} else {
+		for i := 0; i < b.N; i++ {
+			if _, err := Parse(data, opt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*numAtoms), "ns/atom")
+}
+
+// The per-atom cost of checking browser names should not grow with the number
+// of browser names.
+func BenchmarkParse_fewBrowserNames(b *testing.B) {
+	benchmarkParseBrowserNames(b, 4, false)
+}
+
+func BenchmarkParse_manyBrowserNames(b *testing.B) {
+	benchmarkParseBrowserNames(b, 500, false)
+}
+
+func BenchmarkParse_manyBrowserNamesParallel(b *testing.B) {
+	benchmarkParseBrowserNames(b, 500, true)
+}
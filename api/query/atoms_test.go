@@ -8,7 +8,9 @@ package query
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/web-platform-tests/wpt.fyi/shared"
@@ -97,7 +99,7 @@ func TestStructuredQuery_unknownStatus(t *testing.T) {
 	p := shared.ParseProductSpecUnsafe("chrome")
 	assert.Equal(t, RunQuery{
 		RunIDs:        []int64{0, 1, 2},
-		AbstractQuery: TestStatusEq{&p, shared.TestStatusValueFromString("UNKNOWN")},
+		AbstractQuery: TestStatusEq{Product: &p, Status: shared.TestStatusValueFromString("UNKNOWN")},
 	}, rq)
 }
 
@@ -119,7 +121,7 @@ func TestStructuredQuery_emptyPattern(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{""}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{Pattern: ""}}, rq)
 }
 
 func TestStructuredQuery_pattern(t *testing.T) {
@@ -131,7 +133,7 @@ func TestStructuredQuery_pattern(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{"/2dcontext/"}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{Pattern: "/2dcontext/"}}, rq)
 }
 
 func TestStructuredQuery_path(t *testing.T) {
@@ -146,6 +148,130 @@ func TestStructuredQuery_path(t *testing.T) {
 	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestPath{"/2dcontext/"}}, rq)
 }
 
+func TestStructuredQuery_testNameIn(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"test_name_in": ["/a.html", "/b.html"]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	tni := TestNameIn{Names: []string{"/a.html", "/b.html"}}
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: tni}, rq)
+	assert.Equal(t, tni, rq.AbstractQuery.BindToRuns(shared.TestRun{ID: 0}))
+	assert.Equal(t, 1, tni.Size())
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"test_name_in": []}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"test_name_in": []}`), &tni)
+	assert.EqualError(t, err, `Test name list property "test_name_in" must not be empty`)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"test_name_in": "/a.html"}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"test_name_in": [1]}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_manifestPaths(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"manifest_paths": ["/css/a.html", "/dom/b.html"]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	mp := ManifestPaths{Paths: []string{"/css/a.html", "/dom/b.html"}}
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: mp}, rq)
+	assert.Equal(t, mp, rq.AbstractQuery.BindToRuns(shared.TestRun{ID: 0}))
+	assert.Equal(t, 1, mp.Size())
+
+	err = json.Unmarshal([]byte(`{"manifest_paths": ["/css/"], "prefix": true}`), &mp)
+	assert.Nil(t, err)
+	assert.Equal(t, ManifestPaths{Paths: []string{"/css/"}, Prefix: true}, mp)
+
+	err = json.Unmarshal([]byte(`{"manifest_paths": []}`), &mp)
+	assert.EqualError(t, err, `Manifest path list property "manifest_paths" must not be empty`)
+	err = json.Unmarshal([]byte(`{"manifest_paths": ["/css/"], "prefix": "yes"}`), &mp)
+	assert.EqualError(t, err, `Manifest path property "prefix" is not a boolean`)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"manifest_paths": "/css/a.html"}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"manifest_paths": ["/css/"], "prefix": null}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_shard(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"shard": {"index": 3, "total": 8}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	s := Shard{Index: 3, Total: 8}
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: s}, rq)
+	assert.Equal(t, s, rq.AbstractQuery.BindToRuns(shared.TestRun{ID: 0}))
+	assert.Equal(t, 1, s.Size())
+
+	data, err := json.Marshal(s)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"shard":{"index":3,"total":8}}`, string(data))
+
+	err = json.Unmarshal([]byte(`{"shard": {"index": 0, "total": 1}}`), &s)
+	assert.Nil(t, err)
+	assert.Equal(t, Shard{Index: 0, Total: 1}, s)
+
+	err = json.Unmarshal([]byte(`{"shard": {"index": 8, "total": 8}}`), &s)
+	assert.EqualError(t, err, `Invalid shard index 8: must be at least 0 and less than the total, 8`)
+	err = json.Unmarshal([]byte(`{"shard": {"index": -1, "total": 8}}`), &s)
+	assert.EqualError(t, err, `Invalid shard index -1: must be at least 0 and less than the total, 8`)
+	err = json.Unmarshal([]byte(`{"shard": {"index": 0, "total": 0}}`), &s)
+	assert.EqualError(t, err, `Invalid shard total 0: must be positive`)
+	err = json.Unmarshal([]byte(`{"shard": {"index": 0}}`), &s)
+	assert.EqualError(t, err, `Invalid shard property "shard": requires "index" and "total"`)
+	err = json.Unmarshal([]byte(`{"shard": {"index": 0.5, "total": 2}}`), &s)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"shard": null}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestTestShard(t *testing.T) {
+	// The assignment is fixed, so that CI shards agree across releases.
+	assert.Equal(t, 4, TestShard("/css/a.html", 8))
+	assert.Equal(t, 2, TestShard("/dom/historical.html", 8))
+	assert.Equal(t, 6, TestShard("/2dcontext/a.html", 8))
+
+	const total = 8
+	const numTests = 8000
+	counts := make([]int, total)
+	for i := 0; i < numTests; i++ {
+		name := fmt.Sprintf("/dir%d/test-%d.html", i%37, i)
+		shard := TestShard(name, total)
+		assert.Equal(t, shard, TestShard(name, total))
+		counts[shard]++
+
+		// Each test is in exactly one shard.
+		matches := 0
+		for index := 0; index < total; index++ {
+			if (Shard{Index: index, Total: total}).Matches(name) {
+				matches++
+			}
+		}
+		assert.Equal(t, 1, matches)
+	}
+	for index, count := range counts {
+		assert.InDelta(t, numTests/total, count, numTests/total/10, "Unbalanced shard %d", index)
+	}
+}
+
+func TestTestShard_noShards(t *testing.T) {
+	assert.Equal(t, -1, TestShard("/css/a.html", 0))
+	assert.Equal(t, -1, TestShard("/css/a.html", -2))
+	assert.False(t, Shard{Index: 0, Total: 0}.Matches("/css/a.html"))
+	assert.False(t, Shard{Index: -1, Total: -1}.Matches("/css/a.html"))
+}
+
 func TestStructuredQuery_legacyBrowserName(t *testing.T) {
 	var rq RunQuery
 	err := json.Unmarshal([]byte(`{
@@ -158,10 +284,359 @@ func TestStructuredQuery_legacyBrowserName(t *testing.T) {
 	assert.Nil(t, err)
 	p := shared.ParseProductSpecUnsafe("firefox")
 	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2},
-		AbstractQuery: TestStatusEq{&p, shared.TestStatusValueFromString("PASS")},
+		AbstractQuery: TestStatusEq{Product: &p, Status: shared.TestStatusValueFromString("PASS")},
 	}, rq)
 }
 
+func TestStructuredQuery_wildcardBrowserName(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"browser_name": "*", "status": "FAIL"},
+				{"product": "*", "status": {"not": "PASS"}}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusEq{Status: shared.TestStatusFail},
+			TestStatusNeq{Status: shared.TestStatusPass},
+		},
+	}, rq.AbstractQuery)
+
+	// The wildcard is not a valid part of a product spec.
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"product": "*-69", "status": "FAIL"}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindWildcardBrowserName(t *testing.T) {
+	var tse TestStatusEq
+	assert.Nil(t, json.Unmarshal([]byte(`{"browser_name": "*", "status": "FAIL"}`), &tse))
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+		},
+	}, tse.BindToRuns(runs...))
+
+	// Non-wildcard browser names still bind to runs of that browser only.
+	assert.Nil(t, json.Unmarshal([]byte(`{"browser_name": "firefox", "status": "FAIL"}`), &tse))
+	assert.Equal(t, RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}, tse.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_multipleBrowserNames(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"browser_name": ["chrome", "FiReFoX"], "status": "FAIL"},
+				{"product": ["safari"], "status": {"not": "PASS"}},
+				{"browser_name": "chrome", "status": "FAIL"}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	safari := shared.ParseProductSpecUnsafe("safari")
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusEq{Products: []shared.ProductSpec{chrome, firefox}, Status: shared.TestStatusFail},
+			TestStatusNeq{Products: []shared.ProductSpec{safari}, Status: shared.TestStatusPass},
+			TestStatusEq{Product: &chrome, Status: shared.TestStatusFail},
+		},
+	}, rq.AbstractQuery)
+
+	for _, invalid := range []string{
+		`{"browser_name": ["chrome", "not-a-browser"], "status": "FAIL"}`,
+		`{"product": ["chrome", "*"], "status": {"not": "PASS"}}`,
+		`{"product": [], "status": "FAIL"}`,
+		`{"product": ["chrome", 1], "status": "FAIL"}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindMultipleBrowserNames(t *testing.T) {
+	var tse TestStatusEq
+	assert.Nil(t, json.Unmarshal([]byte(`{"browser_name": ["chrome", "safari"], "status": "FAIL"}`), &tse))
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Safari").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 3, Status: shared.TestStatusFail},
+		},
+	}, tse.BindToRuns(runs...))
+
+	var tsn TestStatusNeq
+	assert.Nil(t, json.Unmarshal([]byte(`{"product": ["edge", "firefox"], "status": {"not": "PASS"}}`), &tsn))
+	assert.Equal(t, RunTestStatusNeq{Run: 2, Status: shared.TestStatusPass}, tsn.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_statusOS(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"browser_name": "chrome", "os": "Linux", "status": "FAIL"},
+				{"os": "windows", "status": {"not": "PASS"}}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: &chrome, OS: "linux", Status: shared.TestStatusFail},
+			TestStatusNeq{OS: "windows", Status: shared.TestStatusPass},
+		},
+	}, rq.AbstractQuery)
+
+	for _, invalid := range []string{
+		`{"browser_name": "chrome", "os": "beos", "status": "FAIL"}`,
+		`{"os": null, "status": "FAIL"}`,
+		`{"os": 1, "status": {"not": "PASS"}}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindStatusOS(t *testing.T) {
+	run := func(id int64, spec, os string) shared.TestRun {
+		r := shared.TestRun{
+			ID:                id,
+			ProductAtRevision: shared.ParseProductSpecUnsafe(spec).ProductAtRevision,
+		}
+		r.OSName = os
+		return r
+	}
+	runs := []shared.TestRun{
+		run(1, "chrome", "linux"),
+		run(2, "chrome", "windows"),
+		run(3, "firefox", "linux"),
+		run(4, "chrome", "Linux"),
+	}
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+
+	tse := TestStatusEq{Product: &chrome, OS: "linux", Status: shared.TestStatusFail}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 4, Status: shared.TestStatusFail},
+		},
+	}, tse.BindToRuns(runs...))
+	tsn := TestStatusNeq{OS: "windows", Status: shared.TestStatusPass}
+	assert.Equal(t, RunTestStatusNeq{Run: 2, Status: shared.TestStatusPass}, tsn.BindToRuns(runs...))
+
+	// Mismatched platforms bind to no runs.
+	tse = TestStatusEq{Product: &chrome, OS: "macos", Status: shared.TestStatusFail}
+	assert.Equal(t, False{}, tse.BindToRuns(runs...))
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	tsn = TestStatusNeq{Product: &firefox, OS: "windows", Status: shared.TestStatusPass}
+	assert.Equal(t, False{}, tsn.BindToRuns(runs...))
+
+	// Without an OS, runs on every platform are considered.
+	tse = TestStatusEq{Product: &chrome, Status: shared.TestStatusFail}
+	assert.Equal(t, 3, len(tse.BindToRuns(runs...).(Or).Args))
+}
+
+func TestStructuredQuery_statusRevision(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"browser_name": "chrome", "revision": "ABC1234", "status": "FAIL"},
+				{"revision": "abc1234def", "status": {"not": "PASS"}}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: &chrome, Revision: "abc1234", Status: shared.TestStatusFail},
+			TestStatusNeq{Revision: "abc1234def", Status: shared.TestStatusPass},
+		},
+	}, rq.AbstractQuery)
+
+	data, err := json.Marshal(rq.AbstractQuery)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"or":[{"product":"chrome","revision":"abc1234","status":"FAIL"},{"revision":"abc1234def","status":{"not":"PASS"}}]}`, string(data))
+
+	for _, invalid := range []string{
+		`{"browser_name": "chrome", "revision": "abc", "status": "FAIL"}`,
+		`{"revision": "not-a-sha", "status": "FAIL"}`,
+		`{"revision": "0123456789012345678901234567890123456789a", "status": "FAIL"}`,
+		`{"revision": null, "status": "FAIL"}`,
+		`{"revision": 1234567, "status": {"not": "PASS"}}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindStatusRevision(t *testing.T) {
+	run := func(id int64, spec, revision, fullRevisionHash string) shared.TestRun {
+		r := shared.TestRun{
+			ID:                id,
+			ProductAtRevision: shared.ParseProductSpecUnsafe(spec).ProductAtRevision,
+		}
+		r.Revision = revision
+		r.FullRevisionHash = fullRevisionHash
+		return r
+	}
+	full := "abc1234def567890abc1234def567890abc12345"
+	runs := []shared.TestRun{
+		run(1, "chrome", full[:10], full),
+		run(2, "chrome", "0123456789", ""),
+		run(3, "firefox", full[:10], ""),
+		run(4, "chrome", "abc1234fff", "ABC1234FFF567890abc1234def567890abc12345"),
+	}
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+
+	// A full SHA matches only the run with that full revision hash.
+	tse := TestStatusEq{Product: &chrome, Revision: full, Status: shared.TestStatusFail}
+	assert.Equal(t, RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}, tse.BindToRuns(runs...))
+
+	// A prefix matches full and short revisions alike, regardless of case.
+	tse = TestStatusEq{Revision: "abc1234", Status: shared.TestStatusFail}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 3, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 4, Status: shared.TestStatusFail},
+		},
+	}, tse.BindToRuns(runs...))
+	tsn := TestStatusNeq{Product: &chrome, Revision: "abc1234d", Status: shared.TestStatusPass}
+	assert.Equal(t, RunTestStatusNeq{Run: 1, Status: shared.TestStatusPass}, tsn.BindToRuns(runs...))
+
+	// A run with only a short revision does not match a longer prefix.
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	tse = TestStatusEq{Product: &firefox, Revision: full[:12], Status: shared.TestStatusFail}
+	assert.Equal(t, False{}, tse.BindToRuns(runs...))
+
+	// Without a revision, runs at every revision are considered.
+	tse = TestStatusEq{Product: &chrome, Status: shared.TestStatusFail}
+	assert.Equal(t, 3, len(tse.BindToRuns(runs...).(Or).Args))
+}
+
+func TestStructuredQuery_statusFullRun(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"browser_name": "chrome", "full_run": true, "status": "FAIL"},
+				{"full_run": false, "status": {"not": "PASS"}}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	full, partial := true, false
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: &chrome, FullRun: &full, Status: shared.TestStatusFail},
+			TestStatusNeq{FullRun: &partial, Status: shared.TestStatusPass},
+		},
+	}, rq.AbstractQuery)
+
+	data, err := json.Marshal(rq.AbstractQuery)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"or":[{"product":"chrome","full_run":true,"status":"FAIL"},{"full_run":false,"status":{"not":"PASS"}}]}`, string(data))
+
+	// Omitting the flag leaves it unset.
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"status": "FAIL"}}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, TestStatusEq{Status: shared.TestStatusFail}, rq.AbstractQuery)
+
+	for _, invalid := range []string{
+		`{"full_run": null, "status": "FAIL"}`,
+		`{"full_run": "true", "status": {"not": "PASS"}}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindStatusFullRun(t *testing.T) {
+	run := func(id int64, spec string, labels ...string) shared.TestRun {
+		return shared.TestRun{
+			ID:                id,
+			ProductAtRevision: shared.ParseProductSpecUnsafe(spec).ProductAtRevision,
+			Labels:            labels,
+		}
+	}
+	runs := []shared.TestRun{
+		run(1, "chrome", shared.MasterLabel),
+		run(2, "chrome", shared.PRBaseLabel),
+		run(3, "chrome", shared.PRHeadLabel),
+		run(4, "firefox"),
+	}
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	full, partial := true, false
+
+	// Partial (PR) runs are skipped.
+	tse := TestStatusEq{Product: &chrome, FullRun: &full, Status: shared.TestStatusFail}
+	assert.Equal(t, RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}, tse.BindToRuns(runs...))
+	tsn := TestStatusNeq{FullRun: &full, Status: shared.TestStatusPass}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusNeq{Run: 1, Status: shared.TestStatusPass},
+			RunTestStatusNeq{Run: 4, Status: shared.TestStatusPass},
+		},
+	}, tsn.BindToRuns(runs...))
+
+	// Only partial runs bind to no runs.
+	tse = TestStatusEq{FullRun: &full, Status: shared.TestStatusFail}
+	assert.Equal(t, False{}, tse.BindToRuns(runs[1:3]...))
+
+	// A false flag selects only partial runs.
+	tse = TestStatusEq{Product: &chrome, FullRun: &partial, Status: shared.TestStatusFail}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 3, Status: shared.TestStatusFail},
+		},
+	}, tse.BindToRuns(runs...))
+	assert.Equal(t, False{}, tse.BindToRuns(runs[0], runs[3]))
+
+	// Without the flag, complete and partial runs are considered.
+	tse = TestStatusEq{Product: &chrome, Status: shared.TestStatusFail}
+	assert.Equal(t, 3, len(tse.BindToRuns(runs...).(Or).Args))
+}
+
 func TestStructuredQuery_status(t *testing.T) {
 	var rq RunQuery
 	err := json.Unmarshal([]byte(`{
@@ -174,7 +649,7 @@ func TestStructuredQuery_status(t *testing.T) {
 	assert.Nil(t, err)
 	p := shared.ParseProductSpecUnsafe("firefox")
 	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2},
-		AbstractQuery: TestStatusEq{&p, shared.TestStatusValueFromString("PASS")},
+		AbstractQuery: TestStatusEq{Product: &p, Status: shared.TestStatusValueFromString("PASS")},
 	}, rq)
 }
 
@@ -190,7 +665,7 @@ func TestStructuredQuery_statusNeq(t *testing.T) {
 	assert.Nil(t, err)
 	p := shared.ParseProductSpecUnsafe("firefox")
 	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2},
-		AbstractQuery: TestStatusNeq{&p, shared.TestStatusValueFromString("PASS")},
+		AbstractQuery: TestStatusNeq{Product: &p, Status: shared.TestStatusValueFromString("PASS")},
 	}, rq)
 }
 
@@ -217,7 +692,35 @@ func TestStructuredQuery_not(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractNot{TestNamePattern{"cssom"}}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractNot{TestNamePattern{Pattern: "cssom"}}}, rq)
+}
+
+func TestStructuredQuery_notArray(t *testing.T) {
+	var nor, notOr AbstractNot
+	assert.Nil(t, json.Unmarshal([]byte(`{"not": [{"pattern": "cssom"}, {"status": "FAIL"}]}`), &nor))
+	assert.Nil(t, json.Unmarshal([]byte(`{"not": {"or": [{"pattern": "cssom"}, {"status": "FAIL"}]}}`), &notOr))
+	assert.Equal(t, AbstractNot{
+		Arg: AbstractOr{
+			Args: []AbstractQuery{
+				TestNamePattern{Pattern: "cssom"},
+				TestStatusEq{Status: shared.TestStatusFail},
+			},
+		},
+	}, nor)
+	assert.Equal(t, notOr, nor)
+
+	// A single-element array is still a disjunction.
+	assert.Nil(t, json.Unmarshal([]byte(`{"not": [{"pattern": "cssom"}]}`), &nor))
+	assert.Equal(t, AbstractNot{Arg: AbstractOr{Args: []AbstractQuery{TestNamePattern{Pattern: "cssom"}}}}, nor)
+
+	for _, invalid := range []string{
+		`{"not": []}`,
+		`{"not": [{"pattern": "a"}, {"bad": "atom"}]}`,
+		`{"not": [{"pattern": "a"}, null]}`,
+	} {
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &nor), invalid)
+	}
+	assert.EqualError(t, json.Unmarshal([]byte(`{"not": []}`), &nor), `Missing query property: "not"`)
 }
 
 func TestStructuredQuery_or(t *testing.T) {
@@ -232,7 +735,52 @@ func TestStructuredQuery_or(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractOr{[]AbstractQuery{TestNamePattern{"cssom"}, TestNamePattern{"html"}}}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractOr{[]AbstractQuery{TestNamePattern{Pattern: "cssom"}, TestNamePattern{Pattern: "html"}}}}, rq)
+}
+
+func TestStructuredQuery_largeOr(t *testing.T) {
+	data := largeQuery("or", 100)
+	var raw struct {
+		Or []json.RawMessage `json:"or"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &raw))
+	expected := AbstractOr{Args: make([]AbstractQuery, len(raw.Or))}
+	for i, msg := range raw.Or {
+		q, err := unmarshalQ(msg)
+		assert.Nil(t, err)
+		expected.Args[i] = q
+	}
+
+	q, err := unmarshalQ(data)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, q)
+}
+
+func TestStructuredQuery_orStreaming(t *testing.T) {
+	var o AbstractOr
+	// Other properties are skipped, the key is matched case-insensitively, and
+	// the last of repeated keys wins, as for json.Unmarshal.
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"x": {"or": [1, 2]},
+		"or": [{"pattern": "a"}, {"pattern": "b"}],
+		"OR": [{"pattern": "c"}]
+	}`), &o))
+	assert.Equal(t, AbstractOr{Args: []AbstractQuery{TestNamePattern{Pattern: "c"}}}, o)
+
+	for _, invalid := range []string{
+		`{"or": []}`,
+		`{"or": {"pattern": "a"}}`,
+		`{"or": [{"pattern": "a"}, {"bad": "atom"}]}`,
+		`{"or": [{"pattern": "a"}]`,
+		`{"or": [{"pattern": "a"}]} trailing`,
+		`[{"or": [{"pattern": "a"}]}]`,
+		`{"and": [{"pattern": "a"}]}`,
+	} {
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &o), invalid)
+	}
+
+	err := json.Unmarshal([]byte(`{"or": null}`), &o)
+	assert.Equal(t, errNullProperty("or"), err)
 }
 
 func TestStructuredQuery_and(t *testing.T) {
@@ -247,7 +795,7 @@ func TestStructuredQuery_and(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractAnd{[]AbstractQuery{TestNamePattern{"cssom"}, TestNamePattern{"html"}}}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractAnd{[]AbstractQuery{TestNamePattern{Pattern: "cssom"}, TestNamePattern{Pattern: "html"}}}}, rq)
 }
 
 func TestStructuredQuery_exists(t *testing.T) {
@@ -262,7 +810,7 @@ func TestStructuredQuery_exists(t *testing.T) {
 		}
 	}`), &rq)
 	assert.Nil(t, err)
-	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractExists{[]AbstractQuery{TestNamePattern{"cssom"}, TestNamePattern{"html"}}}}, rq)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractExists{[]AbstractQuery{TestNamePattern{Pattern: "cssom"}, TestNamePattern{Pattern: "html"}}}}, rq)
 }
 
 func TestStructuredQuery_sequential(t *testing.T) {
@@ -352,11 +900,11 @@ func TestStructuredQuery_nested(t *testing.T) {
 			Args: []AbstractQuery{
 				AbstractAnd{
 					Args: []AbstractQuery{
-						AbstractNot{TestNamePattern{"cssom"}},
-						TestNamePattern{"html"},
+						AbstractNot{TestNamePattern{Pattern: "cssom"}},
+						TestNamePattern{Pattern: "html"},
 					},
 				},
-				TestStatusEq{&p, shared.TestStatusValueFromString("TIMEOUT")},
+				TestStatusEq{Product: &p, Status: shared.TestStatusValueFromString("TIMEOUT")},
 			},
 		},
 	}, rq)
@@ -554,6 +1102,77 @@ func TestStructuredQuery_bindExists(t *testing.T) {
 	assert.Equal(t, expected, q.BindToRuns(runs...))
 }
 
+func TestStructuredQuery_bindExistsSubtest(t *testing.T) {
+	q := AbstractExists{
+		Args: []AbstractQuery{Subtest{Name: "foo"}},
+	}
+	runs := []shared.TestRun{shared.TestRun{ID: 1}, shared.TestRun{ID: 2}}
+	expected := And{
+		Args: []ConcreteQuery{
+			Or{
+				Args: []ConcreteQuery{
+					And{Args: []ConcreteQuery{
+						Subtest{Name: "foo"},
+						RunTestStatusNeq{Run: 1, Status: shared.TestStatusUnknown},
+					}},
+					And{Args: []ConcreteQuery{
+						Subtest{Name: "foo"},
+						RunTestStatusNeq{Run: 2, Status: shared.TestStatusUnknown},
+					}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_bindExistsSingleRun(t *testing.T) {
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	run := shared.TestRun{ID: 1, ProductAtRevision: chrome.ProductAtRevision}
+	status := TestStatusEq{Product: &chrome, Status: shared.TestStatusFail}
+
+	// A single atom is bound directly, rather than as And(Or(atom)).
+	q := AbstractExists{Args: []AbstractQuery{status}}
+	assert.Equal(t, RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}, q.BindToRuns(run))
+
+	// Several atoms must still all hold.
+	q = AbstractExists{Args: []AbstractQuery{status, TestNamePattern{Pattern: "/dom/"}}}
+	assert.Equal(t, And{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "/dom/"},
+		},
+	}, q.BindToRuns(run))
+
+	// Atoms for other browsers match nothing, as they would in an empty Or.
+	q = AbstractExists{Args: []AbstractQuery{TestStatusEq{Product: &firefox, Status: shared.TestStatusFail}}}
+	assert.Equal(t, False{}, q.BindToRuns(run))
+
+	// Subtests must still have a result in the run.
+	q = AbstractExists{Args: []AbstractQuery{Subtest{Name: "foo"}}}
+	assert.Equal(t, And{
+		Args: []ConcreteQuery{
+			Subtest{Name: "foo"},
+			RunTestStatusNeq{Run: 1, Status: shared.TestStatusUnknown},
+		},
+	}, q.BindToRuns(run))
+
+	// With more than one run, the or-combo over runs is kept.
+	q = AbstractExists{Args: []AbstractQuery{status}}
+	other := shared.TestRun{ID: 2, ProductAtRevision: chrome.ProductAtRevision}
+	assert.Equal(t, And{
+		Args: []ConcreteQuery{
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+					RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+				},
+			},
+		},
+	}, q.BindToRuns(run, other))
+}
+
 func TestStructuredQuery_bindSequential(t *testing.T) {
 	e := shared.ParseProductSpecUnsafe("edge")
 	f := shared.ParseProductSpecUnsafe("firefox")
@@ -778,7 +1397,7 @@ func TestStructuredQuery_bindOrReduce(t *testing.T) {
 	}
 	// No runs match Safari constraint; it becomes False,
 	// Pattern="/" || False => Pattern.
-	expected := TestNamePattern{"/"}
+	expected := TestNamePattern{Pattern: "/"}
 	assert.Equal(t, expected, q.BindToRuns(runs...))
 }
 
@@ -831,3 +1450,1736 @@ func TestStructuredQuery_bindComplex(t *testing.T) {
 	}
 	assert.Equal(t, expected, q.BindToRuns(runs...))
 }
+
+func TestStructuredQuery_caseInsensitiveKey(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"Status": "PASS"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestStatusEq{Status: shared.TestStatusPass}}, rq)
+}
+
+func TestStructuredQuery_atomPrecedence(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"status": "PASS",
+			"pattern": "cssom"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{Pattern: "cssom"}}, rq)
+}
+
+func TestStructuredQuery_notAnObject(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": [{"pattern": "cssom"}]
+	}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestJSONObjectKeys(t *testing.T) {
+	keys, ok := jsonObjectKeys([]byte(` { "a" : [1, {"x": "]}"}], "b\"c":{"d":null}, "e": true } `), nil)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte(`b"c`), []byte("e")}, keys)
+
+	keys, ok = jsonObjectKeys([]byte(`{}`), nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(keys))
+
+	for _, bad := range []string{``, `[]`, `"a"`, `{"a"}`, `{"a":}`, `{"a":[1,2}`, `{"a":1 "b":2}`, `{"a":"b`} {
+		_, ok = jsonObjectKeys([]byte(bad), nil)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestJSONPropertyValue(t *testing.T) {
+	b := []byte(` { "a" : [1, {"x": "]}"}], "b\"c":{"d":null}, "E": true, "e": null } `)
+	v, ok := jsonPropertyValue(b, "a")
+	assert.True(t, ok)
+	assert.Equal(t, `[1, {"x": "]}"}]`, string(v))
+	v, ok = jsonPropertyValue(b, `b"c`)
+	assert.True(t, ok)
+	assert.Equal(t, `{"d":null}`, string(v))
+	v, ok = jsonPropertyValue(b, "e")
+	assert.True(t, ok)
+	assert.Equal(t, "null", string(v))
+	_, ok = jsonPropertyValue(b, "f")
+	assert.False(t, ok)
+
+	assert.Nil(t, checkNotNull(b, "a", "b\"c.e", "f.g"))
+	assert.Equal(t, errNullProperty("b\"c.d"), checkNotNull(b, "a", "b\"c.d"))
+	assert.Equal(t, errNullProperty("e"), checkNotNull(b, "e"))
+}
+
+func TestStructuredQuery_anyStatus(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"any_status": "cRaSh"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AnyStatus{shared.TestStatusCrash}}, rq)
+}
+
+func TestStructuredQuery_anyStatusBad(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"any_status": "NOT_A_REAL_STATUS"
+		}
+	}`), &rq)
+	assert.NotNil(t, err)
+
+	err = json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"any_status": ""
+		}
+	}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindAnyStatusNoRuns(t *testing.T) {
+	assert.Equal(t, False{}, AnyStatus{Status: shared.TestStatusCrash}.BindToRuns())
+}
+
+func TestStructuredQuery_bindAnyStatus(t *testing.T) {
+	q := AnyStatus{Status: shared.TestStatusTimeout}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	expected := AnyRunTestStatusEq{
+		Runs:   []int64{1, 2},
+		Status: shared.TestStatusTimeout,
+	}
+	bound := q.BindToRuns(runs...)
+	assert.Equal(t, expected, bound)
+	assert.Equal(t, 1, bound.Size())
+
+	assert.Equal(t, AnyRunTestStatusEq{
+		Runs:   []int64{2},
+		Status: shared.TestStatusTimeout,
+	}, q.BindToRuns(runs[1]))
+}
+
+func TestStructuredQuery_quantifierDefault(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"where": [
+				{"pattern": "cssom"},
+				{"pattern": "html"}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractExists{[]AbstractQuery{TestNamePattern{Pattern: "cssom"}, TestNamePattern{Pattern: "html"}}}}, rq)
+}
+
+func TestStructuredQuery_quantifierAny(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"quantifier": "any",
+			"where": [
+				{"pattern": "cssom"}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractExists{[]AbstractQuery{TestNamePattern{Pattern: "cssom"}}}}, rq)
+}
+
+func TestStructuredQuery_quantifierAll(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"quantifier": "ALL",
+			"where": [
+				{"status": "PASS"}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractAll{[]AbstractQuery{TestStatusEq{Status: shared.TestStatusPass}}}}, rq)
+}
+
+func TestStructuredQuery_quantifierInvalid(t *testing.T) {
+	var rq RunQuery
+	for _, q := range []string{
+		`{"quantifier": "some", "where": [{"pattern": "cssom"}]}`,
+		`{"quantifier": "all", "where": []}`,
+		`{"quantifier": "all"}`,
+		`{"count": 1, "where": [{"pattern": "cssom"}]}`,
+	} {
+		err := json.Unmarshal([]byte(`{"run_ids": [0, 1, 2], "query": `+q+`}`), &rq)
+		assert.NotNil(t, err, q)
+	}
+}
+
+func TestStructuredQuery_bindAll(t *testing.T) {
+	e := shared.ParseProductSpecUnsafe("edge")
+	f := shared.ParseProductSpecUnsafe("firefox")
+	q := AbstractAll{
+		Args: []AbstractQuery{
+			TestStatusEq{Status: 1},
+			TestStatusNeq{Product: &f, Status: 2},
+		},
+	}
+	runs := shared.TestRuns{
+		{
+			ID:                int64(1),
+			ProductAtRevision: e.ProductAtRevision,
+		},
+		{
+			ID:                int64(2),
+			ProductAtRevision: f.ProductAtRevision,
+		},
+	}
+	expected := And{
+		Args: []ConcreteQuery{
+			And{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 1, Status: 1},
+					RunTestStatusEq{Run: 2, Status: 1},
+				},
+			},
+			// Edge run does not participate in Firefox constraint.
+			And{
+				Args: []ConcreteQuery{
+					RunTestStatusNeq{Run: 2, Status: 2},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_bindAllNoApplicableRuns(t *testing.T) {
+	s := shared.ParseProductSpecUnsafe("safari")
+	q := AbstractAll{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: &s, Status: 1},
+		},
+	}
+	runs := shared.TestRuns{
+		{
+			ID:                int64(1),
+			ProductAtRevision: shared.ParseProductSpecUnsafe("edge").ProductAtRevision,
+		},
+	}
+	expected := And{
+		Args: []ConcreteQuery{False{}},
+	}
+	assert.Equal(t, expected, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_hasScreenshot(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"has_screenshot": "ChRoMe"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: HasScreenshot{"chrome"}}, rq)
+}
+
+func TestStructuredQuery_hasScreenshotBadBrowser(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"has_screenshot": "not-a-browser"
+		}
+	}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindHasScreenshot(t *testing.T) {
+	q := HasScreenshot{BrowserName: "chrome"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunHasScreenshot{1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunHasScreenshot{1},
+			RunHasScreenshot{3},
+		},
+	}, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_skipped(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"skipped": "FireFox"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: Skipped{"firefox"}}, rq)
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"skipped": "not-a-browser"}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"skipped": ""}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindSkipped(t *testing.T) {
+	q := Skipped{BrowserName: "firefox"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunSkipped{1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunSkipped{1},
+			RunSkipped{3},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunSkipped{1}.Size())
+}
+
+func TestStructuredQuery_intermittent(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"intermittent": "Chrome"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: Intermittent{"chrome"}}, rq)
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"intermittent": "not-a-browser"}}`), &rq)
+	assert.NotNil(t, err)
+	var i Intermittent
+	err = json.Unmarshal([]byte(`{"intermittent": ""}`), &i)
+	assert.EqualError(t, err, `Missing intermittent property: "intermittent"`)
+
+	data, err := json.Marshal(Intermittent{"chrome"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"intermittent":"chrome"}`, string(data))
+}
+
+func TestStructuredQuery_bindIntermittent(t *testing.T) {
+	q := Intermittent{BrowserName: "chrome"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Safari").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunIntermittent{1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunIntermittent{1},
+			RunIntermittent{3},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunIntermittent{1}.Size())
+}
+
+func TestStructuredQuery_asserts(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"asserts": {"browser_name": "Chrome", "eq": 0}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, AssertCount{BrowserName: "chrome", Min: 0, Max: 0}, rq.AbstractQuery)
+
+	for _, tc := range []struct {
+		json     string
+		expected AssertCount
+		marshal  string
+	}{
+		{`{"asserts": {"browser_name": "chrome", "eq": 3}}`, AssertCount{"chrome", 3, 3}, `{"asserts":{"browser_name":"chrome","eq":3}}`},
+		{`{"asserts": {"browser_name": "chrome", "gte": 10}}`, AssertCount{"chrome", 10, -1}, `{"asserts":{"browser_name":"chrome","gte":10}}`},
+		{`{"asserts": {"browser_name": "chrome", "lte": 5}}`, AssertCount{"chrome", 0, 5}, `{"asserts":{"browser_name":"chrome","gte":0,"lte":5}}`},
+		{`{"asserts": {"browser_name": "chrome", "gte": 1, "lte": 5}}`, AssertCount{"chrome", 1, 5}, `{"asserts":{"browser_name":"chrome","gte":1,"lte":5}}`},
+		{`{"asserts": {"browser_name": "chrome", "gte": 0, "lte": 0}}`, AssertCount{"chrome", 0, 0}, `{"asserts":{"browser_name":"chrome","eq":0}}`},
+	} {
+		var ac AssertCount
+		assert.Nil(t, json.Unmarshal([]byte(tc.json), &ac), tc.json)
+		assert.Equal(t, tc.expected, ac, tc.json)
+		data, err := json.Marshal(ac)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.marshal, string(data))
+	}
+
+	var ac AssertCount
+	err = json.Unmarshal([]byte(`{"asserts": {"browser_name": "chrome", "gte": 5, "lte": 2}}`), &ac)
+	assert.EqualError(t, err, `Invalid asserts bounds: "gte" (5) exceeds "lte" (2)`)
+	err = json.Unmarshal([]byte(`{"asserts": {"browser_name": "chrome", "eq": 1, "gte": 1}}`), &ac)
+	assert.EqualError(t, err, `Invalid asserts bounds: "eq" cannot be combined with "gte" or "lte"`)
+	err = json.Unmarshal([]byte(`{"asserts": {"browser_name": "chrome"}}`), &ac)
+	assert.EqualError(t, err, `Missing asserts bound: "eq", "gte" or "lte"`)
+	for _, invalid := range []string{
+		`{"asserts": {"browser_name": "chrome", "eq": -1}}`,
+		`{"asserts": {"browser_name": "chrome", "lte": -1}}`,
+		`{"asserts": {"browser_name": "not-a-browser", "eq": 0}}`,
+		`{"asserts": {"eq": 0}}`,
+		`{"asserts": {"browser_name": "chrome", "eq": null}}`,
+		`{"asserts": {"browser_name": "chrome", "ne": 0}}`,
+		`{"asserts": 0}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindAsserts(t *testing.T) {
+	q := AssertCount{BrowserName: "chrome", Min: 0, Max: 0}
+	runs := []shared.TestRun{
+		channelRun(1, "chrome"),
+		channelRun(2, "safari"),
+		channelRun(3, "chrome"),
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunAssertCount{Run: 1, Min: 0, Max: 0}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunAssertCount{Run: 1, Min: 0, Max: 0},
+			RunAssertCount{Run: 3, Min: 0, Max: 0},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunAssertCount{Run: 1}.Size())
+}
+
+func TestStructuredQuery_unexpected(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"unexpected": "Chrome"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: Unexpected{"chrome"}}, rq)
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"unexpected": "not-a-browser"}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"unexpected": ""}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindUnexpected(t *testing.T) {
+	q := Unexpected{BrowserName: "chrome"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunUnexpected{1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunUnexpected{1},
+			RunUnexpected{3},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunUnexpected{1}.Size())
+}
+
+func TestStructuredQuery_subtestMajority(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"subtest_majority": {"browser_name": "Chrome", "status": "fail"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{0, 1, 2},
+		AbstractQuery: SubtestMajority{BrowserName: "chrome", Status: shared.TestStatusFail},
+	}, rq)
+
+	for _, q := range []string{
+		`{"subtest_majority": {"browser_name": "not-a-browser", "status": "FAIL"}}`,
+		`{"subtest_majority": {"browser_name": "chrome", "status": "not-a-status"}}`,
+		`{"subtest_majority": {"browser_name": "chrome"}}`,
+		`{"subtest_majority": {"status": "FAIL"}}`,
+		`{"subtest_majority": {"browser_name": "chrome", "status": "FAIL", "extra": 1}}`,
+		`{"subtest_majority": "chrome"}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+q+`}`), &rq)
+		assert.NotNil(t, err, q)
+	}
+
+	var sm SubtestMajority
+	err = sm.unmarshal(newParser(ParseOpts{}), []byte(`{"subtest_majority": {"status": "FAIL"}}`))
+	assert.EqualError(t, err, `Missing subtest majority property: "subtest_majority.browser_name"`)
+}
+
+func TestStructuredQuery_bindSubtestMajority(t *testing.T) {
+	q := SubtestMajority{BrowserName: "chrome", Status: shared.TestStatusFail}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunSubtestPlurality{1, shared.TestStatusFail}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunSubtestPlurality{1, shared.TestStatusFail},
+			RunSubtestPlurality{3, shared.TestStatusFail},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunSubtestPlurality{1, shared.TestStatusFail}.Size())
+}
+
+func TestStructuredQuery_harnessMessage(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"harness_message": {"browser_name": "Chrome", "pattern": "Uncaught"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{0, 1, 2},
+		AbstractQuery: HarnessMessage{BrowserName: "chrome", Pattern: "Uncaught"},
+	}, rq)
+
+	for _, q := range []string{
+		`{"harness_message": {"browser_name": "not-a-browser", "pattern": "uncaught"}}`,
+		`{"harness_message": {"browser_name": "chrome", "pattern": ""}}`,
+		`{"harness_message": {"browser_name": "chrome"}}`,
+		`{"harness_message": {"pattern": "uncaught"}}`,
+		`{"harness_message": {"browser_name": "chrome", "pattern": "uncaught", "extra": 1}}`,
+		`{"harness_message": "uncaught"}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+q+`}`), &rq)
+		assert.NotNil(t, err, q)
+	}
+
+	var hm HarnessMessage
+	err = hm.unmarshal(newParser(ParseOpts{}), []byte(`{"harness_message": {"browser_name": "chrome"}}`))
+	assert.EqualError(t, err, `Missing harness message property: "harness_message.pattern"`)
+}
+
+func TestStructuredQuery_bindHarnessMessage(t *testing.T) {
+	q := HarnessMessage{BrowserName: "chrome", Pattern: "uncaught"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunHarnessMessage{1, "uncaught"}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunHarnessMessage{1, "uncaught"},
+			RunHarnessMessage{3, "uncaught"},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunHarnessMessage{1, "uncaught"}.Size())
+}
+
+func TestStructuredQuery_allSubtestsPass(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"all_subtests_pass": "Chrome"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AllSubtestsPass{"chrome"}}, rq)
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"all_subtests_pass": "not-a-browser"}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"all_subtests_pass": ""}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_bindAllSubtestsPass(t *testing.T) {
+	q := AllSubtestsPass{BrowserName: "chrome"}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunAllSubtestsPass{1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunAllSubtestsPass{1},
+			RunAllSubtestsPass{3},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunAllSubtestsPass{1}.Size())
+}
+
+func TestStructuredQuery_subtestPassRatio(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"subtest_pass_ratio": {"browser_name": "Chrome", "below": 0.5}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: SubtestPassRatio{BrowserName: "chrome", Below: 0.5, Above: -1}}, rq)
+
+	var spr SubtestPassRatio
+	assert.Nil(t, json.Unmarshal([]byte(`{"subtest_pass_ratio": {"browser_name": "firefox", "above": 0}}`), &spr))
+	assert.Equal(t, SubtestPassRatio{BrowserName: "firefox", Below: -1, Above: 0}, spr)
+	assert.Nil(t, json.Unmarshal([]byte(`{"subtest_pass_ratio": {"browser_name": "firefox", "above": 0, "below": 1}}`), &spr))
+	assert.Equal(t, SubtestPassRatio{BrowserName: "firefox", Below: 1, Above: 0}, spr)
+
+	for _, bad := range []string{
+		`{"subtest_pass_ratio": {"browser_name": "chrome"}}`,
+		`{"subtest_pass_ratio": {"below": 0.5}}`,
+		`{"subtest_pass_ratio": {"browser_name": "not-a-browser", "below": 0.5}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": -0.1}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": 1.1}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "above": 1.01}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "above": 0.5, "below": 0.5}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": "0.5"}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": 0.5, "lte": 1}}`,
+		`{"subtest_pass_ratio": 0.5}`,
+	} {
+		err := json.Unmarshal([]byte(bad), &spr)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestStructuredQuery_bindSubtestPassRatio(t *testing.T) {
+	q := SubtestPassRatio{BrowserName: "chrome", Below: 0.5, Above: -1}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunSubtestPassRatio{Run: 1, Below: 0.5, Above: -1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunSubtestPassRatio{Run: 1, Below: 0.5, Above: -1},
+			RunSubtestPassRatio{Run: 3, Below: 0.5, Above: -1},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunSubtestPassRatio{Run: 1, Below: 0.5, Above: -1}.Size())
+}
+
+func TestStructuredQuery_flakiness(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"flakiness": {"browser_name": "Chrome", "above": 0.2}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: FlakinessRate{BrowserName: "chrome", Above: 0.2}}, rq)
+
+	for _, bad := range []string{
+		`{"flakiness": {"above": 0.2}}`,
+		`{"flakiness": {"browser_name": "chrome"}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": 1}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": -0.1}}`,
+		`{"flakiness": {"browser_name": "not-a-browser", "above": 0.2}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": 0.2, "below": 0.5}}`,
+	} {
+		var fr FlakinessRate
+		assert.NotNil(t, json.Unmarshal([]byte(bad), &fr), bad)
+	}
+}
+
+func TestStructuredQuery_nonFiniteThresholds(t *testing.T) {
+	for _, bad := range []string{
+		`{"flakiness": {"browser_name": "chrome", "above": 1e400}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": -1e400}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": NaN}}`,
+		`{"flakiness": {"browser_name": "chrome", "above": Infinity}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": 1e400}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "above": -1e400}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "below": NaN}}`,
+		`{"subtest_pass_ratio": {"browser_name": "chrome", "above": "NaN"}}`,
+	} {
+		_, err := Parse([]byte(bad))
+		assert.NotNil(t, err, bad)
+	}
+
+	var fr FlakinessRate
+	err := fr.unmarshal(newParser(ParseOpts{}), []byte(`{"flakiness": {"browser_name": "chrome", "above": 1e400}}`))
+	assert.EqualError(t, err, `Invalid flakiness rate "above": 1e400 is not a finite number`)
+	var spr SubtestPassRatio
+	err = spr.unmarshal(newParser(ParseOpts{}), []byte(`{"subtest_pass_ratio": {"browser_name": "chrome", "below": -1e400}}`))
+	assert.EqualError(t, err, `Invalid subtest pass ratio "below": -1e400 is not a finite number`)
+
+	// Numbers too small to represent are rounded to 0.
+	assert.Nil(t, fr.unmarshal(newParser(ParseOpts{}), []byte(`{"flakiness": {"browser_name": "chrome", "above": 1e-400}}`)))
+	assert.Equal(t, FlakinessRate{BrowserName: "chrome", Above: 0}, fr)
+}
+
+func TestStructuredQuery_bindFlakiness(t *testing.T) {
+	q := FlakinessRate{BrowserName: "chrome", Above: 0.2}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	bound := q.BindToRuns(runs...)
+	assert.Equal(t, RunsFlakinessRate{Runs: []int64{1, 3}, Above: 0.2}, bound)
+	assert.Equal(t, 2, bound.Size())
+}
+
+func TestStructuredQuery_ref(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"ref": {"browser_name": "Chrome", "expect": "MisMatch"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: RefMatch{BrowserName: "chrome", Expected: RefExpectMismatch}}, rq)
+
+	var rm RefMatch
+	assert.Nil(t, json.Unmarshal([]byte(`{"ref": {"browser_name": "firefox", "expect": "match"}}`), &rm))
+	assert.Equal(t, RefMatch{BrowserName: "firefox", Expected: RefExpectMatch}, rm)
+
+	for _, bad := range []string{
+		`{"ref": {"expect": "match"}}`,
+		`{"ref": {"browser_name": "chrome"}}`,
+		`{"ref": {"browser_name": "chrome", "expect": "=="}}`,
+		`{"ref": {"browser_name": "chrome", "expect": "equal"}}`,
+		`{"ref": {"browser_name": "not-a-browser", "expect": "match"}}`,
+		`{"ref": {"browser_name": "chrome", "expect": "match", "type": "=="}}`,
+		`{"ref": null}`,
+	} {
+		assert.NotNil(t, json.Unmarshal([]byte(bad), &rm), bad)
+	}
+}
+
+func TestStructuredQuery_bindRef(t *testing.T) {
+	q := RefMatch{BrowserName: "chrome", Expected: RefExpectMatch}
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+	}
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, RunRefMatch{Run: 1, Expected: RefExpectMatch}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunRefMatch{Run: 1, Expected: RefExpectMatch},
+			RunRefMatch{Run: 3, Expected: RefExpectMatch},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, RunRefMatch{Run: 1, Expected: RefExpectMatch}.Size())
+}
+
+func TestStructuredQuery_focusArea(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {"focus_area": "flexbox"}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, FocusArea{Area: "flexbox", Paths: []string{"/css/css-flexbox/"}}, rq.AbstractQuery)
+
+	areas := map[string][]string{
+		"layout": []string{"/css/css-flexbox/", "/css/css-grid/"},
+	}
+	parsed, err := Parse([]byte(`{"run_ids": [0], "query": {"focus_area": "layout"}}`), WithFocusAreas(areas))
+	assert.Nil(t, err)
+	assert.Equal(t, FocusArea{Area: "layout", Paths: []string{"/css/css-flexbox/", "/css/css-grid/"}}, parsed.RunQuery.AbstractQuery)
+
+	// Areas are validated against the mapping in effect.
+	_, err = Parse([]byte(`{"run_ids": [0], "query": {"focus_area": "flexbox"}}`), WithFocusAreas(areas))
+	assert.NotNil(t, err)
+	for _, invalid := range []string{
+		`{"focus_area": "not-an-area"}`,
+		`{"focus_area": ""}`,
+		`{"focus_area": null}`,
+		`{"focus_area": 1}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindFocusArea(t *testing.T) {
+	fa := FocusArea{Area: "layout", Paths: []string{"/css/css-flexbox/", "/css/css-grid/"}}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			TestPath{Path: "/css/css-flexbox/"},
+			TestPath{Path: "/css/css-grid/"},
+		},
+	}, fa.BindToRuns())
+	assert.Equal(t, TestPath{Path: "/css/css-flexbox/"}, FocusArea{Area: "flexbox", Paths: []string{"/css/css-flexbox/"}}.BindToRuns())
+	assert.Equal(t, False{}, FocusArea{Area: "empty"}.BindToRuns())
+}
+
+func TestStructuredQuery_interopYear(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {"interop_year": 2021}
+	}`), &rq)
+	assert.Nil(t, err)
+	iy, ok := rq.AbstractQuery.(InteropYear)
+	assert.True(t, ok)
+	assert.Equal(t, 2021, iy.Year)
+	assert.Contains(t, iy.Paths, "/css/css-flexbox/")
+	assert.Equal(t, 5, len(iy.Paths))
+
+	areas := map[string][]string{
+		"layout": []string{"/css/css-flexbox/", "/css/css-grid/"},
+		"dom":    []string{"/dom/"},
+	}
+	years := map[int][]string{
+		2024: []string{"layout", "dom"},
+		2025: []string{"not-an-area"},
+	}
+	parsed, err := Parse([]byte(`{"run_ids": [0], "query": {"interop_year": 2024}}`), WithFocusAreas(areas), WithInteropYears(years))
+	assert.Nil(t, err)
+	assert.Equal(t, InteropYear{Year: 2024, Paths: []string{"/css/css-flexbox/", "/css/css-grid/", "/dom/"}}, parsed.RunQuery.AbstractQuery)
+
+	data, err := json.Marshal(parsed.RunQuery.AbstractQuery)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"interop_year":2024}`, string(data))
+
+	// Years are validated against the mappings in effect.
+	_, err = Parse([]byte(`{"run_ids": [0], "query": {"interop_year": 2021}}`), WithInteropYears(years))
+	assert.NotNil(t, err)
+	p := newParser(ParseOpts{FocusAreas: areas, InteropYears: years})
+	assert.EqualError(t, iy.unmarshal(p, []byte(`{"interop_year": 2021}`)), "Unknown interop year: 2021")
+	assert.EqualError(t, iy.unmarshal(p, []byte(`{"interop_year": 2025}`)), `Unknown focus area "not-an-area" of interop year 2025`)
+	for _, invalid := range []string{
+		`{"interop_year": 1999}`,
+		`{"interop_year": null}`,
+		`{"interop_year": "2021"}`,
+		`{"interop_year": 2021.5}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindInteropYear(t *testing.T) {
+	iy := InteropYear{Year: 2024, Paths: []string{"/css/css-flexbox/", "/css/css-grid/"}}
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			TestPath{Path: "/css/css-flexbox/"},
+			TestPath{Path: "/css/css-grid/"},
+		},
+	}, iy.BindToRuns())
+	assert.Equal(t, False{}, InteropYear{Year: 2024}.BindToRuns())
+}
+
+func TestStructuredQuery_subtest(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"subtest": "foo",
+			"exact": true
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: Subtest{Name: "foo", Exact: true}}, rq)
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"subtest": "foo"}}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, Subtest{Name: "foo"}, rq.AbstractQuery)
+	assert.Equal(t, Subtest{Name: "foo"}, rq.AbstractQuery.BindToRuns(shared.TestRun{ID: 0}))
+
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"subtest": 1}}`), &rq)
+	assert.NotNil(t, err)
+	err = json.Unmarshal([]byte(`{"run_ids": [0], "query": {"subtest": "foo", "exact": "yes"}}`), &rq)
+	assert.NotNil(t, err)
+}
+
+func TestStructuredQuery_nullPattern(t *testing.T) {
+	var tnp TestNamePattern
+	err := json.Unmarshal([]byte(`{"pattern":null}`), &tnp)
+	assert.EqualError(t, err, `Property "pattern" must not be null`)
+}
+
+func TestStructuredQuery_nullBrowserName(t *testing.T) {
+	var tse TestStatusEq
+	err := json.Unmarshal([]byte(`{"browser_name":null,"status":"PASS"}`), &tse)
+	assert.EqualError(t, err, `Property "browser_name" must not be null`)
+}
+
+func TestStructuredQuery_nullStatus(t *testing.T) {
+	var tse TestStatusEq
+	err := json.Unmarshal([]byte(`{"product":"chrome","status":null}`), &tse)
+	assert.EqualError(t, err, `Property "status" must not be null`)
+
+	var tsn TestStatusNeq
+	err = json.Unmarshal([]byte(`{"product":"chrome","status":{"not":null}}`), &tsn)
+	assert.EqualError(t, err, `Property "status.not" must not be null`)
+}
+
+func TestStructuredQuery_nullNested(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"or": [
+				{"pattern": "cssom"},
+				{"pattern": null}
+			]
+		}
+	}`), &rq)
+	assert.EqualError(t, err, `Property "pattern" must not be null`)
+}
+
+func TestStructuredQuery_subtestTotal(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"subtest_total": {"gte": 500}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: SubtestTotal{Min: 500, Max: -1}}, rq)
+
+	var st SubtestTotal
+	err = json.Unmarshal([]byte(`{"subtest_total": {"lte": 0}}`), &st)
+	assert.Nil(t, err)
+	assert.Equal(t, SubtestTotal{Min: 0, Max: 0}, st)
+
+	err = json.Unmarshal([]byte(`{"subtest_total": {"gte": 10, "lte": 10}}`), &st)
+	assert.Nil(t, err)
+	assert.Equal(t, SubtestTotal{Min: 10, Max: 10}, st)
+}
+
+func TestStructuredQuery_subtestTotalBadBounds(t *testing.T) {
+	for _, bad := range []string{
+		`{"subtest_total": {}}`,
+		`{"subtest_total": {"gte": -1}}`,
+		`{"subtest_total": {"lte": -1}}`,
+		`{"subtest_total": {"gte": 11, "lte": 10}}`,
+		`{"subtest_total": {"gt": 10}}`,
+		`{"subtest_total": {"gte": "10"}}`,
+		`{"subtest_total": 10}`,
+	} {
+		var st SubtestTotal
+		err := json.Unmarshal([]byte(bad), &st)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestStructuredQuery_bindSubtestTotal(t *testing.T) {
+	q := SubtestTotal{Min: 500, Max: -1}
+	assert.Equal(t, False{}, q.BindToRuns())
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+	}
+	assert.Equal(t, AnyRunSubtestTotal{Runs: []int64{1, 2}, Min: 500, Max: -1}, q.BindToRuns(runs...))
+}
+
+func TestParseWithOpts_strictStatus(t *testing.T) {
+	b := []byte(`{"run_ids": [1], "query": {"product": "chrome", "status": "NEW_STATUS"}}`)
+	var rq RunQuery
+	assert.NotNil(t, json.Unmarshal(b, &rq))
+
+	_, warnings, err := ParseWithOpts(b, ParseOpts{})
+	assert.NotNil(t, err)
+	assert.Nil(t, warnings)
+}
+
+func TestParseWithOpts_lenientStatus(t *testing.T) {
+	b := []byte(`{
+		"run_ids": [1],
+		"query": {
+			"and": [
+				{"product": "chrome", "status": "NEW_STATUS"},
+				{"status": {"not": "pass"}},
+				{"any_status": "other_status"}
+			]
+		}
+	}`)
+	rq, warnings, err := ParseWithOpts(b, ParseOpts{LenientStatus: true})
+	assert.Nil(t, err)
+	p := shared.ParseProductSpecUnsafe("chrome")
+	assert.Equal(t, RunQuery{
+		RunIDs: []int64{1},
+		AbstractQuery: AbstractAnd{
+			Args: []AbstractQuery{
+				TestStatusEq{Product: &p, Status: shared.TestStatusUnknown},
+				TestStatusNeq{Status: shared.TestStatusPass},
+				AnyStatus{Status: shared.TestStatusUnknown},
+			},
+		},
+	}, rq)
+	assert.Equal(t, []Warning{
+		Warning{Message: `Unknown test status "NEW_STATUS" treated as UNKNOWN`},
+		Warning{Message: `Unknown test status "other_status" treated as UNKNOWN`},
+	}, warnings)
+}
+
+func TestStructuredQuery_longTimeout(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"not": {"long_timeout": true}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: AbstractNot{LongTimeout{}}}, rq)
+
+	var lt LongTimeout
+	assert.NotNil(t, json.Unmarshal([]byte(`{"long_timeout": false}`), &lt))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"long_timeout": "yes"}`), &lt))
+}
+
+func TestStructuredQuery_bindLongTimeout(t *testing.T) {
+	q := LongTimeout{}
+	assert.Equal(t, False{}, q.BindToRuns())
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+	}
+	assert.Equal(t, AnyRunLongTimeout{Runs: []int64{1, 2}}, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_hasMetadataField(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"has_metadata": "Bug"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: HasMetadataField{Field: "bug"}}, rq)
+
+	for _, invalid := range []string{
+		`{"has_metadata": "url"}`,
+		`{"has_metadata": ""}`,
+		`{"has_metadata": null}`,
+		`{"has_metadata": true}`,
+	} {
+		var hmf HasMetadataField
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &hmf), invalid)
+	}
+}
+
+func TestStructuredQuery_bindHasMetadataField(t *testing.T) {
+	q := HasMetadataField{Field: "label"}
+	assert.Equal(t, False{}, q.BindToRuns())
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+	}
+	assert.Equal(t, AnyRunHasMetadataField{Runs: []int64{1, 2}, Field: "label"}, q.BindToRuns(runs...))
+	assert.Equal(t, 1, q.BindToRuns(runs...).Size())
+}
+
+func TestStructuredQuery_regressedSince(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"regressed_since": "last_stable"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: RegressedSince{Label: "last_stable"}}, rq)
+
+	for _, invalid := range []string{
+		`{"regressed_since": ""}`,
+		`{"regressed_since": null}`,
+		`{"regressed_since": 1}`,
+	} {
+		var rs RegressedSince
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &rs), invalid)
+	}
+}
+
+func TestStructuredQuery_bindRegressedSince(t *testing.T) {
+	q := RegressedSince{Label: "last_stable"}
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2, Labels: []string{"stable", "last_stable"}},
+		shared.TestRun{ID: 3, Labels: []string{"last_stable"}},
+	}
+	// The first labeled run is the baseline.
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunRegressed{Baseline: 2, Run: 1},
+			RunRegressed{Baseline: 2, Run: 3},
+		},
+	}, q.BindToRuns(runs...))
+	assert.Equal(t, RunRegressed{Baseline: 2, Run: 1}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[1]))
+	assert.Equal(t, False{}, q.BindToRuns(runs[0]))
+	assert.Equal(t, False{}, q.BindToRuns())
+}
+
+func TestStructuredQuery_bindRegressedSince_multiBrowser(t *testing.T) {
+	q := RegressedSince{Label: "last_stable"}
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1, ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision},
+		shared.TestRun{ID: 2, ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision},
+		shared.TestRun{ID: 3, ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision, Labels: []string{"last_stable"}},
+		shared.TestRun{ID: 4, ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision, Labels: []string{"last_stable"}},
+	}
+	// Only runs of the baseline's browser are compared.
+	assert.Equal(t, RunRegressed{Baseline: 3, Run: 1}, q.BindToRuns(runs...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[1:]...))
+}
+
+func TestStructuredQuery_changedInPR(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"changed_in_pr": true
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: ChangedInPR{}}, rq)
+
+	for _, invalid := range []string{
+		`{"changed_in_pr": false}`,
+		`{"changed_in_pr": null}`,
+		`{"changed_in_pr": "true"}`,
+	} {
+		var c ChangedInPR
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &c), invalid)
+	}
+}
+
+func TestStructuredQuery_bindChangedInPR(t *testing.T) {
+	q := ChangedInPR{}
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2, Labels: []string{shared.PRHeadLabel}},
+		shared.TestRun{ID: 3, Labels: []string{shared.PRBaseLabel}},
+	}
+	assert.Equal(t, RunStatusChanged{Base: 3, Head: 2}, q.BindToRuns(runs...))
+	assert.Equal(t, 2, RunStatusChanged{Base: 3, Head: 2}.Size())
+	assert.Equal(t, False{}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[0], runs[2]))
+	assert.Equal(t, False{}, q.BindToRuns())
+}
+
+func TestStructuredQuery_anyChanged(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"any_changed": "last_stable"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: AnyChanged{Label: "last_stable"}}, rq)
+
+	var ac AnyChanged
+	assert.Nil(t, json.Unmarshal([]byte(`{"any_changed": 123}`), &ac))
+	assert.Equal(t, AnyChanged{Run: 123}, ac)
+
+	for _, bad := range []string{
+		`{"any_changed": ""}`,
+		`{"any_changed": 0}`,
+		`{"any_changed": -1}`,
+		`{"any_changed": true}`,
+		`{"any_changed": null}`,
+	} {
+		assert.NotNil(t, json.Unmarshal([]byte(bad), &ac), bad)
+	}
+}
+
+func TestStructuredQuery_bindAnyChanged(t *testing.T) {
+	runs := []shared.TestRun{
+		channelRun(1, "chrome", "last_stable"),
+		channelRun(2, "firefox", "last_stable"),
+		channelRun(3, "chrome"),
+		channelRun(4, "firefox"),
+		channelRun(5, "safari"),
+		channelRun(6, "chrome"),
+	}
+	q := AnyChanged{Label: "last_stable"}
+	assert.Equal(t, AnyRunStatusChanged{Bases: []int64{1, 2}, Heads: []int64{3, 4}}, q.BindToRuns(runs...))
+	assert.Equal(t, 2, AnyRunStatusChanged{Bases: []int64{1, 2}, Heads: []int64{3, 4}}.Size())
+	assert.Equal(t, AnyRunStatusChanged{Bases: []int64{1}, Heads: []int64{3}}, q.BindToRuns(runs[0], runs[1], runs[2]))
+	assert.Equal(t, False{}, q.BindToRuns(runs[:2]...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[2:]...))
+
+	q = AnyChanged{Run: 6}
+	assert.Equal(t, AnyRunStatusChanged{Bases: []int64{6}, Heads: []int64{1}}, q.BindToRuns(runs...))
+	q = AnyChanged{Run: 5}
+	assert.Equal(t, False{}, q.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_subtestDelta(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"subtest_delta": {"from": "Chrome", "to": "firefox", "changed": true}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, SubtestCountDelta{From: "chrome", To: "firefox", Changed: true}, rq.AbstractQuery)
+
+	var scd SubtestCountDelta
+	assert.Nil(t, json.Unmarshal([]byte(`{"subtest_delta": {"from": "chrome", "to": "chrome"}}`), &scd))
+	assert.Equal(t, SubtestCountDelta{From: "chrome", To: "chrome", Changed: true}, scd)
+	assert.Nil(t, json.Unmarshal([]byte(`{"subtest_delta": {"from": "chrome", "to": "safari", "changed": false}}`), &scd))
+	assert.Equal(t, SubtestCountDelta{From: "chrome", To: "safari", Changed: false}, scd)
+
+	data, err := json.Marshal(scd)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"subtest_delta":{"from":"chrome","to":"safari","changed":false}}`, string(data))
+
+	err = json.Unmarshal([]byte(`{"subtest_delta": {"from": "chrome"}}`), &scd)
+	assert.EqualError(t, err, `Missing subtest delta property: "subtest_delta.to"`)
+	for _, invalid := range []string{
+		`{"subtest_delta": {"from": "not-a-browser", "to": "chrome"}}`,
+		`{"subtest_delta": {"from": "chrome", "to": "firefox", "changed": "yes"}}`,
+		`{"subtest_delta": {"from": "chrome", "to": "firefox", "changed": null}}`,
+		`{"subtest_delta": {"from": "chrome", "to": "firefox", "by": 2}}`,
+		`{"subtest_delta": "chrome"}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindSubtestDelta(t *testing.T) {
+	runs := []shared.TestRun{
+		channelRun(1, "chrome"),
+		channelRun(2, "firefox"),
+		channelRun(3, "chrome"),
+		channelRun(4, "firefox"),
+	}
+	q := SubtestCountDelta{From: "chrome", To: "firefox", Changed: true}
+	assert.Equal(t, RunsSubtestCountDelta{From: 1, To: 2, Changed: true}, q.BindToRuns(runs...))
+	assert.Equal(t, 2, RunsSubtestCountDelta{From: 1, To: 2}.Size())
+	assert.Equal(t, RunsSubtestCountDelta{From: 3, To: 4, Changed: true}, q.BindToRuns(runs[2:]...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[0], runs[2]))
+
+	// The To run of the same browser is the first other run.
+	q = SubtestCountDelta{From: "firefox", To: "firefox"}
+	assert.Equal(t, RunsSubtestCountDelta{From: 2, To: 4}, q.BindToRuns(runs...))
+	assert.Equal(t, False{}, q.BindToRuns(runs[:3]...))
+}
+
+func TestStructuredQuery_sameRender(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"same_render": {"a": "Chrome", "b": "firefox"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, SameRender{LeftBrowser: "chrome", RightBrowser: "firefox"}, rq.AbstractQuery)
+
+	data, err := json.Marshal(rq.AbstractQuery)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"same_render":{"a":"chrome","b":"firefox"}}`, string(data))
+
+	var sr SameRender
+	err = json.Unmarshal([]byte(`{"same_render": {"a": "chrome"}}`), &sr)
+	assert.EqualError(t, err, `Missing same render property: "same_render.b"`)
+	for _, invalid := range []string{
+		`{"same_render": {"a": "not-a-browser", "b": "chrome"}}`,
+		`{"same_render": {"a": "chrome", "b": null}}`,
+		`{"same_render": {"a": "chrome", "b": "firefox", "c": "safari"}}`,
+		`{"same_render": ["chrome", "firefox"]}`,
+	} {
+		err = json.Unmarshal([]byte(`{"run_ids": [0], "query": `+invalid+`}`), &rq)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStructuredQuery_bindSameRender(t *testing.T) {
+	runs := []shared.TestRun{
+		channelRun(1, "chrome"),
+		channelRun(2, "firefox"),
+		channelRun(3, "chrome"),
+	}
+	q := SameRender{LeftBrowser: "chrome", RightBrowser: "firefox"}
+	assert.Equal(t, RunsSameRender{Left: 1, Right: 2}, q.BindToRuns(runs...))
+	assert.Equal(t, 2, RunsSameRender{Left: 1, Right: 2}.Size())
+	assert.Equal(t, False{}, q.BindToRuns(runs[0], runs[2]))
+
+	// The right run of the same browser is the first other run.
+	q = SameRender{LeftBrowser: "chrome", RightBrowser: "chrome"}
+	assert.Equal(t, RunsSameRender{Left: 1, Right: 3}, q.BindToRuns(runs...))
+}
+
+func TestStatusSeverity(t *testing.T) {
+	ordered := [][]shared.TestStatus{
+		{shared.TestStatusPass, shared.TestStatusOK},
+		{shared.TestStatusSkip, shared.TestStatusNotRun},
+		{shared.TestStatusAssert},
+		{shared.TestStatusFail},
+		{shared.TestStatusTimeout},
+		{shared.TestStatusError},
+		{shared.TestStatusCrash},
+	}
+	for i, statuses := range ordered {
+		for _, status := range statuses {
+			assert.Equal(t, i, StatusSeverity(status), status.String())
+		}
+	}
+	assert.Equal(t, -1, StatusSeverity(shared.TestStatusUnknown))
+}
+
+func TestStructuredQuery_presentInAll(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"present_in_all": true
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: PresentInAll{}}, rq)
+
+	var pia PresentInAll
+	assert.NotNil(t, json.Unmarshal([]byte(`{"present_in_all": false}`), &pia))
+}
+
+func TestStructuredQuery_bindPresentInAll(t *testing.T) {
+	assert.Equal(t, False{}, PresentInAll{}.BindToRuns())
+
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+	}
+	q := PresentInAll{}.BindToRuns(runs...)
+	assert.Equal(t, PresentInAllBrowsers{RunsByBrowser: [][]int64{{1, 3}, {2}}}, q)
+	assert.Equal(t, 2, q.Size())
+}
+
+func TestStructuredQuery_browsersFailing(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"browsers_failing": {"eq": 2}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: BrowsersFailing{Count: 2, Comparison: ComparisonEq}}, rq)
+
+	var bf BrowsersFailing
+	assert.Nil(t, json.Unmarshal([]byte(`{"browsers_failing": {"gte": 1}}`), &bf))
+	assert.Equal(t, BrowsersFailing{Count: 1, Comparison: ComparisonGte}, bf)
+	assert.Nil(t, json.Unmarshal([]byte(`{"browsers_failing": {"lte": 0}}`), &bf))
+	assert.Equal(t, BrowsersFailing{Count: 0, Comparison: ComparisonLte}, bf)
+
+	for _, invalid := range []string{
+		`{"browsers_failing": {}}`,
+		`{"browsers_failing": {"eq": 1, "lte": 2}}`,
+		`{"browsers_failing": {"gt": 1}}`,
+		`{"browsers_failing": {"eq": -1}}`,
+		`{"browsers_failing": {"eq": "2"}}`,
+		`{"browsers_failing": 2}`,
+		`{"browsers_failing": null}`,
+	} {
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &bf), invalid)
+	}
+
+	data, err := json.Marshal(BrowsersFailing{Count: 3, Comparison: ComparisonGte})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"browsers_failing":{"gte":3}}`, string(data))
+}
+
+func TestStructuredQuery_bindBrowsersFailing(t *testing.T) {
+	bf := BrowsersFailing{Count: 2, Comparison: ComparisonEq}
+	assert.Equal(t, False{}, bf.BindToRuns())
+
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                4,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("safari").ProductAtRevision,
+		},
+	}
+	q := bf.BindToRuns(runs...)
+	assert.Equal(t, BrowsersFailingCount{
+		RunsByBrowser: [][]int64{{1, 3}, {2}, {4}},
+		Count:         2,
+		Comparison:    ComparisonEq,
+	}, q)
+	assert.Equal(t, 3, q.Size())
+}
+
+func TestStructuredQuery_worseThanMedian(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"worse_than_median": "Safari"
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: WorseThanMedian{BrowserName: "safari"}}, rq)
+
+	var wtm WorseThanMedian
+	assert.NotNil(t, json.Unmarshal([]byte(`{"worse_than_median": "netscape"}`), &wtm))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"worse_than_median": ""}`), &wtm))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"worse_than_median": null}`), &wtm))
+
+	data, err := json.Marshal(WorseThanMedian{BrowserName: "safari"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"worse_than_median":"safari"}`, string(data))
+}
+
+func TestStructuredQuery_bindWorseThanMedian(t *testing.T) {
+	wtm := WorseThanMedian{BrowserName: "safari"}
+	assert.Equal(t, False{}, wtm.BindToRuns())
+
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("Safari").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                4,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("safari").ProductAtRevision,
+		},
+	}
+	q := wtm.BindToRuns(runs...)
+	assert.Equal(t, BrowserWorseThanMedian{Browser: 2, RunsByBrowser: [][]int64{{1}, {2}, {3, 4}}}, q)
+	assert.Equal(t, 3, q.Size())
+
+	// No safari run.
+	assert.Equal(t, False{}, wtm.BindToRuns(runs[:2]...))
+}
+
+func TestStructuredQuery_subtestOnlyIn(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"subtest_only_in": {"browser_name": "Chrome", "subtest": "foo"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1}, AbstractQuery: SubtestOnlyIn{BrowserName: "chrome", Subtest: "foo"}}, rq)
+
+	var soi SubtestOnlyIn
+	assert.NotNil(t, json.Unmarshal([]byte(`{"subtest_only_in": {"browser_name": "netscape", "subtest": "foo"}}`), &soi))
+	assert.EqualError(t, json.Unmarshal([]byte(`{"subtest_only_in": {"browser_name": "chrome"}}`), &soi), `Missing subtest only in property: "subtest_only_in.subtest"`)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"subtest_only_in": {"browser_name": "chrome", "subtest": "foo", "exact": true}}`), &soi))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"subtest_only_in": null}`), &soi))
+
+	data, err := json.Marshal(SubtestOnlyIn{BrowserName: "chrome", Subtest: "foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"subtest_only_in":{"browser_name":"chrome","subtest":"foo"}}`, string(data))
+}
+
+func TestStructuredQuery_bindSubtestOnlyIn(t *testing.T) {
+	soi := SubtestOnlyIn{BrowserName: "firefox", Subtest: "foo"}
+	assert.Equal(t, False{}, soi.BindToRuns())
+
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+	}
+	q := soi.BindToRuns(runs...)
+	assert.Equal(t, BrowserSubtestOnlyIn{Browser: 1, RunsByBrowser: [][]int64{{1, 3}, {2}}, Subtest: "foo"}, q)
+	assert.Equal(t, 2, q.Size())
+
+	// No firefox run.
+	assert.Equal(t, False{}, soi.BindToRuns(runs[0], runs[2]))
+}
+
+func TestStructuredQuery_firstSeen(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"first_seen": {"after": "2024-01-01T00:00:00Z"}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: FirstSeen{Since: since}}, rq)
+
+	data, err := json.Marshal(FirstSeen{Since: since})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"first_seen":{"after":"2024-01-01T00:00:00Z"}}`, string(data))
+
+	var fs FirstSeen
+	assert.EqualError(t, json.Unmarshal([]byte(`{"first_seen": {"after": "2024-01-01"}}`), &fs),
+		`Invalid first seen timestamp "2024-01-01": must be RFC 3339 (e.g., "2024-01-01T00:00:00Z")`)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"first_seen": {}}`), &fs))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"first_seen": {"before": "2024-01-01T00:00:00Z"}}`), &fs))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"first_seen": "2024-01-01T00:00:00Z"}`), &fs))
+}
+
+func TestStructuredQuery_bindFirstSeen(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := FirstSeen{Since: since}
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1, TimeStart: since.AddDate(0, -1, 0)},
+		shared.TestRun{ID: 2, TimeStart: since},
+		shared.TestRun{ID: 3, TimeStart: since.AddDate(0, 0, 1)},
+	}
+	q := fs.BindToRuns(runs...)
+	assert.Equal(t, FirstSeenAfter{Before: []int64{1, 2}, After: []int64{3}}, q)
+	assert.Equal(t, 1, q.Size())
+
+	// No run started after the threshold.
+	assert.Equal(t, False{}, fs.BindToRuns(runs[:2]...))
+}
+
+func TestStructuredQuery_coverage(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"coverage": {"gte": 3}
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: CoverageCount{Min: 3}}, rq)
+
+	data, err := json.Marshal(CoverageCount{Min: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"coverage":{"gte":3}}`, string(data))
+
+	var cc CoverageCount
+	assert.Nil(t, json.Unmarshal([]byte(`{"coverage": {"gte": 0}}`), &cc))
+	assert.EqualError(t, json.Unmarshal([]byte(`{"coverage": {"gte": -1}}`), &cc), `Invalid coverage bound "gte": -1`)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"coverage": {}}`), &cc))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"coverage": {"lte": 2}}`), &cc))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"coverage": 3}`), &cc))
+}
+
+func TestStructuredQuery_bindCoverage(t *testing.T) {
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+		shared.TestRun{ID: 3},
+	}
+	q := CoverageCount{Min: 2}.BindToRuns(runs...)
+	assert.Equal(t, RunsWithResults{Runs: []int64{1, 2, 3}, Min: 2}, q)
+	assert.Equal(t, 3, q.Size())
+
+	// Too few runs.
+	assert.Equal(t, False{}, CoverageCount{Min: 4}.BindToRuns(runs...))
+}
+
+func TestStructuredQuery_patternIgnoreCase(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"pattern": "CSSOM",
+			"ignore_case": true
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{0, 1, 2}, AbstractQuery: TestNamePattern{Pattern: "CSSOM", IgnoreCase: true}}, rq)
+
+	var tnp TestNamePattern
+	assert.NotNil(t, json.Unmarshal([]byte(`{"pattern": "cssom", "ignore_case": "yes"}`), &tnp))
+}
+
+func TestStructuredQuery_patternArray(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1, 2],
+		"query": {
+			"pattern": ["flexbox", "grid"],
+			"match_all": true
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{0, 1, 2},
+		AbstractQuery: TestNamePattern{Patterns: []string{"flexbox", "grid"}, MatchAll: true},
+	}, rq)
+
+	var tnp TestNamePattern
+	err = json.Unmarshal([]byte(`{"pattern": ["flexbox", "grid"]}`), &tnp)
+	assert.Nil(t, err)
+	assert.Equal(t, TestNamePattern{Patterns: []string{"flexbox", "grid"}}, tnp)
+}
+
+func TestStructuredQuery_patternArrayBad(t *testing.T) {
+	for _, bad := range []string{
+		`{"pattern": []}`,
+		`{"pattern": ["flexbox", 1]}`,
+		`{"pattern": {"flexbox": true}}`,
+		`{"pattern": ["flexbox"], "match_all": "yes"}`,
+	} {
+		var tnp TestNamePattern
+		assert.NotNil(t, json.Unmarshal([]byte(bad), &tnp), bad)
+	}
+}
@@ -12,14 +12,20 @@ import (
 type aggregator interface {
 	Add(t TestID) error
 	Done() []query.SearchResult
+	// Count is the number of distinct tests (i.e., search results) added.
+	Count() int
+	// Truncated reports whether tests were dropped because of the index's
+	// result limit.
+	Truncated() bool
 }
 
 type indexAggregator struct {
 	index
 
-	runIDs []RunID
-	agg    map[uint64]query.SearchResult
-	opts   query.AggregationOpts
+	runIDs    []RunID
+	agg       map[uint64]query.SearchResult
+	opts      query.AggregationOpts
+	truncated bool
 }
 
 func (a *indexAggregator) Add(t TestID) error {
@@ -40,13 +46,13 @@ func (a *indexAggregator) Add(t TestID) error {
 		}
 	}
 
-	if a.opts.IgnoreTestHarnessResult {
-		for _, id := range a.runIDs {
-			res := shared.TestStatus(a.runResults[id].GetResult(t))
-			if res.IsHarnessStatus() {
-				return nil
-			}
-		}
+	if a.opts.IgnoreTestHarnessResult && hasHarnessResult(a.index, a.runIDs, t) {
+		return nil
+	}
+	if !ok && a.maxResults > 0 && len(a.agg) >= a.maxResults {
+		// Results for tests already added are still aggregated.
+		a.truncated = true
+		return nil
 	}
 
 	if a.opts.InteropFormat {
@@ -63,6 +69,21 @@ func (a *indexAggregator) Add(t TestID) error {
 		r.Interop[passing]++
 	}
 
+	if a.opts.IncludeStatuses && r.Statuses == nil {
+		r.Statuses = make([]string, len(a.runIDs))
+		// The statuses of the test itself, regardless of which of its subtests
+		// are added.
+		top := TestID{testID: id}
+		for i, run := range a.runIDs {
+			rrs := a.runResults[run]
+			if rrs.HasResult(top) {
+				r.Statuses[i] = shared.TestStatus(rrs.GetResult(top)).String()
+			} else {
+				r.Statuses[i] = query.StatusMissing
+			}
+		}
+	}
+
 	results := r.LegacyStatus
 	if results == nil {
 		results = make([]query.LegacySearchRunResult, len(a.runIDs))
@@ -101,6 +122,25 @@ func (a *indexAggregator) Add(t TestID) error {
 	return nil
 }
 
+func (a *indexAggregator) Count() int {
+	return len(a.agg)
+}
+
+func (a *indexAggregator) Truncated() bool {
+	return a.truncated
+}
+
+// each calls f with each search result aggregated so far, stopping at the first
+// error.
+func (a *indexAggregator) each(f func(query.SearchResult) error) error {
+	for _, r := range a.agg {
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (a *indexAggregator) Done() []query.SearchResult {
 	res := make([]query.SearchResult, 0, len(a.agg))
 	for _, r := range a.agg {
@@ -109,7 +149,65 @@ func (a *indexAggregator) Done() []query.SearchResult {
 	return res
 }
 
-func newIndexAggregator(idx index, runIDs []RunID, opts query.AggregationOpts) aggregator {
+// countAggregator is an aggregator that counts distinct tests, as
+// indexAggregator would aggregate them, without assembling search results.
+type countAggregator struct {
+	index
+
+	runIDs []RunID
+	tests  map[uint64]bool
+	opts   query.AggregationOpts
+}
+
+func (a *countAggregator) Add(t TestID) error {
+	if a.opts.IgnoreTestHarnessResult && hasHarnessResult(a.index, a.runIDs, t) {
+		return nil
+	}
+	a.tests[t.testID] = true
+	return nil
+}
+
+func (a *countAggregator) Count() int {
+	return len(a.tests)
+}
+
+// Truncated for countAggregator is always false; counts are not capped.
+func (a *countAggregator) Truncated() bool {
+	return false
+}
+
+// Done for countAggregator produces no search results; see Count.
+func (a *countAggregator) Done() []query.SearchResult {
+	return nil
+}
+
+// hasHarnessResult reports whether t has a test harness status (e.g., OK) in
+// any of the given runs.
+func hasHarnessResult(idx index, runIDs []RunID, t TestID) bool {
+	for _, id := range runIDs {
+		res := shared.TestStatus(idx.runResults[id].GetResult(t))
+		if res.IsHarnessStatus() {
+			return true
+		}
+	}
+	return false
+}
+
+// newAggregator produces a countAggregator when opts.CountOnly is set, and an
+// indexAggregator otherwise.
+func newAggregator(idx index, runIDs []RunID, opts query.AggregationOpts) aggregator {
+	if opts.CountOnly {
+		return &countAggregator{
+			index:  idx,
+			runIDs: runIDs,
+			tests:  make(map[uint64]bool),
+			opts:   opts,
+		}
+	}
+	return newIndexAggregator(idx, runIDs, opts)
+}
+
+func newIndexAggregator(idx index, runIDs []RunID, opts query.AggregationOpts) *indexAggregator {
 	return &indexAggregator{
 		index:  idx,
 		runIDs: runIDs,
@@ -0,0 +1,522 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"errors"
+	"math/bits"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+var errNotShardedIndex = errors.New("BitsetBinder requires an Index created by NewShardedWPTIndex")
+
+// bitset is a set of test positions (within a shard), one bit per test.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, numWords(n))
+}
+
+// numWords is the number of words in a bitset of n bits.
+func numWords(n int) int {
+	return (n + 63) / 64
+}
+
+// fullBitset produces a bitset containing each of the n test positions.
+func fullBitset(n int) bitset {
+	b := newBitset(n)
+	for i := range b {
+		b[i] = ^uint64(0)
+	}
+	b.trim(n)
+	return b
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+// trim clears bits beyond the first n.
+func (b bitset) trim(n int) {
+	if rem := uint(n % 64); rem != 0 {
+		b[len(b)-1] &= (1 << rem) - 1
+	}
+}
+
+func (b bitset) copy() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+func (b bitset) and(o bitset) {
+	for i := range b {
+		b[i] &= o[i]
+	}
+}
+
+func (b bitset) or(o bitset) {
+	for i := range b {
+		b[i] |= o[i]
+	}
+}
+
+// not complements the first n bits.
+func (b bitset) not(n int) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	b.trim(n)
+}
+
+// forEach calls f with the position of each bit that is set.
+func (b bitset) forEach(f func(int)) {
+	for i, word := range b {
+		for word != 0 {
+			j := bits.TrailingZeros64(word)
+			f(i*64 + j)
+			word &= word - 1
+		}
+	}
+}
+
+// runStatus identifies the bitset of tests with a particular status in a run.
+type runStatus struct {
+	run    RunID
+	status ResultID
+}
+
+// bitsetNode is a node of a query compiled for bitset evaluation.
+type bitsetNode interface {
+	eval(s *bitsetShard) bitset
+	// cost estimates the work of eval, as for BitsetPlan.Cost.
+	cost(s *bitsetShard) int
+}
+
+// bitsetLeaf evaluates to a precomputed bitset, which must not be modified.
+type bitsetLeaf struct {
+	rs runStatus
+}
+
+// bitsetAll evaluates to a constant: all tests, or none.
+type bitsetAll bool
+
+// bitsetScan evaluates a filter (i.e., an atom that has no precomputed bitset,
+// such as a test name pattern) over every test. With negate, it evaluates to
+// the tests that the filter does not match, so that the negation of a scanned
+// atom (e.g., Not(TestNamePattern)) needs only a single pass over the tests.
+type bitsetScan struct {
+	f      filter
+	negate bool
+}
+
+type bitsetAnd []bitsetNode
+
+type bitsetOr []bitsetNode
+
+// bitsetPath evaluates a test path prefix to the contiguous range of tests that
+// it occupies in a pathTrie order.
+type bitsetPath struct {
+	path string
+}
+
+type bitsetNot struct {
+	arg bitsetNode
+}
+
+type bitsetCount struct {
+	count int
+	args  []bitsetNode
+}
+
+func (l bitsetLeaf) cost(s *bitsetShard) int { return len(s.statuses[l.rs]) }
+
+func (a bitsetAll) cost(s *bitsetShard) int { return numWords(len(s.order)) }
+
+func (sc bitsetScan) cost(s *bitsetShard) int {
+	evals, _ := estimateFilter(sc.f, s.index)
+	return int(float64(len(s.order))*evals + 0.5)
+}
+
+func (p bitsetPath) cost(s *bitsetShard) int { return numWords(len(s.order)) }
+
+func (a bitsetAnd) cost(s *bitsetShard) int { return costAll(s, a) }
+
+func (o bitsetOr) cost(s *bitsetShard) int { return costAll(s, o) }
+
+func (n bitsetNot) cost(s *bitsetShard) int {
+	return numWords(len(s.order)) + n.arg.cost(s)
+}
+
+func (c bitsetCount) cost(s *bitsetShard) int {
+	return len(s.order) + costAll(s, c.args)
+}
+
+// costAll is the cost of evaluating nodes and combining each of their bitsets
+// into another.
+func costAll(s *bitsetShard, nodes []bitsetNode) int {
+	cost := numWords(len(s.order))
+	for _, node := range nodes {
+		cost += node.cost(s) + numWords(len(s.order))
+	}
+	return cost
+}
+
+func (l bitsetLeaf) eval(s *bitsetShard) bitset {
+	return s.statuses[l.rs].copy()
+}
+
+func (a bitsetAll) eval(s *bitsetShard) bitset {
+	if a {
+		return fullBitset(len(s.order))
+	}
+	return newBitset(len(s.order))
+}
+
+func (sc bitsetScan) eval(s *bitsetShard) bitset {
+	b := newBitset(len(s.order))
+	for i, t := range s.order {
+		if sc.f.Filter(t) != sc.negate {
+			b.set(i)
+		}
+	}
+	return b
+}
+
+func (p bitsetPath) eval(s *bitsetShard) bitset {
+	b := newBitset(len(s.order))
+	start, end := s.trie.lookup(p.path)
+	for i := start; i < end; i++ {
+		b.set(i)
+	}
+	return b
+}
+
+func (a bitsetAnd) eval(s *bitsetShard) bitset {
+	b := fullBitset(len(s.order))
+	for _, arg := range a {
+		b.and(arg.eval(s))
+	}
+	return b
+}
+
+func (o bitsetOr) eval(s *bitsetShard) bitset {
+	b := newBitset(len(s.order))
+	for _, arg := range o {
+		b.or(arg.eval(s))
+	}
+	return b
+}
+
+func (n bitsetNot) eval(s *bitsetShard) bitset {
+	b := n.arg.eval(s)
+	b.not(len(s.order))
+	return b
+}
+
+func (c bitsetCount) eval(s *bitsetShard) bitset {
+	counts := make([]int, len(s.order))
+	for _, arg := range c.args {
+		arg.eval(s).forEach(func(i int) {
+			counts[i]++
+		})
+	}
+	b := newBitset(len(s.order))
+	for i, count := range counts {
+		if count == c.count {
+			b.set(i)
+		}
+	}
+	return b
+}
+
+// bitsetShard is a shard's index data, along with an ordering of the shard's
+// tests and bitsets over that ordering for each (run, status) pair referenced
+// by the query.
+type bitsetShard struct {
+	index
+	order    []TestID
+	statuses map[runStatus]bitset
+	root     bitsetNode
+	// tries, when non-nil, provides the order of the shard's tests, by which
+	// test paths are resolved without scanning.
+	tries *pathTries
+	trie  *pathTrie
+}
+
+// BitsetBinder is a query.Binder that precomputes, at bind time, a bitset of
+// tests for each (run, status) pair that the query constrains. Executing the
+// resulting Plan reduces status constraints, and the logical operators over
+// them, to bitwise operations. Other atoms, such as test name patterns, are
+// evaluated by scanning every test, as with Index.Bind. BitsetBinder pays off
+// for queries over many runs that consist mostly of status constraints. It is a
+// query.PartialBinder, and its plans are query.TruncatingPlans.
+type BitsetBinder struct {
+	idx   *shardedWPTIndex
+	tries *pathTries
+}
+
+// BitsetOption is a functional option for NewBitsetBinder.
+type BitsetOption func(*BitsetBinder)
+
+// WithPathTrie is a BitsetOption that precomputes, and caches across binds, a
+// trie of each shard's test names, so that test path atoms (including focus
+// areas) resolve by trie lookup rather than by scanning every test. The trie
+// for a shard is rebuilt when tests are added to the shard.
+func WithPathTrie() BitsetOption {
+	return func(bb *BitsetBinder) {
+		bb.tries = newPathTries()
+	}
+}
+
+// NewBitsetBinder constructs a BitsetBinder over an Index created by
+// NewShardedWPTIndex.
+func NewBitsetBinder(idx Index, opts ...BitsetOption) (BitsetBinder, error) {
+	sharded, ok := idx.(*shardedWPTIndex)
+	if !ok {
+		return BitsetBinder{}, errNotShardedIndex
+	}
+	bb := BitsetBinder{idx: sharded}
+	for _, opt := range opts {
+		opt(&bb)
+	}
+	return bb, nil
+}
+
+// BitsetPlan is a query.Plan produced by BitsetBinder.
+type BitsetPlan []*bitsetShard
+
+// Bind produces a BitsetPlan for the given runs and query.
+func (bb BitsetBinder) Bind(runs []shared.TestRun, q query.ConcreteQuery) (query.Plan, error) {
+	plan, _, err := bb.BindWithOpts(runs, q, query.BindOpts{})
+	return plan, err
+}
+
+// BindWithOpts produces a BitsetPlan for the given runs and query, respecting
+// the given BindOpts as Index.BindWithOpts does.
+func (bb BitsetBinder) BindWithOpts(runs []shared.TestRun, q query.ConcreteQuery, opts query.BindOpts) (query.Plan, []query.BindWarning, error) {
+	if len(runs) == 0 {
+		return nil, nil, errNoRuns
+	} else if q == nil {
+		return nil, nil, errNoQuery
+	}
+	warnings, err := checkLoaders(bb.idx.loader, q, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]RunID, len(runs))
+	for j, run := range runs {
+		ids[j] = RunID(run.ID)
+	}
+	idxs, missing, err := bb.idx.syncExtractRuns(ids, opts.AllowPartial)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(missing) == len(ids) {
+		return nil, nil, errNoRuns
+	}
+	warnings = append(warnings, missingRunWarnings(missing)...)
+
+	plan := make(BitsetPlan, len(idxs))
+	for j, idx := range idxs {
+		idx.maxResults = opts.MaxResults
+		shard := &bitsetShard{
+			index:    idx,
+			statuses: make(map[runStatus]bitset),
+			tries:    bb.tries,
+		}
+		if shard.root, err = shard.compile(q); err != nil {
+			return nil, nil, err
+		}
+		shard.syncBuildBitsets()
+		plan[j] = shard
+	}
+	return plan, warnings, nil
+}
+
+// compile compiles q into a bitsetNode, noting the (run, status) pairs for
+// which bitsets are needed.
+func (s *bitsetShard) compile(q query.ConcreteQuery) (bitsetNode, error) {
+	switch v := q.(type) {
+	case query.True:
+		return bitsetAll(true), nil
+	case query.False:
+		return bitsetAll(false), nil
+	case query.RunTestStatusEq:
+		return s.leaf(v.Run, v.Status), nil
+	case query.RunTestStatusNeq:
+		return bitsetNot{s.leaf(v.Run, v.Status)}, nil
+	case query.RunTestStatusIn:
+		or := make(bitsetOr, len(v.Statuses))
+		for i, status := range v.Statuses {
+			or[i] = s.leaf(v.Run, status)
+		}
+		return or, nil
+	case query.AnyRunTestStatusEq:
+		or := make(bitsetOr, len(v.Runs))
+		for i, run := range v.Runs {
+			or[i] = s.leaf(run, v.Status)
+		}
+		return or, nil
+	case query.And:
+		args, err := s.compileAll(v.Args)
+		return bitsetAnd(args), err
+	case query.Or:
+		args, err := s.compileAll(v.Args)
+		return bitsetOr(args), err
+	case query.Not:
+		arg, err := s.compile(v.Arg)
+		if sc, ok := arg.(bitsetScan); ok {
+			sc.negate = !sc.negate
+			return sc, err
+		}
+		return bitsetNot{arg}, err
+	case query.Count:
+		args, err := s.compileAll(v.Args)
+		return bitsetCount{v.Count, args}, err
+	case query.TestPath:
+		if s.tries != nil {
+			return bitsetPath{v.Path}, nil
+		}
+		f, err := newFilter(s.index, q)
+		return bitsetScan{f: f}, err
+	default:
+		f, err := newFilter(s.index, q)
+		return bitsetScan{f: f}, err
+	}
+}
+
+func (s *bitsetShard) compileAll(qs []query.ConcreteQuery) ([]bitsetNode, error) {
+	nodes := make([]bitsetNode, len(qs))
+	for i := range qs {
+		var err error
+		if nodes[i], err = s.compile(qs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func (s *bitsetShard) leaf(run int64, status shared.TestStatus) bitsetLeaf {
+	rs := runStatus{RunID(run), ResultID(status)}
+	s.statuses[rs] = nil
+	return bitsetLeaf{rs}
+}
+
+// syncBuildBitsets orders the shard's tests and builds a bitset for each of the
+// (run, status) pairs noted by compile.
+func (s *bitsetShard) syncBuildBitsets() {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if s.tries != nil {
+		s.trie = s.tries.syncGet(s.index)
+		s.order = s.trie.order
+	} else {
+		s.order = make([]TestID, 0)
+		s.tests.Range(func(t TestID) bool {
+			s.order = append(s.order, t)
+			return true
+		})
+	}
+
+	statusesByRun := make(map[RunID][]ResultID)
+	for rs := range s.statuses {
+		s.statuses[rs] = newBitset(len(s.order))
+		statusesByRun[rs.run] = append(statusesByRun[rs.run], rs.status)
+	}
+	for run, statuses := range statusesByRun {
+		rrs := s.runResults[run]
+		for i, t := range s.order {
+			res := rrs.GetResult(t)
+			for _, status := range statuses {
+				if res == status {
+					s.statuses[runStatus{run, status}].set(i)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Cost estimates the work of executing a BitsetPlan: the number of bitset words
+// processed by bitwise operations, plus the tests scanned (as estimated for
+// ShardedFilter) by atoms without precomputed bitsets, and by the final scan
+// for matching tests.
+func (p BitsetPlan) Cost() int {
+	cost := 0
+	for _, s := range p {
+		s.m.RLock()
+		cost += len(s.order) + s.root.cost(s)
+		s.m.RUnlock()
+	}
+	return cost
+}
+
+// Execute evaluates the compiled query for each shard in parallel, aggregating
+// (or, when opts.CountOnly is set, counting) the matching tests.
+func (p BitsetPlan) Execute(runs []shared.TestRun, opts query.AggregationOpts) interface{} {
+	ret, _ := p.execute(runs, opts)
+	return ret
+}
+
+// ExecuteWithTruncation evaluates the compiled query, as Execute does, also
+// returning whether any matching tests were dropped from the results because of
+// the result limit that the plan was bound with.
+func (p BitsetPlan) ExecuteWithTruncation(runs []shared.TestRun, opts query.AggregationOpts) (interface{}, bool) {
+	return p.execute(runs, opts)
+}
+
+func (p BitsetPlan) execute(runs []shared.TestRun, opts query.AggregationOpts) (interface{}, bool) {
+	rus := make([]RunID, len(runs))
+	for i := range runs {
+		rus[i] = RunID(runs[i].ID)
+	}
+	res := make(chan aggregator, len(p))
+	for _, shard := range p {
+		go shard.syncExecute(rus, opts, res)
+	}
+
+	if opts.CountOnly {
+		count := 0
+		for i := 0; i < len(p); i++ {
+			count += (<-res).Count()
+		}
+		return count, false
+	}
+
+	ret := make([]query.SearchResult, 0)
+	truncated := false
+	for i := 0; i < len(p); i++ {
+		agg := <-res
+		truncated = truncated || agg.Truncated()
+		ret = append(ret, agg.Done()...)
+	}
+	// Each shard is capped separately; cap the combined results too.
+	if len(p) > 0 {
+		if limit := p[0].maxResults; limit > 0 && len(ret) > limit {
+			ret = ret[:limit]
+			truncated = true
+		}
+	}
+	return ret, truncated
+}
+
+func (s *bitsetShard) syncExecute(rus []RunID, opts query.AggregationOpts, res chan aggregator) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	agg := newAggregator(s.index, rus, opts)
+	s.root.eval(s).forEach(func(i int) {
+		if err := agg.Add(s.order[i]); err != nil {
+			log.Errorf("Error executing bitset query: %v", err)
+		}
+	})
+	res <- agg
+}
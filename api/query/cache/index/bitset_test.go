@@ -0,0 +1,490 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	metrics "github.com/web-platform-tests/results-analysis/metrics"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// staticReportLoader loads reports from a fixed mapping of run ID to report.
+type staticReportLoader map[int64]*metrics.TestResultsReport
+
+func (l staticReportLoader) Load(run shared.TestRun) (*metrics.TestResultsReport, error) {
+	report, ok := l[run.ID]
+	if !ok {
+		return nil, fmt.Errorf("No report for run %d", run.ID)
+	}
+	return report, nil
+}
+
+var generatedStatuses = []string{"PASS", "FAIL", "OK", "TIMEOUT", "ERROR", "CRASH", "SKIP"}
+
+// generatedIndex produces an index of numRuns runs, alternating between
+// browsers, over numTests tests (each with a subtest). Statuses vary with the
+// test and run, and some tests are missing from some runs.
+func generatedIndex(t testing.TB, numRuns, numTests int) (Index, []shared.TestRun) {
+	loader := make(staticReportLoader)
+	runs := make([]shared.TestRun, numRuns)
+	browserNames := []string{"chrome", "firefox", "safari"}
+	for r := range runs {
+		runs[r] = shared.TestRun{ID: int64(r + 1)}
+		runs[r].BrowserName = browserNames[r%len(browserNames)]
+
+		report := &metrics.TestResultsReport{}
+		for i := 0; i < numTests; i++ {
+			if (i+r)%11 == 0 {
+				continue
+			}
+			report.Results = append(report.Results, &metrics.TestResults{
+				Test:   fmt.Sprintf("/dir%d/test%d.html", i%10, i),
+				Status: generatedStatuses[(i*7+r*3)%len(generatedStatuses)],
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{
+						Name:   "sub",
+						Status: generatedStatuses[(i+r)%len(generatedStatuses)],
+					},
+				},
+			})
+		}
+		loader[runs[r].ID] = report
+	}
+
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+	for _, run := range runs {
+		assert.Nil(t, idx.IngestRun(run))
+	}
+	return idx, runs
+}
+
+// bitsetTestQueries are queries over generatedIndex runs that exercise each
+// kind of bitset node, including scanning fallbacks.
+func bitsetTestQueries() []query.AbstractQuery {
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	return []query.AbstractQuery{
+		query.TestStatusEq{Product: &chrome, Status: shared.TestStatusPass},
+		query.TestStatusNeq{Product: &firefox, Status: shared.TestStatusPass},
+		query.AnyStatus{Status: shared.TestStatusCrash},
+		query.TestStatusEq{Status: shared.TestStatusUnknown},
+		query.AbstractAnd{
+			Args: []query.AbstractQuery{
+				query.TestStatusEq{Product: &chrome, Status: shared.TestStatusPass},
+				query.AbstractNot{Arg: query.TestStatusEq{Product: &firefox, Status: shared.TestStatusPass}},
+			},
+		},
+		query.AbstractOr{
+			Args: []query.AbstractQuery{
+				query.TestStatusEq{Product: &chrome, Status: shared.TestStatusFail},
+				query.TestStatusEq{Product: &chrome, Status: shared.TestStatusError},
+				query.TestStatusEq{Product: &firefox, Status: shared.TestStatusTimeout},
+			},
+		},
+		query.AbstractExists{
+			Args: []query.AbstractQuery{
+				query.TestNamePattern{Pattern: "dir3"},
+				query.TestStatusNeq{Status: shared.TestStatusOK},
+			},
+		},
+		query.AbstractAll{
+			Args: []query.AbstractQuery{
+				query.AbstractOr{
+					Args: []query.AbstractQuery{
+						query.TestStatusEq{Status: shared.TestStatusPass},
+						query.TestStatusEq{Status: shared.TestStatusOK},
+					},
+				},
+			},
+		},
+		query.AbstractSequential{
+			Args: []query.AbstractQuery{
+				query.TestStatusEq{Status: shared.TestStatusPass},
+				query.TestStatusEq{Status: shared.TestStatusFail},
+			},
+		},
+		query.AbstractCount{
+			Count: 2,
+			Where: query.TestStatusEq{Status: shared.TestStatusPass},
+		},
+		query.AbstractAnd{
+			Args: []query.AbstractQuery{
+				query.TestPath{Path: "/dir1/"},
+				query.Skipped{BrowserName: "safari"},
+			},
+		},
+		query.AbstractNot{Arg: query.True{}},
+	}
+}
+
+func TestBitsetBinder_matchesFilters(t *testing.T) {
+	idx, runs := generatedIndex(t, 6, 500)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+
+	for _, opts := range []query.AggregationOpts{
+		query.AggregationOpts{},
+		query.AggregationOpts{IgnoreTestHarnessResult: true},
+	} {
+		for _, aq := range bitsetTestQueries() {
+			q := aq.BindToRuns(runs...)
+
+			filterPlan, err := idx.Bind(runs, q)
+			assert.Nil(t, err)
+			expected := filterPlan.Execute(runs, opts).([]query.SearchResult)
+
+			bitsetPlan, err := bb.Bind(runs, q)
+			assert.Nil(t, err)
+			actual := bitsetPlan.Execute(runs, opts).([]query.SearchResult)
+
+			assert.Equal(t, len(expected), len(actual), "Query: %#v", q)
+			assert.True(t, resultSet(t, expected).Equal(resultSet(t, actual)), "Query: %#v", q)
+		}
+	}
+}
+
+func TestBitsetBinder_negatedScan(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+
+	pattern := query.TestNamePattern{Pattern: "dir3"}
+	for _, aq := range []query.AbstractQuery{
+		query.AbstractNot{Arg: pattern},
+		query.AbstractNot{Arg: query.AbstractNot{Arg: pattern}},
+		query.AbstractNot{Arg: query.TestNamePattern{Patterns: []string{"dir1", "test1"}, MatchAll: true}},
+		query.AbstractAnd{
+			Args: []query.AbstractQuery{
+				query.AbstractNot{Arg: pattern},
+				query.TestStatusEq{Status: shared.TestStatusPass},
+			},
+		},
+		query.AbstractNot{Arg: query.TestPath{Path: "/dir1/"}},
+	} {
+		q := aq.BindToRuns(runs...)
+
+		filterPlan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		expected := filterPlan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+
+		bitsetPlan, err := bb.Bind(runs, q)
+		assert.Nil(t, err)
+		actual := bitsetPlan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+
+		assert.Equal(t, len(expected), len(actual), "Query: %#v", q)
+		assert.True(t, resultSet(t, expected).Equal(resultSet(t, actual)), "Query: %#v", q)
+	}
+
+	// The negation is compiled into the scan.
+	plan, err := bb.Bind(runs, query.Not{Arg: query.TestNamePattern{Pattern: "dir3"}})
+	assert.Nil(t, err)
+	for _, shard := range plan.(BitsetPlan) {
+		sc, ok := shard.root.(bitsetScan)
+		assert.True(t, ok)
+		assert.True(t, sc.negate)
+	}
+	plan, err = bb.Bind(runs, query.Not{Arg: query.Not{Arg: query.TestNamePattern{Pattern: "dir3"}}})
+	assert.Nil(t, err)
+	for _, shard := range plan.(BitsetPlan) {
+		sc, ok := shard.root.(bitsetScan)
+		assert.True(t, ok)
+		assert.False(t, sc.negate)
+	}
+}
+
+func TestBitsetBinder_withPathTrie(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	bb, err := NewBitsetBinder(idx, WithPathTrie())
+	assert.Nil(t, err)
+
+	aqs := bitsetTestQueries()
+	for _, path := range []string{"", "/", "/dir1", "/dir1/", "/dir1/test11", "/dir1/test11.html", "/dir", "/nope/"} {
+		aqs = append(aqs, query.TestPath{Path: path})
+	}
+	aqs = append(aqs,
+		query.AbstractNot{Arg: query.TestPath{Path: "/dir2/"}},
+		query.FocusArea{Area: "dirs", Paths: []string{"/dir3/", "/dir4/test14"}},
+	)
+	for _, aq := range aqs {
+		q := aq.BindToRuns(runs...)
+
+		filterPlan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		expected := filterPlan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+
+		bitsetPlan, err := bb.Bind(runs, q)
+		assert.Nil(t, err)
+		actual := bitsetPlan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+
+		assert.Equal(t, len(expected), len(actual), "Query: %#v", q)
+		assert.True(t, resultSet(t, expected).Equal(resultSet(t, actual)), "Query: %#v", q)
+	}
+
+	// Paths resolve via the trie, which is cached across binds.
+	q := query.TestPath{Path: "/dir1/"}
+	first, err := bb.Bind(runs, q)
+	assert.Nil(t, err)
+	second, err := bb.Bind(runs, q)
+	assert.Nil(t, err)
+	for i, shard := range first.(BitsetPlan) {
+		_, ok := shard.root.(bitsetPath)
+		assert.True(t, ok)
+		assert.True(t, shard.trie == second.(BitsetPlan)[i].trie)
+	}
+}
+
+func TestBitsetBinder_withPathTrieNewTests(t *testing.T) {
+	loader := staticReportLoader{
+		1: &metrics.TestResultsReport{Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/b.html", Status: "PASS"},
+		}},
+		2: &metrics.TestResultsReport{Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/b.html", Status: "FAIL"},
+			&metrics.TestResults{Test: "/a/c.html", Status: "FAIL"},
+		}},
+	}
+	idx, err := NewShardedWPTIndex(loader, 1)
+	assert.Nil(t, err)
+	run1 := shared.TestRun{ID: 1}
+	run1.BrowserName = "chrome"
+	run2 := shared.TestRun{ID: 2}
+	run2.BrowserName = "firefox"
+	assert.Nil(t, idx.IngestRun(run1))
+	bb, err := NewBitsetBinder(idx, WithPathTrie())
+	assert.Nil(t, err)
+
+	q := query.TestPath{Path: "/a/"}
+	plan, err := bb.Bind([]shared.TestRun{run1}, q)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(plan.Execute([]shared.TestRun{run1}, query.AggregationOpts{}).([]query.SearchResult)))
+
+	// Tests added by a later run invalidate the cached trie.
+	assert.Nil(t, idx.IngestRun(run2))
+	runs := []shared.TestRun{run1, run2}
+	plan, err = bb.Bind(runs, q)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)))
+}
+
+func TestBitsetPlan_Cost(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 1000)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	cost := func(q query.ConcreteQuery) int {
+		plan, err := bb.Bind(runs, q)
+		assert.Nil(t, err)
+		return plan.Cost()
+	}
+
+	status := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusPass}
+	pattern := query.TestNamePattern{Pattern: "dir1"}
+	assert.True(t, cost(status) > 0)
+	// Bitwise operations are cheaper than scanning every test.
+	assert.True(t, cost(query.And{Args: []query.ConcreteQuery{status, query.Not{Arg: status}}}) < cost(pattern))
+	assert.True(t, cost(pattern) < cost(query.And{Args: []query.ConcreteQuery{status, pattern}}))
+}
+
+func TestBitsetBinder_partial(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	expected := planAndExecute(t, runs, idx, query.AnyStatus{Status: shared.TestStatusPass})
+	assert.True(t, len(expected) > 10)
+	q := query.AnyStatus{Status: shared.TestStatusPass}.BindToRuns(runs...)
+
+	// Unknown runs are bound with no results, with a warning.
+	allRuns := append(runs, shared.TestRun{ID: 123})
+	_, _, err = bb.BindWithOpts(allRuns, q, query.BindOpts{})
+	assert.NotNil(t, err)
+	plan, warnings, err := bb.BindWithOpts(allRuns, q, query.BindOpts{AllowPartial: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []query.BindWarning{query.BindWarning{Run: 123, Message: "Run 123 has no results in index"}}, warnings)
+	srs, ok := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+	assert.True(t, ok)
+	assert.Equal(t, len(expected), len(srs))
+}
+
+func TestBitsetPlan_countOnly(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	q := query.AnyStatus{Status: shared.TestStatusPass}.BindToRuns(runs...)
+
+	filterPlan, err := idx.Bind(runs, q)
+	assert.Nil(t, err)
+	expected := filterPlan.Execute(runs, query.AggregationOpts{CountOnly: true}).(int)
+	assert.True(t, expected > 10)
+
+	// Counts match those of filters.
+	plan, err := bb.Bind(runs, q)
+	assert.Nil(t, err)
+	count, ok := plan.Execute(runs, query.AggregationOpts{CountOnly: true}).(int)
+	assert.True(t, ok)
+	assert.Equal(t, expected, count)
+}
+
+func TestBitsetBinder_maxResults(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	q := query.AnyStatus{Status: shared.TestStatusPass}.BindToRuns(runs...)
+	plan, err := bb.Bind(runs, q)
+	assert.Nil(t, err)
+	expected := plan.Execute(runs, query.AggregationOpts{CountOnly: true}).(int)
+	assert.True(t, expected > 10)
+
+	// Results are capped, but counts are not.
+	plan, warnings, err := bb.BindWithOpts(runs, q, query.BindOpts{MaxResults: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(warnings))
+	tp, ok := plan.(query.TruncatingPlan)
+	assert.True(t, ok)
+	srs, truncated := tp.ExecuteWithTruncation(runs, query.AggregationOpts{})
+	assert.Equal(t, 10, len(srs.([]query.SearchResult)))
+	assert.True(t, truncated)
+	assert.Equal(t, expected, plan.Execute(runs, query.AggregationOpts{CountOnly: true}))
+}
+
+func TestBitsetBinder_errors(t *testing.T) {
+	idx, runs := generatedIndex(t, 1, 10)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+
+	_, err = bb.Bind(nil, query.True{})
+	assert.NotNil(t, err)
+	_, err = bb.Bind(runs, nil)
+	assert.NotNil(t, err)
+	_, err = bb.Bind([]shared.TestRun{shared.TestRun{ID: 123}}, query.True{})
+	assert.NotNil(t, err)
+
+	_, err = NewBitsetBinder(NewMockIndex(gomock.NewController(t)))
+	assert.NotNil(t, err)
+}
+
+func TestBitset(t *testing.T) {
+	b := newBitset(70)
+	assert.Equal(t, 2, len(b))
+	b.set(0)
+	b.set(64)
+	b.set(69)
+
+	var set []int
+	b.forEach(func(i int) { set = append(set, i) })
+	assert.Equal(t, []int{0, 64, 69}, set)
+
+	b.not(70)
+	count := 0
+	b.forEach(func(i int) {
+		assert.NotContains(t, []int{0, 64, 69}, i)
+		assert.True(t, i < 70)
+		count++
+	})
+	assert.Equal(t, 67, count)
+
+	full := fullBitset(70)
+	full.and(b)
+	assert.Equal(t, b, full)
+	full.or(newBitset(70))
+	assert.Equal(t, b, full)
+}
+
+func benchmarkBinder(b *testing.B, bind func(Index) query.Binder) {
+	idx, runs := generatedIndex(b, 8, 20000)
+	binder := bind(idx)
+	q := query.AbstractOr{
+		Args: []query.AbstractQuery{
+			query.AbstractAll{
+				Args: []query.AbstractQuery{query.TestStatusNeq{Status: shared.TestStatusPass}},
+			},
+			query.AbstractCount{
+				Count: 3,
+				Where: query.TestStatusEq{Status: shared.TestStatusFail},
+			},
+		},
+	}.BindToRuns(runs...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan, err := binder.Bind(runs, q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		plan.Execute(runs, query.AggregationOpts{})
+	}
+}
+
+func BenchmarkBind_filters(b *testing.B) {
+	benchmarkBinder(b, func(idx Index) query.Binder {
+		return idx
+	})
+}
+
+// BenchmarkExecute_notPattern executes a negated test name pattern, which
+// compiles to a single negated scan.
+func BenchmarkExecute_notPattern(b *testing.B) {
+	idx, runs := generatedIndex(b, 2, 20000)
+	bb, err := NewBitsetBinder(idx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	q := query.AbstractNot{Arg: query.TestNamePattern{Pattern: "dir3"}}.BindToRuns(runs...)
+	plan, err := bb.Bind(runs, q)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan.Execute(runs, query.AggregationOpts{})
+	}
+}
+
+func BenchmarkBind_bitsets(b *testing.B) {
+	benchmarkBinder(b, func(idx Index) query.Binder {
+		bb, err := NewBitsetBinder(idx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return bb
+	})
+}
+
+func benchmarkExecutePath(b *testing.B, opts ...BitsetOption) {
+	idx, runs := generatedIndex(b, 2, 20000)
+	bb, err := NewBitsetBinder(idx, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	q := query.TestPath{Path: "/dir3/"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plan, err := bb.Bind(runs, q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		plan.Execute(runs, query.AggregationOpts{})
+	}
+}
+
+// BenchmarkExecute_pathScan binds and executes a test path query by scanning
+// every test.
+func BenchmarkExecute_pathScan(b *testing.B) {
+	benchmarkExecutePath(b)
+}
+
+// BenchmarkExecute_pathTrie binds and executes a test path query by lookup in
+// a cached path trie.
+func BenchmarkExecute_pathTrie(b *testing.B) {
+	benchmarkExecutePath(b, WithPathTrie())
+}
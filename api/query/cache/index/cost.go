@@ -0,0 +1,276 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"reflect"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// defaultSelectivity is the assumed fraction of tests matched by an atom for
+// which the index keeps no result counts, such as a test name pattern.
+const defaultSelectivity = 0.5
+
+// Cost estimates the work of executing a ShardedFilter: the number of tests
+// scanned, plus the expected number of atom evaluations (as counted by
+// ExecuteWithStats). Expected evaluations account for And and Or evaluating
+// their arguments lazily, using the number of results with each status in each
+// run to estimate how many tests each status atom matches.
+func (fs ShardedFilter) Cost() int {
+	cost := 0.0
+	for _, f := range fs {
+		idx := f.idx()
+		idx.m.RLock()
+		n := idx.numTests
+		evals, _ := estimateFilter(f, idx)
+		idx.m.RUnlock()
+		cost += float64(n) * (1 + evals)
+	}
+	return int(cost + 0.5)
+}
+
+// EstimateMatches estimates the number of tests and subtests that q matches over
+// runs, without executing it. The estimate is approximate: as for Cost, each
+// status atom's selectivity is estimated from the number of results with each
+// status in each run (summed over all shards), other atoms are assumed to match
+// a fixed fraction of tests, and atoms are assumed to match independently of
+// one another. Runs unknown to the index are treated as having no results;
+// queries that cannot be bound are estimated to match nothing.
+func (i *shardedWPTIndex) EstimateMatches(q query.ConcreteQuery, runs []shared.TestRun) int {
+	if q == nil {
+		return 0
+	}
+	ids := make([]RunID, len(runs))
+	for j, run := range runs {
+		ids[j] = RunID(run.ID)
+	}
+	idxs, _, err := i.syncExtractRuns(ids, true)
+	if err != nil || len(idxs) == 0 {
+		return 0
+	}
+	f, err := newFilter(idxs[0], q)
+	if err != nil {
+		return 0
+	}
+
+	// Selectivities are estimated from counts summed over all shards, so that
+	// the estimate does not depend on how tests are sharded.
+	total := index{statusCounts: make(map[RunID]map[ResultID]int)}
+	for _, idx := range idxs {
+		idx.m.RLock()
+		total.numTests += idx.numTests
+		for run, counts := range idx.statusCounts {
+			if total.statusCounts[run] == nil {
+				total.statusCounts[run] = make(map[ResultID]int)
+			}
+			for status, count := range counts {
+				total.statusCounts[run][status] += count
+			}
+		}
+		idx.m.RUnlock()
+	}
+	_, sel := estimateFilter(f, total)
+	return int(float64(total.numTests)*sel + 0.5)
+}
+
+// CostBreakdown estimates the rows into and out of each node of q, as for
+// EstimateMatches, summed over shards. Queries that cannot be bound have no
+// breakdown.
+func (i *shardedWPTIndex) CostBreakdown(q query.ConcreteQuery, runs []shared.TestRun) []query.NodeCost {
+	if q == nil {
+		return nil
+	}
+	ids := make([]RunID, len(runs))
+	for j, run := range runs {
+		ids[j] = RunID(run.ID)
+	}
+	idxs, _, err := i.syncExtractRuns(ids, true)
+	if err != nil {
+		return nil
+	}
+
+	var total []nodeRows
+	for _, idx := range idxs {
+		f, err := newFilter(idx, q)
+		if err != nil {
+			return nil
+		}
+		idx.m.RLock()
+		nodes, _ := breakdownFilter(f, idx, 0, float64(idx.numTests), nil)
+		idx.m.RUnlock()
+		if total == nil {
+			total = nodes
+			continue
+		}
+		for j := range nodes {
+			total[j].in += nodes[j].in
+			total[j].out += nodes[j].out
+		}
+	}
+
+	costs := make([]query.NodeCost, len(total))
+	for j, node := range total {
+		costs[j] = query.NodeCost{
+			Type:       node.typ,
+			Depth:      node.depth,
+			InputRows:  int(node.in + 0.5),
+			OutputRows: int(node.out + 0.5),
+		}
+	}
+	return costs
+}
+
+// nodeRows is the estimated number of rows into and out of a filter node.
+type nodeRows struct {
+	typ     string
+	depth   int
+	in, out float64
+}
+
+// breakdownFilter appends, to nodes, the estimated rows into and out of f and
+// each of its arguments, given the rows into f, returning them along with the
+// selectivity of f (as estimated by estimateFilter).
+func breakdownFilter(f filter, idx index, depth int, in float64, nodes []nodeRows) ([]nodeRows, float64) {
+	_, sel := estimateFilter(f, idx)
+	nodes = append(nodes, nodeRows{filterType(f), depth, in, in * sel})
+	switch v := f.(type) {
+	case And:
+		rows := in
+		for _, arg := range v.args {
+			var s float64
+			nodes, s = breakdownFilter(arg, idx, depth+1, rows, nodes)
+			rows *= s
+		}
+	case Or:
+		rows := in
+		for _, arg := range v.args {
+			var s float64
+			nodes, s = breakdownFilter(arg, idx, depth+1, rows, nodes)
+			rows *= 1 - s
+		}
+	case Not:
+		nodes, _ = breakdownFilter(v.arg, idx, depth+1, in, nodes)
+	case Count:
+		for _, arg := range v.args {
+			nodes, _ = breakdownFilter(arg, idx, depth+1, in, nodes)
+		}
+	}
+	return nodes, sel
+}
+
+// filterType is the name of the type of the ConcreteQuery that f was bound
+// from.
+func filterType(f filter) string {
+	switch f.(type) {
+	case And:
+		return "And"
+	case Or:
+		return "Or"
+	case Not:
+		return "Not"
+	case Count:
+		return "Count"
+	}
+	if q := leafQuery(f); q != nil {
+		return reflect.TypeOf(q).Name()
+	}
+	return "<unknown>"
+}
+
+// estimateFilter estimates, for a single test, the expected number of atom
+// evaluations performed by f, and the probability that f matches.
+func estimateFilter(f filter, idx index) (evals float64, selectivity float64) {
+	switch v := f.(type) {
+	case True:
+		return 1, 1
+	case False:
+		return 1, 0
+	case runTestStatusEq:
+		return 1, statusSelectivity(idx, v.q.Run, v.q.Status)
+	case runTestStatusNeq:
+		return 1, 1 - statusSelectivity(idx, v.q.Run, v.q.Status)
+	case runTestStatusIn:
+		sel := 0.0
+		for _, status := range v.q.Statuses {
+			sel += statusSelectivity(idx, v.q.Run, status)
+		}
+		return 1, clamp(sel)
+	case anyRunTestStatusEq:
+		none := 1.0
+		for _, run := range v.q.Runs {
+			none *= 1 - statusSelectivity(idx, run, v.q.Status)
+		}
+		return 1, 1 - none
+	case And:
+		evals, sel := 0.0, 1.0
+		for _, arg := range v.args {
+			e, s := estimateFilter(arg, idx)
+			evals += sel * e
+			sel *= s
+		}
+		return evals, sel
+	case Or:
+		evals, none := 0.0, 1.0
+		for _, arg := range v.args {
+			e, s := estimateFilter(arg, idx)
+			evals += none * e
+			none *= 1 - s
+		}
+		return evals, 1 - none
+	case Not:
+		e, s := estimateFilter(v.arg, idx)
+		return e, 1 - s
+	case Count:
+		// probs[k] is the probability that exactly k of the args considered so
+		// far match.
+		evals := 0.0
+		probs := []float64{1}
+		for _, arg := range v.args {
+			e, s := estimateFilter(arg, idx)
+			evals += e
+			next := make([]float64, len(probs)+1)
+			for k, p := range probs {
+				next[k] += p * (1 - s)
+				next[k+1] += p * s
+			}
+			probs = next
+		}
+		if v.count < 0 || v.count >= len(probs) {
+			return evals, 0
+		}
+		return evals, probs[v.count]
+	default:
+		return 1, defaultSelectivity
+	}
+}
+
+// statusSelectivity is the fraction of tests in idx with the given status in
+// the given run. Tests without a result in the run have an UNKNOWN status.
+func statusSelectivity(idx index, run int64, status shared.TestStatus) float64 {
+	if idx.numTests == 0 {
+		return 0
+	}
+	counts := idx.statusCounts[RunID(run)]
+	count := counts[ResultID(status)]
+	if status == shared.TestStatusUnknown {
+		results := 0
+		for _, c := range counts {
+			results += c
+		}
+		count += idx.numTests - results
+	}
+	return clamp(float64(count) / float64(idx.numTests))
+}
+
+func clamp(p float64) float64 {
+	if p < 0 {
+		return 0
+	} else if p > 1 {
+		return 1
+	}
+	return p
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// debugRun describes a run that a query was bound to.
+type debugRun struct {
+	ID             int64    `json:"id"`
+	BrowserName    string   `json:"browser_name,omitempty"`
+	BrowserVersion string   `json:"browser_version,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+}
+
+// debugAtom describes a leaf atom of a bound query, and the runs that it
+// resolved to.
+type debugAtom struct {
+	Atom   string                 `json:"atom"`
+	Filter map[string]interface{} `json:"filter"`
+	Runs   []debugRun             `json:"runs,omitempty"`
+}
+
+// DebugJSON describes the query that a ShardedFilter was bound from, for
+// debugging unexpected results. The description contains the filter map of the
+// whole query (see query.ToFilterMap), the runs that it was bound to, and each
+// distinct leaf atom along with the runs (and their browsers) that the atom
+// resolved to.
+func (fs ShardedFilter) DebugJSON() ([]byte, error) {
+	if len(fs) == 0 {
+		return nil, errNotShardedFilter
+	}
+	q, err := filterQuery(fs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	idx := fs[0].idx()
+	byID := make(map[int64]shared.TestRun, len(idx.boundRuns))
+	for _, run := range idx.boundRuns {
+		byID[run.ID] = run
+	}
+	describeRun := func(id int64) debugRun {
+		run, ok := byID[id]
+		if !ok {
+			return debugRun{ID: id}
+		}
+		return debugRun{
+			ID:             id,
+			BrowserName:    run.BrowserName,
+			BrowserVersion: run.BrowserVersion,
+			Labels:         run.Labels,
+		}
+	}
+
+	runs := make([]debugRun, len(idx.runs))
+	for i, id := range idx.runs {
+		runs[i] = describeRun(int64(id))
+	}
+
+	leaves := make(map[string]filter)
+	collectLeaves(fs[0], leaves)
+	keys := make([]string, 0, len(leaves))
+	for key := range leaves {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	atoms := make([]debugAtom, len(keys))
+	for i, key := range keys {
+		leaf := leafQuery(leaves[key])
+		atoms[i] = debugAtom{
+			Atom:   key,
+			Filter: query.ToFilterMap(leaf),
+		}
+		for _, id := range query.ReferencedRuns(leaf) {
+			atoms[i].Runs = append(atoms[i].Runs, describeRun(id))
+		}
+	}
+
+	return json.Marshal(struct {
+		Query map[string]interface{} `json:"query"`
+		Runs  []debugRun             `json:"runs"`
+		Atoms []debugAtom            `json:"atoms"`
+	}{query.ToFilterMap(q), runs, atoms})
+}
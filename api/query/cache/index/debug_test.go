@@ -0,0 +1,68 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestDebugJSON_statusAtoms(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 20)
+	chrome := shared.ParseProductSpecUnsafe("chrome")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	q := query.AbstractAnd{
+		Args: []query.AbstractQuery{
+			query.TestNamePattern{Pattern: "dir1"},
+			query.AbstractOr{
+				Args: []query.AbstractQuery{
+					query.TestStatusEq{Product: &chrome, Status: shared.TestStatusFail},
+					query.TestStatusEq{Product: &firefox, Status: shared.TestStatusPass},
+				},
+			},
+		},
+	}
+	plan, err := idx.Bind(runs, q.BindToRuns(runs...))
+	assert.Nil(t, err)
+
+	data, err := plan.(ShardedFilter).DebugJSON()
+	assert.Nil(t, err)
+	var debug struct {
+		Query map[string]interface{} `json:"query"`
+		Runs  []debugRun             `json:"runs"`
+		Atoms []debugAtom            `json:"atoms"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &debug))
+
+	assert.Contains(t, debug.Query, "and")
+	assert.Equal(t, []debugRun{
+		debugRun{ID: 1, BrowserName: "chrome"},
+		debugRun{ID: 2, BrowserName: "firefox"},
+		debugRun{ID: 3, BrowserName: "safari"},
+	}, debug.Runs)
+
+	atoms := make(map[string]debugAtom)
+	for _, atom := range debug.Atoms {
+		atoms[atom.Atom] = atom
+	}
+	assert.Equal(t, 3, len(atoms))
+	assert.Equal(t, []debugRun{debugRun{ID: 1, BrowserName: "chrome"}}, atoms["RunTestStatusEq{Run:1 Status:FAIL}"].Runs)
+	assert.Equal(t, []debugRun{debugRun{ID: 2, BrowserName: "firefox"}}, atoms["RunTestStatusEq{Run:2 Status:PASS}"].Runs)
+	pattern, ok := atoms["TestNamePattern{Pattern:dir1 Patterns:[] MatchAll:false IgnoreCase:false}"]
+	assert.True(t, ok)
+	assert.Nil(t, pattern.Runs)
+	assert.Contains(t, pattern.Filter, "test_name_pattern")
+}
+
+func TestDebugJSON_empty(t *testing.T) {
+	_, err := ShardedFilter{}.DebugJSON()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// DryRun evaluates each leaf atom of a ShardedFilter independently of the
+// others, returning the number of tests and subtests that each atom matches,
+// keyed by a description of the atom (e.g., "RunTestStatusEq{Run:1 Status:FAIL}").
+// Atoms that match zero tests explain empty result sets. The runs are those that
+// the plan was bound to, as for Execute; no results are aggregated for them.
+func (fs ShardedFilter) DryRun(runs []shared.TestRun) map[string]int {
+	counts := make(map[string]int)
+	for _, f := range fs {
+		leaves := make(map[string]filter)
+		collectLeaves(f, leaves)
+
+		idx := f.idx()
+		idx.m.RLock()
+		for key, leaf := range leaves {
+			count := 0
+			idx.tests.Range(func(t TestID) bool {
+				if leaf.Filter(t) {
+					count++
+				}
+				return true
+			})
+			counts[key] += count
+		}
+		idx.m.RUnlock()
+	}
+	return counts
+}
+
+// collectLeaves adds each distinct leaf filter of f to leaves, keyed by its
+// description.
+func collectLeaves(f filter, leaves map[string]filter) {
+	switch v := f.(type) {
+	case Count:
+		for _, arg := range v.args {
+			collectLeaves(arg, leaves)
+		}
+	case And:
+		for _, arg := range v.args {
+			collectLeaves(arg, leaves)
+		}
+	case Or:
+		for _, arg := range v.args {
+			collectLeaves(arg, leaves)
+		}
+	case Not:
+		collectLeaves(v.arg, leaves)
+	default:
+		leaves[describeAtom(leafQuery(f))] = f
+	}
+}
+
+// leafQuery is the ConcreteQuery that a leaf filter was bound from.
+func leafQuery(f filter) query.ConcreteQuery {
+	switch v := f.(type) {
+	case True:
+		return query.True{}
+	case False:
+		return query.False{}
+	case TestNamePattern:
+		return v.q
+	case TestPath:
+		return v.q
+	case TestNameIn:
+		return v.q
+	case ManifestPaths:
+		return v.q
+	case Shard:
+		return v.q
+	case Subtest:
+		return v.q
+	case runTestStatusEq:
+		return v.q
+	case runTestStatusNeq:
+		return v.q
+	case runTestStatusIn:
+		return v.q
+	case anyRunTestStatusEq:
+		return v.q
+	case runHasScreenshot:
+		return v.q
+	case runSkipped:
+		return v.q
+	case runIntermittent:
+		return v.q
+	case runAssertCount:
+		return v.q
+	case runUnexpected:
+		return v.q
+	case runSubtestPlurality:
+		return v.q
+	case runHarnessMessage:
+		return v.q
+	case runAllSubtestsPass:
+		return v.q
+	case runSubtestPassRatio:
+		return v.q
+	case runRefMatch:
+		return v.q
+	case runRegressed:
+		return v.q
+	case runStatusChanged:
+		return v.q
+	case anyRunStatusChanged:
+		return v.q
+	case runsSubtestCountDelta:
+		return v.q
+	case runsSameRender:
+		return v.q
+	case runsFlakinessRate:
+		return v.q
+	case anyRunSubtestTotal:
+		return v.q
+	case anyRunLongTimeout:
+		return v.q
+	case anyRunHasMetadataField:
+		return v.q
+	case presentInAllBrowsers:
+		return v.q
+	case browsersFailingCount:
+		return v.q
+	case browserWorseThanMedian:
+		return v.q
+	case browserSubtestOnlyIn:
+		return v.q
+	case firstSeenAfter:
+		return v.q
+	case runsWithResults:
+		return v.q
+	case anyRunMatches:
+		return v.q
+	default:
+		return nil
+	}
+}
+
+func describeAtom(q query.ConcreteQuery) string {
+	if q == nil {
+		return "<unknown>"
+	}
+	return fmt.Sprintf("%s%+v", reflect.TypeOf(q).Name(), q)
+}
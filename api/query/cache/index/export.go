@@ -0,0 +1,151 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// WriteResults executes a ShardedFilter, as Execute does with default
+// aggregation options, writing each search result to w as a line of JSON
+// (i.e., newline-delimited JSON) rather than collecting them in a slice. Shards
+// are executed one at a time, and each shard's results are written as soon as
+// its tests have been aggregated, so that memory use is bounded by the largest
+// shard rather than the whole result set. Results are written in no particular
+// order. As with Execute, at most the result limit that the filter was bound
+// with are written.
+func (fs ShardedFilter) WriteResults(w io.Writer, runs []shared.TestRun) error {
+	rus := make([]RunID, len(runs))
+	for i := range runs {
+		rus[i] = RunID(runs[i].ID)
+	}
+	enc := json.NewEncoder(w)
+	limit := fs.maxResults()
+	for _, f := range fs {
+		written, err := syncWriteFilterResults(rus, f, limit, enc)
+		if err != nil {
+			return err
+		}
+		if limit > 0 {
+			if limit -= written; limit == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// syncWriteFilterResults writes the results of f, up to limit results when
+// limit is positive, returning the number of results written.
+func syncWriteFilterResults(rus []RunID, f filter, limit int, enc *json.Encoder) (int, error) {
+	idx := f.idx()
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	idx.maxResults = limit
+	agg := newIndexAggregator(idx, rus, query.AggregationOpts{})
+	var err error
+	idx.tests.Range(func(t TestID) bool {
+		if f.Filter(t) {
+			err = agg.Add(t)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return agg.Count(), agg.each(func(r query.SearchResult) error {
+		return enc.Encode(r)
+	})
+}
+
+// WriteCSV executes a ShardedFilter, as WriteResults does, writing the matching
+// tests to w as CSV for use in spreadsheets: a header row of "test" followed by
+// a column per run, named by the run's browser and channel (e.g.,
+// "chrome[experimental]", or "chrome" for a run without a channel label), then
+// a row per test of its name and its status (e.g., "PASS") in each run. The
+// status is that of the test itself, not of its subtests; a test without a
+// result in a run has the status UNKNOWN. As with WriteResults, rows are
+// written in no particular order, and at most the result limit that the filter
+// was bound with are written.
+func (fs ShardedFilter) WriteCSV(w io.Writer, runs []shared.TestRun) error {
+	rus := make([]RunID, len(runs))
+	header := make([]string, len(runs)+1)
+	header[0] = "test"
+	for i, run := range runs {
+		rus[i] = RunID(run.ID)
+		header[i+1] = csvColumnName(run)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	limit := fs.maxResults()
+	for _, f := range fs {
+		written, err := syncWriteFilterCSV(rus, f, limit, cw)
+		if err != nil {
+			return err
+		}
+		if limit > 0 {
+			if limit -= written; limit == 0 {
+				break
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvColumnName is the name of the CSV column for a run: its browser name,
+// followed by its channel in brackets when it has one.
+func csvColumnName(run shared.TestRun) string {
+	if channel := run.Channel(); channel != "" {
+		return run.BrowserName + "[" + channel + "]"
+	}
+	return run.BrowserName
+}
+
+// syncWriteFilterCSV writes a CSV row for each test that f matches, up to limit
+// tests when limit is positive, returning the number of rows written.
+func syncWriteFilterCSV(rus []RunID, f filter, limit int, cw *csv.Writer) (int, error) {
+	idx := f.idx()
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	// Subtests match on behalf of their test, so collect distinct tests first.
+	var tests []TestID
+	seen := make(map[uint64]bool)
+	idx.tests.Range(func(t TestID) bool {
+		if seen[t.testID] || !f.Filter(t) {
+			return true
+		}
+		seen[t.testID] = true
+		tests = append(tests, t)
+		return limit <= 0 || len(tests) < limit
+	})
+
+	row := make([]string, len(rus)+1)
+	for _, t := range tests {
+		name, _, err := idx.tests.GetName(t)
+		if err != nil {
+			return 0, err
+		}
+		row[0] = name
+		test := TestID{testID: t.testID}
+		for i, ru := range rus {
+			row[i+1] = shared.TestStatus(idx.runResults[ru].GetResult(test)).String()
+		}
+		if err := cw.Write(row); err != nil {
+			return 0, err
+		}
+	}
+	return len(tests), nil
+}
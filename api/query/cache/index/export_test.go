@@ -0,0 +1,173 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	metrics "github.com/web-platform-tests/results-analysis/metrics"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestWriteResults_matchesExecute(t *testing.T) {
+	idx, runs := generatedIndex(t, 4, 500)
+
+	for _, aq := range bitsetTestQueries() {
+		q := aq.BindToRuns(runs...)
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		fs, ok := plan.(ShardedFilter)
+		assert.True(t, ok)
+
+		var buf bytes.Buffer
+		assert.Nil(t, fs.WriteResults(&buf, runs))
+
+		var written []query.SearchResult
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			var sr query.SearchResult
+			assert.Nil(t, json.Unmarshal(scanner.Bytes(), &sr), "Line: %s", scanner.Text())
+			written = append(written, sr)
+		}
+		assert.Nil(t, scanner.Err())
+
+		expected := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+		assert.Equal(t, len(expected), len(written), "Query: %#v", q)
+		assert.True(t, resultSet(t, expected).Equal(resultSet(t, written)), "Query: %#v", q)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("Write failed")
+}
+
+func TestWriteResults_writeError(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 10)
+	plan, err := idx.Bind(runs, query.True{})
+	assert.Nil(t, err)
+	assert.NotNil(t, plan.(ShardedFilter).WriteResults(failingWriter{}, runs))
+}
+
+// readCSV parses CSV written by WriteCSV into its header and its rows, sorted by
+// test name.
+func readCSV(t *testing.T, buf *bytes.Buffer) ([]string, [][]string) {
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.Nil(t, err)
+	if !assert.True(t, len(records) > 0) {
+		return nil, nil
+	}
+	rows := records[1:]
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return records[0], rows
+}
+
+func TestWriteCSV(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1, Labels: []string{"experimental"}},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/one,two.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "first", Status: "PASS"},
+							metrics.SubTest{Name: "second", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{Test: `/a/"quoted".html`, Status: "FAIL"},
+					&metrics.TestResults{Test: "/b/plain.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/one,two.html", Status: "ERROR"},
+					&metrics.TestResults{Test: "/b/plain.html", Status: "TIMEOUT"},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	plan, err := idx.Bind(runs, query.TestPath{Path: "/a/"})
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, plan.(ShardedFilter).WriteCSV(&buf, runs))
+	header, rows := readCSV(t, &buf)
+	assert.Equal(t, []string{"test", "chrome[experimental]", "firefox"}, header)
+	// Names with quotes and commas survive escaping; a missing result is UNKNOWN;
+	// the test with subtests is a single row of its own statuses.
+	assert.Equal(t, [][]string{
+		[]string{`/a/"quoted".html`, "FAIL", "UNKNOWN"},
+		[]string{"/a/one,two.html", "OK", "ERROR"},
+	}, rows)
+
+	// A test whose subtests match is written once.
+	plan, err = idx.Bind(runs, query.Subtest{Name: "s"})
+	assert.Nil(t, err)
+	buf.Reset()
+	assert.Nil(t, plan.(ShardedFilter).WriteCSV(&buf, runs))
+	_, rows = readCSV(t, &buf)
+	assert.Equal(t, [][]string{[]string{"/a/one,two.html", "OK", "ERROR"}}, rows)
+}
+
+func TestWriteCSV_matchesExecute(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 200)
+
+	for _, aq := range bitsetTestQueries() {
+		q := aq.BindToRuns(runs...)
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+
+		var buf bytes.Buffer
+		assert.Nil(t, plan.(ShardedFilter).WriteCSV(&buf, runs))
+		header, rows := readCSV(t, &buf)
+		assert.Equal(t, []string{"test", "chrome", "firefox", "safari"}, header)
+
+		expected := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+		names := make([]string, len(expected))
+		for i, sr := range expected {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		written := make([]string, len(rows))
+		for i, row := range rows {
+			written[i] = row[0]
+			assert.Equal(t, len(runs)+1, len(row))
+		}
+		assert.Equal(t, names, written, "Query: %#v", q)
+	}
+}
+
+func TestWriteCSV_writeError(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 10)
+	plan, err := idx.Bind(runs, query.True{})
+	assert.Nil(t, err)
+	assert.NotNil(t, plan.(ShardedFilter).WriteCSV(failingWriter{}, runs))
+}
@@ -8,8 +8,12 @@ import (
 	"errors"
 	"fmt"
 	reflect "reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/web-platform-tests/wpt.fyi/api/query"
@@ -38,6 +42,29 @@ type TestPath struct {
 	q query.TestPath
 }
 
+// TestNameIn is a query.TestNameIn bound to an in-memory index.
+type TestNameIn struct {
+	index
+	q     query.TestNameIn
+	names map[string]bool
+}
+
+// ManifestPaths is a query.ManifestPaths bound to an in-memory index. Exact
+// paths are looked up in a set; prefixes are sorted, without any that are
+// redundant because they begin with another prefix, for binary search.
+type ManifestPaths struct {
+	index
+	q        query.ManifestPaths
+	paths    map[string]bool
+	prefixes []string
+}
+
+// Subtest is a query.Subtest bound to an in-memory index.
+type Subtest struct {
+	index
+	q query.Subtest
+}
+
 // runTestStatusEq is a query.RunTestStatusEq bound to an
 // in-memory index.
 type runTestStatusEq struct {
@@ -52,6 +79,186 @@ type runTestStatusNeq struct {
 	q query.RunTestStatusNeq
 }
 
+// runTestStatusIn is a query.RunTestStatusIn bound to an
+// in-memory index.
+type runTestStatusIn struct {
+	index
+	q query.RunTestStatusIn
+}
+
+// anyRunTestStatusEq is a query.AnyRunTestStatusEq bound to an
+// in-memory index.
+type anyRunTestStatusEq struct {
+	index
+	q query.AnyRunTestStatusEq
+}
+
+// runHasScreenshot is a query.RunHasScreenshot bound to an in-memory index.
+type runHasScreenshot struct {
+	index
+	q query.RunHasScreenshot
+}
+
+// runIntermittent is a query.RunIntermittent bound to an in-memory index.
+type runIntermittent struct {
+	index
+	q query.RunIntermittent
+}
+
+// runAssertCount is a query.RunAssertCount bound to an in-memory index.
+type runAssertCount struct {
+	index
+	q query.RunAssertCount
+}
+
+// runSkipped is a query.RunSkipped bound to an in-memory index.
+type runSkipped struct {
+	index
+	q query.RunSkipped
+}
+
+// runUnexpected is a query.RunUnexpected bound to an in-memory index.
+type runUnexpected struct {
+	index
+	q query.RunUnexpected
+}
+
+// runSubtestPlurality is a query.RunSubtestPlurality bound to an in-memory
+// index.
+type runSubtestPlurality struct {
+	index
+	q query.RunSubtestPlurality
+}
+
+// runHarnessMessage is a query.RunHarnessMessage bound to an in-memory index.
+type runHarnessMessage struct {
+	index
+	q query.RunHarnessMessage
+}
+
+// runAllSubtestsPass is a query.RunAllSubtestsPass bound to an in-memory index.
+type runAllSubtestsPass struct {
+	index
+	q query.RunAllSubtestsPass
+}
+
+// runSubtestPassRatio is a query.RunSubtestPassRatio bound to an in-memory
+// index.
+type runSubtestPassRatio struct {
+	index
+	q query.RunSubtestPassRatio
+}
+
+// runRefMatch is a query.RunRefMatch bound to an in-memory index.
+type runRefMatch struct {
+	index
+	q query.RunRefMatch
+}
+
+// runRegressed is a query.RunRegressed bound to an in-memory index.
+type runRegressed struct {
+	index
+	q query.RunRegressed
+}
+
+// anyRunStatusChanged is a query.AnyRunStatusChanged bound to an in-memory
+// index.
+type anyRunStatusChanged struct {
+	index
+	q query.AnyRunStatusChanged
+}
+
+// runsSubtestCountDelta is a query.RunsSubtestCountDelta bound to an in-memory
+// index.
+type runsSubtestCountDelta struct {
+	index
+	q query.RunsSubtestCountDelta
+}
+
+// runsSameRender is a query.RunsSameRender bound to an in-memory index.
+type runsSameRender struct {
+	index
+	q query.RunsSameRender
+}
+
+// runStatusChanged is a query.RunStatusChanged bound to an in-memory index.
+type runStatusChanged struct {
+	index
+	q query.RunStatusChanged
+}
+
+// runsFlakinessRate is a query.RunsFlakinessRate bound to an in-memory index.
+type runsFlakinessRate struct {
+	index
+	q query.RunsFlakinessRate
+}
+
+// anyRunSubtestTotal is a query.AnyRunSubtestTotal bound to an in-memory
+// index.
+type anyRunSubtestTotal struct {
+	index
+	q query.AnyRunSubtestTotal
+}
+
+// anyRunLongTimeout is a query.AnyRunLongTimeout bound to an in-memory index.
+type anyRunLongTimeout struct {
+	index
+	q query.AnyRunLongTimeout
+}
+
+// anyRunHasMetadataField is a query.AnyRunHasMetadataField bound to an
+// in-memory index.
+type anyRunHasMetadataField struct {
+	index
+	q query.AnyRunHasMetadataField
+}
+
+// browsersFailingCount is a query.BrowsersFailingCount bound to an in-memory
+// index.
+type browsersFailingCount struct {
+	index
+	q query.BrowsersFailingCount
+}
+
+// browserWorseThanMedian is a query.BrowserWorseThanMedian bound to an
+// in-memory index.
+type browserWorseThanMedian struct {
+	index
+	q query.BrowserWorseThanMedian
+}
+
+// browserSubtestOnlyIn is a query.BrowserSubtestOnlyIn bound to an in-memory
+// index.
+type browserSubtestOnlyIn struct {
+	index
+	q query.BrowserSubtestOnlyIn
+}
+
+// runsWithResults is a query.RunsWithResults bound to an in-memory index.
+type runsWithResults struct {
+	index
+	q query.RunsWithResults
+}
+
+// firstSeenAfter is a query.FirstSeenAfter bound to an in-memory index.
+type firstSeenAfter struct {
+	index
+	q query.FirstSeenAfter
+}
+
+// anyRunMatches is a query.AnyRunMatches bound to an in-memory index.
+type anyRunMatches struct {
+	index
+	q query.AnyRunMatches
+}
+
+// presentInAllBrowsers is a query.PresentInAllBrowsers bound to an in-memory
+// index.
+type presentInAllBrowsers struct {
+	index
+	q query.PresentInAllBrowsers
+}
+
 // Count is a query.Count bound to an in-memory index.
 type Count struct {
 	index
@@ -87,8 +294,32 @@ type filter interface {
 }
 
 type index struct {
-	tests      Tests
-	runResults map[RunID]RunResults
+	tests           Tests
+	runResults      map[RunID]RunResults
+	screenshots     map[RunID]map[TestID]string
+	subtestTotals   map[RunID]map[TestID]int
+	longTimeouts    map[RunID]map[TestID]bool
+	intermittent    map[RunID]map[TestID]bool
+	assertCounts    map[RunID]map[TestID]int
+	disabled        map[RunID]map[TestID]bool
+	failingSubtests map[RunID]map[TestID]bool
+	passingSubtests map[RunID]map[TestID]int
+	reftests        map[RunID]map[TestID]bool
+	metadataFields  map[RunID]map[TestID][]string
+	expected        map[RunID]map[TestID][]ResultID
+	pluralities     map[RunID]map[TestID]ResultID
+	messages        map[RunID]map[TestID]string
+	statusCounts    map[RunID]map[ResultID]int
+	numTests        int
+	// runs are the IDs of the runs that the index was extracted for, in the
+	// order in which they were bound.
+	runs []RunID
+	// boundRuns are the runs that the index was bound to, when known, for
+	// describing the bound query.
+	boundRuns []shared.TestRun
+	// maxResults, when positive, caps the number of search results collected
+	// from the index's shard.
+	maxResults int
 	m          *sync.RWMutex
 }
 
@@ -110,7 +341,29 @@ func (tnp TestNamePattern) Filter(t TestID) bool {
 	if err != nil {
 		return false
 	}
-	return strings.Contains(name, tnp.q.Pattern)
+	if len(tnp.q.Patterns) == 0 {
+		return tnp.contains(name, tnp.q.Pattern)
+	}
+	for _, pattern := range tnp.q.Patterns {
+		if tnp.contains(name, pattern) != tnp.q.MatchAll {
+			// A mismatch under match-all, or a match under match-any.
+			return !tnp.q.MatchAll
+		}
+	}
+	return tnp.q.MatchAll
+}
+
+func (tnp TestNamePattern) contains(name, pattern string) bool {
+	if tnp.q.IgnoreCase {
+		return containsFold(name, pattern)
+	}
+	return strings.Contains(name, pattern)
+}
+
+// Shard is a query.Shard bound to an in-memory index.
+type Shard struct {
+	index
+	q query.Shard
 }
 
 // Filter interprets a TestPath as a filter function over TestIDs.
@@ -122,6 +375,75 @@ func (tp TestPath) Filter(t TestID) bool {
 	return strings.HasPrefix(name, tp.q.Path)
 }
 
+// Filter interprets a TestNameIn as a filter function over TestIDs.
+func (tni TestNameIn) Filter(t TestID) bool {
+	name, _, err := tni.tests.GetName(t)
+	if err != nil {
+		return false
+	}
+	return tni.names[name]
+}
+
+// Filter interprets a ManifestPaths as a filter function over TestIDs.
+func (mp ManifestPaths) Filter(t TestID) bool {
+	name, _, err := mp.tests.GetName(t)
+	if err != nil {
+		return false
+	}
+	if !mp.q.Prefix {
+		return mp.paths[name]
+	}
+	// Any prefix of name sorts at or before it, and no other prefix can sort
+	// between them (it would begin with the first, and so have been dropped), so
+	// only the last prefix that sorts at or before name need be checked.
+	i := sort.Search(len(mp.prefixes), func(i int) bool { return mp.prefixes[i] > name })
+	return i > 0 && strings.HasPrefix(name, mp.prefixes[i-1])
+}
+
+// Filter interprets a Shard as a filter function over TestIDs.
+func (s Shard) Filter(t TestID) bool {
+	name, _, err := s.tests.GetName(t)
+	if err != nil {
+		return false
+	}
+	return s.q.Matches(name)
+}
+
+// newManifestPaths binds a query.ManifestPaths to idx.
+func newManifestPaths(idx index, q query.ManifestPaths) ManifestPaths {
+	if !q.Prefix {
+		paths := make(map[string]bool, len(q.Paths))
+		for _, path := range q.Paths {
+			paths[path] = true
+		}
+		return ManifestPaths{index: idx, q: q, paths: paths}
+	}
+
+	sorted := append([]string(nil), q.Paths...)
+	sort.Strings(sorted)
+	prefixes := make([]string, 0, len(sorted))
+	for _, prefix := range sorted {
+		// A prefix sorts after any prefix of itself.
+		if len(prefixes) > 0 && strings.HasPrefix(prefix, prefixes[len(prefixes)-1]) {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return ManifestPaths{index: idx, q: q, prefixes: prefixes}
+}
+
+// Filter interprets a Subtest as a filter function over TestIDs.
+func (s Subtest) Filter(t TestID) bool {
+	_, subName, err := s.tests.GetName(t)
+	if err != nil || subName == nil {
+		return false
+	}
+	if s.q.Exact {
+		return *subName == s.q.Name
+	}
+	return strings.Contains(*subName, s.q.Name)
+}
+
 // Filter interprets a runTestStatusEq as a filter function over TestIDs.
 func (rtse runTestStatusEq) Filter(t TestID) bool {
 	return rtse.runResults[RunID(rtse.q.Run)].GetResult(t) == ResultID(rtse.q.Status)
@@ -132,6 +454,383 @@ func (rtsn runTestStatusNeq) Filter(t TestID) bool {
 	return rtsn.runResults[RunID(rtsn.q.Run)].GetResult(t) != ResultID(rtsn.q.Status)
 }
 
+// Filter interprets a runTestStatusIn as a filter function over TestIDs.
+func (rtsi runTestStatusIn) Filter(t TestID) bool {
+	res := rtsi.runResults[RunID(rtsi.q.Run)].GetResult(t)
+	for _, status := range rtsi.q.Statuses {
+		if res == ResultID(status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets an anyRunTestStatusEq as a filter function over TestIDs.
+func (artse anyRunTestStatusEq) Filter(t TestID) bool {
+	for _, run := range artse.q.Runs {
+		if artse.runResults[RunID(run)].GetResult(t) == ResultID(artse.q.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets a runHasScreenshot as a filter function over TestIDs.
+func (rhs runHasScreenshot) Filter(t TestID) bool {
+	_, ok := rhs.screenshots[RunID(rhs.q.Run)][t]
+	return ok
+}
+
+// Filter interprets a runSkipped as a filter function over TestIDs. Subtests of
+// a disabled test are also considered skipped.
+func (rs runSkipped) Filter(t TestID) bool {
+	run := RunID(rs.q.Run)
+	if rs.runResults[run].GetResult(t) == ResultID(shared.TestStatusSkip) {
+		return true
+	}
+	return rs.disabled[run][TestID{testID: t.testID}]
+}
+
+// Filter interprets a runIntermittent as a filter function over TestIDs.
+// Subtests match according to their top-level test.
+func (ri runIntermittent) Filter(t TestID) bool {
+	return ri.intermittent[RunID(ri.q.Run)][TestID{testID: t.testID}]
+}
+
+// Filter interprets a runAssertCount as a filter function over TestIDs.
+// Subtests match according to their top-level test.
+func (rac runAssertCount) Filter(t TestID) bool {
+	count, ok := rac.assertCounts[RunID(rac.q.Run)][TestID{testID: t.testID}]
+	return ok && count >= rac.q.Min && (rac.q.Max < 0 || count <= rac.q.Max)
+}
+
+// Filter interprets a runUnexpected as a filter function over TestIDs. Subtests
+// match according to the result of their top-level test.
+func (ru runUnexpected) Filter(t TestID) bool {
+	run := RunID(ru.q.Run)
+	top := TestID{testID: t.testID}
+	res := ru.runResults[run].GetResult(top)
+	if res == ResultID(shared.TestStatusUnknown) {
+		return false
+	}
+	expected, ok := ru.expected[run][top]
+	if !ok {
+		return !shared.TestStatus(res).IsPassOrOK()
+	}
+	for _, e := range expected {
+		if res == e {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter interprets a runSubtestPlurality as a filter function over TestIDs.
+// Subtests match according to the subtests of their top-level test.
+func (rsp runSubtestPlurality) Filter(t TestID) bool {
+	plurality, ok := rsp.pluralities[RunID(rsp.q.Run)][TestID{testID: t.testID}]
+	return ok && plurality == ResultID(rsp.q.Status)
+}
+
+// Filter interprets a runHarnessMessage as a filter function over TestIDs.
+// Subtests match according to the message of their top-level test.
+func (rhm runHarnessMessage) Filter(t TestID) bool {
+	message, ok := rhm.messages[RunID(rhm.q.Run)][TestID{testID: t.testID}]
+	return ok && strings.Contains(message, rhm.q.Pattern)
+}
+
+// Filter interprets a runAllSubtestsPass as a filter function over TestIDs.
+// Subtests match according to the subtests of their top-level test.
+func (rasp runAllSubtestsPass) Filter(t TestID) bool {
+	run := RunID(rasp.q.Run)
+	top := TestID{testID: t.testID}
+	total, ok := rasp.subtestTotals[run][top]
+	if !ok {
+		// No result for the test in this run.
+		return false
+	}
+	if total == 0 {
+		return rasp.runResults[run].GetResult(top) == ResultID(shared.TestStatusPass)
+	}
+	return !rasp.failingSubtests[run][top]
+}
+
+// Filter interprets a runSubtestPassRatio as a filter function over TestIDs.
+// Subtests match according to the subtests of their top-level test.
+func (rspr runSubtestPassRatio) Filter(t TestID) bool {
+	run := RunID(rspr.q.Run)
+	top := TestID{testID: t.testID}
+	total := rspr.subtestTotals[run][top]
+	if total == 0 {
+		// No result, or no subtests, for the test in this run.
+		return false
+	}
+	ratio := float64(rspr.passingSubtests[run][top]) / float64(total)
+	if rspr.q.Below >= 0 && ratio >= rspr.q.Below {
+		return false
+	}
+	return ratio > rspr.q.Above
+}
+
+// Filter interprets a runRefMatch as a filter function over TestIDs. Subtests
+// match according to the reference comparison of their top-level test.
+func (rrm runRefMatch) Filter(t TestID) bool {
+	mismatch, ok := rrm.reftests[RunID(rrm.q.Run)][TestID{testID: t.testID}]
+	return ok && mismatch == (rrm.q.Expected == query.RefExpectMismatch)
+}
+
+// Filter interprets a runRegressed as a filter function over TestIDs.
+func (rr runRegressed) Filter(t TestID) bool {
+	base := query.StatusSeverity(shared.TestStatus(rr.runResults[RunID(rr.q.Baseline)].GetResult(t)))
+	if base < 0 {
+		return false
+	}
+	return query.StatusSeverity(shared.TestStatus(rr.runResults[RunID(rr.q.Run)].GetResult(t))) > base
+}
+
+// Filter interprets a runStatusChanged as a filter function over TestIDs.
+func (rsc runStatusChanged) Filter(t TestID) bool {
+	return rsc.runResults[RunID(rsc.q.Base)].GetResult(t) != rsc.runResults[RunID(rsc.q.Head)].GetResult(t)
+}
+
+// Filter interprets an anyRunStatusChanged as a filter function over TestIDs.
+func (arsc anyRunStatusChanged) Filter(t TestID) bool {
+	for i, head := range arsc.q.Heads {
+		if arsc.runResults[RunID(arsc.q.Bases[i])].GetResult(t) != arsc.runResults[RunID(head)].GetResult(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets a runsSubtestCountDelta as a filter function over TestIDs.
+// Subtests match according to the subtest totals of their top-level test.
+func (rscd runsSubtestCountDelta) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	from, fromOK := rscd.subtestTotals[RunID(rscd.q.From)][top]
+	to, toOK := rscd.subtestTotals[RunID(rscd.q.To)][top]
+	if !fromOK && !toOK {
+		return false
+	}
+	changed := fromOK != toOK || from != to
+	return changed == rscd.q.Changed
+}
+
+// Filter interprets a runsSameRender as a filter function over TestIDs.
+// Subtests match according to the screenshots of their top-level test.
+func (rsr runsSameRender) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	left, ok := rsr.screenshots[RunID(rsr.q.Left)][top]
+	if !ok {
+		return false
+	}
+	right, ok := rsr.screenshots[RunID(rsr.q.Right)][top]
+	return ok && left == right
+}
+
+// Filter interprets a runsFlakinessRate as a filter function over TestIDs.
+func (rfr runsFlakinessRate) Filter(t TestID) bool {
+	// Statuses are small integers; count them without allocating.
+	var counts [16]int
+	results, mode := 0, 0
+	for _, run := range rfr.q.Runs {
+		res := rfr.runResults[RunID(run)].GetResult(t)
+		if res == ResultID(shared.TestStatusUnknown) {
+			continue
+		}
+		results++
+		if res >= 0 && int(res) < len(counts) {
+			counts[res]++
+			if counts[res] > mode {
+				mode = counts[res]
+			}
+		}
+	}
+	if results == 0 {
+		return false
+	}
+	return float64(results-mode)/float64(results) > rfr.q.Above
+}
+
+// Filter interprets an anyRunSubtestTotal as a filter function over TestIDs.
+// Subtests match according to the subtest total of their top-level test.
+func (arst anyRunSubtestTotal) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	for _, run := range arst.q.Runs {
+		total, ok := arst.subtestTotals[RunID(run)][top]
+		if ok && total >= arst.q.Min && (arst.q.Max < 0 || total <= arst.q.Max) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets an anyRunLongTimeout as a filter function over TestIDs.
+// Subtests match according to the timeout of their top-level test.
+func (arlt anyRunLongTimeout) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	for _, run := range arlt.q.Runs {
+		if arlt.longTimeouts[RunID(run)][top] {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets an anyRunHasMetadataField as a filter function over
+// TestIDs. Subtests match according to the metadata of their top-level test.
+func (arhmf anyRunHasMetadataField) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	for _, run := range arhmf.q.Runs {
+		for _, field := range arhmf.metadataFields[RunID(run)][top] {
+			if field == arhmf.q.Field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter interprets a presentInAllBrowsers as a filter function over TestIDs.
+func (piab presentInAllBrowsers) Filter(t TestID) bool {
+	for _, runs := range piab.q.RunsByBrowser {
+		present := false
+		for _, run := range runs {
+			if piab.runResults[RunID(run)].GetResult(t) != ResultID(shared.TestStatusUnknown) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter interprets a browsersFailingCount as a filter function over TestIDs.
+func (bfc browsersFailingCount) Filter(t TestID) bool {
+	failing := 0
+	for _, runs := range bfc.q.RunsByBrowser {
+		for _, run := range runs {
+			status := shared.TestStatus(bfc.runResults[RunID(run)].GetResult(t))
+			if status != shared.TestStatusUnknown && !status.IsPassOrOK() {
+				failing++
+				break
+			}
+		}
+	}
+	switch bfc.q.Comparison {
+	case query.ComparisonEq:
+		return failing == bfc.q.Count
+	case query.ComparisonGte:
+		return failing >= bfc.q.Count
+	case query.ComparisonLte:
+		return failing <= bfc.q.Count
+	default:
+		return false
+	}
+}
+
+// Filter interprets a browserWorseThanMedian as a filter function over
+// TestIDs.
+func (bwtm browserWorseThanMedian) Filter(t TestID) bool {
+	var buf [8]int
+	severities := buf[:0]
+	target := -1
+	for i, runs := range bwtm.q.RunsByBrowser {
+		// The browser's least severe result among its runs.
+		severity := -1
+		for _, run := range runs {
+			s := query.StatusSeverity(shared.TestStatus(bwtm.runResults[RunID(run)].GetResult(t)))
+			if s >= 0 && (severity < 0 || s < severity) {
+				severity = s
+			}
+		}
+		if severity < 0 {
+			continue
+		}
+		if i == bwtm.q.Browser {
+			target = severity
+		}
+		severities = append(severities, severity)
+	}
+	if target < 0 {
+		return false
+	}
+	sort.Ints(severities)
+	return target > severities[(len(severities)-1)/2]
+}
+
+// Filter interprets a browserSubtestOnlyIn as a filter function over TestIDs.
+func (bsoi browserSubtestOnlyIn) Filter(t TestID) bool {
+	_, subName, err := bsoi.tests.GetName(t)
+	if err != nil || subName == nil || *subName != bsoi.q.Subtest {
+		return false
+	}
+	found := false
+	for i, runs := range bsoi.q.RunsByBrowser {
+		for _, run := range runs {
+			if bsoi.runResults[RunID(run)].GetResult(t) == ResultID(shared.TestStatusUnknown) {
+				continue
+			}
+			if i != bsoi.q.Browser {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// Filter interprets a runsWithResults as a filter function over TestIDs.
+func (rwr runsWithResults) Filter(t TestID) bool {
+	if rwr.q.Min <= 0 {
+		return true
+	}
+	count := 0
+	for _, run := range rwr.q.Runs {
+		if rwr.runResults[RunID(run)].GetResult(t) != ResultID(shared.TestStatusUnknown) {
+			if count++; count >= rwr.q.Min {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter interprets a firstSeenAfter as a filter function over TestIDs.
+func (fsa firstSeenAfter) Filter(t TestID) bool {
+	for _, run := range fsa.q.Before {
+		if fsa.runResults[RunID(run)].GetResult(t) != ResultID(shared.TestStatusUnknown) {
+			return false
+		}
+	}
+	for _, run := range fsa.q.After {
+		if fsa.runResults[RunID(run)].GetResult(t) != ResultID(shared.TestStatusUnknown) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter interprets an anyRunMatches as a filter function over TestIDs.
+// Subtests match according to the results of their top-level test.
+func (arm anyRunMatches) Filter(t TestID) bool {
+	top := TestID{testID: t.testID}
+	name, _, err := arm.tests.GetName(t)
+	if err != nil {
+		return false
+	}
+	for _, run := range arm.q.Runs {
+		status := shared.TestStatus(arm.runResults[RunID(run)].GetResult(top))
+		if arm.q.Matcher.Match(name, query.RunResults{Run: run, Status: status}) {
+			return true
+		}
+	}
+	return false
+}
+
 // Filter interprets a Count as a filter function over TestIDs.
 func (c Count) Filter(t TestID) bool {
 	args := c.args
@@ -184,10 +883,78 @@ func newFilter(idx index, q query.ConcreteQuery) (filter, error) {
 		return TestNamePattern{idx, v}, nil
 	case query.TestPath:
 		return TestPath{idx, v}, nil
+	case query.TestNameIn:
+		names := make(map[string]bool, len(v.Names))
+		for _, name := range v.Names {
+			names[name] = true
+		}
+		return TestNameIn{idx, v, names}, nil
+	case query.ManifestPaths:
+		return newManifestPaths(idx, v), nil
+	case query.Shard:
+		return Shard{idx, v}, nil
+	case query.Subtest:
+		return Subtest{idx, v}, nil
 	case query.RunTestStatusEq:
 		return runTestStatusEq{idx, v}, nil
 	case query.RunTestStatusNeq:
 		return runTestStatusNeq{idx, v}, nil
+	case query.RunTestStatusIn:
+		return runTestStatusIn{idx, v}, nil
+	case query.AnyRunTestStatusEq:
+		return anyRunTestStatusEq{idx, v}, nil
+	case query.RunHasScreenshot:
+		return runHasScreenshot{idx, v}, nil
+	case query.RunSkipped:
+		return runSkipped{idx, v}, nil
+	case query.RunIntermittent:
+		return runIntermittent{idx, v}, nil
+	case query.RunAssertCount:
+		return runAssertCount{idx, v}, nil
+	case query.RunUnexpected:
+		return runUnexpected{idx, v}, nil
+	case query.RunSubtestPlurality:
+		return runSubtestPlurality{idx, v}, nil
+	case query.RunHarnessMessage:
+		return runHarnessMessage{idx, v}, nil
+	case query.RunAllSubtestsPass:
+		return runAllSubtestsPass{idx, v}, nil
+	case query.RunSubtestPassRatio:
+		return runSubtestPassRatio{idx, v}, nil
+	case query.RunRefMatch:
+		return runRefMatch{idx, v}, nil
+	case query.RunRegressed:
+		return runRegressed{idx, v}, nil
+	case query.RunStatusChanged:
+		return runStatusChanged{idx, v}, nil
+	case query.AnyRunStatusChanged:
+		return anyRunStatusChanged{idx, v}, nil
+	case query.RunsSubtestCountDelta:
+		return runsSubtestCountDelta{idx, v}, nil
+	case query.RunsSameRender:
+		return runsSameRender{idx, v}, nil
+	case query.RunsFlakinessRate:
+		return runsFlakinessRate{idx, v}, nil
+	case query.AnyRunSubtestTotal:
+		return anyRunSubtestTotal{idx, v}, nil
+	case query.AnyRunLongTimeout:
+		return anyRunLongTimeout{idx, v}, nil
+	case query.AnyRunHasMetadataField:
+		return anyRunHasMetadataField{idx, v}, nil
+	case query.PresentInAllBrowsers:
+		return presentInAllBrowsers{idx, v}, nil
+	case query.BrowsersFailingCount:
+		return browsersFailingCount{idx, v}, nil
+	case query.BrowserWorseThanMedian:
+		return browserWorseThanMedian{idx, v}, nil
+	case query.BrowserSubtestOnlyIn:
+		return browserSubtestOnlyIn{idx, v}, nil
+	case query.FirstSeenAfter:
+		return firstSeenAfter{idx, v}, nil
+	case query.RunsWithResults:
+		return runsWithResults{idx, v}, nil
+	case query.AnyRunMatches:
+		return anyRunMatches{idx, v}, nil
 	case query.Count:
 		fs, err := filters(idx, v.Args)
 		if err != nil {
@@ -218,23 +985,80 @@ func newFilter(idx index, q query.ConcreteQuery) (filter, error) {
 }
 
 // Execute runs each filter in a ShardedFilter in parallel, returning a slice of
-// TestIDs as the result. Note that TestIDs are not deduplicated; the assumption
-// is that each filter is bound to a different shard, sharded by TestID.
+// TestIDs as the result (or, with opts.CountOnly, their number). Note that
+// TestIDs are not deduplicated; the assumption is that each filter is bound to
+// a different shard, sharded by TestID. When the filter was bound with a result
+// limit (see query.BindOpts.MaxResults), at most that many results are
+// collected.
 func (fs ShardedFilter) Execute(runs []shared.TestRun, opts query.AggregationOpts) interface{} {
+	ret, _ := fs.execute(runs, opts, nil)
+	return ret
+}
+
+// ExecuteWithTruncation runs each filter in a ShardedFilter in parallel, as
+// Execute does, also returning whether any matching tests were dropped from the
+// results because of the result limit that the filter was bound with.
+func (fs ShardedFilter) ExecuteWithTruncation(runs []shared.TestRun, opts query.AggregationOpts) (interface{}, bool) {
+	return fs.execute(runs, opts, nil)
+}
+
+// ExecuteWithStats runs each filter in a ShardedFilter in parallel, as Execute
+// does, also returning counts of the tests scanned and matched, and the atoms
+// evaluated, across all shards.
+func (fs ShardedFilter) ExecuteWithStats(runs []shared.TestRun, opts query.AggregationOpts) (interface{}, query.QueryStats) {
+	start := time.Now()
+	shardStats := make([]query.QueryStats, len(fs))
+	ret, _ := fs.execute(runs, opts, shardStats)
+
+	var stats query.QueryStats
+	for _, s := range shardStats {
+		stats.TestsScanned += s.TestsScanned
+		stats.TestsMatched += s.TestsMatched
+		stats.AtomsEvaluated += s.AtomsEvaluated
+	}
+	stats.WallTime = time.Since(start)
+	return ret, stats
+}
+
+// execute runs each filter in a ShardedFilter in parallel, returning its results
+// and whether they were truncated. When shardStats is non-nil, it must have an
+// element per filter, which collects stats for that filter's shard.
+func (fs ShardedFilter) execute(runs []shared.TestRun, opts query.AggregationOpts, shardStats []query.QueryStats) (interface{}, bool) {
 	rus := make([]RunID, len(runs))
 	for i := range runs {
 		rus[i] = RunID(runs[i].ID)
 	}
-	res := make(chan []query.SearchResult, len(fs))
+	res := make(chan aggregator, len(fs))
 	errs := make(chan error)
-	for _, f := range fs {
-		go syncRunFilter(rus, f, opts, res, errs)
+	for i, f := range fs {
+		var stats *query.QueryStats
+		if shardStats != nil {
+			stats = &shardStats[i]
+		}
+		go syncRunFilter(rus, f, opts, stats, res, errs)
 	}
 
-	ret := make([]query.SearchResult, 0)
-	for i := 0; i < len(fs); i++ {
-		ts := <-res
-		ret = append(ret, ts...)
+	var ret interface{}
+	truncated := false
+	if opts.CountOnly {
+		count := 0
+		for i := 0; i < len(fs); i++ {
+			count += (<-res).Count()
+		}
+		ret = count
+	} else {
+		srs := make([]query.SearchResult, 0)
+		for i := 0; i < len(fs); i++ {
+			agg := <-res
+			truncated = truncated || agg.Truncated()
+			srs = append(srs, agg.Done()...)
+		}
+		// Each shard is capped separately; cap the combined results too.
+		if limit := fs.maxResults(); limit > 0 && len(srs) > limit {
+			srs = srs[:limit]
+			truncated = true
+		}
+		ret = srs
 	}
 
 	// To keep query execution fast, report errors in a separate goroutine and
@@ -251,17 +1075,33 @@ func (fs ShardedFilter) Execute(runs []shared.TestRun, opts query.AggregationOpt
 		}()
 	}
 
-	return ret
+	return ret, truncated
+}
+
+func (fs ShardedFilter) maxResults() int {
+	if len(fs) == 0 {
+		return 0
+	}
+	return fs[0].idx().maxResults
 }
 
-func syncRunFilter(rus []RunID, f filter, opts query.AggregationOpts, res chan []query.SearchResult, errs chan error) {
+func syncRunFilter(rus []RunID, f filter, opts query.AggregationOpts, stats *query.QueryStats, res chan aggregator, errs chan error) {
 	idx := f.idx()
 	idx.m.RLock()
 	defer idx.m.RUnlock()
 
-	agg := newIndexAggregator(idx, rus, opts)
+	if stats != nil {
+		f = instrument(f, &stats.AtomsEvaluated)
+	}
+	agg := newAggregator(idx, rus, opts)
 	idx.tests.Range(func(t TestID) bool {
+		if stats != nil {
+			stats.TestsScanned++
+		}
 		if f.Filter(t) {
+			if stats != nil {
+				stats.TestsMatched++
+			}
 			err := agg.Add(t)
 			if err != nil {
 				errs <- err
@@ -269,7 +1109,7 @@ func syncRunFilter(rus []RunID, f filter, opts query.AggregationOpts, res chan [
 		}
 		return true
 	})
-	res <- agg.Done()
+	res <- agg
 }
 
 func filters(idx index, qs []query.ConcreteQuery) ([]filter, error) {
@@ -283,3 +1123,85 @@ func filters(idx index, qs []query.ConcreteQuery) ([]filter, error) {
 	}
 	return fs, nil
 }
+
+// countingFilter is a filter that counts its evaluations.
+type countingFilter struct {
+	filter
+	evals *int
+}
+
+// Filter delegates to the underlying filter, counting the evaluation.
+func (cf countingFilter) Filter(t TestID) bool {
+	*cf.evals++
+	return cf.filter.Filter(t)
+}
+
+// instrument produces a copy of a filter tree in which each leaf filter counts
+// its evaluations in evals.
+func instrument(f filter, evals *int) filter {
+	switch v := f.(type) {
+	case Count:
+		return Count{v.index, v.count, instrumentAll(v.args, evals)}
+	case And:
+		return And{v.index, instrumentAll(v.args, evals)}
+	case Or:
+		return Or{v.index, instrumentAll(v.args, evals)}
+	case Not:
+		return Not{v.index, instrument(v.arg, evals)}
+	default:
+		return countingFilter{f, evals}
+	}
+}
+
+func instrumentAll(fs []filter, evals *int) []filter {
+	instrumented := make([]filter, len(fs))
+	for i := range fs {
+		instrumented[i] = instrument(fs[i], evals)
+	}
+	return instrumented
+}
+
+// containsFold reports whether substr is within s under Unicode simple case
+// folding, i.e., the case-insensitive equivalent of strings.Contains, in the
+// sense of strings.EqualFold. Unlike comparing the strings.ToLower forms of both
+// strings, all runes in a case-folding orbit are equivalent (e.g., "σ", "ς" and
+// "Σ"), and no locale-specific mappings apply (e.g., Turkish "İ" and "i" are
+// distinct).
+func containsFold(s, substr string) bool {
+	if substr == "" {
+		return true
+	}
+	for i := range s {
+		if hasPrefixFold(s[i:], substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	for _, pr := range prefix {
+		if s == "" {
+			return false
+		}
+		sr, size := utf8.DecodeRuneInString(s)
+		if !equalFoldRune(sr, pr) {
+			return false
+		}
+		s = s[size:]
+	}
+	return true
+}
+
+func equalFoldRune(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	// SimpleFold iterates over the runes equivalent to a under case folding.
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
+			return true
+		}
+	}
+	return false
+}
@@ -117,11 +117,157 @@ func NewProxyIndex(idx Index) ProxyIndex {
 }
 
 // ReportLoader handles loading a WPT test results report based on metadata in
-// a shared.TestRun.
+// a shared.TestRun. Optional extensions, such as ScreenshotLoader, load further
+// per-run data; binding a query that consults data that the index's loader does
+// not load fails (or, where the query falls back to results, warns).
 type ReportLoader interface {
 	Load(shared.TestRun) (*metrics.TestResultsReport, error)
 }
 
+// ScreenshotLoader is an optional extension of ReportLoader for loaders that
+// can also load screenshot artifact metadata for a test run. LoadScreenshots
+// produces a mapping from test name to screenshot hash (of the form
+// "HASH_METHOD:HASH_DIGEST"). Queries over screenshots can only match runs
+// whose screenshots were loaded this way when the run was ingested.
+type ScreenshotLoader interface {
+	LoadScreenshots(shared.TestRun) (map[string]string, error)
+}
+
+// LongTimeoutLoader is an optional extension of ReportLoader for loaders that
+// can also load the names of tests marked as having a long timeout (i.e., with
+// "timeout": "long") in the WPT manifest for a test run's revision. Queries over
+// long timeouts can only match runs whose long timeout tests were loaded this
+// way when the run was ingested.
+type LongTimeoutLoader interface {
+	LoadLongTimeoutTests(shared.TestRun) ([]string, error)
+}
+
+// DisabledTestLoader is an optional extension of ReportLoader for loaders that
+// can also load the names of tests marked as disabled (and therefore not run)
+// in a test run's metadata. Such tests are considered skipped in the run, as
+// are tests with a SKIP result.
+type DisabledTestLoader interface {
+	LoadDisabledTests(shared.TestRun) ([]string, error)
+}
+
+// IntermittentLoader is an optional extension of ReportLoader for loaders that
+// can also load the names of tests whose results are flagged as known to be
+// intermittent in a test run's metadata. Queries over intermittent results can
+// only match runs whose intermittent tests were loaded this way when the run
+// was ingested.
+type IntermittentLoader interface {
+	LoadIntermittentTests(shared.TestRun) ([]string, error)
+}
+
+// AssertCountLoader is an optional extension of ReportLoader for loaders that
+// can also load the number of assertions that each test ran in a test run, as
+// recorded by some harnesses. LoadAssertCounts produces a mapping from test name
+// to assertion count. Queries over assertion counts can only match runs whose
+// counts were loaded this way when the run was ingested.
+type AssertCountLoader interface {
+	LoadAssertCounts(shared.TestRun) (map[string]int, error)
+}
+
+// ReftestLoader is an optional extension of ReportLoader for loaders that can
+// also load the reference comparisons of reftests in the WPT manifest for a test
+// run's revision. LoadReftests produces a mapping from test name to comparison,
+// either "==" (the test must match its reference) or "!=" (the test must not
+// match its reference). Queries over reftests can only match runs whose
+// reftests were loaded this way when the run was ingested.
+type ReftestLoader interface {
+	LoadReftests(shared.TestRun) (map[string]string, error)
+}
+
+// MetadataLoader is an optional extension of ReportLoader for loaders that can
+// also load the triage metadata (from wpt-metadata) associated with a test run.
+// LoadMetadataFields produces a mapping from test name to the metadata fields
+// (see query.MetadataFields) defined for the test. Queries over metadata fields
+// can only match runs whose metadata was loaded this way when the run was
+// ingested.
+type MetadataLoader interface {
+	LoadMetadataFields(shared.TestRun) (map[string][]string, error)
+}
+
+// ExpectationLoader is an optional extension of ReportLoader for loaders that
+// can also load the expected statuses of tests recorded in a test run's
+// metadata. LoadExpectedStatuses produces a mapping from test name to the
+// statuses expected for the test; tests without an entry are expected to pass.
+// Queries over unexpected results treat runs whose expectations were not loaded
+// this way when the run was ingested as expecting every test to pass.
+type ExpectationLoader interface {
+	LoadExpectedStatuses(shared.TestRun) (map[string][]shared.TestStatus, error)
+}
+
+// checkLoaders checks that loader provides the per-run data consulted by the
+// atoms of q. Atoms that would match nothing without their data (e.g.,
+// screenshot atoms without a ScreenshotLoader) produce an error. Atoms that
+// fall back to run results without their data (skipped and unexpected results)
+// instead produce a BindWarning for each such run, appended to warnings.
+func checkLoaders(loader ReportLoader, q query.ConcreteQuery, warnings []query.BindWarning) ([]query.BindWarning, error) {
+	var ok bool
+	var data string
+	switch v := q.(type) {
+	case query.And:
+		return checkLoadersAll(loader, v.Args, warnings)
+	case query.Or:
+		return checkLoadersAll(loader, v.Args, warnings)
+	case query.Count:
+		return checkLoadersAll(loader, v.Args, warnings)
+	case query.Not:
+		return checkLoaders(loader, v.Arg, warnings)
+	case query.RunHasScreenshot, query.RunsSameRender:
+		_, ok = loader.(ScreenshotLoader)
+		data = "screenshot metadata"
+	case query.AnyRunLongTimeout:
+		_, ok = loader.(LongTimeoutLoader)
+		data = "long timeout metadata"
+	case query.RunRefMatch:
+		_, ok = loader.(ReftestLoader)
+		data = "reftest metadata"
+	case query.AnyRunHasMetadataField:
+		_, ok = loader.(MetadataLoader)
+		data = "triage metadata"
+	case query.RunIntermittent:
+		_, ok = loader.(IntermittentLoader)
+		data = "intermittent test metadata"
+	case query.RunAssertCount:
+		_, ok = loader.(AssertCountLoader)
+		data = "assertion counts"
+	case query.RunSkipped:
+		if _, ok := loader.(DisabledTestLoader); !ok {
+			warnings = append(warnings, query.BindWarning{
+				Run:     v.Run,
+				Message: fmt.Sprintf("Disabled tests are not loaded for run %v; only SKIP results are considered skipped", v.Run),
+			})
+		}
+		return warnings, nil
+	case query.RunUnexpected:
+		if _, ok := loader.(ExpectationLoader); !ok {
+			warnings = append(warnings, query.BindWarning{
+				Run:     v.Run,
+				Message: fmt.Sprintf("Expected statuses are not loaded for run %v; every test is expected to pass", v.Run),
+			})
+		}
+		return warnings, nil
+	default:
+		return warnings, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("Query requires %s, which the index does not load", data)
+	}
+	return warnings, nil
+}
+
+func checkLoadersAll(loader ReportLoader, qs []query.ConcreteQuery, warnings []query.BindWarning) ([]query.BindWarning, error) {
+	for _, q := range qs {
+		var err error
+		if warnings, err = checkLoaders(loader, q, warnings); err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
+
 // shardedWPTIndex is an Index that manages test and result data across mutually
 // exclusive shards.
 type shardedWPTIndex struct {
@@ -138,15 +284,73 @@ type shardedWPTIndex struct {
 // shardedWPTIndex, which embed a slice of wptIndex containing mutually
 // exclusive subsets of test and result data.
 type wptIndex struct {
-	tests   Tests
-	results Results
-	m       *sync.RWMutex
+	tests         Tests
+	results       Results
+	screenshots   map[RunID]map[TestID]string
+	subtestTotals map[RunID]map[TestID]int
+	longTimeouts  map[RunID]map[TestID]bool
+	disabled      map[RunID]map[TestID]bool
+	// intermittent records, per run, the top-level tests whose results are
+	// flagged as known to be intermittent.
+	intermittent map[RunID]map[TestID]bool
+	// assertCounts records, per run, the number of assertions run by each
+	// top-level test with a recorded count.
+	assertCounts map[RunID]map[TestID]int
+	// failingSubtests records, per run, the top-level tests with at least one
+	// subtest that did not pass.
+	failingSubtests map[RunID]map[TestID]bool
+	// passingSubtests records, per run, the number of passing subtests of
+	// top-level tests with at least one passing subtest.
+	passingSubtests map[RunID]map[TestID]int
+	// reftests records, per run, the top-level reftests, mapped to whether their
+	// reference comparison is a mismatch (!=) rather than a match (==).
+	reftests map[RunID]map[TestID]bool
+	// metadataFields records, per run, the triage metadata fields defined for
+	// top-level tests.
+	metadataFields map[RunID]map[TestID][]string
+	// expected records, per run, the statuses expected by the run's metadata for
+	// top-level tests with a recorded expectation.
+	expected map[RunID]map[TestID][]ResultID
+	// pluralities records, per run, the status that is more common than any
+	// other among the subtests of top-level tests, for tests with such a status.
+	pluralities map[RunID]map[TestID]ResultID
+	// messages records, per run, the non-empty harness-level messages of
+	// top-level tests.
+	messages map[RunID]map[TestID]string
+	// statusCounts records, per run, the number of tests and subtests with each
+	// result, for estimating the cost of plans.
+	statusCounts map[RunID]map[ResultID]int
+	// numTests is the number of distinct tests and subtests in tests.
+	numTests int
+	m        *sync.RWMutex
 }
 
 // testData is a wrapper for a single unit of test+result data from a test run.
 type testData struct {
 	testName
 	ResultID
+	screenshot     string
+	subtestTotal   int
+	longTimeout    bool
+	intermittent   bool
+	failingSubtest bool
+	// assertCount is the number of assertions that the test ran, or -1 when it
+	// is not recorded.
+	assertCount int
+	// passingSubtests is the number of subtests of the test that passed.
+	passingSubtests int
+	// refComparison is the reference comparison of a reftest ("==" or "!="), or
+	// empty for other tests.
+	refComparison string
+	// metadataFields are the triage metadata fields defined for the test.
+	metadataFields []string
+	// expected are the statuses expected for the test by the run's metadata.
+	expected []shared.TestStatus
+	// plurality is the status that is more common than any other among the
+	// subtests of the test, or UNKNOWN when there is no such status.
+	plurality ResultID
+	// message is the harness-level message of the test, if any.
+	message string
 }
 
 // HTTPReportLoader loads WPT test run reports from the URL specified in test
@@ -184,6 +388,84 @@ func (i *shardedWPTIndex) IngestRun(r shared.TestRun) error {
 		return err
 	}
 
+	// Screenshots are optional artifacts; failing to load them is not fatal.
+	var screenshots map[string]string
+	if sl, ok := i.loader.(ScreenshotLoader); ok {
+		screenshots, err = sl.LoadScreenshots(r)
+		if err != nil {
+			log.Warningf("Failed to load screenshots for run %v: %v", r.ID, err)
+		}
+	}
+
+	// Likewise for long timeout tests from the manifest.
+	longTimeouts := make(map[string]bool)
+	if ltl, ok := i.loader.(LongTimeoutLoader); ok {
+		tests, err := ltl.LoadLongTimeoutTests(r)
+		if err != nil {
+			log.Warningf("Failed to load long timeout tests for run %v: %v", r.ID, err)
+		}
+		for _, test := range tests {
+			longTimeouts[test] = true
+		}
+	}
+
+	// Likewise for known intermittent tests from the run's metadata.
+	intermittent := make(map[string]bool)
+	if il, ok := i.loader.(IntermittentLoader); ok {
+		tests, err := il.LoadIntermittentTests(r)
+		if err != nil {
+			log.Warningf("Failed to load intermittent tests for run %v: %v", r.ID, err)
+		}
+		for _, test := range tests {
+			intermittent[test] = true
+		}
+	}
+
+	// Likewise for assertion counts.
+	var assertCounts map[string]int
+	if al, ok := i.loader.(AssertCountLoader); ok {
+		assertCounts, err = al.LoadAssertCounts(r)
+		if err != nil {
+			log.Warningf("Failed to load assertion counts for run %v: %v", r.ID, err)
+		}
+	}
+
+	// Likewise for reftest comparisons from the manifest.
+	var reftests map[string]string
+	if rl, ok := i.loader.(ReftestLoader); ok {
+		reftests, err = rl.LoadReftests(r)
+		if err != nil {
+			log.Warningf("Failed to load reftests for run %v: %v", r.ID, err)
+		}
+	}
+
+	// Likewise for triage metadata.
+	var metadataFields map[string][]string
+	if ml, ok := i.loader.(MetadataLoader); ok {
+		metadataFields, err = ml.LoadMetadataFields(r)
+		if err != nil {
+			log.Warningf("Failed to load metadata fields for run %v: %v", r.ID, err)
+		}
+	}
+
+	// Likewise for expected statuses.
+	var expected map[string][]shared.TestStatus
+	if el, ok := i.loader.(ExpectationLoader); ok {
+		expected, err = el.LoadExpectedStatuses(r)
+		if err != nil {
+			log.Warningf("Failed to load expected statuses for run %v: %v", r.ID, err)
+		}
+	}
+
+	// Likewise for tests disabled in the run's metadata.
+	var disabled []string
+	if dtl, ok := i.loader.(DisabledTestLoader); ok {
+		disabled, err = dtl.LoadDisabledTests(r)
+		if err != nil {
+			log.Warningf("Failed to load disabled tests for run %v: %v", r.ID, err)
+		}
+	}
+
 	// Results of different tests will be stored in different shards, based on the
 	// top-level test (i.e., not subtests) integral ID of each test in the report.
 	//
@@ -202,17 +484,6 @@ func (i *shardedWPTIndex) IngestRun(r shared.TestRun) error {
 			return err
 		}
 
-		shardIdx := int(t.testID % numShardsU64)
-		dataForShard := shardData[shardIdx]
-		re := ResultID(shared.TestStatusValueFromString(res.Status))
-		dataForShard[t] = testData{
-			testName: testName{
-				name:    res.Test,
-				subName: nil,
-			},
-			ResultID: re,
-		}
-
 		// Dedup subtests, warning when subtest names are duplicated.
 		subs := make(map[string]metrics.SubTest)
 		for _, sub := range res.Subtests {
@@ -223,6 +494,50 @@ func (i *shardedWPTIndex) IngestRun(r shared.TestRun) error {
 			subs[sub.Name] = sub
 		}
 
+		failingSubtest := false
+		passingSubtests := 0
+		subtestStatuses := make(map[ResultID]int)
+		for _, sub := range subs {
+			status := shared.TestStatusValueFromString(sub.Status)
+			if status != shared.TestStatusPass {
+				failingSubtest = true
+			} else {
+				passingSubtests++
+			}
+			subtestStatuses[ResultID(status)]++
+		}
+
+		shardIdx := int(t.testID % numShardsU64)
+		dataForShard := shardData[shardIdx]
+		re := ResultID(shared.TestStatusValueFromString(res.Status))
+		var message string
+		if res.Message != nil {
+			message = *res.Message
+		}
+		assertCount, ok := assertCounts[res.Test]
+		if !ok {
+			assertCount = -1
+		}
+		dataForShard[t] = testData{
+			testName: testName{
+				name:    res.Test,
+				subName: nil,
+			},
+			ResultID:        re,
+			screenshot:      screenshots[res.Test],
+			subtestTotal:    len(subs),
+			longTimeout:     longTimeouts[res.Test],
+			intermittent:    intermittent[res.Test],
+			failingSubtest:  failingSubtest,
+			assertCount:     assertCount,
+			passingSubtests: passingSubtests,
+			refComparison:   reftests[res.Test],
+			metadataFields:  metadataFields[res.Test],
+			expected:        expected[res.Test],
+			plurality:       plurality(subtestStatuses),
+			message:         message,
+		}
+
 		// Add each subtests' result to the appropriate shard (same shard as
 		// top-level test).
 		for i := range subs {
@@ -243,7 +558,20 @@ func (i *shardedWPTIndex) IngestRun(r shared.TestRun) error {
 		}
 	}
 
-	i.syncStoreRun(r, shardData)
+	// Disabled tests are recorded whether or not the run has results for them.
+	shardDisabled := make([]map[TestID]bool, numShards)
+	for j := 0; j < numShards; j++ {
+		shardDisabled[j] = make(map[TestID]bool)
+	}
+	for _, test := range disabled {
+		t, err := computeTestID(test, nil)
+		if err != nil {
+			return err
+		}
+		shardDisabled[int(t.testID%numShardsU64)][t] = true
+	}
+
+	i.syncStoreRun(r, shardData, shardDisabled)
 
 	return nil
 }
@@ -253,30 +581,59 @@ func (i *shardedWPTIndex) EvictRuns(percent float64) (int, error) {
 }
 
 func (i *shardedWPTIndex) Bind(runs []shared.TestRun, q query.ConcreteQuery) (query.Plan, error) {
+	plan, _, err := i.BindWithOpts(runs, q, query.BindOpts{})
+	return plan, err
+}
+
+func (i *shardedWPTIndex) BindWithOpts(runs []shared.TestRun, q query.ConcreteQuery, opts query.BindOpts) (query.Plan, []query.BindWarning, error) {
 	if len(runs) == 0 {
-		return nil, errNoRuns
+		return nil, nil, errNoRuns
 	} else if q == nil {
-		return nil, errNoQuery
+		return nil, nil, errNoQuery
+	}
+	warnings, err := checkLoaders(i.loader, q, nil)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	ids := make([]RunID, len(runs))
 	for j, run := range runs {
 		ids[j] = RunID(run.ID)
 	}
-	idxs, err := i.syncExtractRuns(ids)
+	idxs, missing, err := i.syncExtractRuns(ids, opts.AllowPartial)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if len(missing) == len(ids) {
+		return nil, nil, errNoRuns
 	}
 
+	warnings = append(warnings, missingRunWarnings(missing)...)
+
 	fs := make(ShardedFilter, len(idxs))
 	for j, idx := range idxs {
+		idx.boundRuns = runs
+		idx.maxResults = opts.MaxResults
 		f, err := newFilter(idx, q)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		fs[j] = f
 	}
-	return fs, nil
+	return fs, warnings, nil
+}
+
+// missingRunWarnings describes runs that were bound with no results because
+// they are unknown to the index.
+func missingRunWarnings(missing []RunID) []query.BindWarning {
+	var warnings []query.BindWarning
+	for _, id := range missing {
+		warnings = append(warnings, query.BindWarning{
+			Run:     int64(id),
+			Message: fmt.Sprintf("Run %v has no results in index", id),
+		})
+	}
+	return warnings
 }
 
 func (i *shardedWPTIndex) SetIngestChan(c chan bool) {
@@ -398,13 +755,13 @@ func (i *shardedWPTIndex) syncClearInProgress(run shared.TestRun) error {
 	return nil
 }
 
-func (i *shardedWPTIndex) syncStoreRun(run shared.TestRun, data []map[TestID]testData) error {
+func (i *shardedWPTIndex) syncStoreRun(run shared.TestRun, data []map[TestID]testData, disabled []map[TestID]bool) error {
 	i.m.Lock()
 	defer i.m.Unlock()
 
 	id := RunID(run.ID)
 	for j, shardData := range data {
-		if err := syncStoreRunOnShard(i.shards[j], id, shardData); err != nil {
+		if err := syncStoreRunOnShard(i.shards[j], id, shardData, disabled[j]); err != nil {
 			return err
 		}
 	}
@@ -414,15 +771,134 @@ func (i *shardedWPTIndex) syncStoreRun(run shared.TestRun, data []map[TestID]tes
 	return nil
 }
 
-func syncStoreRunOnShard(shard *wptIndex, id RunID, shardData map[TestID]testData) error {
+// plurality returns the status with a count greater than that of any other
+// status, or UNKNOWN when there is no such status (including when there are
+// no statuses).
+func plurality(counts map[ResultID]int) ResultID {
+	best, bestCount, tied := ResultID(shared.TestStatusUnknown), 0, false
+	for status, count := range counts {
+		if count > bestCount {
+			best, bestCount, tied = status, count, false
+		} else if count == bestCount {
+			tied = true
+		}
+	}
+	if tied {
+		return ResultID(shared.TestStatusUnknown)
+	}
+	return best
+}
+
+func syncStoreRunOnShard(shard *wptIndex, id RunID, shardData map[TestID]testData, disabled map[TestID]bool) error {
 	shard.m.Lock()
 	defer shard.m.Unlock()
 
 	runResults := NewRunResults()
+	screenshots := make(map[TestID]string)
+	subtestTotals := make(map[TestID]int)
+	longTimeouts := make(map[TestID]bool)
+	intermittent := make(map[TestID]bool)
+	assertCounts := make(map[TestID]int)
+	failingSubtests := make(map[TestID]bool)
+	passingSubtests := make(map[TestID]int)
+	reftests := make(map[TestID]bool)
+	metadataFields := make(map[TestID][]string)
+	expected := make(map[TestID][]ResultID)
+	pluralities := make(map[TestID]ResultID)
+	messages := make(map[TestID]string)
+	statusCounts := make(map[ResultID]int)
 	for t, data := range shardData {
+		if _, _, err := shard.tests.GetName(t); err != nil {
+			shard.numTests++
+		}
 		shard.tests.Add(t, data.testName.name, data.testName.subName)
 		runResults.Add(data.ResultID, t)
+		statusCounts[data.ResultID]++
+		if data.screenshot != "" {
+			screenshots[t] = data.screenshot
+		}
+		if data.testName.subName == nil {
+			subtestTotals[t] = data.subtestTotal
+		}
+		if data.longTimeout {
+			longTimeouts[t] = true
+		}
+		if data.intermittent {
+			intermittent[t] = true
+		}
+		if data.testName.subName == nil && data.assertCount >= 0 {
+			assertCounts[t] = data.assertCount
+		}
+		if data.failingSubtest {
+			failingSubtests[t] = true
+		}
+		if data.passingSubtests > 0 {
+			passingSubtests[t] = data.passingSubtests
+		}
+		switch data.refComparison {
+		case "==":
+			reftests[t] = false
+		case "!=":
+			reftests[t] = true
+		case "":
+		default:
+			log.Warningf("Unknown reftest comparison for %s: %s", data.testName.name, data.refComparison)
+		}
+		if len(data.metadataFields) > 0 {
+			metadataFields[t] = data.metadataFields
+		}
+		if len(data.expected) > 0 {
+			statuses := make([]ResultID, len(data.expected))
+			for j, status := range data.expected {
+				statuses[j] = ResultID(status)
+			}
+			expected[t] = statuses
+		}
+		if data.plurality != ResultID(shared.TestStatusUnknown) {
+			pluralities[t] = data.plurality
+		}
+		if data.message != "" {
+			messages[t] = data.message
+		}
+	}
+	if len(screenshots) > 0 {
+		shard.screenshots[id] = screenshots
+	}
+	shard.subtestTotals[id] = subtestTotals
+	if len(longTimeouts) > 0 {
+		shard.longTimeouts[id] = longTimeouts
+	}
+	if len(intermittent) > 0 {
+		shard.intermittent[id] = intermittent
 	}
+	if len(assertCounts) > 0 {
+		shard.assertCounts[id] = assertCounts
+	}
+	if len(disabled) > 0 {
+		shard.disabled[id] = disabled
+	}
+	if len(failingSubtests) > 0 {
+		shard.failingSubtests[id] = failingSubtests
+	}
+	if len(passingSubtests) > 0 {
+		shard.passingSubtests[id] = passingSubtests
+	}
+	if len(reftests) > 0 {
+		shard.reftests[id] = reftests
+	}
+	if len(metadataFields) > 0 {
+		shard.metadataFields[id] = metadataFields
+	}
+	if len(expected) > 0 {
+		shard.expected[id] = expected
+	}
+	if len(pluralities) > 0 {
+		shard.pluralities[id] = pluralities
+	}
+	if len(messages) > 0 {
+		shard.messages[id] = messages
+	}
+	shard.statusCounts[id] = statusCounts
 	return shard.results.Add(id, runResults)
 }
 
@@ -458,53 +934,169 @@ func syncDeleteResultsFromShard(shard *wptIndex, id RunID) error {
 	shard.m.Lock()
 	defer shard.m.Unlock()
 
+	delete(shard.screenshots, id)
+	delete(shard.subtestTotals, id)
+	delete(shard.longTimeouts, id)
+	delete(shard.intermittent, id)
+	delete(shard.assertCounts, id)
+	delete(shard.disabled, id)
+	delete(shard.failingSubtests, id)
+	delete(shard.passingSubtests, id)
+	delete(shard.reftests, id)
+	delete(shard.metadataFields, id)
+	delete(shard.expected, id)
+	delete(shard.pluralities, id)
+	delete(shard.messages, id)
+	delete(shard.statusCounts, id)
 	return shard.results.Delete(id)
 }
 
-func (i *shardedWPTIndex) syncExtractRuns(ids []RunID) ([]index, error) {
+func (i *shardedWPTIndex) syncExtractRuns(ids []RunID, allowPartial bool) ([]index, []RunID, error) {
 	i.m.RLock()
 	defer i.m.RUnlock()
 
 	idxs := make([]index, len(i.shards))
-	var err error
+	missingSet := mapset.NewSet()
+	missing := make([]RunID, 0)
 	for j, shard := range i.shards {
-		idxs[j], err = syncMakeIndex(shard, ids)
+		var shardMissing []RunID
+		var err error
+		idxs[j], shardMissing, err = syncMakeIndex(shard, ids, allowPartial)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		for _, id := range shardMissing {
+			if missingSet.Add(id) {
+				missing = append(missing, id)
+			}
 		}
 	}
 
 	for _, id := range ids {
-		i.lru.Access(int64(id))
+		if !missingSet.Contains(id) {
+			i.lru.Access(int64(id))
+		}
 	}
 
-	return idxs, nil
+	return idxs, missing, nil
 }
 
-func syncMakeIndex(shard *wptIndex, ids []RunID) (index, error) {
+func syncMakeIndex(shard *wptIndex, ids []RunID, allowPartial bool) (index, []RunID, error) {
 	shard.m.RLock()
 	defer shard.m.RUnlock()
 
 	tests := shard.tests
 	runResults := make(map[RunID]RunResults)
+	screenshots := make(map[RunID]map[TestID]string)
+	subtestTotals := make(map[RunID]map[TestID]int)
+	longTimeouts := make(map[RunID]map[TestID]bool)
+	intermittent := make(map[RunID]map[TestID]bool)
+	assertCounts := make(map[RunID]map[TestID]int)
+	disabled := make(map[RunID]map[TestID]bool)
+	failingSubtests := make(map[RunID]map[TestID]bool)
+	passingSubtests := make(map[RunID]map[TestID]int)
+	reftests := make(map[RunID]map[TestID]bool)
+	metadataFields := make(map[RunID]map[TestID][]string)
+	expected := make(map[RunID]map[TestID][]ResultID)
+	pluralities := make(map[RunID]map[TestID]ResultID)
+	messages := make(map[RunID]map[TestID]string)
+	statusCounts := make(map[RunID]map[ResultID]int)
+	var missing []RunID
 	for _, id := range ids {
 		rrs := shard.results.ForRun(id)
 		if rrs == nil {
-			return index{}, fmt.Errorf("Run is unknown to shard: RunID=%v", id)
+			if !allowPartial {
+				return index{}, nil, fmt.Errorf("Run is unknown to shard: RunID=%v", id)
+			}
+			// Treat results for unknown runs as missing.
+			missing = append(missing, id)
+			rrs = NewRunResults()
+		}
+		runResults[id] = rrs
+		if ss, ok := shard.screenshots[id]; ok {
+			screenshots[id] = ss
+		}
+		if sts, ok := shard.subtestTotals[id]; ok {
+			subtestTotals[id] = sts
+		}
+		if lts, ok := shard.longTimeouts[id]; ok {
+			longTimeouts[id] = lts
+		}
+		if its, ok := shard.intermittent[id]; ok {
+			intermittent[id] = its
+		}
+		if acs, ok := shard.assertCounts[id]; ok {
+			assertCounts[id] = acs
+		}
+		if ds, ok := shard.disabled[id]; ok {
+			disabled[id] = ds
+		}
+		if fss, ok := shard.failingSubtests[id]; ok {
+			failingSubtests[id] = fss
+		}
+		if pss, ok := shard.passingSubtests[id]; ok {
+			passingSubtests[id] = pss
+		}
+		if rts, ok := shard.reftests[id]; ok {
+			reftests[id] = rts
+		}
+		if mfs, ok := shard.metadataFields[id]; ok {
+			metadataFields[id] = mfs
+		}
+		if es, ok := shard.expected[id]; ok {
+			expected[id] = es
+		}
+		if ps, ok := shard.pluralities[id]; ok {
+			pluralities[id] = ps
+		}
+		if ms, ok := shard.messages[id]; ok {
+			messages[id] = ms
+		}
+		if scs, ok := shard.statusCounts[id]; ok {
+			statusCounts[id] = scs
 		}
-		runResults[id] = shard.results.ForRun(id)
 	}
 	return index{
-		tests:      tests,
-		runResults: runResults,
-		m:          shard.m,
-	}, nil
+		tests:           tests,
+		runResults:      runResults,
+		screenshots:     screenshots,
+		subtestTotals:   subtestTotals,
+		longTimeouts:    longTimeouts,
+		intermittent:    intermittent,
+		assertCounts:    assertCounts,
+		disabled:        disabled,
+		failingSubtests: failingSubtests,
+		passingSubtests: passingSubtests,
+		reftests:        reftests,
+		metadataFields:  metadataFields,
+		expected:        expected,
+		pluralities:     pluralities,
+		messages:        messages,
+		statusCounts:    statusCounts,
+		numTests:        shard.numTests,
+		runs:            ids,
+		m:               shard.m,
+	}, missing, nil
 }
 
 func newWPTIndex(tests Tests) *wptIndex {
 	return &wptIndex{
-		tests:   tests,
-		results: NewResults(),
-		m:       &sync.RWMutex{},
+		tests:           tests,
+		results:         NewResults(),
+		screenshots:     make(map[RunID]map[TestID]string),
+		subtestTotals:   make(map[RunID]map[TestID]int),
+		longTimeouts:    make(map[RunID]map[TestID]bool),
+		intermittent:    make(map[RunID]map[TestID]bool),
+		assertCounts:    make(map[RunID]map[TestID]int),
+		disabled:        make(map[RunID]map[TestID]bool),
+		failingSubtests: make(map[RunID]map[TestID]bool),
+		passingSubtests: make(map[RunID]map[TestID]int),
+		reftests:        make(map[RunID]map[TestID]bool),
+		metadataFields:  make(map[RunID]map[TestID][]string),
+		expected:        make(map[RunID]map[TestID][]ResultID),
+		pluralities:     make(map[RunID]map[TestID]ResultID),
+		messages:        make(map[RunID]map[TestID]string),
+		statusCounts:    make(map[RunID]map[ResultID]int),
+		m:               &sync.RWMutex{},
 	}
 }
@@ -7,8 +7,14 @@
 package index
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/golang/mock/gomock"
@@ -186,6 +192,384 @@ func TestBindExecute_TestPath(t *testing.T) {
 	assert.Equal(t, expectedResult, srs[0])
 }
 
+func TestBindExecute_TestNameIn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/b.html",
+						Status: "PASS",
+					},
+					&metrics.TestResults{
+						Test:   "/a/b.html.ini",
+						Status: "PASS",
+					},
+					&metrics.TestResults{
+						Test:   "/a/c.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "/a/b.html", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/d.html",
+						Status: "FAIL",
+					},
+				},
+			},
+		},
+	})
+
+	// Only exact test names match; prefixes and subtest names do not.
+	srs := planAndExecute(t, runs, idx, query.TestNameIn{Names: []string{"/a/b.html", "/d.html", "/e.html"}})
+	assert.True(t, resultSet(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/b.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+		query.SearchResult{
+			Test: "/d.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+			},
+		},
+	}).Equal(resultSet(t, srs)))
+}
+
+func TestBindExecute_TestNameInLarge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	numTests := 2000
+	results := make([]*metrics.TestResults, numTests)
+	for i := range results {
+		results[i] = &metrics.TestResults{
+			Test:   fmt.Sprintf("/test/%d.html", i),
+			Status: "PASS",
+		}
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, &metrics.TestResultsReport{Results: results}},
+	})
+
+	// Every third test, and some names that are not in the index.
+	var names []string
+	expected := make(map[string]bool)
+	for i := 0; i < numTests+300; i += 3 {
+		name := fmt.Sprintf("/test/%d.html", i)
+		names = append(names, name)
+		if i < numTests {
+			expected[name] = true
+		}
+	}
+
+	srs := planAndExecute(t, runs, idx, query.TestNameIn{Names: names})
+	assert.Equal(t, len(expected), len(srs))
+	for _, sr := range srs {
+		assert.True(t, expected[sr.Test], "Unexpected test %s", sr.Test)
+	}
+}
+
+func TestBindExecute_ManifestPaths(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/css/a.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/a.html.ini", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/grid/b.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/dom/c.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/dom-parsing/d.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/css/a.html", "/dom/c.html"}, testNames(query.ManifestPaths{
+		Paths: []string{"/css/a.html", "/dom/c.html", "/css/grid/", "/missing.html"},
+	}))
+	assert.Equal(t, []string{"/css/a.html", "/css/a.html.ini", "/css/grid/b.html", "/dom/c.html"}, testNames(query.ManifestPaths{
+		Paths:  []string{"/dom/", "/css/a.html", "/css/grid/", "/css/"},
+		Prefix: true,
+	}))
+	assert.Equal(t, []string{"/dom-parsing/d.html", "/dom/c.html"}, testNames(query.ManifestPaths{
+		Paths:  []string{"/dom"},
+		Prefix: true,
+	}))
+}
+
+func TestBindExecute_Shard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{}
+	for i := 0; i < 100; i++ {
+		results.Results = append(results.Results, &metrics.TestResults{
+			Test:   fmt.Sprintf("/dir%d/%d.html", i%7, i),
+			Status: "OK",
+			Subtests: []metrics.SubTest{
+				metrics.SubTest{Name: "sub", Status: "PASS"},
+			},
+		})
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+	})
+
+	// The shards partition the tests, and subtests match along with their test.
+	const total = 4
+	seen := make(map[string]bool)
+	for index := 0; index < total; index++ {
+		q := query.Shard{Index: index, Total: total}
+		srs := planAndExecute(t, runs, idx, q)
+		assert.True(t, len(srs) > 0, "Empty shard %d", index)
+		for _, sr := range srs {
+			assert.False(t, seen[sr.Test], "%s is in more than one shard", sr.Test)
+			seen[sr.Test] = true
+			assert.True(t, q.Matches(sr.Test))
+			assert.Equal(t, 2, sr.LegacyStatus[0].Total)
+		}
+	}
+	assert.Equal(t, len(results.Results), len(seen))
+}
+
+func TestBindExecute_ManifestPathsLarge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	numDirs, testsPerDir := 100, 20
+	var results []*metrics.TestResults
+	for d := 0; d < numDirs; d++ {
+		for i := 0; i < testsPerDir; i++ {
+			results = append(results, &metrics.TestResults{
+				Test:   fmt.Sprintf("/dir%d/%d.html", d, i),
+				Status: "PASS",
+			})
+		}
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, &metrics.TestResultsReport{Results: results}},
+	})
+
+	// Every other test of every third directory, and some paths that are not in
+	// the index.
+	var paths []string
+	expected := make(map[string]bool)
+	for d := 0; d < numDirs+30; d += 3 {
+		for i := 0; i < testsPerDir; i += 2 {
+			path := fmt.Sprintf("/dir%d/%d.html", d, i)
+			paths = append(paths, path)
+			if d < numDirs {
+				expected[path] = true
+			}
+		}
+	}
+	srs := planAndExecute(t, runs, idx, query.ManifestPaths{Paths: paths})
+	assert.Equal(t, len(expected), len(srs))
+	for _, sr := range srs {
+		assert.True(t, expected[sr.Test], "Unexpected test %s", sr.Test)
+	}
+
+	// Every third directory, as prefixes, along with redundant prefixes of
+	// tests within them.
+	paths = nil
+	expected = make(map[string]bool)
+	for d := 0; d < numDirs+30; d += 3 {
+		paths = append(paths, fmt.Sprintf("/dir%d/", d), fmt.Sprintf("/dir%d/1", d))
+		if d < numDirs {
+			for i := 0; i < testsPerDir; i++ {
+				expected[fmt.Sprintf("/dir%d/%d.html", d, i)] = true
+			}
+		}
+	}
+	srs = planAndExecute(t, runs, idx, query.ManifestPaths{Paths: paths, Prefix: true})
+	assert.Equal(t, len(expected), len(srs))
+	for _, sr := range srs {
+		assert.True(t, expected[sr.Test], "Unexpected test %s", sr.Test)
+	}
+}
+
+func TestBindExecute_Subtest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/exact.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "foo", Status: "PASS"},
+							metrics.SubTest{Name: "foo bar", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/similar.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "foobar", Status: "PASS"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/foo.html",
+						Status: "OK",
+					},
+				},
+			},
+		},
+	})
+
+	srs := planAndExecute(t, runs, idx, query.Subtest{Name: "foo"})
+	assert.Equal(t, 2, len(srs))
+	assert.True(t, resultSet(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/exact.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 2},
+			},
+		},
+		query.SearchResult{
+			Test: "/a/similar.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+	}).Equal(resultSet(t, srs)))
+
+	srs = planAndExecute(t, runs, idx, query.Subtest{Name: "foo", Exact: true})
+	assert.Equal(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/exact.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				// Only the subtest named exactly "foo".
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+	}, srs)
+
+	srs = planAndExecute(t, runs, idx, query.Subtest{Name: "fo", Exact: true})
+	assert.Equal(t, 0, len(srs))
+}
+
+func TestBindExecute_FocusArea(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/css/css-flexbox/a.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/css-grid/b.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/css-grid-2/c.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/dom/css-flexbox/d.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	area := query.FocusArea{
+		Area:  "layout",
+		Paths: []string{"/css/css-flexbox/", "/css/css-grid/"},
+	}
+	srs := planAndExecute(t, runs, idx, area)
+	assert.True(t, resultSet(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/css/css-flexbox/a.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+		query.SearchResult{
+			Test: "/css/css-grid/b.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+	}).Equal(resultSet(t, srs)))
+
+	srs = planAndExecute(t, runs, idx, query.FocusArea{Area: "none"})
+	assert.Equal(t, 0, len(srs))
+}
+
+func TestBindExecute_InteropYear(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/css/css-flexbox/a.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/css-transforms/b.html", Status: "FAIL"},
+					&metrics.TestResults{Test: "/css/css-grid-2/c.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/dom/d.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	parsed, err := query.Parse([]byte(`{"run_ids": [1], "query": {"interop_year": 2021}}`))
+	assert.Nil(t, err)
+	srs := planAndExecute(t, runs, idx, parsed.RunQuery.AbstractQuery)
+	names := make([]string, len(srs))
+	for i, sr := range srs {
+		names[i] = sr.Test
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"/css/css-flexbox/a.html", "/css/css-transforms/b.html"}, names)
+}
+
 func TestBindExecute_TestStatus(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -332,3 +716,2623 @@ func TestBindExecute_TestStatus(t *testing.T) {
 		},
 	}), resultSet(t, srs))
 }
+
+func TestBindExecute_RunTestStatusIn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/fail",
+						Status: "FAIL",
+					},
+					&metrics.TestResults{
+						Test:   "/b/error",
+						Status: "ERROR",
+					},
+					&metrics.TestResults{
+						Test:   "/c/pass",
+						Status: "PASS",
+					},
+				},
+			},
+		},
+	})
+
+	q := query.RunTestStatusIn{
+		Run:      1,
+		Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusError},
+	}
+	plan, err := idx.Bind(runs, q)
+	assert.Nil(t, err)
+	srs, ok := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+	assert.True(t, ok)
+
+	assert.Equal(t, resultSet(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/fail",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+			},
+		},
+		query.SearchResult{
+			Test: "/b/error",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+			},
+		},
+	}), resultSet(t, srs))
+}
+
+func TestBindWithOpts_Partial(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	matchingTestName := "/a/b/c"
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   matchingTestName,
+						Status: "FAIL",
+					},
+					&metrics.TestResults{
+						Test:   "/d/e/f",
+						Status: "PASS",
+					},
+				},
+			},
+		},
+	})
+	// Run ID=2 was never ingested.
+	allRuns := append(runs, shared.TestRun{ID: 2})
+	q := query.TestStatusEq{Status: shared.TestStatusFail}.BindToRuns(allRuns...)
+
+	pb, ok := idx.(query.PartialBinder)
+	assert.True(t, ok)
+
+	_, _, err = pb.BindWithOpts(allRuns, q, query.BindOpts{})
+	assert.NotNil(t, err)
+
+	plan, warnings, err := pb.BindWithOpts(allRuns, q, query.BindOpts{AllowPartial: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(warnings))
+	assert.Equal(t, int64(2), warnings[0].Run)
+
+	srs, ok := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+	assert.True(t, ok)
+	assert.Equal(t, []query.SearchResult{
+		query.SearchResult{
+			Test: matchingTestName,
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{
+					Passes: 0,
+					Total:  1,
+				},
+			},
+		},
+	}, srs)
+
+	// Unresolved runs are bound with no results, so still have a column.
+	srs, ok = plan.Execute(allRuns, query.AggregationOpts{}).([]query.SearchResult)
+	assert.True(t, ok)
+	assert.Equal(t, []query.SearchResult{
+		query.SearchResult{
+			Test: matchingTestName,
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{
+					Passes: 0,
+					Total:  1,
+				},
+				query.LegacySearchRunResult{},
+			},
+		},
+	}, srs)
+}
+
+func TestBindWithOpts_PartialNoneResolved(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := []shared.TestRun{shared.TestRun{ID: 1}, shared.TestRun{ID: 2}}
+	pb, ok := idx.(query.PartialBinder)
+	assert.True(t, ok)
+	_, _, err = pb.BindWithOpts(runs, query.TestNamePattern{Pattern: "/"}, query.BindOpts{AllowPartial: true})
+	assert.NotNil(t, err)
+}
+
+func TestBindExecute_ExistsSubtest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "foo", Status: "PASS"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/b.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "bar", Status: "FAIL"},
+						},
+					},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "foo", Status: "FAIL"},
+						},
+					},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 3},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/c.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "baz", Status: "FAIL"},
+						},
+					},
+				},
+			},
+		},
+	}
+	for i := range data {
+		data[i].run.BrowserName = "chrome"
+	}
+	runs := mockTestRuns(loader, idx, data)[:2]
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+	failing := func(name string) query.AbstractQuery {
+		return query.AbstractExists{
+			Args: []query.AbstractQuery{
+				query.AbstractAnd{
+					Args: []query.AbstractQuery{
+						query.Subtest{Name: name, Exact: true},
+						query.TestStatusEq{Status: shared.TestStatusFail},
+					},
+				},
+			},
+		}
+	}
+
+	// Only run 2 has a failing "foo" subtest.
+	assert.Equal(t, []string{"/a.html"}, testNames(failing("foo")))
+	assert.Equal(t, []string{"/b.html"}, testNames(failing("bar")))
+	// Run 3, which has a "baz" subtest, is not among the bound runs.
+	assert.Equal(t, []string{}, testNames(failing("baz")))
+	assert.Equal(t, []string{}, testNames(query.AbstractExists{
+		Args: []query.AbstractQuery{query.Subtest{Name: "baz"}},
+	}))
+	assert.Equal(t, []string{"/b.html"}, testNames(query.AbstractExists{
+		Args: []query.AbstractQuery{query.Subtest{Name: "bar"}},
+	}))
+}
+
+func TestBindExecute_AnyStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/crash",
+						Status: "CRASH",
+					},
+					&metrics.TestResults{
+						Test:   "/b/crash",
+						Status: "PASS",
+					},
+					&metrics.TestResults{
+						Test:   "/c/pass",
+						Status: "PASS",
+					},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/crash",
+						Status: "CRASH",
+					},
+					&metrics.TestResults{
+						Test:   "/b/crash",
+						Status: "CRASH",
+					},
+					&metrics.TestResults{
+						Test:   "/c/pass",
+						Status: "PASS",
+					},
+				},
+			},
+		},
+	})
+
+	q := query.AnyStatus{Status: shared.TestStatusCrash}
+	srs := planAndExecute(t, runs, idx, q)
+
+	assert.Equal(t, resultSet(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/crash",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+			},
+		},
+		query.SearchResult{
+			Test: "/b/crash",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+				query.LegacySearchRunResult{Passes: 0, Total: 1},
+			},
+		},
+	}), resultSet(t, srs))
+}
+
+type screenshotLoader struct {
+	*MockReportLoader
+
+	screenshots map[int64]map[string]string
+}
+
+func (l screenshotLoader) LoadScreenshots(run shared.TestRun) (map[string]string, error) {
+	return l.screenshots[run.ID], nil
+}
+
+func TestBindExecute_HasScreenshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := screenshotLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string]string{
+			1: map[string]string{"/a/ref.html": "sha1:000"},
+			// Run ID=2 has screenshots for a different test.
+			2: map[string]string{"/b/ref.html": "sha1:111"},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{
+				Test:   "/a/ref.html",
+				Status: "FAIL",
+			},
+			&metrics.TestResults{
+				Test:   "/b/ref.html",
+				Status: "PASS",
+			},
+		},
+	}
+	data := []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	srs := planAndExecute(t, runs, idx, query.HasScreenshot{BrowserName: "chrome"})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/ref.html", srs[0].Test)
+
+	srs = planAndExecute(t, runs, idx, query.HasScreenshot{BrowserName: "firefox"})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/b/ref.html", srs[0].Test)
+}
+
+func TestBindExecute_SameRender(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := screenshotLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string]string{
+			1: map[string]string{
+				"/a/identical.html":    "sha1:000",
+				"/a/different.html":    "sha1:111",
+				"/a/chrome-only.html":  "sha1:222",
+				"/a/with-subtest.html": "sha1:333",
+			},
+			2: map[string]string{
+				"/a/identical.html":     "sha1:000",
+				"/a/different.html":     "sha1:999",
+				"/a/firefox-only.html":  "sha1:222",
+				"/a/with-subtest.html":  "sha1:333",
+				"/a/no-chrome-run.html": "sha1:444",
+			},
+			3: map[string]string{
+				"/a/no-chrome-run.html": "sha1:444",
+			},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{}
+	for _, test := range []string{"/a/identical.html", "/a/different.html", "/a/chrome-only.html", "/a/firefox-only.html", "/a/no-screenshot.html", "/a/no-chrome-run.html"} {
+		results.Results = append(results.Results, &metrics.TestResults{Test: test, Status: "PASS"})
+	}
+	results.Results = append(results.Results, &metrics.TestResults{
+		Test:     "/a/with-subtest.html",
+		Status:   "OK",
+		Subtests: []metrics.SubTest{metrics.SubTest{Name: "sub", Status: "PASS"}},
+	})
+	browsers := []string{"chrome", "firefox", "firefox"}
+	data := make([]testRunData, len(browsers))
+	for i, browser := range browsers {
+		data[i] = testRunData{shared.TestRun{ID: int64(i + 1)}, results}
+		data[i].run.BrowserName = browser
+	}
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	srs := planAndExecute(t, runs, idx, query.SameRender{LeftBrowser: "chrome", RightBrowser: "firefox"})
+	names := make([]string, len(srs))
+	for i, sr := range srs {
+		names[i] = sr.Test
+	}
+	sort.Strings(names)
+	// Differing hashes, and tests without a screenshot in either run, do not
+	// match; only the first firefox run is compared.
+	assert.Equal(t, []string{"/a/identical.html", "/a/with-subtest.html"}, names)
+
+	// Both firefox runs share the screenshot of /a/no-chrome-run.html.
+	srs = planAndExecute(t, runs, idx, query.SameRender{LeftBrowser: "firefox", RightBrowser: "firefox"})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/no-chrome-run.html", srs[0].Test)
+}
+
+func TestBind_OptionalLoadersAbsent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// A plain ReportLoader provides no screenshot metadata.
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/ref.html",
+						Status: "FAIL",
+					},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	runs := mockTestRuns(loader, idx, data)
+
+	// Rather than silently matching nothing, binding fails.
+	q := query.HasScreenshot{BrowserName: "chrome"}.BindToRuns(runs...)
+	_, err = idx.Bind(runs, q)
+	assert.NotNil(t, err)
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	_, err = bb.Bind(runs, q)
+	assert.NotNil(t, err)
+
+	// As do other atoms over data that the loader does not provide.
+	for _, q := range []query.ConcreteQuery{
+		query.AnyRunLongTimeout{Runs: []int64{1}},
+		query.RunRefMatch{Run: 1, Expected: "PASS"},
+		query.AnyRunHasMetadataField{Runs: []int64{1}, Field: "label"},
+		query.RunIntermittent{Run: 1},
+		query.Not{Arg: query.RunsSameRender{Left: 1, Right: 1}},
+		query.RunAssertCount{Run: 1, Min: 1, Max: -1},
+	} {
+		_, err = idx.Bind(runs, q)
+		assert.NotNil(t, err, "%v", q)
+	}
+
+	// Atoms that fall back to run results bind with a warning per run.
+	pb, ok := idx.(query.PartialBinder)
+	assert.True(t, ok)
+	for _, q := range []query.AbstractQuery{
+		query.Skipped{BrowserName: "chrome"},
+		query.Unexpected{BrowserName: "chrome"},
+	} {
+		_, warnings, err := pb.BindWithOpts(runs, q.BindToRuns(runs...), query.BindOpts{})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(warnings))
+		assert.Equal(t, int64(1), warnings[0].Run)
+	}
+}
+
+func TestBindExecute_SubtestTotal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	subtests := func(n int) []metrics.SubTest {
+		subs := make([]metrics.SubTest, n)
+		for i := range subs {
+			subs[i] = metrics.SubTest{Name: fmt.Sprintf("sub%d", i), Status: "PASS"}
+		}
+		return subs
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:     "/a/b.html",
+						Status:   "OK",
+						Subtests: subtests(2),
+					},
+					&metrics.TestResults{
+						Test:   "/a/ref.html",
+						Status: "PASS",
+					},
+					&metrics.TestResults{
+						Test:     "/c/d.html",
+						Status:   "OK",
+						Subtests: subtests(3),
+					},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:     "/a/b.html",
+						Status:   "OK",
+						Subtests: subtests(3),
+					},
+				},
+			},
+		},
+	})
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/a/b.html", "/c/d.html"}, testNames(query.SubtestTotal{Min: 3, Max: -1}))
+	assert.Equal(t, []string{"/a/b.html"}, testNames(query.SubtestTotal{Min: 2, Max: 2}))
+	assert.Equal(t, []string{"/a/ref.html"}, testNames(query.SubtestTotal{Min: 0, Max: 0}))
+	assert.Equal(t, []string{}, testNames(query.SubtestTotal{Min: 4, Max: -1}))
+}
+
+func TestExecuteWithStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/b.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "first", Status: "PASS"},
+							metrics.SubTest{Name: "second", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/c/d.html",
+						Status: "PASS",
+					},
+				},
+			},
+		},
+	})
+
+	q := query.And{
+		Args: []query.ConcreteQuery{
+			query.TestNamePattern{Pattern: "/a/"},
+			query.RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+		},
+	}
+	plan, err := idx.Bind(runs, q)
+	assert.Nil(t, err)
+	statsPlan, ok := plan.(query.StatsPlan)
+	assert.True(t, ok)
+
+	res, stats := statsPlan.ExecuteWithStats(runs, query.AggregationOpts{})
+	srs, ok := res.([]query.SearchResult)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/b.html", srs[0].Test)
+
+	// Four tests/subtests; the pattern is evaluated for all of them, and the
+	// status only for the three under /a/, of which one passes.
+	assert.Equal(t, 4, stats.TestsScanned)
+	assert.Equal(t, 1, stats.TestsMatched)
+	assert.Equal(t, 7, stats.AtomsEvaluated)
+	assert.True(t, stats.WallTime > 0)
+
+	// Plain execution is unaffected.
+	assert.Equal(t, resultSet(t, srs), resultSet(t, plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)))
+}
+
+func TestExecute_CountOnly(t *testing.T) {
+	idx, runs := generatedIndex(t, 3, 500)
+	for _, opts := range []query.AggregationOpts{
+		query.AggregationOpts{},
+		query.AggregationOpts{IgnoreTestHarnessResult: true},
+	} {
+		for _, aq := range bitsetTestQueries() {
+			q := aq.BindToRuns(runs...)
+			plan, err := idx.Bind(runs, q)
+			assert.Nil(t, err)
+			srs := plan.Execute(runs, opts).([]query.SearchResult)
+
+			countOpts := opts
+			countOpts.CountOnly = true
+			count, ok := plan.Execute(runs, countOpts).(int)
+			assert.True(t, ok)
+			assert.Equal(t, len(srs), count, "Query: %#v", q)
+
+			res, _ := plan.(query.StatsPlan).ExecuteWithStats(runs, countOpts)
+			assert.Equal(t, len(srs), res)
+		}
+	}
+}
+
+func TestExecute_IncludeStatuses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/everywhere.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/first.html", Status: "FAIL"},
+					&metrics.TestResults{
+						Test:     "/a/subtests.html",
+						Status:   "OK",
+						Subtests: []metrics.SubTest{metrics.SubTest{Name: "sub", Status: "FAIL"}},
+					},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/everywhere.html", Status: "TIMEOUT"},
+					&metrics.TestResults{Test: "/a/second.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/unknown.html", Status: "UNKNOWN"},
+				},
+			},
+		},
+	})
+
+	plan, err := idx.Bind(runs, query.TestNamePattern{Pattern: "/a/"})
+	assert.Nil(t, err)
+	srs := plan.Execute(runs, query.AggregationOpts{IncludeStatuses: true}).([]query.SearchResult)
+	statuses := make(map[string][]string)
+	for _, sr := range srs {
+		statuses[sr.Test] = sr.Statuses
+	}
+	assert.Equal(t, map[string][]string{
+		"/a/everywhere.html": []string{"PASS", "TIMEOUT"},
+		"/a/first.html":      []string{"FAIL", query.StatusMissing},
+		"/a/second.html":     []string{query.StatusMissing, "PASS"},
+		// The statuses of the test itself, not of its subtests.
+		"/a/subtests.html": []string{"OK", query.StatusMissing},
+		// An explicit UNKNOWN result is not missing.
+		"/a/unknown.html": []string{query.StatusMissing, "UNKNOWN"},
+	}, statuses)
+
+	// A test matched by a subtest only still has its own statuses.
+	plan, err = idx.Bind(runs, query.Subtest{Name: "sub"})
+	assert.Nil(t, err)
+	srs = plan.Execute(runs, query.AggregationOpts{IncludeStatuses: true}).([]query.SearchResult)
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, []string{"OK", query.StatusMissing}, srs[0].Statuses)
+	// Missing results are otherwise zero-valued entries.
+	assert.Equal(t, []query.LegacySearchRunResult{{Passes: 0, Total: 1}, {}}, srs[0].LegacyStatus)
+
+	// Statuses are omitted by default.
+	srs = plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+	assert.Nil(t, srs[0].Statuses)
+}
+
+func TestExecute_maxResults(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 500)
+	q := query.TestNamePattern{Pattern: "dir1"}.BindToRuns(runs...)
+	plan, err := idx.Bind(runs, q)
+	assert.Nil(t, err)
+	all := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+	assert.True(t, len(all) > 10)
+	byTest := make(map[string]query.SearchResult)
+	for _, sr := range all {
+		byTest[sr.Test] = sr
+	}
+
+	pb := idx.(query.PartialBinder)
+	capped, _, err := pb.BindWithOpts(runs, q, query.BindOpts{MaxResults: 10})
+	assert.Nil(t, err)
+	res, truncated := capped.(query.TruncatingPlan).ExecuteWithTruncation(runs, query.AggregationOpts{})
+	assert.True(t, truncated)
+	srs := res.([]query.SearchResult)
+	assert.Equal(t, 10, len(srs))
+	for _, sr := range srs {
+		// Collected results are fully aggregated.
+		assert.Equal(t, byTest[sr.Test], sr)
+	}
+	assert.Equal(t, 10, len(capped.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)))
+	// Counts are not capped.
+	assert.Equal(t, len(all), capped.Execute(runs, query.AggregationOpts{CountOnly: true}))
+
+	var buf bytes.Buffer
+	assert.Nil(t, capped.(ShardedFilter).WriteResults(&buf, runs))
+	assert.Equal(t, 10, bytes.Count(buf.Bytes(), []byte("\n")))
+
+	fits, _, err := pb.BindWithOpts(runs, q, query.BindOpts{MaxResults: len(all)})
+	assert.Nil(t, err)
+	res, truncated = fits.(query.TruncatingPlan).ExecuteWithTruncation(runs, query.AggregationOpts{})
+	assert.False(t, truncated)
+	assert.True(t, resultSet(t, all).Equal(resultSet(t, res.([]query.SearchResult))))
+}
+
+func TestShardedFilter_Cost(t *testing.T) {
+	// 100 tests in a single run, of which 10 fail.
+	report := &metrics.TestResultsReport{}
+	for i := 0; i < 100; i++ {
+		status := "PASS"
+		if i%10 == 0 {
+			status = "FAIL"
+		}
+		report.Results = append(report.Results, &metrics.TestResults{
+			Test:   fmt.Sprintf("/a/%d.html", i),
+			Status: status,
+		})
+	}
+	idx, err := NewShardedWPTIndex(staticReportLoader{1: report}, testNumShards)
+	assert.Nil(t, err)
+	runs := []shared.TestRun{shared.TestRun{ID: 1}}
+	assert.Nil(t, idx.IngestRun(runs[0]))
+
+	cost := func(q query.ConcreteQuery) int {
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		return plan.Cost()
+	}
+	pass := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusPass}
+	fail := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	pattern := query.TestNamePattern{Pattern: "/a/"}
+
+	// A single atom is evaluated once per test scanned, as counted by
+	// ExecuteWithStats.
+	plan, err := idx.Bind(runs, pass)
+	assert.Nil(t, err)
+	_, stats := plan.(query.StatsPlan).ExecuteWithStats(runs, query.AggregationOpts{})
+	assert.Equal(t, stats.TestsScanned+stats.AtomsEvaluated, plan.Cost())
+	assert.Equal(t, 200, plan.Cost())
+
+	// The pattern is evaluated only for tests matching the first conjunct, and
+	// only for tests not matching the first disjunct.
+	assert.Equal(t, 210, cost(query.And{Args: []query.ConcreteQuery{fail, pattern}}))
+	assert.Equal(t, 290, cost(query.And{Args: []query.ConcreteQuery{pass, pattern}}))
+	assert.Equal(t, 210, cost(query.Or{Args: []query.ConcreteQuery{pass, pattern}}))
+	assert.Equal(t, 290, cost(query.Or{Args: []query.ConcreteQuery{fail, pattern}}))
+	assert.Equal(t, 290, cost(query.Not{Arg: query.Or{Args: []query.ConcreteQuery{fail, pattern}}}))
+
+	// Missing results are UNKNOWN.
+	unknown := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusUnknown}
+	assert.Equal(t, 300, cost(query.Or{Args: []query.ConcreteQuery{unknown, pattern}}))
+}
+
+func TestEstimateMatches(t *testing.T) {
+	// 100 tests in each of two runs, of which 10 fail in the first run, and 50
+	// in the second.
+	reports := make(staticReportLoader)
+	for run := int64(1); run <= 2; run++ {
+		report := &metrics.TestResultsReport{}
+		for i := 0; i < 100; i++ {
+			status := "PASS"
+			if i%10 == 0 || (run == 2 && i%2 == 0) {
+				status = "FAIL"
+			}
+			report.Results = append(report.Results, &metrics.TestResults{
+				Test:   fmt.Sprintf("/a/%d.html", i),
+				Status: status,
+			})
+		}
+		reports[run] = report
+	}
+	runs := []shared.TestRun{shared.TestRun{ID: 1}, shared.TestRun{ID: 2}}
+	newEstimator := func(numShards int) query.MatchEstimator {
+		idx, err := NewShardedWPTIndex(reports, numShards)
+		assert.Nil(t, err)
+		for _, run := range runs {
+			assert.Nil(t, idx.IngestRun(run))
+		}
+		estimator, ok := idx.(query.MatchEstimator)
+		assert.True(t, ok)
+		return estimator
+	}
+	estimator := newEstimator(testNumShards)
+
+	fail1 := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	fail2 := query.RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}
+	pattern := query.TestNamePattern{Pattern: "/a/"}
+
+	// Single status atoms are estimated from exact counts.
+	assert.Equal(t, 10, estimator.EstimateMatches(fail1, runs))
+	assert.Equal(t, 50, estimator.EstimateMatches(fail2, runs))
+	assert.Equal(t, 90, estimator.EstimateMatches(query.Not{Arg: fail1}, runs))
+	// Atoms are assumed to be independent, although here every failure in the
+	// first run is also a failure in the second.
+	assert.Equal(t, 5, estimator.EstimateMatches(query.And{Args: []query.ConcreteQuery{fail1, fail2}}, runs))
+	assert.Equal(t, 55, estimator.EstimateMatches(query.Or{Args: []query.ConcreteQuery{fail1, fail2}}, runs))
+	// Estimates do not depend on how tests are sharded.
+	single := newEstimator(1)
+	assert.Equal(t, 5, single.EstimateMatches(query.And{Args: []query.ConcreteQuery{fail1, fail2}}, runs))
+	assert.Equal(t, 55, single.EstimateMatches(query.Or{Args: []query.ConcreteQuery{fail1, fail2}}, runs))
+	// Other atoms match a fixed fraction of tests.
+	assert.Equal(t, 50, estimator.EstimateMatches(pattern, runs))
+	// Unknown runs have no results.
+	unknown := query.RunTestStatusEq{Run: 3, Status: shared.TestStatusUnknown}
+	assert.Equal(t, 100, estimator.EstimateMatches(unknown, append(runs, shared.TestRun{ID: 3})))
+	assert.Equal(t, 0, estimator.EstimateMatches(nil, runs))
+}
+
+func TestCostBreakdown(t *testing.T) {
+	// 100 tests in each of two runs, of which 25 fail in the first run, and 50
+	// in the second. A single shard keeps estimates exact.
+	reports := make(staticReportLoader)
+	for run := int64(1); run <= 2; run++ {
+		report := &metrics.TestResultsReport{}
+		for i := 0; i < 100; i++ {
+			status := "PASS"
+			if (run == 1 && i%4 == 0) || (run == 2 && i%2 == 0) {
+				status = "FAIL"
+			}
+			report.Results = append(report.Results, &metrics.TestResults{
+				Test:   fmt.Sprintf("/a/%d.html", i),
+				Status: status,
+			})
+		}
+		reports[run] = report
+	}
+	idx, err := NewShardedWPTIndex(reports, 1)
+	assert.Nil(t, err)
+	runs := []shared.TestRun{shared.TestRun{ID: 1}, shared.TestRun{ID: 2}}
+	for _, run := range runs {
+		assert.Nil(t, idx.IngestRun(run))
+	}
+	explainer, ok := idx.(query.CostExplainer)
+	assert.True(t, ok)
+
+	fail1 := query.RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	fail2 := query.RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}
+	q := query.And{
+		Args: []query.ConcreteQuery{
+			query.Or{Args: []query.ConcreteQuery{fail1, fail2}},
+			query.Not{Arg: query.Count{Count: 2, Args: []query.ConcreteQuery{fail1, fail2}}},
+			query.TestNamePattern{Pattern: "/a/"},
+		},
+	}
+	// Arguments of And are evaluated only for tests matching the preceding
+	// arguments, and those of Or only for tests matching none of them.
+	assert.Equal(t, []query.NodeCost{
+		query.NodeCost{Type: "And", Depth: 0, InputRows: 100, OutputRows: 27},
+		query.NodeCost{Type: "Or", Depth: 1, InputRows: 100, OutputRows: 63},
+		query.NodeCost{Type: "RunTestStatusEq", Depth: 2, InputRows: 100, OutputRows: 25},
+		query.NodeCost{Type: "RunTestStatusEq", Depth: 2, InputRows: 75, OutputRows: 38},
+		query.NodeCost{Type: "Not", Depth: 1, InputRows: 63, OutputRows: 55},
+		query.NodeCost{Type: "Count", Depth: 2, InputRows: 63, OutputRows: 8},
+		query.NodeCost{Type: "RunTestStatusEq", Depth: 3, InputRows: 63, OutputRows: 16},
+		query.NodeCost{Type: "RunTestStatusEq", Depth: 3, InputRows: 63, OutputRows: 31},
+		query.NodeCost{Type: "TestNamePattern", Depth: 1, InputRows: 55, OutputRows: 27},
+	}, explainer.CostBreakdown(q, runs))
+	assert.Equal(t, explainer.EstimateMatches(q, runs), explainer.CostBreakdown(q, runs)[0].OutputRows)
+
+	// With many shards, the breakdown still covers every node once.
+	sharded, err := NewShardedWPTIndex(reports, testNumShards)
+	assert.Nil(t, err)
+	for _, run := range runs {
+		assert.Nil(t, sharded.IngestRun(run))
+	}
+	breakdown := sharded.(query.CostExplainer).CostBreakdown(q, runs)
+	assert.Equal(t, 9, len(breakdown))
+	assert.Equal(t, 100, breakdown[0].InputRows)
+
+	assert.Nil(t, explainer.CostBreakdown(nil, runs))
+}
+
+func TestEstimateMatches_withinTolerance(t *testing.T) {
+	// Statuses drawn independently at random, so that the independence
+	// assumption of estimates holds (approximately).
+	rng := rand.New(rand.NewSource(1))
+	statuses := []string{"PASS", "PASS", "FAIL", "TIMEOUT"}
+	reports := make(staticReportLoader)
+	runs := make([]shared.TestRun, 3)
+	for r := range runs {
+		runs[r] = shared.TestRun{ID: int64(r + 1)}
+		report := &metrics.TestResultsReport{}
+		for i := 0; i < 2000; i++ {
+			if rng.Intn(10) == 0 {
+				continue
+			}
+			report.Results = append(report.Results, &metrics.TestResults{
+				Test:   fmt.Sprintf("/a/%d.html", i),
+				Status: statuses[rng.Intn(len(statuses))],
+			})
+		}
+		reports[runs[r].ID] = report
+	}
+	idx, err := NewShardedWPTIndex(reports, testNumShards)
+	assert.Nil(t, err)
+	for _, run := range runs {
+		assert.Nil(t, idx.IngestRun(run))
+	}
+	estimator := idx.(query.MatchEstimator)
+
+	pass := func(run int64) query.ConcreteQuery {
+		return query.RunTestStatusEq{Run: run, Status: shared.TestStatusPass}
+	}
+	fail := func(run int64) query.ConcreteQuery {
+		return query.RunTestStatusEq{Run: run, Status: shared.TestStatusFail}
+	}
+	for _, q := range []query.ConcreteQuery{
+		query.And{Args: []query.ConcreteQuery{pass(1), fail(2)}},
+		query.Or{Args: []query.ConcreteQuery{fail(1), fail(2), fail(3)}},
+		query.And{Args: []query.ConcreteQuery{pass(1), query.Not{Arg: pass(2)}, query.Not{Arg: fail(3)}}},
+		query.AnyRunTestStatusEq{Runs: []int64{1, 2, 3}, Status: shared.TestStatusTimeout},
+		query.RunTestStatusIn{Run: 2, Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusUnknown}},
+		query.Count{Count: 2, Args: []query.ConcreteQuery{pass(1), pass(2), pass(3)}},
+	} {
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		_, stats := plan.(query.StatsPlan).ExecuteWithStats(runs, query.AggregationOpts{})
+		// Within 2% of the 2000 tests.
+		assert.InDelta(t, stats.TestsMatched, estimator.EstimateMatches(q, runs), 40, "Query: %#v", q)
+	}
+}
+
+func TestShardedFilter_DryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/pass.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/fail.html", Status: "FAIL"},
+					&metrics.TestResults{Test: "/b/pass.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	q := query.And{
+		Args: []query.ConcreteQuery{
+			query.TestPath{Path: "/"},
+			query.Or{
+				Args: []query.ConcreteQuery{
+					query.RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+					query.Not{Arg: query.TestPath{Path: "/a/"}},
+				},
+			},
+			query.RunTestStatusEq{Run: 1, Status: shared.TestStatusCrash},
+		},
+	}
+	plan, err := idx.Bind(runs, q)
+	assert.Nil(t, err)
+	dryRunPlan, ok := plan.(query.DryRunPlan)
+	assert.True(t, ok)
+
+	// Each atom is counted independently, regardless of negation or the other
+	// atoms.
+	assert.Equal(t, map[string]int{
+		"TestPath{Path:/}":                    3,
+		"TestPath{Path:/a/}":                  2,
+		"RunTestStatusEq{Run:1 Status:PASS}":  2,
+		"RunTestStatusEq{Run:1 Status:CRASH}": 0,
+	}, dryRunPlan.DryRun(runs))
+	assert.Equal(t, 0, len(plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)))
+}
+
+type longTimeoutLoader struct {
+	*MockReportLoader
+
+	tests map[int64][]string
+}
+
+func (l longTimeoutLoader) LoadLongTimeoutTests(run shared.TestRun) ([]string, error) {
+	return l.tests[run.ID], nil
+}
+
+func TestBindExecute_LongTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := longTimeoutLoader{
+		NewMockReportLoader(ctrl),
+		map[int64][]string{
+			1: []string{"/a/slow.html"},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{
+				Test:   "/a/slow.html",
+				Status: "OK",
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{Name: "sub", Status: "PASS"},
+				},
+			},
+			&metrics.TestResults{
+				Test:   "/a/fast.html",
+				Status: "PASS",
+			},
+		},
+	}
+	runs := mockTestRuns(loader.MockReportLoader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	})
+
+	srs := planAndExecute(t, runs, idx, query.LongTimeout{})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/slow.html", srs[0].Test)
+	// The subtest matches along with its test.
+	assert.Equal(t, 2, srs[0].LegacyStatus[0].Total)
+
+	srs = planAndExecute(t, runs, idx, query.AbstractNot{Arg: query.LongTimeout{}})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/fast.html", srs[0].Test)
+
+	// Only run 2, which has no long timeout data.
+	srs = planAndExecute(t, runs[1:], idx, query.LongTimeout{})
+	assert.Equal(t, 0, len(srs))
+}
+
+type metadataLoader struct {
+	*MockReportLoader
+
+	fields map[int64]map[string][]string
+}
+
+func (l metadataLoader) LoadMetadataFields(run shared.TestRun) (map[string][]string, error) {
+	return l.fields[run.ID], nil
+}
+
+func TestBindExecute_HasMetadataField(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := metadataLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string][]string{
+			1: map[string][]string{
+				"/a/bug.html":     []string{"bug"},
+				"/a/labeled.html": []string{"bug", "label"},
+			},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{
+				Test:   "/a/bug.html",
+				Status: "OK",
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{Name: "sub", Status: "FAIL"},
+				},
+			},
+			&metrics.TestResults{
+				Test:   "/a/labeled.html",
+				Status: "FAIL",
+			},
+			&metrics.TestResults{
+				Test:   "/a/untriaged.html",
+				Status: "FAIL",
+			},
+		},
+	}
+	runs := mockTestRuns(loader.MockReportLoader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	})
+
+	testNames := func(srs []query.SearchResult) []string {
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	srs := planAndExecute(t, runs, idx, query.HasMetadataField{Field: "bug"})
+	assert.Equal(t, []string{"/a/bug.html", "/a/labeled.html"}, testNames(srs))
+	// The subtest matches along with its test.
+	for _, sr := range srs {
+		if sr.Test == "/a/bug.html" {
+			assert.Equal(t, 2, sr.LegacyStatus[0].Total)
+		}
+	}
+
+	srs = planAndExecute(t, runs, idx, query.HasMetadataField{Field: "label"})
+	assert.Equal(t, []string{"/a/labeled.html"}, testNames(srs))
+
+	srs = planAndExecute(t, runs, idx, query.HasMetadataField{Field: "results"})
+	assert.Equal(t, []string{}, testNames(srs))
+
+	srs = planAndExecute(t, runs, idx, query.AbstractNot{Arg: query.HasMetadataField{Field: "bug"}})
+	assert.Equal(t, []string{"/a/untriaged.html"}, testNames(srs))
+
+	// Only run 2, which has no metadata.
+	srs = planAndExecute(t, runs[1:], idx, query.HasMetadataField{Field: "bug"})
+	assert.Equal(t, 0, len(srs))
+}
+
+type reftestLoader struct {
+	*MockReportLoader
+
+	reftests map[int64]map[string]string
+}
+
+func (l reftestLoader) LoadReftests(run shared.TestRun) (map[string]string, error) {
+	return l.reftests[run.ID], nil
+}
+
+func TestBindExecute_RefMatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := reftestLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string]string{
+			1: map[string]string{
+				"/a/match.html":    "==",
+				"/a/mismatch.html": "!=",
+			},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/match.html", Status: "PASS"},
+			&metrics.TestResults{Test: "/a/mismatch.html", Status: "FAIL"},
+			&metrics.TestResults{
+				Test:   "/a/testharness.html",
+				Status: "OK",
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{Name: "sub", Status: "PASS"},
+				},
+			},
+		},
+	}
+	chrome := shared.ParseProductSpecUnsafe("chrome").ProductAtRevision
+	runs := mockTestRuns(loader.MockReportLoader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1, ProductAtRevision: chrome}, results},
+		testRunData{shared.TestRun{ID: 2, ProductAtRevision: chrome}, results},
+	})
+
+	srs := planAndExecute(t, runs, idx, query.RefMatch{BrowserName: "chrome", Expected: query.RefExpectMatch})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/match.html", srs[0].Test)
+
+	srs = planAndExecute(t, runs, idx, query.RefMatch{BrowserName: "chrome", Expected: query.RefExpectMismatch})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/mismatch.html", srs[0].Test)
+
+	// Only run 2, which has no reftest data.
+	srs = planAndExecute(t, runs[1:], idx, query.RefMatch{BrowserName: "chrome", Expected: query.RefExpectMatch})
+	assert.Equal(t, 0, len(srs))
+}
+
+type disabledTestLoader struct {
+	*MockReportLoader
+
+	tests map[int64][]string
+}
+
+func (l disabledTestLoader) LoadDisabledTests(run shared.TestRun) ([]string, error) {
+	return l.tests[run.ID], nil
+}
+
+func TestBindExecute_Skipped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := disabledTestLoader{
+		NewMockReportLoader(ctrl),
+		map[int64][]string{
+			1: []string{"/a/disabled.html"},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/skip.html", Status: "SKIP"},
+					&metrics.TestResults{Test: "/a/present.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/skip.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/present.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/disabled.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/missing.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 3},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/present.html", Status: "SKIP"},
+				},
+			},
+		},
+	}
+	// In Firefox run 1, /a/skip.html has a SKIP result, /a/disabled.html is
+	// disabled in metadata (and has no result), and /a/missing.html is missing.
+	data[0].run.BrowserName = "firefox"
+	data[1].run.BrowserName = "firefox"
+	data[2].run.BrowserName = "chrome"
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	srs := planAndExecute(t, runs, idx, query.Skipped{BrowserName: "firefox"})
+	tests := make([]string, len(srs))
+	for i, sr := range srs {
+		tests[i] = sr.Test
+	}
+	sort.Strings(tests)
+	assert.Equal(t, []string{"/a/disabled.html", "/a/skip.html"}, tests)
+
+	srs = planAndExecute(t, runs, idx, query.Skipped{BrowserName: "chrome"})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/present.html", srs[0].Test)
+
+	// Only run 2, in which nothing was skipped.
+	srs = planAndExecute(t, runs[1:2], idx, query.Skipped{BrowserName: "firefox"})
+	assert.Equal(t, 0, len(srs))
+}
+
+type intermittentLoader struct {
+	*MockReportLoader
+
+	tests map[int64][]string
+}
+
+func (l intermittentLoader) LoadIntermittentTests(run shared.TestRun) ([]string, error) {
+	return l.tests[run.ID], nil
+}
+
+func TestBindExecute_Intermittent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := intermittentLoader{
+		NewMockReportLoader(ctrl),
+		map[int64][]string{
+			1: []string{"/a/flaky.html", "/a/flaky-sub.html", "/a/missing.html"},
+			2: []string{"/a/stable.html"},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/flaky.html", Status: "FAIL"},
+			&metrics.TestResults{
+				Test:   "/a/flaky-sub.html",
+				Status: "OK",
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{Name: "sub", Status: "PASS"},
+				},
+			},
+			&metrics.TestResults{Test: "/a/stable.html", Status: "PASS"},
+		},
+	}
+	data := []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	}
+	// In Chrome run 1, /a/missing.html is flagged but has no result.
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "safari"
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	srs := planAndExecute(t, runs, idx, query.Intermittent{BrowserName: "chrome"})
+	testNames := func() []string {
+		tests := make([]string, len(srs))
+		for i, sr := range srs {
+			tests[i] = sr.Test
+		}
+		sort.Strings(tests)
+		return tests
+	}
+	assert.Equal(t, []string{"/a/flaky-sub.html", "/a/flaky.html"}, testNames())
+	for _, sr := range srs {
+		if sr.Test == "/a/flaky-sub.html" {
+			// The subtest matches along with its test.
+			assert.Equal(t, 2, sr.LegacyStatus[0].Total)
+		}
+	}
+
+	srs = planAndExecute(t, runs, idx, query.AbstractNot{Arg: query.Intermittent{BrowserName: "chrome"}})
+	assert.Equal(t, []string{"/a/stable.html"}, testNames())
+
+	srs = planAndExecute(t, runs, idx, query.Intermittent{BrowserName: "safari"})
+	assert.Equal(t, []string{"/a/stable.html"}, testNames())
+
+	srs = planAndExecute(t, runs, idx, query.Intermittent{BrowserName: "firefox"})
+	assert.Equal(t, 0, len(srs))
+}
+
+type assertCountLoader struct {
+	*MockReportLoader
+
+	counts map[int64]map[string]int
+}
+
+func (l assertCountLoader) LoadAssertCounts(run shared.TestRun) (map[string]int, error) {
+	return l.counts[run.ID], nil
+}
+
+func TestBindExecute_AssertCount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := assertCountLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string]int{
+			1: map[string]int{
+				"/a/bailout.html": 0,
+				"/a/many.html":    12,
+				"/a/one.html":     1,
+				"/a/sub.html":     0,
+				// No result for the test.
+				"/a/missing.html": 0,
+			},
+			2: map[string]int{
+				"/a/bailout.html": 7,
+				"/a/many.html":    12,
+			},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/bailout.html", Status: "ERROR"},
+			&metrics.TestResults{Test: "/a/many.html", Status: "PASS"},
+			&metrics.TestResults{Test: "/a/one.html", Status: "PASS"},
+			&metrics.TestResults{
+				Test:     "/a/sub.html",
+				Status:   "OK",
+				Subtests: []metrics.SubTest{metrics.SubTest{Name: "sub", Status: "PASS"}},
+			},
+			// No recorded assertion count.
+			&metrics.TestResults{Test: "/a/uncounted.html", Status: "PASS"},
+		},
+	}
+	data := []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Zero assertions; the subtest matches along with its test.
+	assert.Equal(t, []string{"/a/bailout.html", "/a/sub.html"}, testNames(query.AssertCount{BrowserName: "chrome", Min: 0, Max: 0}))
+	assert.Equal(t, []string{"/a/many.html", "/a/one.html"}, testNames(query.AssertCount{BrowserName: "chrome", Min: 1, Max: -1}))
+	assert.Equal(t, []string{"/a/bailout.html", "/a/one.html", "/a/sub.html"}, testNames(query.AssertCount{BrowserName: "chrome", Min: 0, Max: 5}))
+	assert.Equal(t, []string{"/a/bailout.html", "/a/many.html"}, testNames(query.AssertCount{BrowserName: "firefox", Min: 5, Max: 20}))
+	assert.Equal(t, []string{}, testNames(query.AssertCount{BrowserName: "firefox", Min: 0, Max: 0}))
+	assert.Equal(t, []string{}, testNames(query.AssertCount{BrowserName: "safari", Min: 0, Max: -1}))
+}
+
+type expectationLoader struct {
+	*MockReportLoader
+
+	expected map[int64]map[string][]shared.TestStatus
+}
+
+func (l expectationLoader) LoadExpectedStatuses(run shared.TestRun) (map[string][]shared.TestStatus, error) {
+	return l.expected[run.ID], nil
+}
+
+func TestBindExecute_Unexpected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := expectationLoader{
+		NewMockReportLoader(ctrl),
+		map[int64]map[string][]shared.TestStatus{
+			1: map[string][]shared.TestStatus{
+				"/a/expected-fail.html":  []shared.TestStatus{shared.TestStatusFail},
+				"/a/expected-flaky.html": []shared.TestStatus{shared.TestStatusFail, shared.TestStatusTimeout},
+				"/a/new-timeout.html":    []shared.TestStatus{shared.TestStatusFail},
+				"/a/fixed.html":          []shared.TestStatus{shared.TestStatusFail},
+			},
+		},
+	}
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	results := &metrics.TestResultsReport{
+		Results: []*metrics.TestResults{
+			&metrics.TestResults{Test: "/a/expected-fail.html", Status: "FAIL"},
+			&metrics.TestResults{Test: "/a/expected-flaky.html", Status: "TIMEOUT"},
+			&metrics.TestResults{Test: "/a/new-timeout.html", Status: "TIMEOUT"},
+			&metrics.TestResults{Test: "/a/fixed.html", Status: "PASS"},
+			&metrics.TestResults{Test: "/a/unexpected-fail.html", Status: "FAIL"},
+			&metrics.TestResults{
+				Test:   "/a/ok.html",
+				Status: "OK",
+				Subtests: []metrics.SubTest{
+					metrics.SubTest{Name: "sub", Status: "FAIL"},
+				},
+			},
+		},
+	}
+	data := []testRunData{
+		testRunData{shared.TestRun{ID: 1}, results},
+		testRunData{shared.TestRun{ID: 2}, results},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader.MockReportLoader, idx, data)
+
+	testNames := func(srs []query.SearchResult) []string {
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Expected failures (including flaky ones) don't match, but unexpected
+	// failures, timeouts and passes do. The OK test matches according to its
+	// harness status, not its failing subtest.
+	srs := planAndExecute(t, runs, idx, query.Unexpected{BrowserName: "chrome"})
+	assert.Equal(t, []string{"/a/fixed.html", "/a/new-timeout.html", "/a/unexpected-fail.html"}, testNames(srs))
+
+	// Firefox run 2 has no expectations, so every failure is unexpected.
+	srs = planAndExecute(t, runs, idx, query.Unexpected{BrowserName: "firefox"})
+	assert.Equal(t, []string{"/a/expected-fail.html", "/a/expected-flaky.html", "/a/new-timeout.html", "/a/unexpected-fail.html"}, testNames(srs))
+
+	srs = planAndExecute(t, runs, idx, query.Unexpected{BrowserName: "safari"})
+	assert.Equal(t, 0, len(srs))
+}
+
+func TestBindExecute_SubtestMajority(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/mostly-fail.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "FAIL"},
+							metrics.SubTest{Name: "sub2", Status: "FAIL"},
+							metrics.SubTest{Name: "sub3", Status: "PASS"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/plurality-fail.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "FAIL"},
+							metrics.SubTest{Name: "sub2", Status: "FAIL"},
+							metrics.SubTest{Name: "sub3", Status: "PASS"},
+							metrics.SubTest{Name: "sub4", Status: "TIMEOUT"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/tie.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "FAIL"},
+							metrics.SubTest{Name: "sub2", Status: "PASS"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/mostly-pass.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "PASS"},
+							metrics.SubTest{Name: "sub2", Status: "PASS"},
+							metrics.SubTest{Name: "sub3", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{Test: "/a/reftest-fail.html", Status: "FAIL"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/tie.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "FAIL"},
+							metrics.SubTest{Name: "sub2", Status: "FAIL"},
+						},
+					},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Ties do not match, nor do tests without subtests.
+	assert.Equal(t, []string{"/a/mostly-fail.html", "/a/plurality-fail.html"}, testNames(query.SubtestMajority{BrowserName: "chrome", Status: shared.TestStatusFail}))
+	assert.Equal(t, []string{"/a/mostly-pass.html"}, testNames(query.SubtestMajority{BrowserName: "chrome", Status: shared.TestStatusPass}))
+	assert.Equal(t, []string{}, testNames(query.SubtestMajority{BrowserName: "chrome", Status: shared.TestStatusTimeout}))
+	assert.Equal(t, []string{"/a/tie.html"}, testNames(query.SubtestMajority{BrowserName: "firefox", Status: shared.TestStatusFail}))
+	assert.Equal(t, []string{}, testNames(query.SubtestMajority{BrowserName: "safari", Status: shared.TestStatusFail}))
+}
+
+func TestBindExecute_HarnessMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	uncaught := "Uncaught TypeError: foo is not a function"
+	timeout := "Test timed out"
+	subtestMessage := "uncaught in subtest"
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:    "/a/uncaught.html",
+						Status:  "ERROR",
+						Message: &uncaught,
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub", Status: "NOTRUN"},
+						},
+					},
+					&metrics.TestResults{Test: "/a/timeout.html", Status: "TIMEOUT", Message: &timeout},
+					&metrics.TestResults{
+						Test:   "/a/subtest-message.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub", Status: "FAIL", Message: &subtestMessage},
+						},
+					},
+					&metrics.TestResults{Test: "/a/no-message.html", Status: "OK"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/timeout.html", Status: "ERROR", Message: &uncaught},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	results := func(q query.AbstractQuery) map[string][]string {
+		srs := planAndExecute(t, runs, idx, q)
+		tests := make(map[string][]string)
+		for _, sr := range srs {
+			tests[sr.Test] = sr.Subtests
+		}
+		return tests
+	}
+
+	// Only harness-level messages match, case-sensitively; subtest messages never do.
+	assert.Equal(t, map[string][]string{"/a/uncaught.html": nil}, results(query.HarnessMessage{BrowserName: "chrome", Pattern: "Uncaught"}))
+	assert.Equal(t, map[string][]string{}, results(query.HarnessMessage{BrowserName: "chrome", Pattern: "uncaught"}))
+	assert.Equal(t, map[string][]string{"/a/timeout.html": nil}, results(query.HarnessMessage{BrowserName: "chrome", Pattern: "timed out"}))
+	assert.Equal(t, map[string][]string{"/a/timeout.html": nil}, results(query.HarnessMessage{BrowserName: "firefox", Pattern: "Uncaught"}))
+	assert.Equal(t, map[string][]string{"/a/no-message.html": nil, "/a/subtest-message.html": nil}, results(query.AbstractAnd{
+		Args: []query.AbstractQuery{
+			query.TestStatusEq{Status: shared.TestStatusOK},
+			query.AbstractNot{Arg: query.HarnessMessage{BrowserName: "chrome", Pattern: "e"}},
+		},
+	}))
+}
+
+func TestBindExecute_Columns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/b.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/b.html", Status: "FAIL"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 3},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/b.html", Status: "PASS"},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	data[2].run.BrowserName = "safari"
+	runs := mockTestRuns(loader, idx, data)
+
+	// The query is bound to every run, including the (unselected) firefox run.
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	rq := query.RunQuery{
+		Columns:       []string{"safari", "chrome"},
+		AbstractQuery: query.TestStatusEq{Product: &firefox, Status: shared.TestStatusFail},
+	}
+	columns, err := rq.SelectColumns(runs)
+	assert.Nil(t, err)
+	plan, err := idx.Bind(runs, rq.AbstractQuery.BindToRuns(runs...))
+	assert.Nil(t, err)
+	srs := plan.Execute(columns, query.AggregationOpts{}).([]query.SearchResult)
+	assert.Equal(t, []query.SearchResult{
+		query.SearchResult{
+			Test: "/a/b.html",
+			LegacyStatus: []query.LegacySearchRunResult{
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+				query.LegacySearchRunResult{Passes: 1, Total: 1},
+			},
+		},
+	}, srs)
+
+	_, err = query.RunQuery{Columns: []string{"edge"}}.SelectColumns(runs)
+	assert.NotNil(t, err)
+}
+
+func TestBindExecute_AllSubtestsPass(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/all-pass.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "PASS"},
+							metrics.SubTest{Name: "sub2", Status: "PASS"},
+						},
+					},
+					&metrics.TestResults{
+						Test:   "/a/ok-with-failure.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "PASS"},
+							metrics.SubTest{Name: "sub2", Status: "FAIL"},
+						},
+					},
+					&metrics.TestResults{Test: "/a/reftest-pass.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/reftest-fail.html", Status: "FAIL"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/ok-with-failure.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub1", Status: "PASS"},
+							metrics.SubTest{Name: "sub2", Status: "PASS"},
+						},
+					},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Harness OK with a failing subtest is not fully passing.
+	assert.Equal(t, []string{"/a/all-pass.html", "/a/reftest-pass.html"}, testNames(query.AllSubtestsPass{BrowserName: "chrome"}))
+	assert.Equal(t, []string{"/a/ok-with-failure.html"}, testNames(query.AllSubtestsPass{BrowserName: "firefox"}))
+	assert.Equal(t, []string{"/a/ok-with-failure.html"}, testNames(query.AbstractAnd{
+		Args: []query.AbstractQuery{
+			query.TestStatusEq{Status: shared.TestStatusOK},
+			query.AbstractNot{Arg: query.AllSubtestsPass{BrowserName: "chrome"}},
+		},
+	}))
+	assert.Equal(t, []string{}, testNames(query.AllSubtestsPass{BrowserName: "safari"}))
+}
+
+func TestBindExecute_SubtestPassRatio(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	subtests := func(statuses ...string) []metrics.SubTest {
+		subs := make([]metrics.SubTest, len(statuses))
+		for i, status := range statuses {
+			subs[i] = metrics.SubTest{Name: fmt.Sprintf("sub%d", i), Status: status}
+		}
+		return subs
+	}
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/none.html", Status: "OK", Subtests: subtests("FAIL", "TIMEOUT")},
+					&metrics.TestResults{Test: "/a/quarter.html", Status: "OK", Subtests: subtests("PASS", "FAIL", "FAIL", "FAIL")},
+					&metrics.TestResults{Test: "/a/half.html", Status: "OK", Subtests: subtests("PASS", "FAIL")},
+					&metrics.TestResults{Test: "/a/all.html", Status: "OK", Subtests: subtests("PASS", "PASS")},
+					&metrics.TestResults{Test: "/a/reftest.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/half.html", Status: "OK", Subtests: subtests("PASS", "PASS")},
+				},
+			},
+		},
+	}
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(below, above float64) []string {
+		srs := planAndExecute(t, runs, idx, query.SubtestPassRatio{BrowserName: "chrome", Below: below, Above: above})
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Bounds are exclusive, and tests without subtests never match.
+	assert.Equal(t, []string{"/a/none.html", "/a/quarter.html"}, testNames(0.5, -1))
+	assert.Equal(t, []string{"/a/all.html"}, testNames(-1, 0.5))
+	assert.Equal(t, []string{"/a/half.html", "/a/none.html", "/a/quarter.html"}, testNames(1, -1))
+	assert.Equal(t, []string{"/a/all.html", "/a/half.html", "/a/quarter.html"}, testNames(-1, 0))
+	assert.Equal(t, []string{"/a/half.html"}, testNames(1, 0.25))
+	assert.Equal(t, []string{}, testNames(0, -1))
+	assert.Equal(t, []string{}, testNames(-1, 1))
+
+	srs := planAndExecute(t, runs, idx, query.SubtestPassRatio{BrowserName: "firefox", Below: 1, Above: -1})
+	assert.Equal(t, 0, len(srs))
+}
+
+func TestBindExecute_FlakinessRate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Statuses of each test over five Chrome runs and a Firefox run.
+	histories := map[string][]string{
+		"/a/stable.html":        []string{"PASS", "PASS", "PASS", "PASS", "PASS", "FAIL"},
+		"/a/stable-fail.html":   []string{"FAIL", "FAIL", "FAIL", "FAIL", "FAIL", "PASS"},
+		"/a/once.html":          []string{"PASS", "FAIL", "PASS", "PASS", "PASS", "PASS"},
+		"/a/flaky.html":         []string{"PASS", "FAIL", "PASS", "TIMEOUT", "PASS", "PASS"},
+		"/a/very-flaky.html":    []string{"PASS", "FAIL", "TIMEOUT", "FAIL", "PASS", "PASS"},
+		"/a/mostly-absent.html": []string{"PASS", "", "", "", "FAIL", "PASS"},
+	}
+	data := make([]testRunData, 6)
+	for i := range data {
+		data[i].run = shared.TestRun{ID: int64(i + 1)}
+		data[i].run.BrowserName = "chrome"
+		data[i].results = &metrics.TestResultsReport{}
+		for test, statuses := range histories {
+			if statuses[i] != "" {
+				data[i].results.Results = append(data[i].results.Results, &metrics.TestResults{
+					Test:   test,
+					Status: statuses[i],
+				})
+			}
+		}
+	}
+	data[5].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Rates: stable 0, stable-fail 0, once 0.2, flaky 0.4, very-flaky 0.6, and
+	// mostly-absent 0.5 (of the two runs with results).
+	assert.Equal(t, []string{"/a/flaky.html", "/a/mostly-absent.html", "/a/very-flaky.html"}, testNames(query.FlakinessRate{BrowserName: "chrome", Above: 0.2}))
+	assert.Equal(t, []string{"/a/very-flaky.html"}, testNames(query.FlakinessRate{BrowserName: "chrome", Above: 0.5}))
+	assert.Equal(t, []string{"/a/flaky.html", "/a/mostly-absent.html", "/a/once.html", "/a/very-flaky.html"}, testNames(query.FlakinessRate{BrowserName: "chrome", Above: 0}))
+	// A single run is never flaky.
+	assert.Equal(t, []string{}, testNames(query.FlakinessRate{BrowserName: "firefox", Above: 0}))
+}
+
+func TestBindExecute_RegressedSince(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Statuses of each test in the baseline run, then two later runs.
+	histories := map[string][]string{
+		"/a/regressed.html":   []string{"PASS", "PASS", "FAIL"},
+		"/a/crashed.html":     []string{"TIMEOUT", "CRASH", "TIMEOUT"},
+		"/a/improved.html":    []string{"FAIL", "PASS", "PASS"},
+		"/a/unchanged.html":   []string{"FAIL", "FAIL", "FAIL"},
+		"/a/equivalent.html":  []string{"OK", "PASS", "OK"},
+		"/a/new.html":         []string{"", "FAIL", "FAIL"},
+		"/a/removed.html":     []string{"PASS", "", ""},
+		"/a/worse-later.html": []string{"SKIP", "SKIP", "ERROR"},
+	}
+	data := make([]testRunData, 3)
+	for i := range data {
+		data[i].run = shared.TestRun{ID: int64(i + 1)}
+		data[i].run.BrowserName = "chrome"
+		data[i].results = &metrics.TestResultsReport{}
+		for test, statuses := range histories {
+			if statuses[i] != "" {
+				data[i].results.Results = append(data[i].results.Results, &metrics.TestResults{
+					Test:   test,
+					Status: statuses[i],
+				})
+			}
+		}
+	}
+	data[0].run.Labels = []string{"last_stable"}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(runs []shared.TestRun, q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	q := query.RegressedSince{Label: "last_stable"}
+	assert.Equal(t, []string{"/a/crashed.html", "/a/regressed.html", "/a/worse-later.html"}, testNames(runs, q))
+	assert.Equal(t, []string{"/a/crashed.html"}, testNames(runs[:2], q))
+	assert.Equal(t, []string{"/a/equivalent.html", "/a/improved.html", "/a/new.html", "/a/removed.html", "/a/unchanged.html"}, testNames(runs, query.AbstractNot{Arg: q}))
+	// Only the baseline run.
+	assert.Equal(t, []string{}, testNames(runs[:1], q))
+}
+
+func TestBindExecute_ChangedInPR(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Statuses of each test in the base run, then the head run.
+	histories := map[string][]string{
+		"/a/fixed.html":     []string{"FAIL", "PASS"},
+		"/a/broken.html":    []string{"PASS", "TIMEOUT"},
+		"/a/unchanged.html": []string{"FAIL", "FAIL"},
+		"/a/passing.html":   []string{"PASS", "PASS"},
+		"/a/new.html":       []string{"", "PASS"},
+		"/a/removed.html":   []string{"PASS", ""},
+	}
+	data := make([]testRunData, 2)
+	for i := range data {
+		data[i].run = shared.TestRun{ID: int64(i + 1)}
+		data[i].run.BrowserName = "chrome"
+		data[i].results = &metrics.TestResultsReport{}
+		for test, statuses := range histories {
+			if statuses[i] != "" {
+				data[i].results.Results = append(data[i].results.Results, &metrics.TestResults{
+					Test:   test,
+					Status: statuses[i],
+				})
+			}
+		}
+	}
+	data[0].run.Labels = []string{shared.PRBaseLabel}
+	data[1].run.Labels = []string{shared.PRHeadLabel}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/a/broken.html", "/a/fixed.html", "/a/new.html", "/a/removed.html"}, testNames(query.ChangedInPR{}))
+	assert.Equal(t, []string{"/a/passing.html", "/a/unchanged.html"}, testNames(query.AbstractNot{Arg: query.ChangedInPR{}}))
+}
+
+func TestBindExecute_AnyChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Statuses of each test in the chrome baseline and current runs, then the
+	// firefox baseline and current runs.
+	histories := map[string][]string{
+		"/a/unchanged.html":     []string{"PASS", "PASS", "FAIL", "FAIL"},
+		"/a/chrome-broken.html": []string{"PASS", "FAIL", "PASS", "PASS"},
+		"/a/both-broken.html":   []string{"PASS", "TIMEOUT", "PASS", "CRASH"},
+		"/a/firefox-fixed.html": []string{"FAIL", "FAIL", "FAIL", "PASS"},
+		"/a/firefox-new.html":   []string{"", "", "", "PASS"},
+	}
+	data := make([]testRunData, 4)
+	for i := range data {
+		data[i].run = shared.TestRun{ID: int64(i + 1)}
+		data[i].run.BrowserName = []string{"chrome", "firefox"}[i/2]
+		if i%2 == 0 {
+			data[i].run.Labels = []string{"last_stable"}
+		}
+		data[i].results = &metrics.TestResultsReport{}
+		for test, statuses := range histories {
+			if statuses[i] != "" {
+				data[i].results.Results = append(data[i].results.Results, &metrics.TestResults{
+					Test:   test,
+					Status: statuses[i],
+				})
+			}
+		}
+	}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(runs []shared.TestRun, q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	q := query.AnyChanged{Label: "last_stable"}
+	assert.Equal(t, []string{"/a/both-broken.html", "/a/chrome-broken.html", "/a/firefox-fixed.html", "/a/firefox-new.html"}, testNames(runs, q))
+	assert.Equal(t, []string{"/a/unchanged.html"}, testNames(runs, query.AbstractNot{Arg: q}))
+	// Changes in the firefox runs only count when they are bound.
+	assert.Equal(t, []string{"/a/both-broken.html", "/a/chrome-broken.html"}, testNames(runs[:2], q))
+	assert.Equal(t, []string{"/a/both-broken.html", "/a/firefox-fixed.html", "/a/firefox-new.html"}, testNames(runs, query.AnyChanged{Run: 3}))
+}
+
+func TestBindExecute_SubtestCountDelta(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	subtests := func(n int) []metrics.SubTest {
+		subs := make([]metrics.SubTest, n)
+		for i := range subs {
+			subs[i] = metrics.SubTest{Name: fmt.Sprintf("sub%d", i), Status: "PASS"}
+		}
+		return subs
+	}
+	// Numbers of subtests of each test in the chrome and firefox runs, where -1
+	// is a missing result.
+	counts := map[string][]int{
+		"/a/equal.html":       []int{3, 3},
+		"/a/none.html":        []int{0, 0},
+		"/a/fewer.html":       []int{3, 1},
+		"/a/more.html":        []int{0, 2},
+		"/a/chrome-only.html": []int{2, -1},
+	}
+	data := make([]testRunData, 2)
+	for i := range data {
+		data[i].run = shared.TestRun{ID: int64(i + 1)}
+		data[i].run.BrowserName = []string{"chrome", "firefox"}[i]
+		data[i].results = &metrics.TestResultsReport{}
+		for test, n := range counts {
+			if n[i] >= 0 {
+				data[i].results.Results = append(data[i].results.Results, &metrics.TestResults{
+					Test:     test,
+					Status:   "OK",
+					Subtests: subtests(n[i]),
+				})
+			}
+		}
+	}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/a/chrome-only.html", "/a/fewer.html", "/a/more.html"}, testNames(query.SubtestCountDelta{From: "chrome", To: "firefox", Changed: true}))
+	assert.Equal(t, []string{"/a/equal.html", "/a/none.html"}, testNames(query.SubtestCountDelta{From: "chrome", To: "firefox", Changed: false}))
+	// Subtests match along with their test.
+	srs := planAndExecute(t, runs, idx, query.SubtestCountDelta{From: "firefox", To: "chrome", Changed: false})
+	for _, sr := range srs {
+		if sr.Test == "/a/equal.html" {
+			assert.Equal(t, 4, sr.LegacyStatus[0].Total)
+		}
+	}
+	assert.Equal(t, 2, len(srs))
+}
+
+func TestBindExecute_PresentInAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/all.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/chrome.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/all.html", Status: "FAIL"},
+					&metrics.TestResults{Test: "/a/chrome.html", Status: "FAIL"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 3},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/all.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/firefox.html", Status: "PASS"},
+				},
+			},
+		},
+	}
+	// Two Chrome runs and a Firefox run; /a/all.html is present in both
+	// browsers, and /a/chrome.html is only present in Chrome.
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "chrome"
+	data[2].run.BrowserName = "firefox"
+	runs := mockTestRuns(loader, idx, data)
+
+	srs := planAndExecute(t, runs, idx, query.PresentInAll{})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/all.html", srs[0].Test)
+
+	// With a single browser, each of its tests is present in all browsers.
+	srs = planAndExecute(t, runs[:1], idx, query.PresentInAll{})
+	assert.Equal(t, 2, len(srs))
+}
+
+func TestBindExecute_BrowsersFailing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	data := []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/none.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/one.html", Status: "OK"},
+					&metrics.TestResults{Test: "/a/two.html", Status: "FAIL"},
+					&metrics.TestResults{Test: "/a/three.html", Status: "TIMEOUT"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/none.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/one.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/two.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/three.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 3},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/none.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/a/one.html", Status: "CRASH"},
+					&metrics.TestResults{Test: "/a/two.html", Status: "ERROR"},
+					&metrics.TestResults{Test: "/a/three.html", Status: "FAIL"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 4},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/three.html", Status: "FAIL"},
+				},
+			},
+		},
+	}
+	// Two Chrome runs, in either of which a failure counts for Chrome, a
+	// Firefox run, and a Safari run missing all but /a/three.html.
+	data[0].run.BrowserName = "chrome"
+	data[1].run.BrowserName = "chrome"
+	data[2].run.BrowserName = "firefox"
+	data[3].run.BrowserName = "safari"
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+	failing := func(comparison string, count int) query.AbstractQuery {
+		return query.BrowsersFailing{Count: count, Comparison: comparison}
+	}
+
+	assert.Equal(t, []string{"/a/none.html"}, testNames(failing(query.ComparisonEq, 0)))
+	assert.Equal(t, []string{"/a/one.html"}, testNames(failing(query.ComparisonEq, 1)))
+	assert.Equal(t, []string{"/a/two.html"}, testNames(failing(query.ComparisonEq, 2)))
+	assert.Equal(t, []string{"/a/three.html"}, testNames(failing(query.ComparisonEq, 3)))
+	assert.Equal(t, []string{"/a/three.html", "/a/two.html"}, testNames(failing(query.ComparisonGte, 2)))
+	assert.Equal(t, []string{"/a/none.html", "/a/one.html", "/a/three.html", "/a/two.html"}, testNames(failing(query.ComparisonGte, 0)))
+	assert.Equal(t, []string{"/a/none.html", "/a/one.html"}, testNames(failing(query.ComparisonLte, 1)))
+	assert.Equal(t, []string{}, testNames(failing(query.ComparisonGte, 4)))
+}
+
+func TestBindExecute_WorseThanMedian(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Results for chrome, firefox, edge and safari (in two runs), respectively.
+	results := map[string][]string{
+		// A clear outlier: safari fails where others pass.
+		"/a/outlier.html": {"PASS", "PASS", "OK", "FAIL", "FAIL"},
+		// Safari's best run counts.
+		"/a/flaky.html": {"PASS", "PASS", "PASS", "CRASH", "PASS"},
+		// Ties: safari is as bad as the median.
+		"/a/tied.html": {"PASS", "FAIL", "FAIL", "FAIL", "FAIL"},
+		// An even number of browsers: safari is worse than the better of the
+		// middle results, PASS.
+		"/a/even.html": {"PASS", "PASS", "TIMEOUT", "FAIL", ""},
+		// Safari is better than the median.
+		"/a/better.html": {"FAIL", "CRASH", "ERROR", "PASS", ""},
+		// Safari has no result.
+		"/a/missing.html": {"FAIL", "PASS", "PASS", "", ""},
+	}
+	browsers := []string{"chrome", "firefox", "edge", "safari", "safari"}
+	data := make([]testRunData, len(browsers))
+	for i, browser := range browsers {
+		report := &metrics.TestResultsReport{}
+		for test, statuses := range results {
+			if statuses[i] != "" {
+				report.Results = append(report.Results, &metrics.TestResults{Test: test, Status: statuses[i]})
+			}
+		}
+		data[i] = testRunData{shared.TestRun{ID: int64(i + 1)}, report}
+		data[i].run.BrowserName = browser
+	}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery, runs []shared.TestRun) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/a/even.html", "/a/outlier.html"}, testNames(query.WorseThanMedian{BrowserName: "safari"}, runs))
+	// Firefox's CRASH on /a/better.html is worse than the median FAIL, while its
+	// FAIL on /a/tied.html ties with the median.
+	assert.Equal(t, []string{"/a/better.html"}, testNames(query.WorseThanMedian{BrowserName: "firefox"}, runs))
+	// Browsers without a result do not participate: chrome's FAIL on
+	// /a/missing.html is worse than the median PASS of three browsers.
+	assert.Equal(t, []string{"/a/missing.html"}, testNames(query.WorseThanMedian{BrowserName: "chrome"}, runs))
+	// A single browser is its own median.
+	assert.Equal(t, []string{}, testNames(query.WorseThanMedian{BrowserName: "safari"}, runs[3:]))
+}
+
+func TestBindExecute_SubtestOnlyIn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	// Names of the subtests of each test in the chrome, firefox and (second)
+	// chrome runs, respectively.
+	subtests := map[string][][]string{
+		"/a/exclusive.html": {{"foo", "bar"}, {"bar"}, {}},
+		"/a/shared.html":    {{"foo"}, {"foo"}, {"foo"}},
+		"/a/second.html":    {{}, {}, {"foo"}},
+		"/a/firefox.html":   {{}, {"foo"}, {}},
+		"/a/similar.html":   {{"foobar"}, {}, {}},
+	}
+	browsers := []string{"chrome", "firefox", "chrome"}
+	data := make([]testRunData, len(browsers))
+	for i, browser := range browsers {
+		report := &metrics.TestResultsReport{}
+		for test, names := range subtests {
+			result := &metrics.TestResults{Test: test, Status: "OK"}
+			for _, name := range names[i] {
+				result.Subtests = append(result.Subtests, metrics.SubTest{Name: name, Status: "PASS"})
+			}
+			report.Results = append(report.Results, result)
+		}
+		data[i] = testRunData{shared.TestRun{ID: int64(i + 1)}, report}
+		data[i].run.BrowserName = browser
+	}
+	runs := mockTestRuns(loader, idx, data)
+
+	testNames := func(q query.AbstractQuery, runs []shared.TestRun) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// A subtest in any chrome run, but in no firefox run, matches; shared
+	// subtests and subtests of other names do not.
+	assert.Equal(t, []string{"/a/exclusive.html", "/a/second.html"}, testNames(query.SubtestOnlyIn{BrowserName: "chrome", Subtest: "foo"}, runs))
+	assert.Equal(t, []string{"/a/firefox.html"}, testNames(query.SubtestOnlyIn{BrowserName: "firefox", Subtest: "foo"}, runs))
+	assert.Equal(t, []string{}, testNames(query.SubtestOnlyIn{BrowserName: "chrome", Subtest: "bar"}, runs))
+
+	// Only the matching subtest is aggregated.
+	srs := planAndExecute(t, runs, idx, query.SubtestOnlyIn{BrowserName: "firefox", Subtest: "bar"})
+	assert.Equal(t, []query.SearchResult{}, srs)
+	srs = planAndExecute(t, runs[:2], idx, query.SubtestOnlyIn{BrowserName: "chrome", Subtest: "foo"})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/exclusive.html", srs[0].Test)
+	assert.Equal(t, []query.LegacySearchRunResult{{Passes: 1, Total: 1}, {}}, srs[0].LegacyStatus)
+
+	// Without a run of the browser, nothing matches.
+	assert.Equal(t, []string{}, testNames(query.SubtestOnlyIn{BrowserName: "safari", Subtest: "foo"}, runs))
+}
+
+func TestBindExecute_Coverage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	report := func(tests ...string) *metrics.TestResultsReport {
+		r := &metrics.TestResultsReport{}
+		for _, test := range tests {
+			r.Results = append(r.Results, &metrics.TestResults{Test: test, Status: "FAIL"})
+		}
+		return r
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1}, report("/a/full.html", "/a/most.html", "/a/sparse.html")},
+		testRunData{shared.TestRun{ID: 2}, report("/a/full.html", "/a/most.html")},
+		testRunData{shared.TestRun{ID: 3}, report("/a/full.html", "/a/most.html")},
+		testRunData{shared.TestRun{ID: 4}, report("/a/full.html")},
+	})
+
+	testNames := func(srs []query.SearchResult) []string {
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	srs := planAndExecute(t, runs, idx, query.CoverageCount{Min: 4})
+	assert.Equal(t, []string{"/a/full.html"}, testNames(srs))
+	srs = planAndExecute(t, runs, idx, query.CoverageCount{Min: 3})
+	assert.Equal(t, []string{"/a/full.html", "/a/most.html"}, testNames(srs))
+	srs = planAndExecute(t, runs, idx, query.CoverageCount{Min: 1})
+	assert.Equal(t, []string{"/a/full.html", "/a/most.html", "/a/sparse.html"}, testNames(srs))
+	srs = planAndExecute(t, runs, idx, query.AbstractNot{Arg: query.CoverageCount{Min: 2}})
+	assert.Equal(t, []string{"/a/sparse.html"}, testNames(srs))
+}
+
+func TestBindExecute_FirstSeen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := func(tests ...string) *metrics.TestResultsReport {
+		r := &metrics.TestResultsReport{}
+		for _, test := range tests {
+			r.Results = append(r.Results, &metrics.TestResults{Test: test, Status: "PASS"})
+		}
+		return r
+	}
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{shared.TestRun{ID: 1, TimeStart: since.AddDate(0, -2, 0)}, report("/a/old.html")},
+		testRunData{shared.TestRun{ID: 2, TimeStart: since}, report("/a/old.html", "/a/at-threshold.html")},
+		testRunData{shared.TestRun{ID: 3, TimeStart: since.AddDate(0, 0, 10)}, report("/a/old.html", "/a/at-threshold.html", "/a/new.html")},
+		testRunData{shared.TestRun{ID: 4, TimeStart: since.AddDate(0, 0, 20)}, report("/a/newer.html")},
+	})
+
+	testNames := func(srs []query.SearchResult) []string {
+		names := make([]string, len(srs))
+		for i, sr := range srs {
+			names[i] = sr.Test
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	srs := planAndExecute(t, runs, idx, query.FirstSeen{Since: since})
+	assert.Equal(t, []string{"/a/new.html", "/a/newer.html"}, testNames(srs))
+
+	// Only tests new to the queried runs are recognized as new.
+	srs = planAndExecute(t, runs[2:], idx, query.FirstSeen{Since: since})
+	assert.Equal(t, []string{"/a/at-threshold.html", "/a/new.html", "/a/newer.html", "/a/old.html"}, testNames(srs))
+
+	srs = planAndExecute(t, runs, idx, query.FirstSeen{Since: since.AddDate(0, 1, 0)})
+	assert.Equal(t, 0, len(srs))
+}
+
+// prefixMatcher matches tests whose name has a prefix, in runs that have a
+// result for the test.
+type prefixMatcher struct {
+	prefix string
+}
+
+func (m prefixMatcher) Match(testName string, run query.RunResults) bool {
+	return strings.HasPrefix(testName, m.prefix) && run.Status != shared.TestStatusUnknown
+}
+
+func init() {
+	query.RegisterAtom("present_with_prefix", func(b json.RawMessage) (query.AbstractQuery, error) {
+		var prefix string
+		err := json.Unmarshal(b, &prefix)
+		return query.CustomAtom{Key: "present_with_prefix", Value: b, Matcher: prefixMatcher{prefix}}, err
+	})
+}
+
+func TestBindExecute_CustomAtom(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/a/b.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/b/a.html", Status: "PASS"},
+				},
+			},
+		},
+		testRunData{
+			shared.TestRun{ID: 2},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{
+						Test:   "/a/c.html",
+						Status: "OK",
+						Subtests: []metrics.SubTest{
+							metrics.SubTest{Name: "sub", Status: "FAIL"},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	parsed, err := query.Parse([]byte(`{"run_ids": [1, 2], "query": {"present_with_prefix": "/a/"}}`))
+	assert.Nil(t, err)
+	srs := planAndExecute(t, runs, idx, parsed.RunQuery.AbstractQuery)
+	names := make([]string, len(srs))
+	for i, sr := range srs {
+		names[i] = sr.Test
+		if sr.Test == "/a/c.html" {
+			// The subtest matches along with its test.
+			assert.Equal(t, 2, sr.LegacyStatus[1].Total)
+		}
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"/a/b.html", "/a/c.html"}, names)
+
+	// Only run 1, in which /a/c.html is missing.
+	srs = planAndExecute(t, runs[:1], idx, parsed.RunQuery.AbstractQuery)
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/a/b.html", srs[0].Test)
+}
+
+func TestContainsFold(t *testing.T) {
+	assert.True(t, containsFold("/css/CSSOM/x.html", "cssom"))
+	assert.True(t, containsFold("/a/b.html", ""))
+	assert.False(t, containsFold("/a/b.html", "c"))
+	// All forms of sigma fold together; lowercasing alone misses the final form.
+	assert.True(t, containsFold("/i18n/ΟΔΟΣ.html", "οδος"))
+	assert.True(t, containsFold("/i18n/οδός.html", "ΌΣ"))
+	// Kelvin sign and long s fold to ASCII letters.
+	assert.True(t, containsFold("/a/\u212Aey-ſet.html", "key-set"))
+	// Turkish dotted and dotless i are not folded to ASCII i.
+	assert.False(t, containsFold("/tr/İstanbul.html", "istanbul"))
+	assert.False(t, containsFold("/tr/ıi.html", "II"))
+	assert.True(t, containsFold("/tr/İstanbul.html", "İSTANBUL"))
+	// The folded pattern may differ in length (in bytes) from the match.
+	assert.True(t, containsFold("/a/\u212A.html", "/k."))
+}
+
+func TestBindExecute_TestNamePatternIgnoreCase(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/i18n/ΣΟΦΊΑ.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/i18n/İstanbul.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/i18n/ascii.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	srs := planAndExecute(t, runs, idx, query.TestNamePattern{Pattern: "σοφία", IgnoreCase: true})
+	assert.Equal(t, 1, len(srs))
+	assert.Equal(t, "/i18n/ΣΟΦΊΑ.html", srs[0].Test)
+
+	srs = planAndExecute(t, runs, idx, query.TestNamePattern{Pattern: "istanbul", IgnoreCase: true})
+	assert.Equal(t, 0, len(srs))
+
+	srs = planAndExecute(t, runs, idx, query.TestNamePattern{Pattern: "ASCII", IgnoreCase: true})
+	assert.Equal(t, 1, len(srs))
+
+	srs = planAndExecute(t, runs, idx, query.TestNamePattern{Pattern: "ASCII"})
+	assert.Equal(t, 0, len(srs))
+}
+
+func TestBindExecute_TestNamePatterns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	loader := NewMockReportLoader(ctrl)
+	idx, err := NewShardedWPTIndex(loader, testNumShards)
+	assert.Nil(t, err)
+
+	runs := mockTestRuns(loader, idx, []testRunData{
+		testRunData{
+			shared.TestRun{ID: 1},
+			&metrics.TestResultsReport{
+				Results: []*metrics.TestResults{
+					&metrics.TestResults{Test: "/css/flexbox/grid-in-flex.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/flexbox/basic.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/css/grid/basic.html", Status: "PASS"},
+					&metrics.TestResults{Test: "/dom/basic.html", Status: "PASS"},
+				},
+			},
+		},
+	})
+
+	testNames := func(q query.AbstractQuery) []string {
+		srs := planAndExecute(t, runs, idx, q)
+		names := make([]string, 0, len(srs))
+		for _, sr := range srs {
+			names = append(names, sr.Test)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"/css/flexbox/grid-in-flex.html"}, testNames(query.TestNamePattern{
+		Patterns: []string{"flexbox", "grid"},
+		MatchAll: true,
+	}))
+	assert.Equal(t, []string{
+		"/css/flexbox/basic.html",
+		"/css/flexbox/grid-in-flex.html",
+		"/css/grid/basic.html",
+	}, testNames(query.TestNamePattern{
+		Patterns: []string{"flexbox", "grid"},
+	}))
+	assert.Equal(t, []string{"/css/grid/basic.html"}, testNames(query.TestNamePattern{
+		Patterns:   []string{"GRID", "BASIC"},
+		MatchAll:   true,
+		IgnoreCase: true,
+	}))
+	// The legacy single-pattern form.
+	assert.Equal(t, []string{"/dom/basic.html"}, testNames(query.TestNamePattern{Pattern: "/dom/"}))
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// Iterate returns a ResultIterator over the results of a ShardedFilter, as
+// Execute would aggregate them (opts.CountOnly is ignored), that evaluates the
+// filter only as results are consumed. Shards are visited one at a time; when a
+// shard is first reached its tests are grouped by top-level test, and each
+// group is filtered and aggregated only when Next needs another result. Shard
+// locks are not held between calls to Next. Results are produced in no
+// particular order, up to the result limit that the filter was bound with.
+func (fs ShardedFilter) Iterate(runs []shared.TestRun, opts query.AggregationOpts) query.ResultIterator {
+	rus := make([]RunID, len(runs))
+	for i := range runs {
+		rus[i] = RunID(runs[i].ID)
+	}
+	return &filterIterator{
+		fs:    fs,
+		rus:   rus,
+		opts:  opts,
+		limit: fs.maxResults(),
+	}
+}
+
+// filterIterator is the query.ResultIterator of a ShardedFilter.
+type filterIterator struct {
+	fs    ShardedFilter
+	rus   []RunID
+	opts  query.AggregationOpts
+	limit int
+	// count is the number of results produced so far.
+	count int
+	// shard is the index of the filter currently being iterated, and groups
+	// are its remaining tests, grouped by top-level test (nil before the shard
+	// is first reached).
+	shard  int
+	groups [][]TestID
+}
+
+func (it *filterIterator) Next() (query.SearchResult, bool) {
+	if it.limit > 0 && it.count >= it.limit {
+		return query.SearchResult{}, false
+	}
+	for ; it.shard < len(it.fs); it.shard++ {
+		if sr, ok := it.syncNext(it.fs[it.shard]); ok {
+			it.count++
+			return sr, true
+		}
+		it.groups = nil
+	}
+	return query.SearchResult{}, false
+}
+
+// syncNext produces the next result of f, if any, consuming groups of tests
+// until one matches.
+func (it *filterIterator) syncNext(f filter) (query.SearchResult, bool) {
+	idx := f.idx()
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	if it.groups == nil {
+		it.groups = groupTests(idx.tests)
+	}
+	for len(it.groups) > 0 {
+		group := it.groups[0]
+		it.groups = it.groups[1:]
+
+		agg := newIndexAggregator(idx, it.rus, it.opts)
+		for _, t := range group {
+			if !f.Filter(t) {
+				continue
+			}
+			if err := agg.Add(t); err != nil {
+				log.Errorf("Error iterating filter query: %v: %v", f, err)
+			}
+		}
+		if agg.Count() > 0 {
+			return agg.Done()[0], true
+		}
+	}
+	return query.SearchResult{}, false
+}
+
+// groupTests groups the tests that ts ranges over by top-level test, so that
+// each group aggregates to (at most) one search result. The result is non-nil.
+func groupTests(ts Tests) [][]TestID {
+	groups := make([][]TestID, 0)
+	byTest := make(map[uint64]int)
+	ts.Range(func(t TestID) bool {
+		i, ok := byTest[t.testID]
+		if !ok {
+			i = len(groups)
+			byTest[t.testID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+		return true
+	})
+	return groups
+}
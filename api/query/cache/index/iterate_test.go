@@ -0,0 +1,78 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+)
+
+func drain(it query.ResultIterator) []query.SearchResult {
+	var srs []query.SearchResult
+	for sr, ok := it.Next(); ok; sr, ok = it.Next() {
+		srs = append(srs, sr)
+	}
+	return srs
+}
+
+func TestIterate_matchesExecute(t *testing.T) {
+	idx, runs := generatedIndex(t, 4, 200)
+
+	for _, aq := range bitsetTestQueries() {
+		q := aq.BindToRuns(runs...)
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		lazy, ok := plan.(query.LazyPlan)
+		assert.True(t, ok)
+
+		for _, opts := range []query.AggregationOpts{
+			query.AggregationOpts{},
+			query.AggregationOpts{InteropFormat: true, IgnoreTestHarnessResult: true},
+		} {
+			iterated := drain(lazy.Iterate(runs, opts))
+			expected := plan.Execute(runs, opts).([]query.SearchResult)
+			assert.Equal(t, len(expected), len(iterated), "Query: %#v", q)
+			assert.True(t, resultSet(t, expected).Equal(resultSet(t, iterated)), "Query: %#v", q)
+		}
+	}
+}
+
+func TestIterate_partial(t *testing.T) {
+	// Each of the 100 tests has a single subtest.
+	idx, runs := generatedIndex(t, 2, 100)
+	plan, err := idx.Bind(runs, query.True{})
+	assert.Nil(t, err)
+
+	evals := 0
+	fs := plan.(ShardedFilter)
+	counted := make(ShardedFilter, len(fs))
+	for i, f := range fs {
+		counted[i] = instrument(f, &evals)
+	}
+
+	it := counted.Iterate(runs, query.AggregationOpts{})
+	for i := 0; i < 3; i++ {
+		_, ok := it.Next()
+		assert.True(t, ok)
+	}
+	// Only the three tests produced, and their subtests, were evaluated.
+	assert.Equal(t, 6, evals)
+
+	assert.Equal(t, 97, len(drain(it)))
+	assert.Equal(t, 200, evals)
+	_, ok := it.Next()
+	assert.False(t, ok)
+}
+
+func TestIterate_maxResults(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 100)
+	plan, _, err := idx.(*shardedWPTIndex).BindWithOpts(runs, query.True{}, query.BindOpts{MaxResults: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(drain(plan.(query.LazyPlan).Iterate(runs, query.AggregationOpts{}))))
+}
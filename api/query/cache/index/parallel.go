@@ -0,0 +1,158 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// parallelCheckInterval is the number of tests that a worker evaluates between
+// checks for cancellation.
+const parallelCheckInterval = 1024
+
+// parallelJob is a partition of the tests of a shard, evaluated by a single
+// worker.
+type parallelJob struct {
+	f     filter
+	tests []TestID
+}
+
+// ExecuteParallel runs a ShardedFilter, as Execute does, but with the tests of
+// each shard partitioned by top-level test across popts.Workers goroutines (so
+// that each search result is aggregated by a single worker), rather than with
+// a goroutine per shard. Search results are sorted by test name and, when the
+// filter was bound with a result limit, the first results in that order are
+// kept. ctx is checked periodically during evaluation; if it is done before
+// execution completes, ctx.Err() is returned.
+func (fs ShardedFilter) ExecuteParallel(parent context.Context, runs []shared.TestRun, opts query.AggregationOpts, popts query.ParallelOpts) (interface{}, error) {
+	workers := popts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	rus := make([]RunID, len(runs))
+	for i := range runs {
+		rus[i] = RunID(runs[i].ID)
+	}
+
+	// Workers cancel ctx on failure, so that the remaining jobs are abandoned.
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	jobs := make(chan parallelJob)
+	results := make(chan aggregator)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				agg, err := syncRunParallelJob(ctx, rus, job, opts)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				results <- agg
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, f := range fs {
+			for _, tests := range syncPartitionTests(f.idx(), workers) {
+				select {
+				case jobs <- parallelJob{f, tests}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	count := 0
+	srs := make([]query.SearchResult, 0)
+	for agg := range results {
+		if opts.CountOnly {
+			count += agg.Count()
+		} else {
+			srs = append(srs, agg.Done()...)
+		}
+	}
+	// The first error is that of the first failure; any others may be due to
+	// the resulting cancellation.
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.CountOnly {
+		return count, nil
+	}
+	sort.Slice(srs, func(i, j int) bool { return srs[i].Test < srs[j].Test })
+	if limit := fs.maxResults(); limit > 0 && len(srs) > limit {
+		srs = srs[:limit]
+	}
+	return srs, nil
+}
+
+// syncPartitionTests partitions the tests of idx into (at most) n partitions,
+// keeping each top-level test and its subtests together.
+func syncPartitionTests(idx index, n int) [][]TestID {
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	partitions := make([][]TestID, n)
+	idx.tests.Range(func(t TestID) bool {
+		i := t.testID % uint64(n)
+		partitions[i] = append(partitions[i], t)
+		return true
+	})
+	nonEmpty := partitions[:0]
+	for _, p := range partitions {
+		if len(p) > 0 {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return nonEmpty
+}
+
+// syncRunParallelJob aggregates the tests of job that match its filter. When the
+// filter was bound with a result limit, only the aggregation of the job's own
+// tests is limited; ExecuteParallel limits the combined results.
+func syncRunParallelJob(ctx context.Context, rus []RunID, job parallelJob, opts query.AggregationOpts) (aggregator, error) {
+	idx := job.f.idx()
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+
+	idx.maxResults = 0
+	agg := newAggregator(idx, rus, opts)
+	for i, t := range job.tests {
+		if i%parallelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if job.f.Filter(t) {
+			if err := agg.Add(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return agg, nil
+}
@@ -0,0 +1,100 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestExecuteParallel_matchesExecute(t *testing.T) {
+	idx, runs := generatedIndex(t, 4, 500)
+
+	for _, aq := range bitsetTestQueries() {
+		q := aq.BindToRuns(runs...)
+		plan, err := idx.Bind(runs, q)
+		assert.Nil(t, err)
+		parallel, ok := plan.(query.ParallelPlan)
+		assert.True(t, ok)
+
+		expected := plan.Execute(runs, query.AggregationOpts{}).([]query.SearchResult)
+		sort.Slice(expected, func(i, j int) bool { return expected[i].Test < expected[j].Test })
+		for _, workers := range []int{0, 1, 3} {
+			res, err := parallel.ExecuteParallel(context.Background(), runs, query.AggregationOpts{}, query.ParallelOpts{Workers: workers})
+			assert.Nil(t, err)
+			assert.Equal(t, expected, res, "Query: %#v, workers: %d", q, workers)
+
+			count, err := parallel.ExecuteParallel(context.Background(), runs, query.AggregationOpts{CountOnly: true}, query.ParallelOpts{Workers: workers})
+			assert.Nil(t, err)
+			assert.Equal(t, len(expected), count, "Query: %#v, workers: %d", q, workers)
+		}
+	}
+}
+
+func TestExecuteParallel_maxResults(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 100)
+	all, err := idx.Bind(runs, query.True{})
+	assert.Nil(t, err)
+	expected, err := all.(query.ParallelPlan).ExecuteParallel(context.Background(), runs, query.AggregationOpts{}, query.ParallelOpts{Workers: 4})
+	assert.Nil(t, err)
+
+	plan, _, err := idx.(*shardedWPTIndex).BindWithOpts(runs, query.True{}, query.BindOpts{MaxResults: 5})
+	assert.Nil(t, err)
+	res, err := plan.(query.ParallelPlan).ExecuteParallel(context.Background(), runs, query.AggregationOpts{}, query.ParallelOpts{Workers: 4})
+	assert.Nil(t, err)
+	// The first results in sorted order are kept.
+	assert.Equal(t, expected.([]query.SearchResult)[:5], res)
+}
+
+func TestExecuteParallel_cancelled(t *testing.T) {
+	idx, runs := generatedIndex(t, 2, 100)
+	plan, err := idx.Bind(runs, query.True{})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := plan.(query.ParallelPlan).ExecuteParallel(ctx, runs, query.AggregationOpts{}, query.ParallelOpts{Workers: 2})
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, res)
+}
+
+func benchmarkExecuteParallel(b *testing.B, workers int) {
+	idx, runs := generatedIndex(b, 4, 20000)
+	q := query.AbstractOr{
+		Args: []query.AbstractQuery{
+			query.TestNamePattern{Pattern: "test1"},
+			query.TestStatusEq{Status: shared.TestStatusFail},
+		},
+	}.BindToRuns(runs...)
+	plan, err := idx.Bind(runs, q)
+	if err != nil {
+		b.Fatal(err)
+	}
+	parallel := plan.(query.ParallelPlan)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parallel.ExecuteParallel(context.Background(), runs, query.AggregationOpts{}, query.ParallelOpts{Workers: workers}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkExecuteParallel_serial evaluates with a single worker, for
+// comparison with BenchmarkExecuteParallel_perCPU on multicore machines.
+func BenchmarkExecuteParallel_serial(b *testing.B) {
+	benchmarkExecuteParallel(b, 1)
+}
+
+func BenchmarkExecuteParallel_perCPU(b *testing.B) {
+	benchmarkExecuteParallel(b, 0)
+}
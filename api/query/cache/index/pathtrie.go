@@ -0,0 +1,134 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pathTrie orders a shard's tests (and subtests) by the "/"-separated segments
+// of their names, and indexes that order by a trie of name segments. The tests
+// under any path prefix then occupy a contiguous range of the order.
+type pathTrie struct {
+	order []TestID
+	root  *pathTrieNode
+}
+
+// pathTrieNode is the trie node for a sequence of name segments. Tests whose
+// names end with the node's segment are ordered before those of its children.
+type pathTrieNode struct {
+	// start and end delimit the range of the order occupied by tests whose
+	// names begin with the node's segments.
+	start, end int
+	// children are sorted by segment.
+	segments []string
+	children []*pathTrieNode
+}
+
+// newPathTrie builds a pathTrie over the (non-nil) tests that ts ranges over.
+func newPathTrie(ts Tests) *pathTrie {
+	type entry struct {
+		t        TestID
+		segments []string
+	}
+	var entries []entry
+	ts.Range(func(t TestID) bool {
+		name, _, err := ts.GetName(t)
+		if err == nil {
+			entries = append(entries, entry{t, strings.Split(name, "/")})
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].segments, entries[j].segments
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return entries[i].t.subID < entries[j].t.subID
+	})
+
+	trie := &pathTrie{
+		order: make([]TestID, len(entries)),
+		root:  &pathTrieNode{},
+	}
+	for i, e := range entries {
+		trie.order[i] = e.t
+		node := trie.root
+		node.end = i + 1
+		for _, segment := range e.segments {
+			last := len(node.children) - 1
+			if last < 0 || node.segments[last] != segment {
+				node.segments = append(node.segments, segment)
+				node.children = append(node.children, &pathTrieNode{start: i})
+				last++
+			}
+			node = node.children[last]
+			node.end = i + 1
+		}
+	}
+	return trie
+}
+
+// lookup returns the range of the order occupied by tests whose names have the
+// given prefix, equivalently to strings.HasPrefix. Every segment of the prefix
+// but the last must match a name segment exactly; the last need only be a
+// prefix of the corresponding name segment.
+func (pt *pathTrie) lookup(prefix string) (start, end int) {
+	segments := strings.Split(prefix, "/")
+	node := pt.root
+	for _, segment := range segments[:len(segments)-1] {
+		i := sort.SearchStrings(node.segments, segment)
+		if i == len(node.segments) || node.segments[i] != segment {
+			return 0, 0
+		}
+		node = node.children[i]
+	}
+
+	partial := segments[len(segments)-1]
+	lo := sort.SearchStrings(node.segments, partial)
+	hi := lo
+	for hi < len(node.segments) && strings.HasPrefix(node.segments[hi], partial) {
+		hi++
+	}
+	if lo == hi {
+		return 0, 0
+	}
+	return node.children[lo].start, node.children[hi-1].end
+}
+
+// pathTries caches a pathTrie for each shard's tests, across binds. Tests are
+// only ever added to a shard, so a trie remains valid for as long as the number
+// of tests in the shard is unchanged.
+type pathTries struct {
+	m     sync.Mutex
+	tries map[Tests]*pathTrie
+}
+
+func newPathTries() *pathTries {
+	return &pathTries{tries: make(map[Tests]*pathTrie)}
+}
+
+// syncGet returns the pathTrie for idx's tests, building it if necessary. The
+// caller must hold idx.m for reading.
+func (pt *pathTries) syncGet(idx index) *pathTrie {
+	pt.m.Lock()
+	defer pt.m.Unlock()
+
+	if trie, ok := pt.tries[idx.tests]; ok && len(trie.order) == idx.numTests {
+		return trie
+	}
+	trie := newPathTrie(idx.tests)
+	if len(trie.order) == idx.numTests {
+		pt.tries[idx.tests] = trie
+	}
+	return trie
+}
@@ -0,0 +1,59 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathTrie_lookup(t *testing.T) {
+	names := []string{
+		"/a",
+		"/a/b.html",
+		"/a/b/c.html",
+		"/a/bc.html",
+		"/a-b/c.html",
+		"/ab.html",
+		"/b/a/b.html",
+		"relative/a.html",
+	}
+	sub := "sub"
+	ts := NewTests()
+	for _, name := range names {
+		for _, subName := range []*string{nil, &sub} {
+			id, err := computeTestID(name, subName)
+			assert.Nil(t, err)
+			ts.Add(id, name, subName)
+		}
+	}
+	trie := newPathTrie(ts)
+	assert.Equal(t, 2*len(names), len(trie.order))
+
+	for _, prefix := range []string{
+		"", "/", "/a", "/a/", "/a/b", "/a/b/", "/a/b.html", "/a-", "/a-b/",
+		"/ab", "/b/a/", "/c", "/a/b.html/", "relative", "relative/a",
+	} {
+		start, end := trie.lookup(prefix)
+		var actual []string
+		for _, id := range trie.order[start:end] {
+			name, _, err := ts.GetName(id)
+			assert.Nil(t, err)
+			actual = append(actual, name)
+		}
+		var expected []string
+		for _, id := range trie.order {
+			name, _, _ := ts.GetName(id)
+			if strings.HasPrefix(name, prefix) {
+				expected = append(expected, name)
+			}
+		}
+		assert.Equal(t, expected, actual, "Prefix: %q", prefix)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+var errNotShardedFilter = errors.New("Plan was not bound by a sharded index")
+
+// Rebind binds the query of a ShardedFilter previously bound by this index over
+// newRuns, replacing run references in the query position by position: the
+// first of the plan's runs is replaced by newRuns[0], and so on. The query is
+// not re-bound from its AbstractQuery, so each new run must be of the same
+// browser as the run it replaces.
+func (i *shardedWPTIndex) Rebind(plan query.Plan, newRuns []shared.TestRun) (query.Plan, error) {
+	fs, ok := plan.(ShardedFilter)
+	if !ok || len(fs) == 0 {
+		return nil, errNotShardedFilter
+	}
+	oldIDs := fs[0].idx().runs
+	if len(oldIDs) != len(newRuns) {
+		return nil, fmt.Errorf("Cannot rebind plan over %d runs to %d runs", len(oldIDs), len(newRuns))
+	}
+	oldRuns, err := i.Runs(oldIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int64]int64, len(newRuns))
+	newIDs := make([]RunID, len(newRuns))
+	for j, run := range newRuns {
+		if oldRuns[j].BrowserName != run.BrowserName {
+			return nil, fmt.Errorf("Cannot rebind %s run %d to %s run %d", oldRuns[j].BrowserName, oldRuns[j].ID, run.BrowserName, run.ID)
+		}
+		ids[oldRuns[j].ID] = run.ID
+		newIDs[j] = RunID(run.ID)
+	}
+
+	q, err := filterQuery(fs[0])
+	if err != nil {
+		return nil, err
+	}
+	q = query.RemapRuns(q, ids)
+
+	idxs, _, err := i.syncExtractRuns(newIDs, false)
+	if err != nil {
+		return nil, err
+	}
+	rebound := make(ShardedFilter, len(idxs))
+	for j, idx := range idxs {
+		idx.boundRuns = newRuns
+		idx.maxResults = fs[0].idx().maxResults
+		if rebound[j], err = newFilter(idx, q); err != nil {
+			return nil, err
+		}
+	}
+	return rebound, nil
+}
+
+// filterQuery is the ConcreteQuery that a filter was bound from.
+func filterQuery(f filter) (query.ConcreteQuery, error) {
+	switch v := f.(type) {
+	case Count:
+		args, err := filterQueries(v.args)
+		if err != nil {
+			return nil, err
+		}
+		return query.Count{Count: v.count, Args: args}, nil
+	case And:
+		args, err := filterQueries(v.args)
+		if err != nil {
+			return nil, err
+		}
+		return query.And{Args: args}, nil
+	case Or:
+		args, err := filterQueries(v.args)
+		if err != nil {
+			return nil, err
+		}
+		return query.Or{Args: args}, nil
+	case Not:
+		arg, err := filterQuery(v.arg)
+		if err != nil {
+			return nil, err
+		}
+		return query.Not{Arg: arg}, nil
+	default:
+		q := leafQuery(f)
+		if q == nil {
+			return nil, fmt.Errorf("Unknown filter type %T", f)
+		}
+		return q, nil
+	}
+}
+
+func filterQueries(fs []filter) ([]query.ConcreteQuery, error) {
+	qs := make([]query.ConcreteQuery, len(fs))
+	for i, f := range fs {
+		q, err := filterQuery(f)
+		if err != nil {
+			return nil, err
+		}
+		qs[i] = q
+	}
+	return qs, nil
+}
@@ -0,0 +1,66 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package index
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/api/query"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestRebind_matchesFreshBind(t *testing.T) {
+	idx, runs := generatedIndex(t, 6, 500)
+	rebinder, ok := idx.(query.Rebinder)
+	assert.True(t, ok)
+	// Runs alternate between browsers, so runs[3:] are of the same browsers as
+	// runs[:3], in the same order.
+	oldRuns, newRuns := runs[:3], runs[3:]
+
+	for _, aq := range bitsetTestQueries() {
+		plan, err := idx.Bind(oldRuns, aq.BindToRuns(oldRuns...))
+		assert.Nil(t, err)
+		rebound, err := rebinder.Rebind(plan, newRuns)
+		assert.Nil(t, err)
+
+		q := aq.BindToRuns(newRuns...)
+		fresh, err := idx.Bind(newRuns, q)
+		assert.Nil(t, err)
+
+		expected := fresh.Execute(newRuns, query.AggregationOpts{}).([]query.SearchResult)
+		actual := rebound.Execute(newRuns, query.AggregationOpts{}).([]query.SearchResult)
+		assert.Equal(t, len(expected), len(actual), "Query: %#v", q)
+		assert.True(t, resultSet(t, expected).Equal(resultSet(t, actual)), "Query: %#v", q)
+	}
+}
+
+func TestRebind_errors(t *testing.T) {
+	idx, runs := generatedIndex(t, 6, 10)
+	rebinder := idx.(query.Rebinder)
+	q := query.TestStatusEq{Status: shared.TestStatusPass}.BindToRuns(runs[:2]...)
+	plan, err := idx.Bind(runs[:2], q)
+	assert.Nil(t, err)
+
+	// Different number of runs.
+	_, err = rebinder.Rebind(plan, runs[3:4])
+	assert.NotNil(t, err)
+	// Runs of different browsers.
+	_, err = rebinder.Rebind(plan, runs[4:6])
+	assert.NotNil(t, err)
+	// A plan not bound by a sharded index.
+	bb, err := NewBitsetBinder(idx)
+	assert.Nil(t, err)
+	bitsetPlan, err := bb.Bind(runs[:2], q)
+	assert.Nil(t, err)
+	_, err = rebinder.Rebind(bitsetPlan, runs[3:5])
+	assert.NotNil(t, err)
+
+	rebound, err := rebinder.Rebind(plan, runs[3:5])
+	assert.Nil(t, err)
+	assert.NotNil(t, rebound)
+}
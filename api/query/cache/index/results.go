@@ -43,6 +43,9 @@ type RunResults interface {
 	// GetResult looks up the ResultID associated with a TestID; the
 	// "status unknown" value is used if the lookup yields no ResultID.
 	GetResult(TestID) ResultID
+	// HasResult reports whether a ResultID is stored for a TestID, which
+	// distinguishes a missing result from a stored UNKNOWN one.
+	HasResult(TestID) bool
 }
 
 type resultsMap struct {
@@ -102,3 +105,8 @@ func (rrs *runResultsMap) GetResult(t TestID) ResultID {
 	}
 	return re
 }
+
+func (rrs *runResultsMap) HasResult(t TestID) bool {
+	_, ok := rrs.byTest[t]
+	return ok
+}
@@ -44,4 +44,6 @@ func TestAddForRunGetResult(t *testing.T) {
 	assert.NotNil(t, rrs)
 	assert.Equal(t, re, rrs.GetResult(te))
 	assert.Equal(t, ResultID(shared.TestStatusUnknown), rrs.GetResult(TestID{1, 1}))
+	assert.True(t, rrs.HasResult(te))
+	assert.False(t, rrs.HasResult(TestID{1, 1}))
 }
@@ -85,6 +85,19 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	store, err := getDatastore()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open datastore: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	// Resolve run aliases before limiting and binding to the requested runs.
+	rq, err = rq.ResolveRuns(query.NewStoreRunResolver(store))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if len(rq.RunIDs) > *maxRunsPerRequest {
 		http.Error(w, maxRunsPerRequestMsg, http.StatusBadRequest)
 		return
@@ -101,12 +114,6 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	//
 	// `ids` and `runs` tracks run IDs and run metadata for requested runs that
 	// are currently resident in `idx`.
-	store, err := getDatastore()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to open datastore: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-
 	ids := make([]int64, 0, len(rq.RunIDs))
 	runs := make([]shared.TestRun, 0, len(rq.RunIDs))
 	missing := make([]shared.TestRun, 0, len(rq.RunIDs))
@@ -145,6 +152,31 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reject queries that cannot match anything over the requested runs, whether
+	// or not the runs are resident in `idx` yet, and likewise requested columns
+	// that match none of the runs.
+	requested := append(append([]shared.TestRun{}, runs...), missing...)
+	if err := query.Validate(rq.AbstractQuery, requested); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selected, err := rq.SelectColumns(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only the resident runs of the requested browsers, if any, are reported.
+	resident := make(map[int64]bool)
+	for _, run := range runs {
+		resident[run.ID] = true
+	}
+	columns := make([]shared.TestRun, 0, len(selected))
+	for _, run := range selected {
+		if resident[run.ID] {
+			columns = append(columns, run)
+		}
+	}
+
 	// Prepare user query based on `ids` that are (or at least were a moment ago)
 	// resident in `idx`. In the unlikely event that a run in `ids`/`runs` is no
 	// longer in `idx`, `idx.Bind()` below will return an error.
@@ -155,6 +187,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	_, subtests := urlQuery["subtests"]
 	_, interop := urlQuery["interop"]
 	_, diff := urlQuery["diff"]
+	_, statuses := urlQuery["statuses"]
 	diffFilter, _, err := shared.ParseDiffFilterParams(urlQuery)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -164,6 +197,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		IncludeSubtests:         subtests,
 		InteropFormat:           interop,
 		IncludeDiff:             diff,
+		IncludeStatuses:         statuses,
 		DiffFilter:              diffFilter,
 		IgnoreTestHarnessResult: shared.IsFeatureEnabled(store, "ignoreHarnessInTotal"),
 	}
@@ -173,7 +207,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results := plan.Execute(runs, opts)
+	results := plan.Execute(columns, opts)
 	res, ok := results.([]query.SearchResult)
 	if !ok {
 		http.Error(w, "Search index returned bad results", http.StatusInternalServerError)
@@ -194,7 +228,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	// - (If no other error occurs) return `http.StatusUnprocessableEntity` to
 	//   client.
 	resp := query.SearchResponse{
-		Runs:    runs,
+		Runs:    columns,
 		Results: res,
 	}
 	if len(missing) != 0 {
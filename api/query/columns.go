@@ -0,0 +1,43 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// SelectColumns returns the runs, of those that the RunQuery is bound to, whose
+// results are to be returned: the runs of the browsers in the RunQuery's
+// Columns, in their original order, or all of the runs when there are no
+// Columns. Executing a plan over the selected runs, rather than all of the
+// bound runs, produces status vectors for the requested browsers only; the
+// query itself is still evaluated over all of the bound runs. Selecting a
+// column for which there is no run fails.
+func (rq RunQuery) SelectColumns(runs []shared.TestRun) ([]shared.TestRun, error) {
+	if len(rq.Columns) == 0 {
+		return runs, nil
+	}
+
+	columns := make(map[string]bool)
+	for _, column := range rq.Columns {
+		columns[column] = false
+	}
+	selected := make([]shared.TestRun, 0, len(runs))
+	for _, run := range runs {
+		browserName := canonicalizeStr(run.BrowserName)
+		if _, ok := columns[browserName]; ok {
+			columns[browserName] = true
+			selected = append(selected, run)
+		}
+	}
+	for _, column := range rq.Columns {
+		if !columns[column] {
+			return nil, fmt.Errorf(`Unknown column "%s": none of the runs is of that browser`, column)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,85 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestRunQuery_columns(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [1, 2, 3],
+		"columns": ["Chrome", "firefox", "chrome"],
+		"query": {"status": "PASS"}
+	}`), &rq)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{1, 2, 3},
+		Columns:       []string{"chrome", "firefox"},
+		AbstractQuery: TestStatusEq{Status: shared.TestStatusPass},
+	}, rq)
+
+	data, err := json.Marshal(rq)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"run_ids":[1,2,3],"columns":["chrome","firefox"],"query":{"status":"PASS"}}`, string(data))
+
+	err = json.Unmarshal([]byte(`{"run_ids": [1], "columns": ["not-a-browser"]}`), &rq)
+	assert.NotNil(t, err)
+
+	// The columns property is known, even when unknown properties are rejected.
+	_, err = Parse([]byte(`{"run_ids": [1], "columns": ["chrome"]}`), RejectUnknownKeys())
+	assert.Nil(t, err)
+}
+
+func TestRunQuery_columnsResolveRuns(t *testing.T) {
+	rq := RunQuery{
+		RunAliases:    []shared.ProductSpec{shared.ParseProductSpecUnsafe("chrome[stable]")},
+		Columns:       []string{"chrome"},
+		AbstractQuery: True{},
+	}
+	resolved, err := rq.ResolveRuns(stubRunResolver(map[string]int64{"chrome[stable]": 1}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"chrome"}, resolved.Columns)
+}
+
+func TestRunQuery_SelectColumns(t *testing.T) {
+	runs := []shared.TestRun{
+		shared.TestRun{
+			ID:                1,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                2,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("firefox").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                3,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("safari").ProductAtRevision,
+		},
+		shared.TestRun{
+			ID:                4,
+			ProductAtRevision: shared.ParseProductSpecUnsafe("chrome").ProductAtRevision,
+		},
+	}
+
+	selected, err := RunQuery{}.SelectColumns(runs)
+	assert.Nil(t, err)
+	assert.Equal(t, runs, selected)
+
+	// Runs keep their original order, regardless of the order of the columns.
+	selected, err = RunQuery{Columns: []string{"safari", "chrome"}}.SelectColumns(runs)
+	assert.Nil(t, err)
+	assert.Equal(t, []shared.TestRun{runs[0], runs[2], runs[3]}, selected)
+
+	_, err = RunQuery{Columns: []string{"chrome", "edge"}}.SelectColumns(runs)
+	assert.EqualError(t, err, `Unknown column "edge": none of the runs is of that browser`)
+}
@@ -5,6 +5,10 @@
 package query
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	"github.com/web-platform-tests/wpt.fyi/shared"
 )
 
@@ -16,6 +20,16 @@ type AggregationOpts struct {
 	IncludeDiff             bool
 	IgnoreTestHarnessResult bool // Don't +1 the "OK" status for testharness tests.
 	DiffFilter              shared.DiffFilterParam
+	// CountOnly executes a plan to produce the number of matching tests, as an
+	// int, rather than a list of search results. The query must still be fully
+	// evaluated for every test (including all arguments of boolean nodes that
+	// are not short-circuited), so this only saves assembling results. It is
+	// supported by plans of the in-memory index's reference Binder.
+	CountOnly bool
+	// IncludeStatuses adds the status of each matching test in each run to its
+	// search result, as SearchResult.Statuses, with StatusMissing for runs that
+	// have no result for the test.
+	IncludeStatuses bool
 }
 
 // Binder is a mechanism for binding a query over a slice of test runs to
@@ -29,11 +43,175 @@ type Binder interface {
 	Bind([]shared.TestRun, ConcreteQuery) (Plan, error)
 }
 
+// BindOpts are options for binding a query to a query service mechanism.
+type BindOpts struct {
+	// AllowPartial permits binding when some runs cannot be resolved. Such runs
+	// are bound with no results, and reported as BindWarnings rather than
+	// causing binding to fail.
+	AllowPartial bool
+	// MaxResults, when positive, caps the number of search results that
+	// executing the Plan collects; tests matched beyond the cap are dropped, and
+	// the truncation is reported by TruncatingPlan. Counts produced with
+	// AggregationOpts.CountOnly are not capped.
+	MaxResults int
+}
+
+// BindWarning is a non-fatal issue encountered while binding a query, such as a
+// run that could not be resolved and was bound with no results.
+type BindWarning struct {
+	Run     int64  `json:"run_id"`
+	Message string `json:"message"`
+}
+
+// PartialBinder is a Binder that can optionally bind over runs that it is only
+// partially able to resolve.
+type PartialBinder interface {
+	Binder
+
+	// BindWithOpts behaves like Bind, but respects the given BindOpts. When
+	// opts.AllowPartial is set, any runs that cannot be resolved are bound with
+	// no results and described by the returned BindWarnings. Results for such
+	// runs are treated as missing (i.e., status UNKNOWN); executing the Plan over
+	// them still produces an empty result column for each.
+	BindWithOpts([]shared.TestRun, ConcreteQuery, BindOpts) (Plan, []BindWarning, error)
+}
+
+// Rebinder is a Binder that can bind an existing Plan over a different slice
+// of runs, reusing the structure of the bound query rather than binding an
+// AbstractQuery afresh. New runs replace the runs of the Plan position by
+// position; the result matches that of a fresh bind only when each new run
+// satisfies the same product constraints as the run it replaces.
+type Rebinder interface {
+	Binder
+
+	// Rebind produces a Plan equivalent to plan, bound over newRuns instead.
+	Rebind(plan Plan, newRuns []shared.TestRun) (Plan, error)
+}
+
+// MatchEstimator is a Binder that can quickly estimate how many tests a query
+// matches over the given runs without executing it, e.g., for admission
+// control. Estimates are approximate and may differ substantially from actual
+// counts.
+type MatchEstimator interface {
+	Binder
+
+	// EstimateMatches estimates the number of tests and subtests that q matches
+	// over runs, as counted by QueryStats.TestsMatched.
+	EstimateMatches(q ConcreteQuery, runs []shared.TestRun) int
+}
+
+// NodeCost is the estimated cost of a node of a ConcreteQuery tree, as reported
+// by CostExplainer.
+type NodeCost struct {
+	// Type is the type of the node, e.g., "And" or "RunTestStatusEq".
+	Type string `json:"type"`
+	// Depth is the depth of the node in the tree; the root is at depth 0.
+	Depth int `json:"depth"`
+	// InputRows is the estimated number of tests and subtests for which the
+	// node is evaluated, accounting for And and Or evaluating their arguments
+	// lazily.
+	InputRows int `json:"input_rows"`
+	// OutputRows is the estimated number of those tests and subtests that the
+	// node matches.
+	OutputRows int `json:"output_rows"`
+}
+
+// CostExplainer is a MatchEstimator that can break its estimate down by the
+// nodes of a query, exposing where selectivity is lost.
+type CostExplainer interface {
+	MatchEstimator
+
+	// CostBreakdown estimates the rows into and out of each node of q over
+	// runs, in depth-first order (each node precedes its arguments). The
+	// OutputRows of the root are the estimate of EstimateMatches.
+	CostBreakdown(q ConcreteQuery, runs []shared.TestRun) []NodeCost
+}
+
 // Plan a query execution plan that returns results.
 type Plan interface {
 	// Execute runs the query execution plan. The result set type depends on the
 	// underlying query service mechanism that the Plan was bound with.
 	Execute([]shared.TestRun, AggregationOpts) interface{}
+	// Cost estimates the work of executing the plan, in units defined by the
+	// underlying query service mechanism. Unlike RunQuery.EstimatedCost, it may
+	// use knowledge of the bound runs (e.g., their numbers of results), so it is
+	// better suited to admission control.
+	Cost() int
+}
+
+// QueryStats describes the work performed while executing a Plan. Tests are
+// counted individually for each test and subtest considered.
+type QueryStats struct {
+	TestsScanned   int           `json:"tests_scanned"`
+	TestsMatched   int           `json:"tests_matched"`
+	AtomsEvaluated int           `json:"atoms_evaluated"`
+	WallTime       time.Duration `json:"wall_time"`
+}
+
+// StatsPlan is a Plan that can also report statistics about its execution.
+type StatsPlan interface {
+	Plan
+
+	// ExecuteWithStats runs the query execution plan, as Execute does, also
+	// returning QueryStats for the execution.
+	ExecuteWithStats([]shared.TestRun, AggregationOpts) (interface{}, QueryStats)
+}
+
+// TruncatingPlan is a Plan that can also report whether its results were
+// truncated by the result limit that it was bound with (see
+// BindOpts.MaxResults).
+type TruncatingPlan interface {
+	Plan
+
+	// ExecuteWithTruncation runs the query execution plan, as Execute does, also
+	// returning whether matching tests were dropped from the results.
+	ExecuteWithTruncation([]shared.TestRun, AggregationOpts) (interface{}, bool)
+}
+
+// DryRunPlan is a Plan that can also report how many tests each of its atoms
+// would match, evaluated independently of the others, without executing it.
+type DryRunPlan interface {
+	Plan
+
+	// DryRun returns the number of tests matched by each atom of the plan, keyed
+	// by a description of the atom.
+	DryRun([]shared.TestRun) map[string]int
+}
+
+// ParallelOpts configures the parallel execution of a ParallelPlan.
+type ParallelOpts struct {
+	// Workers is the number of goroutines that evaluate the plan. When not
+	// positive, one worker per CPU is used.
+	Workers int
+}
+
+// ParallelPlan is a Plan that can also evaluate its tests in parallel, across a
+// configurable number of workers, with cancellation.
+type ParallelPlan interface {
+	Plan
+
+	// ExecuteParallel runs the query execution plan, as Execute does, but with
+	// its tests partitioned across workers, producing results that are sorted
+	// by test name. It returns ctx.Err() when ctx is done before execution
+	// completes.
+	ExecuteParallel(context.Context, []shared.TestRun, AggregationOpts, ParallelOpts) (interface{}, error)
+}
+
+// ResultIterator iterates over the search results of a query execution.
+type ResultIterator interface {
+	// Next returns the next search result, or false when there are no more.
+	Next() (SearchResult, bool)
+}
+
+// LazyPlan is a Plan that can also produce its results lazily, evaluating tests
+// only as results are consumed, so that consumers that stop early (e.g., after
+// a page of results) don't pay for the remainder of the result set.
+type LazyPlan interface {
+	Plan
+
+	// Iterate returns a ResultIterator over the results that Execute would
+	// return (opts.CountOnly aside), without evaluating the plan up front.
+	Iterate([]shared.TestRun, AggregationOpts) ResultIterator
 }
 
 // ConcreteQuery is an AbstractQuery that has been bound to specific test runs.
@@ -66,6 +244,231 @@ type RunTestStatusNeq struct {
 	Status shared.TestStatus
 }
 
+// RunTestStatusIn constrains search results to include only test results from a
+// particular run that have any one of a set of test status values. It is the
+// merged form of a disjunction of RunTestStatusEq values over the same run.
+type RunTestStatusIn struct {
+	Run      int64
+	Statuses []shared.TestStatus
+}
+
+// AnyRunTestStatusEq constrains search results to include only test results
+// where at least one of the given runs has a particular test status value.
+type AnyRunTestStatusEq struct {
+	Runs   []int64
+	Status shared.TestStatus
+}
+
+// RunHasScreenshot constrains search results to include only tests that have a
+// screenshot artifact in a particular run.
+type RunHasScreenshot struct {
+	Run int64
+}
+
+// RunSkipped constrains search results to include only tests that were skipped
+// in a particular run: either with a SKIP result, or because the run's metadata
+// marks the test as disabled.
+type RunSkipped struct {
+	Run int64
+}
+
+// RunIntermittent constrains search results to include only tests whose result
+// in a particular run is flagged as known to be intermittent by the run's
+// metadata.
+type RunIntermittent struct {
+	Run int64
+}
+
+// RunAssertCount constrains search results to include only tests whose number
+// of assertions run, in a particular run, is within the range [Min, Max]. A
+// negative Max imposes no upper bound.
+type RunAssertCount struct {
+	Run int64
+	Min int
+	Max int
+}
+
+// RunUnexpected constrains search results to include only tests whose result
+// in a particular run differs from the status expected by the run's metadata
+// (or, absent an expectation, is neither PASS nor OK).
+type RunUnexpected struct {
+	Run int64
+}
+
+// RunSubtestPlurality constrains search results to include only tests whose
+// subtests, in a particular run, have the given status more often than any
+// other status.
+type RunSubtestPlurality struct {
+	Run    int64
+	Status shared.TestStatus
+}
+
+// RunHarnessMessage constrains search results to include only tests whose
+// harness-level message, in a particular run, contains Pattern.
+type RunHarnessMessage struct {
+	Run     int64
+	Pattern string
+}
+
+// RunAllSubtestsPass constrains search results to include only tests that
+// fully pass in a particular run: every subtest passes or, for tests without
+// subtests, the test itself passes.
+type RunAllSubtestsPass struct {
+	Run int64
+}
+
+// RunSubtestPassRatio constrains search results to include only tests whose
+// fraction of passing subtests in a particular run is below Below and above
+// Above. A negative Below or Above imposes no bound. Tests without subtests are
+// not matched.
+type RunSubtestPassRatio struct {
+	Run   int64
+	Below float64
+	Above float64
+}
+
+// RunRefMatch constrains search results to include only reftests whose
+// reference comparison in a particular run is of the given expectation: either
+// RefExpectMatch (==) or RefExpectMismatch (!=).
+type RunRefMatch struct {
+	Run      int64
+	Expected string
+}
+
+// RunRegressed constrains search results to include only tests whose status in
+// the run Run is more severe, according to StatusSeverity, than in the run
+// Baseline. Tests without a result in either run are not matched.
+type RunRegressed struct {
+	Baseline int64
+	Run      int64
+}
+
+// RunStatusChanged constrains search results to include only tests whose status
+// differs between the runs Base and Head. Tests with a result in only one of
+// the runs are matched.
+type RunStatusChanged struct {
+	Base int64
+	Head int64
+}
+
+// AnyRunStatusChanged constrains search results to include only tests whose
+// status differs between the runs Bases[i] and Heads[i] for some i (e.g., a
+// baseline and a current run of each browser), as for RunStatusChanged.
+type AnyRunStatusChanged struct {
+	Bases []int64
+	Heads []int64
+}
+
+// RunsSubtestCountDelta constrains search results to include only tests whose
+// number of subtests differs between the runs From and To or, when Changed is
+// false, those whose number does not differ. Tests with a result in only one of
+// the runs have changed.
+type RunsSubtestCountDelta struct {
+	From    int64
+	To      int64
+	Changed bool
+}
+
+// RunsSameRender constrains search results to include only tests that have a
+// screenshot in both of the runs Left and Right, with the same hash.
+type RunsSameRender struct {
+	Left  int64
+	Right int64
+}
+
+// RunsFlakinessRate constrains search results to include only tests whose
+// status, across the given runs, differs from its most common status in more
+// than the fraction Above of the runs. Runs without a result for a test are not
+// counted.
+type RunsFlakinessRate struct {
+	Runs  []int64
+	Above float64
+}
+
+// AnyRunSubtestTotal constrains search results to include only tests where, in
+// at least one of the given runs, the total number of subtests is within the
+// range [Min, Max]. A negative Max imposes no upper bound.
+type AnyRunSubtestTotal struct {
+	Runs []int64
+	Min  int
+	Max  int
+}
+
+// AnyRunLongTimeout constrains search results to include only tests that are
+// marked as having a long timeout in the WPT manifest associated with at least
+// one of the given runs.
+type AnyRunLongTimeout struct {
+	Runs []int64
+}
+
+// AnyRunHasMetadataField constrains search results to include only tests whose
+// triage metadata, associated with at least one of the given runs, defines the
+// given field.
+type AnyRunHasMetadataField struct {
+	Runs  []int64
+	Field string
+}
+
+// PresentInAllBrowsers constrains search results to include only tests that
+// have a non-UNKNOWN status in at least one run of each group of runs, where
+// each group contains the runs of a distinct browser.
+type PresentInAllBrowsers struct {
+	RunsByBrowser [][]int64
+}
+
+// BrowsersFailingCount constrains search results to include only tests for
+// which the number of groups of runs with a failing result (i.e., a status
+// other than PASS, OK or UNKNOWN) in at least one of the group's runs compares
+// to Count by Comparison (see BrowsersFailing), where each group contains the
+// runs of a distinct browser.
+type BrowsersFailingCount struct {
+	RunsByBrowser [][]int64
+	Count         int
+	Comparison    string
+}
+
+// BrowserWorseThanMedian constrains search results to include only tests for
+// which the result of the group of runs RunsByBrowser[Browser] is more severe
+// than the median result across the groups (see WorseThanMedian), where each
+// group contains the runs of a distinct browser.
+type BrowserWorseThanMedian struct {
+	Browser       int
+	RunsByBrowser [][]int64
+}
+
+// BrowserSubtestOnlyIn constrains search results to include only subtests named
+// Subtest that have a result in some run of the group RunsByBrowser[Browser],
+// and in no run of the other groups, where each group contains the runs of a
+// distinct browser.
+type BrowserSubtestOnlyIn struct {
+	Browser       int
+	RunsByBrowser [][]int64
+	Subtest       string
+}
+
+// RunsWithResults constrains search results to include only tests that have a
+// result in at least Min of the given runs.
+type RunsWithResults struct {
+	Runs []int64
+	Min  int
+}
+
+// FirstSeenAfter constrains search results to include only tests that have a
+// result in at least one of the After runs, and in none of the Before runs.
+type FirstSeenAfter struct {
+	Before []int64
+	After  []int64
+}
+
+// AnyRunMatches constrains search results to include only tests for which the
+// Matcher of the custom atom with the given Key matches in at least one of the
+// given runs.
+type AnyRunMatches struct {
+	Key     string
+	Matcher Matcher
+	Runs    []int64
+}
+
 // Or is a logical disjunction of ConcreteQuery instances.
 type Or struct {
 	Args []ConcreteQuery
@@ -81,6 +484,66 @@ type Not struct {
 	Arg ConcreteQuery
 }
 
+// Grow ensures that n more arguments can be appended to the disjunction without
+// reallocating its arguments, for callers that know how many they will append.
+func (o *Or) Grow(n int) {
+	o.Args = growArgs(o.Args, n)
+}
+
+// Append appends qs to the arguments of the disjunction, for building one
+// incrementally.
+func (o *Or) Append(qs ...ConcreteQuery) {
+	o.Args = append(o.Args, qs...)
+}
+
+// Build returns the disjunction built so far: False for no arguments, the sole
+// argument for one, or else an Or of the arguments, whose capacity is trimmed so
+// that appending to the result cannot overwrite arguments that are appended to
+// o later.
+func (o *Or) Build() ConcreteQuery {
+	switch len(o.Args) {
+	case 0:
+		return False{}
+	case 1:
+		return o.Args[0]
+	}
+	return Or{Args: o.Args[:len(o.Args):len(o.Args)]}
+}
+
+// Grow ensures that n more arguments can be appended to the conjunction without
+// reallocating its arguments, for callers that know how many they will append.
+func (a *And) Grow(n int) {
+	a.Args = growArgs(a.Args, n)
+}
+
+// Append appends qs to the arguments of the conjunction, for building one
+// incrementally.
+func (a *And) Append(qs ...ConcreteQuery) {
+	a.Args = append(a.Args, qs...)
+}
+
+// Build returns the conjunction built so far: True for no arguments, the sole
+// argument for one, or else an And of the arguments, trimmed as for Or.Build.
+func (a *And) Build() ConcreteQuery {
+	switch len(a.Args) {
+	case 0:
+		return True{}
+	case 1:
+		return a.Args[0]
+	}
+	return And{Args: a.Args[:len(a.Args):len(a.Args)]}
+}
+
+// growArgs returns args with capacity for at least n more arguments.
+func growArgs(args []ConcreteQuery, n int) []ConcreteQuery {
+	if n <= cap(args)-len(args) {
+		return args
+	}
+	grown := make([]ConcreteQuery, len(args), len(args)+n)
+	copy(grown, args)
+	return grown
+}
+
 // Size of TestNamePattern has a size of 1: servicing such a query requires a
 // substring match per test.
 func (TestNamePattern) Size() int { return 1 }
@@ -89,6 +552,22 @@ func (TestNamePattern) Size() int { return 1 }
 // substring match per test.
 func (TestPath) Size() int { return 1 }
 
+// Size of TestNameIn has a size of 1: servicing such a query requires a set
+// membership check per test.
+func (TestNameIn) Size() int { return 1 }
+
+// Size of ManifestPaths has a size of 1: servicing such a query requires a set
+// membership check (or, for prefixes, a binary search) per test.
+func (ManifestPaths) Size() int { return 1 }
+
+// Size of Shard has a size of 1: servicing such a query requires a hash of the
+// test name per test.
+func (Shard) Size() int { return 1 }
+
+// Size of Subtest has a size of 1: servicing such a query requires a substring
+// match (or string comparison) per test.
+func (Subtest) Size() int { return 1 }
+
 // Size of RunTestStatusEq is 1: servicing such a query requires a single lookup
 // in a test run result mapping per test.
 func (RunTestStatusEq) Size() int { return 1 }
@@ -97,6 +576,120 @@ func (RunTestStatusEq) Size() int { return 1 }
 // lookup in a test run result mapping per test.
 func (RunTestStatusNeq) Size() int { return 1 }
 
+// Size of RunTestStatusIn is 1: servicing such a query requires a single
+// lookup in a test run result mapping per test.
+func (RunTestStatusIn) Size() int { return 1 }
+
+// Size of AnyRunTestStatusEq is 1: Although servicing such a query requires a
+// lookup in each run's result mapping per test, it is planned as a single
+// atom.
+func (AnyRunTestStatusEq) Size() int { return 1 }
+
+// Size of RunHasScreenshot is 1: servicing such a query requires a single
+// lookup in a test run screenshot mapping per test.
+func (RunHasScreenshot) Size() int { return 1 }
+
+// Size of RunSkipped is 1: servicing such a query requires a single lookup in
+// a test run result mapping (and disabled tests) per test.
+func (RunSkipped) Size() int { return 1 }
+
+// Size of RunIntermittent is 1: servicing such a query requires a single lookup
+// in a test run's intermittent tests per test.
+func (RunIntermittent) Size() int { return 1 }
+
+// Size of RunAssertCount is 1: servicing such a query requires a single lookup
+// in a test run's assertion counts per test.
+func (RunAssertCount) Size() int { return 1 }
+
+// Size of RunUnexpected is 1: servicing such a query requires a single lookup
+// in a test run result mapping (and expected statuses) per test.
+func (RunUnexpected) Size() int { return 1 }
+
+// Size of RunSubtestPlurality is 1: servicing such a query requires a single
+// lookup in a test run's subtest pluralities per test.
+func (RunSubtestPlurality) Size() int { return 1 }
+
+// Size of RunHarnessMessage is 1: servicing such a query requires a single
+// lookup in a test run's harness messages per test.
+func (RunHarnessMessage) Size() int { return 1 }
+
+// Size of RunAllSubtestsPass is 1: servicing such a query requires a single
+// lookup in a test run's failing subtests (and subtest totals) per test.
+func (RunAllSubtestsPass) Size() int { return 1 }
+
+// Size of RunSubtestPassRatio is 1: servicing such a query requires a single
+// lookup in a test run's subtest totals (and passing subtests) per test.
+func (RunSubtestPassRatio) Size() int { return 1 }
+
+// Size of RunRefMatch is 1: servicing such a query requires a single lookup in
+// a test run's reftest comparisons per test.
+func (RunRefMatch) Size() int { return 1 }
+
+// Size of RunRegressed is 2: servicing such a query requires a lookup in each
+// of two test run result mappings per test.
+func (RunRegressed) Size() int { return 2 }
+
+// Size of RunStatusChanged is 2: servicing such a query requires a lookup in
+// each of two test run result mappings per test.
+func (RunStatusChanged) Size() int { return 2 }
+
+// Size of AnyRunStatusChanged is the number of compared pairs of runs (e.g., the
+// number of browsers): servicing such a query requires a comparison of results
+// per pair per test.
+func (arsc AnyRunStatusChanged) Size() int { return len(arsc.Heads) }
+
+// Size of RunsSubtestCountDelta is 2: servicing such a query requires a lookup
+// in each of two test runs' subtest totals per test.
+func (RunsSubtestCountDelta) Size() int { return 2 }
+
+// Size of RunsSameRender is 2: servicing such a query requires a lookup in each
+// of two test runs' screenshots per test.
+func (RunsSameRender) Size() int { return 2 }
+
+// Size of RunsFlakinessRate is the number of runs: servicing such a query
+// requires a lookup in each run's result mapping per test.
+func (rfr RunsFlakinessRate) Size() int { return len(rfr.Runs) }
+
+// Size of AnyRunSubtestTotal is 1: servicing such a query requires a lookup in
+// each run's subtest totals per test, but it is planned as a single atom.
+func (AnyRunSubtestTotal) Size() int { return 1 }
+
+// Size of AnyRunLongTimeout is 1: servicing such a query requires a lookup in
+// each run's long timeout tests per test, but it is planned as a single atom.
+func (AnyRunLongTimeout) Size() int { return 1 }
+
+// Size of AnyRunHasMetadataField is 1: servicing such a query requires a lookup
+// in each run's metadata fields per test, but it is planned as a single atom.
+func (AnyRunHasMetadataField) Size() int { return 1 }
+
+// Size of PresentInAllBrowsers is the number of browsers: servicing such a
+// query requires a presence check per browser per test.
+func (p PresentInAllBrowsers) Size() int { return len(p.RunsByBrowser) }
+
+// Size of BrowsersFailingCount is the number of browsers: servicing such a
+// query requires a failure check per browser per test.
+func (b BrowsersFailingCount) Size() int { return len(b.RunsByBrowser) }
+
+// Size of BrowserWorseThanMedian is the number of browsers: servicing such a
+// query requires a result lookup per browser per test.
+func (b BrowserWorseThanMedian) Size() int { return len(b.RunsByBrowser) }
+
+// Size of BrowserSubtestOnlyIn is the number of browsers: servicing such a
+// query requires a result lookup per browser per subtest.
+func (b BrowserSubtestOnlyIn) Size() int { return len(b.RunsByBrowser) }
+
+// Size of RunsWithResults is the number of runs: servicing such a query
+// requires a result lookup per run per test.
+func (r RunsWithResults) Size() int { return len(r.Runs) }
+
+// Size of FirstSeenAfter is 1: servicing such a query requires a scan of the
+// results of each run for the test, which is cheap relative to other atoms.
+func (FirstSeenAfter) Size() int { return 1 }
+
+// Size of AnyRunMatches is the number of runs: servicing such a query requires
+// a call to its Matcher per run per test.
+func (a AnyRunMatches) Size() int { return len(a.Runs) }
+
 // Size of Count is the sum of the sizes of its constituent ConcretQuery instances.
 func (c Count) Size() int { return size(c.Args) }
 
@@ -123,3 +716,378 @@ func size(qs []ConcreteQuery) int {
 	}
 	return s
 }
+
+// Clone produces a deep copy of a ConcreteQuery tree, including the argument
+// slices of And, Or, and Count, so that rewriting the copy in place does not
+// affect the original. Atoms without slices are copied by value.
+func Clone(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case TestNamePattern:
+		if v.Patterns != nil {
+			v.Patterns = append([]string(nil), v.Patterns...)
+		}
+		return v
+	case TestNameIn:
+		return TestNameIn{Names: append([]string(nil), v.Names...)}
+	case ManifestPaths:
+		return ManifestPaths{Paths: append([]string(nil), v.Paths...), Prefix: v.Prefix}
+	case And:
+		return And{Args: cloneAll(v.Args)}
+	case Or:
+		return Or{Args: cloneAll(v.Args)}
+	case Count:
+		return Count{Count: v.Count, Args: cloneAll(v.Args)}
+	case Not:
+		return Not{Arg: Clone(v.Arg)}
+	case RunTestStatusIn:
+		return RunTestStatusIn{Run: v.Run, Statuses: append([]shared.TestStatus(nil), v.Statuses...)}
+	case AnyRunTestStatusEq:
+		return AnyRunTestStatusEq{Runs: append([]int64(nil), v.Runs...), Status: v.Status}
+	case AnyRunSubtestTotal:
+		return AnyRunSubtestTotal{Runs: append([]int64(nil), v.Runs...), Min: v.Min, Max: v.Max}
+	case AnyRunLongTimeout:
+		return AnyRunLongTimeout{Runs: append([]int64(nil), v.Runs...)}
+	case AnyRunHasMetadataField:
+		return AnyRunHasMetadataField{Runs: append([]int64(nil), v.Runs...), Field: v.Field}
+	case RunsFlakinessRate:
+		return RunsFlakinessRate{Runs: append([]int64(nil), v.Runs...), Above: v.Above}
+	case AnyRunStatusChanged:
+		return AnyRunStatusChanged{Bases: append([]int64(nil), v.Bases...), Heads: append([]int64(nil), v.Heads...)}
+	case PresentInAllBrowsers:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		return PresentInAllBrowsers{RunsByBrowser: byBrowser}
+	case BrowsersFailingCount:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		return BrowsersFailingCount{RunsByBrowser: byBrowser, Count: v.Count, Comparison: v.Comparison}
+	case BrowserWorseThanMedian:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		return BrowserWorseThanMedian{Browser: v.Browser, RunsByBrowser: byBrowser}
+	case BrowserSubtestOnlyIn:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		return BrowserSubtestOnlyIn{Browser: v.Browser, RunsByBrowser: byBrowser, Subtest: v.Subtest}
+	case FirstSeenAfter:
+		return FirstSeenAfter{Before: append([]int64(nil), v.Before...), After: append([]int64(nil), v.After...)}
+	case RunsWithResults:
+		return RunsWithResults{Runs: append([]int64(nil), v.Runs...), Min: v.Min}
+	case AnyRunMatches:
+		return AnyRunMatches{Key: v.Key, Matcher: v.Matcher, Runs: append([]int64(nil), v.Runs...)}
+	default:
+		return q
+	}
+}
+
+func cloneAll(qs []ConcreteQuery) []ConcreteQuery {
+	if qs == nil {
+		return nil
+	}
+	cloned := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		cloned[i] = Clone(qs[i])
+	}
+	return cloned
+}
+
+// RemapRuns produces a copy of a ConcreteQuery tree in which each run ID that
+// is a key of ids is replaced by its value. Other run IDs are left untouched.
+func RemapRuns(q ConcreteQuery, ids map[int64]int64) ConcreteQuery {
+	remap := func(run int64) int64 {
+		if id, ok := ids[run]; ok {
+			return id
+		}
+		return run
+	}
+	remapAll := func(runs []int64) []int64 {
+		remapped := make([]int64, len(runs))
+		for i, run := range runs {
+			remapped[i] = remap(run)
+		}
+		return remapped
+	}
+
+	switch v := Clone(q).(type) {
+	case And:
+		return And{Args: remapRunsAll(v.Args, ids)}
+	case Or:
+		return Or{Args: remapRunsAll(v.Args, ids)}
+	case Count:
+		return Count{Count: v.Count, Args: remapRunsAll(v.Args, ids)}
+	case Not:
+		return Not{Arg: RemapRuns(v.Arg, ids)}
+	case RunTestStatusEq:
+		v.Run = remap(v.Run)
+		return v
+	case RunTestStatusNeq:
+		v.Run = remap(v.Run)
+		return v
+	case RunTestStatusIn:
+		v.Run = remap(v.Run)
+		return v
+	case RunHasScreenshot:
+		v.Run = remap(v.Run)
+		return v
+	case RunSkipped:
+		v.Run = remap(v.Run)
+		return v
+	case RunIntermittent:
+		v.Run = remap(v.Run)
+		return v
+	case RunAssertCount:
+		v.Run = remap(v.Run)
+		return v
+	case RunUnexpected:
+		v.Run = remap(v.Run)
+		return v
+	case RunSubtestPlurality:
+		v.Run = remap(v.Run)
+		return v
+	case RunHarnessMessage:
+		v.Run = remap(v.Run)
+		return v
+	case RunAllSubtestsPass:
+		v.Run = remap(v.Run)
+		return v
+	case RunSubtestPassRatio:
+		v.Run = remap(v.Run)
+		return v
+	case RunRefMatch:
+		v.Run = remap(v.Run)
+		return v
+	case RunRegressed:
+		v.Baseline = remap(v.Baseline)
+		v.Run = remap(v.Run)
+		return v
+	case RunStatusChanged:
+		v.Base = remap(v.Base)
+		v.Head = remap(v.Head)
+		return v
+	case AnyRunStatusChanged:
+		v.Bases = remapAll(v.Bases)
+		v.Heads = remapAll(v.Heads)
+		return v
+	case RunsSubtestCountDelta:
+		v.From = remap(v.From)
+		v.To = remap(v.To)
+		return v
+	case RunsSameRender:
+		v.Left = remap(v.Left)
+		v.Right = remap(v.Right)
+		return v
+	case AnyRunTestStatusEq:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case RunsFlakinessRate:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case AnyRunSubtestTotal:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case AnyRunLongTimeout:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case AnyRunHasMetadataField:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case PresentInAllBrowsers:
+		for i, runs := range v.RunsByBrowser {
+			v.RunsByBrowser[i] = remapAll(runs)
+		}
+		return v
+	case BrowsersFailingCount:
+		for i, runs := range v.RunsByBrowser {
+			v.RunsByBrowser[i] = remapAll(runs)
+		}
+		return v
+	case BrowserWorseThanMedian:
+		for i, runs := range v.RunsByBrowser {
+			v.RunsByBrowser[i] = remapAll(runs)
+		}
+		return v
+	case BrowserSubtestOnlyIn:
+		for i, runs := range v.RunsByBrowser {
+			v.RunsByBrowser[i] = remapAll(runs)
+		}
+		return v
+	case FirstSeenAfter:
+		v.Before = remapAll(v.Before)
+		v.After = remapAll(v.After)
+		return v
+	case RunsWithResults:
+		v.Runs = remapAll(v.Runs)
+		return v
+	case AnyRunMatches:
+		v.Runs = remapAll(v.Runs)
+		return v
+	default:
+		return v
+	}
+}
+
+func remapRunsAll(qs []ConcreteQuery, ids map[int64]int64) []ConcreteQuery {
+	remapped := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		remapped[i] = RemapRuns(qs[i], ids)
+	}
+	return remapped
+}
+
+// ReferencedRuns returns the distinct IDs of the runs referenced by atoms
+// throughout a ConcreteQuery tree, in order of first reference.
+func ReferencedRuns(q ConcreteQuery) []int64 {
+	seen := make(map[int64]bool)
+	return referencedRuns(q, seen, nil)
+}
+
+func referencedRuns(q ConcreteQuery, seen map[int64]bool, ids []int64) []int64 {
+	add := func(runs ...int64) {
+		for _, run := range runs {
+			if !seen[run] {
+				seen[run] = true
+				ids = append(ids, run)
+			}
+		}
+	}
+
+	switch v := q.(type) {
+	case And:
+		for _, arg := range v.Args {
+			ids = referencedRuns(arg, seen, ids)
+		}
+	case Or:
+		for _, arg := range v.Args {
+			ids = referencedRuns(arg, seen, ids)
+		}
+	case Count:
+		for _, arg := range v.Args {
+			ids = referencedRuns(arg, seen, ids)
+		}
+	case Not:
+		ids = referencedRuns(v.Arg, seen, ids)
+	case RunTestStatusEq:
+		add(v.Run)
+	case RunTestStatusNeq:
+		add(v.Run)
+	case RunTestStatusIn:
+		add(v.Run)
+	case RunHasScreenshot:
+		add(v.Run)
+	case RunSkipped:
+		add(v.Run)
+	case RunIntermittent:
+		add(v.Run)
+	case RunAssertCount:
+		add(v.Run)
+	case RunUnexpected:
+		add(v.Run)
+	case RunSubtestPlurality:
+		add(v.Run)
+	case RunHarnessMessage:
+		add(v.Run)
+	case RunAllSubtestsPass:
+		add(v.Run)
+	case RunSubtestPassRatio:
+		add(v.Run)
+	case RunRefMatch:
+		add(v.Run)
+	case RunRegressed:
+		add(v.Baseline, v.Run)
+	case RunStatusChanged:
+		add(v.Base, v.Head)
+	case AnyRunStatusChanged:
+		add(v.Bases...)
+		add(v.Heads...)
+	case RunsSubtestCountDelta:
+		add(v.From, v.To)
+	case RunsSameRender:
+		add(v.Left, v.Right)
+	case AnyRunTestStatusEq:
+		add(v.Runs...)
+	case RunsFlakinessRate:
+		add(v.Runs...)
+	case AnyRunSubtestTotal:
+		add(v.Runs...)
+	case AnyRunLongTimeout:
+		add(v.Runs...)
+	case AnyRunHasMetadataField:
+		add(v.Runs...)
+	case PresentInAllBrowsers:
+		for _, runs := range v.RunsByBrowser {
+			add(runs...)
+		}
+	case BrowsersFailingCount:
+		for _, runs := range v.RunsByBrowser {
+			add(runs...)
+		}
+	case BrowserWorseThanMedian:
+		for _, runs := range v.RunsByBrowser {
+			add(runs...)
+		}
+	case BrowserSubtestOnlyIn:
+		for _, runs := range v.RunsByBrowser {
+			add(runs...)
+		}
+	case FirstSeenAfter:
+		add(v.Before...)
+		add(v.After...)
+	case RunsWithResults:
+		add(v.Runs...)
+	case AnyRunMatches:
+		add(v.Runs...)
+	}
+	return ids
+}
+
+// ExtractPatterns returns the distinct test name pattern, path and subtest name
+// strings from TestNamePattern, TestPath and Subtest atoms throughout a
+// ConcreteQuery tree, in sorted order.
+func ExtractPatterns(q ConcreteQuery) []string {
+	seen := make(map[string]bool)
+	extractPatterns(q, seen)
+	patterns := make([]string, 0, len(seen))
+	for pattern := range seen {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func extractPatterns(q ConcreteQuery, seen map[string]bool) {
+	switch v := q.(type) {
+	case TestNamePattern:
+		if len(v.Patterns) == 0 {
+			seen[v.Pattern] = true
+		}
+		for _, pattern := range v.Patterns {
+			seen[pattern] = true
+		}
+	case TestPath:
+		seen[v.Path] = true
+	case Subtest:
+		seen[v.Name] = true
+	case And:
+		for _, arg := range v.Args {
+			extractPatterns(arg, seen)
+		}
+	case Or:
+		for _, arg := range v.Args {
+			extractPatterns(arg, seen)
+		}
+	case Count:
+		for _, arg := range v.Args {
+			extractPatterns(arg, seen)
+		}
+	case Not:
+		extractPatterns(v.Arg, seen)
+	}
+}
@@ -0,0 +1,59 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func bulkArgs(n int) []ConcreteQuery {
+	args := make([]ConcreteQuery, n)
+	for i := range args {
+		args[i] = RunTestStatusEq{Run: int64(i), Status: shared.TestStatusFail}
+	}
+	return args
+}
+
+// benchmarkAndAppend appends args to an And one at a time, as tooling that
+// builds a query incrementally does, optionally growing it first.
+func benchmarkAndAppend(b *testing.B, args []ConcreteQuery, grow bool) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var a And
+		if grow {
+			a.Grow(len(args))
+		}
+		for _, arg := range args {
+			a.Append(arg)
+		}
+		_ = a.Build()
+	}
+}
+
+func BenchmarkAnd_appendBulk(b *testing.B) {
+	benchmarkAndAppend(b, bulkArgs(10000), false)
+}
+
+func BenchmarkAnd_appendBulkGrow(b *testing.B) {
+	benchmarkAndAppend(b, bulkArgs(10000), true)
+}
+
+// An Or that is grown first, then appended to in bulk, allocates only once.
+func BenchmarkOr_appendBulkGrow(b *testing.B) {
+	args := bulkArgs(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var o Or
+		o.Grow(len(args))
+		o.Append(args...)
+		_ = o.Build()
+	}
+}
@@ -0,0 +1,246 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func testCloneQuery() ConcreteQuery {
+	return And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+					RunTestStatusIn{Run: 2, Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusError}},
+				},
+			},
+			Not{
+				Arg: Count{
+					Count: 1,
+					Args: []ConcreteQuery{
+						AnyRunTestStatusEq{Runs: []int64{1, 2}, Status: shared.TestStatusCrash},
+						RunTestStatusNeq{Run: 1, Status: shared.TestStatusPass},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestClone_equal(t *testing.T) {
+	assert.Equal(t, testCloneQuery(), Clone(testCloneQuery()))
+	assert.Equal(t, True{}, Clone(True{}))
+	assert.Equal(t, Or{}, Clone(Or{}))
+}
+
+func TestClone_mutate(t *testing.T) {
+	original := testCloneQuery()
+	clone := Clone(original).(And)
+
+	clone.Args[0] = False{}
+	or := clone.Args[1].(Or)
+	or.Args[0] = True{}
+	or.Args[1].(RunTestStatusIn).Statuses[0] = shared.TestStatusPass
+	count := clone.Args[2].(Not).Arg.(Count)
+	count.Args[0].(AnyRunTestStatusEq).Runs[0] = 3
+	count.Args[1] = False{}
+
+	assert.Equal(t, testCloneQuery(), original)
+}
+
+func TestAnd_Append(t *testing.T) {
+	var a And
+	assert.Equal(t, True{}, a.Build())
+
+	a.Grow(3)
+	assert.Equal(t, 3, cap(a.Args))
+	a.Append(TestPath{Path: "/a/"})
+	assert.Equal(t, TestPath{Path: "/a/"}, a.Build())
+	a.Append(TestPath{Path: "/b/"}, TestPath{Path: "/c/"})
+	assert.Equal(t, 3, cap(a.Args))
+	built := a.Build()
+	assert.Equal(t, And{
+		Args: []ConcreteQuery{TestPath{Path: "/a/"}, TestPath{Path: "/b/"}, TestPath{Path: "/c/"}},
+	}, built)
+
+	// Appending to the built value does not affect later appends, nor vice versa.
+	a.Grow(1)
+	extended := built.(And)
+	extended.Append(TestPath{Path: "/x/"})
+	a.Append(TestPath{Path: "/d/"})
+	assert.Equal(t, TestPath{Path: "/x/"}, extended.Args[3])
+	assert.Equal(t, TestPath{Path: "/d/"}, a.Args[3])
+	assert.Equal(t, 3, len(built.(And).Args))
+}
+
+func TestOr_Append(t *testing.T) {
+	var o Or
+	assert.Equal(t, False{}, o.Build())
+
+	o.Append(RunTestStatusEq{Run: 1, Status: shared.TestStatusFail})
+	assert.Equal(t, RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}, o.Build())
+
+	// Growing keeps existing arguments.
+	o.Grow(10)
+	assert.True(t, cap(o.Args) >= 11)
+	o.Append(RunTestStatusEq{Run: 2, Status: shared.TestStatusFail})
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+		},
+	}, o.Build())
+}
+
+func TestExtractPatterns(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			And{
+				Args: []ConcreteQuery{
+					TestPath{Path: "/dom/"},
+					Not{Arg: TestNamePattern{Pattern: "idlharness"}},
+					RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+				},
+			},
+			Count{
+				Count: 1,
+				Args: []ConcreteQuery{
+					TestNamePattern{Pattern: "css"},
+					TestNamePattern{Pattern: "idlharness"},
+				},
+			},
+			TestNamePattern{Pattern: "/dom/"},
+		},
+	}
+	assert.Equal(t, []string{"/dom/", "css", "idlharness"}, ExtractPatterns(q))
+}
+
+func TestExtractPatterns_none(t *testing.T) {
+	assert.Equal(t, []string{}, ExtractPatterns(True{}))
+	assert.Equal(t, []string{}, ExtractPatterns(Not{Arg: RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}}))
+}
+
+func TestExtractPatterns_multiple(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Patterns: []string{"grid", "flexbox"}, MatchAll: true},
+			TestNamePattern{Pattern: "css"},
+		},
+	}
+	assert.Equal(t, []string{"css", "flexbox", "grid"}, ExtractPatterns(q))
+}
+
+func TestExtractPatterns_subtest(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestPath{Path: "/dom/"},
+			Not{Arg: Subtest{Name: "Interface objects"}},
+			Subtest{Name: "idl_test", Exact: true},
+		},
+	}
+	assert.Equal(t, []string{"/dom/", "Interface objects", "idl_test"}, ExtractPatterns(q))
+}
+
+func TestRemapRuns(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+					Not{Arg: RunSkipped{Run: 2}},
+				},
+			},
+			Count{
+				Count: 1,
+				Args: []ConcreteQuery{
+					AnyRunTestStatusEq{Runs: []int64{1, 2, 3}, Status: shared.TestStatusFail},
+					PresentInAllBrowsers{RunsByBrowser: [][]int64{{1}, {2, 3}}},
+				},
+			},
+		},
+	}
+	original := Clone(q)
+	expected := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 10, Status: shared.TestStatusPass},
+					Not{Arg: RunSkipped{Run: 20}},
+				},
+			},
+			Count{
+				Count: 1,
+				Args: []ConcreteQuery{
+					AnyRunTestStatusEq{Runs: []int64{10, 20, 3}, Status: shared.TestStatusFail},
+					PresentInAllBrowsers{RunsByBrowser: [][]int64{{10}, {20, 3}}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, RemapRuns(q, map[int64]int64{1: 10, 2: 20}))
+	// The original query is unchanged.
+	assert.Equal(t, original, q)
+}
+
+func TestReferencedRuns(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 2, Status: shared.TestStatusPass},
+					Not{Arg: RunSkipped{Run: 1}},
+				},
+			},
+			RunRegressed{Baseline: 4, Run: 2},
+			Count{
+				Count: 1,
+				Args: []ConcreteQuery{
+					AnyRunTestStatusEq{Runs: []int64{1, 3}, Status: shared.TestStatusFail},
+					PresentInAllBrowsers{RunsByBrowser: [][]int64{{1}, {5, 3}}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []int64{2, 1, 4, 3, 5}, ReferencedRuns(q))
+	assert.Nil(t, ReferencedRuns(TestNamePattern{Pattern: "css"}))
+	assert.Nil(t, ReferencedRuns(True{}))
+}
+
+func TestCanonicalStatuses(t *testing.T) {
+	statuses := []shared.TestStatus{shared.TestStatusFail, shared.TestStatusError, shared.TestStatusFail, shared.TestStatusPass}
+	assert.Equal(t, []shared.TestStatus{shared.TestStatusPass, shared.TestStatusError, shared.TestStatusFail}, CanonicalStatuses(statuses))
+	// The argument is not modified.
+	assert.Equal(t, shared.TestStatusFail, statuses[0])
+	assert.Nil(t, CanonicalStatuses(nil))
+}
+
+func TestRunTestStatusIn_marshalCanonical(t *testing.T) {
+	a := RunTestStatusIn{Run: 1, Statuses: []shared.TestStatus{shared.TestStatusError, shared.TestStatusFail}}
+	b := RunTestStatusIn{Run: 1, Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusError, shared.TestStatusFail}}
+	aJSON, err := json.Marshal(a)
+	assert.Nil(t, err)
+	bJSON, err := json.Marshal(b)
+	assert.Nil(t, err)
+	assert.Equal(t, string(aJSON), string(bJSON))
+	assert.Equal(t, `{"Run":1,"Statuses":[3,6]}`, string(aJSON))
+
+	// The representation is that of the default encoding.
+	var decoded RunTestStatusIn
+	assert.Nil(t, json.Unmarshal(bJSON, &decoded))
+	assert.Equal(t, a, decoded)
+
+	assert.Equal(t, ToFilterMap(a), ToFilterMap(b))
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import "fmt"
+
+// ErrQueryTooExpensive is the error returned when the estimated cost of a
+// RunQuery exceeds the limit imposed by the caller.
+type ErrQueryTooExpensive struct {
+	Cost  int
+	Limit int
+}
+
+func (e ErrQueryTooExpensive) Error() string {
+	return fmt.Sprintf("Query too expensive: estimated cost %d exceeds limit %d", e.Cost, e.Limit)
+}
+
+// EstimatedCost is a static estimate of the cost of executing the query, prior
+// to binding it to specific runs. It approximates the Size() of the bound
+// ConcreteQuery, assuming that every run-specific atom applies to every run
+// (including runs referenced by alias).
+func (rq RunQuery) EstimatedCost() int {
+	if rq.AbstractQuery == nil {
+		return 0
+	}
+	return estimateCost(rq.AbstractQuery, len(rq.RunIDs)+len(rq.RunAliases))
+}
+
+// RejectExpensive returns an ErrQueryTooExpensive when the estimated cost of rq
+// exceeds maxCost, and nil otherwise.
+func RejectExpensive(rq RunQuery, maxCost int) error {
+	if cost := rq.EstimatedCost(); cost > maxCost {
+		return ErrQueryTooExpensive{Cost: cost, Limit: maxCost}
+	}
+	return nil
+}
+
+// estimateCost estimates the size of q once bound to the given number of runs,
+// mirroring the structure of each atom's BindToRuns.
+func estimateCost(q AbstractQuery, runs int) int {
+	switch v := q.(type) {
+	case True, False:
+		return 0
+	case TestStatusEq, TestStatusNeq, HasScreenshot, Skipped, Intermittent, AssertCount, Unexpected, SubtestMajority, HarnessMessage, AllSubtestsPass, SubtestPassRatio, FlakinessRate, RefMatch, PresentInAll, BrowsersFailing, WorseThanMedian, SubtestOnlyIn, CoverageCount:
+		return runs
+	case AbstractNot:
+		return 1 + estimateCost(v.Arg, runs)
+	case AbstractOr:
+		return estimateCostAll(v.Args, runs)
+	case AbstractAnd:
+		return estimateCostAll(v.Args, runs)
+	case AbstractExists:
+		return estimateCostQuantified(v.Args, runs)
+	case AbstractAll:
+		return estimateCostQuantified(v.Args, runs)
+	case AbstractSequential:
+		windows := runs - len(v.Args) + 1
+		if windows < 0 {
+			windows = 0
+		}
+		return windows * estimateCostAll(v.Args, 1)
+	case AbstractCount:
+		return runs * estimateCost(v.Where, 1)
+	case RegressedSince:
+		return 2 * runs
+	case ChangedInPR:
+		return 2
+	case AnyChanged:
+		return 2 * runs
+	case SubtestCountDelta, SameRender:
+		return 2
+	case FirstSeen:
+		return 1
+	case FocusArea:
+		return len(v.Paths)
+	case InteropYear:
+		return len(v.Paths)
+	default:
+		// Run-independent atoms, such as test name patterns.
+		return 1
+	}
+}
+
+func estimateCostAll(qs []AbstractQuery, runs int) int {
+	cost := 0
+	for _, q := range qs {
+		cost += estimateCost(q, runs)
+	}
+	return cost
+}
+
+// estimateCostQuantified estimates the cost of the args of an AbstractExists or
+// AbstractAll, each of which is bound to each run separately (apart from
+// sequential and count queries, which are bound to all runs).
+func estimateCostQuantified(qs []AbstractQuery, runs int) int {
+	cost := 0
+	for _, q := range qs {
+		switch q.(type) {
+		case AbstractSequential, AbstractCount:
+			cost += estimateCost(q, runs)
+		default:
+			cost += runs * estimateCost(q, 1)
+		}
+	}
+	return cost
+}
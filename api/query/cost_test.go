@@ -0,0 +1,81 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestEstimatedCost(t *testing.T) {
+	rq := RunQuery{
+		RunIDs: []int64{1, 2, 3},
+		AbstractQuery: AbstractExists{
+			Args: []AbstractQuery{
+				AbstractAnd{
+					Args: []AbstractQuery{
+						TestNamePattern{Pattern: "cssom"},
+						TestStatusEq{Status: shared.TestStatusPass},
+					},
+				},
+				AbstractCount{
+					Count: 1,
+					Where: TestStatusEq{Status: shared.TestStatusFail},
+				},
+			},
+		},
+	}
+	// Exists: 3 runs * (pattern + status); count: 3 runs * status.
+	assert.Equal(t, 9, rq.EstimatedCost())
+}
+
+func TestEstimatedCost_sequential(t *testing.T) {
+	rq := RunQuery{
+		RunIDs: []int64{1, 2, 3},
+		AbstractQuery: AbstractSequential{
+			Args: []AbstractQuery{
+				TestStatusEq{Status: shared.TestStatusPass},
+				TestStatusEq{Status: shared.TestStatusFail},
+			},
+		},
+	}
+	// Two windows of two runs each.
+	assert.Equal(t, 4, rq.EstimatedCost())
+
+	rq.RunIDs = []int64{1}
+	assert.Equal(t, 0, rq.EstimatedCost())
+}
+
+func TestEstimatedCost_matchesSize(t *testing.T) {
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+	}
+	q := AbstractOr{
+		Args: []AbstractQuery{
+			TestPath{Path: "/dom/"},
+			AbstractNot{Arg: TestStatusNeq{Status: shared.TestStatusOK}},
+		},
+	}
+	rq := RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: q}
+	assert.Equal(t, q.BindToRuns(runs...).Size(), rq.EstimatedCost())
+}
+
+func TestRejectExpensive(t *testing.T) {
+	rq := RunQuery{
+		RunIDs:        []int64{1, 2, 3},
+		AbstractQuery: TestStatusEq{Status: shared.TestStatusPass},
+	}
+	assert.Nil(t, RejectExpensive(rq, 4))
+	assert.Nil(t, RejectExpensive(rq, 3))
+
+	err := RejectExpensive(rq, 2)
+	assert.Equal(t, ErrQueryTooExpensive{Cost: 3, Limit: 2}, err)
+	assert.EqualError(t, err, "Query too expensive: estimated cost 3 exceeds limit 2")
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// Matcher decides whether a test matches a custom atom (see CustomAtom), given
+// the test's results in a single run.
+type Matcher interface {
+	Match(testName string, run RunResults) bool
+}
+
+// RunResults are the results of a test in a single run, as presented to a
+// Matcher. Status is shared.TestStatusUnknown when the run has no result for
+// the test.
+type RunResults struct {
+	Run    int64
+	Status shared.TestStatus
+}
+
+// CustomAtom is a query atom, produced by a factory registered with
+// RegisterAtom, that matches tests for which Matcher matches in any of the runs
+// being queried. Key and Value are the atom's property and its (raw) value;
+// they are retained only so that the atom can be marshaled again.
+type CustomAtom struct {
+	Key     string
+	Value   json.RawMessage
+	Matcher Matcher
+}
+
+// BindToRuns for CustomAtom produces an AnyRunMatches over all of the runs, or
+// False if there are none.
+func (ca CustomAtom) BindToRuns(runs ...shared.TestRun) ConcreteQuery {
+	if len(runs) == 0 {
+		return False{}
+	}
+	ids := make([]int64, len(runs))
+	for i, run := range runs {
+		ids[i] = run.ID
+	}
+	return AnyRunMatches{Key: ca.Key, Matcher: ca.Matcher, Runs: ids}
+}
+
+// MarshalJSON for CustomAtom produces {<key>: <value>}.
+func (ca CustomAtom) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]json.RawMessage{ca.Key: ca.Value})
+}
+
+// customAtoms is the registry of custom atom factories, by key.
+var customAtoms = struct {
+	sync.RWMutex
+	factories map[string]func(json.RawMessage) (AbstractQuery, error)
+}{
+	factories: make(map[string]func(json.RawMessage) (AbstractQuery, error)),
+}
+
+// RegisterAtom registers a custom query atom, identified by the given
+// top-level key, e.g., {"my_atom": <value>}. When parsing a query fragment with
+// that key, factory is called with the key's value, and its result (typically a
+// CustomAtom, though any AbstractQuery may be produced) is used in place of the
+// fragment. Errors returned by factory are returned by the parser. Custom atoms
+// are not listed by SupportedAtoms.
+//
+// RegisterAtom is intended to be called from init functions. It panics when
+// key is empty, is the key of a built-in atom, or is already registered.
+func RegisterAtom(key string, factory func(json.RawMessage) (AbstractQuery, error)) {
+	if key == "" {
+		panic("query: RegisterAtom with empty key")
+	}
+	for _, ap := range atomParsers {
+		if ap.schema.topLevelKey() == key {
+			panic(fmt.Sprintf("query: RegisterAtom with built-in atom key %q", key))
+		}
+	}
+	customAtoms.Lock()
+	defer customAtoms.Unlock()
+	if _, ok := customAtoms.factories[key]; ok {
+		panic(fmt.Sprintf("query: RegisterAtom called twice for key %q", key))
+	}
+	customAtoms.factories[key] = factory
+}
+
+// unmarshalCustomAtom parses b as the registered custom atom whose key it has,
+// if any; the second return value is false when b has no registered key. When b
+// has several, the first in sorted order is used.
+func unmarshalCustomAtom(b []byte) (AbstractQuery, bool, error) {
+	customAtoms.RLock()
+	defer customAtoms.RUnlock()
+	if len(customAtoms.factories) == 0 {
+		return nil, false, nil
+	}
+
+	keys := make([]string, 0, len(customAtoms.factories))
+	for key := range customAtoms.factories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := jsonPropertyValue(b, key); ok {
+			q, err := customAtoms.factories[key](json.RawMessage(value))
+			return q, true, err
+		}
+	}
+	return nil, false, nil
+}
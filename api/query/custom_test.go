@@ -0,0 +1,82 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// suffixFailMatcher matches tests whose name has a suffix, and that fail.
+type suffixFailMatcher struct {
+	suffix string
+}
+
+func (m suffixFailMatcher) Match(testName string, run RunResults) bool {
+	return strings.HasSuffix(testName, m.suffix) && run.Status == shared.TestStatusFail
+}
+
+func init() {
+	RegisterAtom("suffix_fail", func(b json.RawMessage) (AbstractQuery, error) {
+		var suffix string
+		if err := json.Unmarshal(b, &suffix); err != nil {
+			return nil, err
+		}
+		if suffix == "" {
+			return nil, errors.New("Empty suffix")
+		}
+		return CustomAtom{Key: "suffix_fail", Value: b, Matcher: suffixFailMatcher{suffix}}, nil
+	})
+}
+
+func TestRegisterAtom_parse(t *testing.T) {
+	var rq RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [0, 1],
+		"query": {
+			"and": [
+				{"pattern": "/dom/"},
+				{"suffix_fail": ".any.html"}
+			]
+		}
+	}`), &rq)
+	assert.Nil(t, err)
+	atom := CustomAtom{Key: "suffix_fail", Value: json.RawMessage(`".any.html"`), Matcher: suffixFailMatcher{".any.html"}}
+	assert.Equal(t, AbstractAnd{Args: []AbstractQuery{TestNamePattern{Pattern: "/dom/"}, atom}}, rq.AbstractQuery)
+
+	data, err := json.Marshal(atom)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"suffix_fail":".any.html"}`, string(data))
+
+	// Errors from the factory are returned.
+	_, err = Parse([]byte(`{"run_ids": [0], "query": {"suffix_fail": ""}}`))
+	assert.EqualError(t, err, "Empty suffix")
+	_, err = Parse([]byte(`{"run_ids": [0], "query": {"suffix_fail": 1}}`))
+	assert.NotNil(t, err)
+}
+
+func TestRegisterAtom_bind(t *testing.T) {
+	atom := CustomAtom{Key: "suffix_fail", Matcher: suffixFailMatcher{".any.html"}}
+	assert.Equal(t, False{}, atom.BindToRuns())
+
+	q := atom.BindToRuns(shared.TestRun{ID: 1}, shared.TestRun{ID: 2})
+	assert.Equal(t, AnyRunMatches{Key: "suffix_fail", Matcher: suffixFailMatcher{".any.html"}, Runs: []int64{1, 2}}, q)
+	assert.Equal(t, 2, q.Size())
+	assert.Equal(t, []int64{1, 2}, ReferencedRuns(q))
+}
+
+func TestRegisterAtom_invalid(t *testing.T) {
+	factory := func(json.RawMessage) (AbstractQuery, error) { return True{}, nil }
+	assert.Panics(t, func() { RegisterAtom("", factory) })
+	assert.Panics(t, func() { RegisterAtom("pattern", factory) })
+	assert.Panics(t, func() { RegisterAtom("suffix_fail", factory) })
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import "sort"
+
+// DiffResults compares the results of two executions of the same query,
+// returning the names of tests that newly match (in after, but not before) and
+// that stopped matching (in before, but not after), each sorted and without
+// duplicates. Only test membership is compared; changes to a test's results are
+// not reported.
+func DiffResults(before, after []SearchResult) (added, removed []string) {
+	beforeTests := resultTests(before)
+	afterTests := resultTests(after)
+	for test := range afterTests {
+		if !beforeTests[test] {
+			added = append(added, test)
+		}
+	}
+	for test := range beforeTests {
+		if !afterTests[test] {
+			removed = append(removed, test)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func resultTests(results []SearchResult) map[string]bool {
+	tests := make(map[string]bool, len(results))
+	for _, result := range results {
+		tests[result.Test] = true
+	}
+	return tests
+}
@@ -0,0 +1,57 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func searchResults(tests ...string) []SearchResult {
+	results := make([]SearchResult, len(tests))
+	for i, test := range tests {
+		results[i] = SearchResult{Test: test}
+	}
+	return results
+}
+
+func TestDiffResults_addedOnly(t *testing.T) {
+	added, removed := DiffResults(searchResults("/a.html"), searchResults("/c.html", "/a.html", "/b.html"))
+	assert.Equal(t, []string{"/b.html", "/c.html"}, added)
+	assert.Nil(t, removed)
+
+	added, removed = DiffResults(nil, searchResults("/a.html"))
+	assert.Equal(t, []string{"/a.html"}, added)
+	assert.Nil(t, removed)
+}
+
+func TestDiffResults_removedOnly(t *testing.T) {
+	added, removed := DiffResults(searchResults("/b.html", "/a.html", "/c.html"), searchResults("/c.html"))
+	assert.Nil(t, added)
+	assert.Equal(t, []string{"/a.html", "/b.html"}, removed)
+
+	added, removed = DiffResults(searchResults("/a.html"), nil)
+	assert.Nil(t, added)
+	assert.Equal(t, []string{"/a.html"}, removed)
+}
+
+func TestDiffResults_mixed(t *testing.T) {
+	old := searchResults("/a.html", "/b.html", "/c.html")
+	new := searchResults("/b.html", "/d.html", "/d.html", "/e.html")
+	// Changed results for a matching test are not a membership change.
+	new[0].LegacyStatus = []LegacySearchRunResult{{Passes: 1, Total: 2}}
+	added, removed := DiffResults(old, new)
+	assert.Equal(t, []string{"/d.html", "/e.html"}, added)
+	assert.Equal(t, []string{"/a.html", "/c.html"}, removed)
+}
+
+func TestDiffResults_unchanged(t *testing.T) {
+	added, removed := DiffResults(searchResults("/a.html", "/b.html"), searchResults("/b.html", "/a.html"))
+	assert.Nil(t, added)
+	assert.Nil(t, removed)
+}
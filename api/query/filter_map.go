@@ -0,0 +1,246 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// ToFilterMap produces a nested map representation of a ConcreteQuery, for
+// translation into other filter representations (such as that of a GraphQL
+// gateway). Each node is a map with a single key naming the node type, e.g.,
+// {"run_test_status_eq": {"run": 1, "status": "PASS"}} or
+// {"and": [<filter maps>]}. Statuses are represented by their string values, and
+// status sets are in canonical order (see CanonicalStatuses).
+// Unlike the canonical JSON of an AbstractQuery, the map describes a query
+// that is already bound to runs.
+func ToFilterMap(q ConcreteQuery) map[string]interface{} {
+	var name string
+	var value interface{}
+	switch v := q.(type) {
+	case True:
+		name, value = "true", map[string]interface{}{}
+	case False:
+		name, value = "false", map[string]interface{}{}
+	case And:
+		name, value = "and", toFilterMaps(v.Args)
+	case Or:
+		name, value = "or", toFilterMaps(v.Args)
+	case Not:
+		name, value = "not", ToFilterMap(v.Arg)
+	case Count:
+		name, value = "count", map[string]interface{}{
+			"count": v.Count,
+			"where": toFilterMaps(v.Args),
+		}
+	case TestNamePattern:
+		params := map[string]interface{}{
+			"match_all":   v.MatchAll,
+			"ignore_case": v.IgnoreCase,
+		}
+		if len(v.Patterns) > 0 {
+			params["patterns"] = append([]string(nil), v.Patterns...)
+		} else {
+			params["pattern"] = v.Pattern
+		}
+		name, value = "test_name_pattern", params
+	case TestPath:
+		name, value = "test_path", map[string]interface{}{"path": v.Path}
+	case TestNameIn:
+		name, value = "test_name_in", map[string]interface{}{
+			"names": append([]string(nil), v.Names...),
+		}
+	case ManifestPaths:
+		name, value = "manifest_paths", map[string]interface{}{
+			"paths":  append([]string(nil), v.Paths...),
+			"prefix": v.Prefix,
+		}
+	case Shard:
+		name, value = "shard", map[string]interface{}{"index": v.Index, "total": v.Total}
+	case Subtest:
+		name, value = "subtest", map[string]interface{}{"name": v.Name, "exact": v.Exact}
+	case RunTestStatusEq:
+		name, value = "run_test_status_eq", map[string]interface{}{
+			"run":    v.Run,
+			"status": v.Status.String(),
+		}
+	case RunTestStatusNeq:
+		name, value = "run_test_status_neq", map[string]interface{}{
+			"run":    v.Run,
+			"status": v.Status.String(),
+		}
+	case RunTestStatusIn:
+		name, value = "run_test_status_in", map[string]interface{}{
+			"run":      v.Run,
+			"statuses": statusStrings(CanonicalStatuses(v.Statuses)),
+		}
+	case AnyRunTestStatusEq:
+		name, value = "any_run_test_status_eq", map[string]interface{}{
+			"runs":   append([]int64(nil), v.Runs...),
+			"status": v.Status.String(),
+		}
+	case RunHasScreenshot:
+		name, value = "run_has_screenshot", map[string]interface{}{"run": v.Run}
+	case RunSkipped:
+		name, value = "run_skipped", map[string]interface{}{"run": v.Run}
+	case RunIntermittent:
+		name, value = "run_intermittent", map[string]interface{}{"run": v.Run}
+	case RunAssertCount:
+		name, value = "run_assert_count", map[string]interface{}{
+			"run": v.Run,
+			"min": v.Min,
+			"max": v.Max,
+		}
+	case RunUnexpected:
+		name, value = "run_unexpected", map[string]interface{}{"run": v.Run}
+	case RunHarnessMessage:
+		name, value = "run_harness_message", map[string]interface{}{
+			"run":     v.Run,
+			"pattern": v.Pattern,
+		}
+	case RunSubtestPlurality:
+		name, value = "run_subtest_plurality", map[string]interface{}{
+			"run":    v.Run,
+			"status": v.Status.String(),
+		}
+	case RunAllSubtestsPass:
+		name, value = "run_all_subtests_pass", map[string]interface{}{"run": v.Run}
+	case RunSubtestPassRatio:
+		params := map[string]interface{}{"run": v.Run}
+		if v.Below >= 0 {
+			params["below"] = v.Below
+		}
+		if v.Above >= 0 {
+			params["above"] = v.Above
+		}
+		name, value = "run_subtest_pass_ratio", params
+	case RunRefMatch:
+		name, value = "run_ref_match", map[string]interface{}{
+			"run":      v.Run,
+			"expected": v.Expected,
+		}
+	case RunRegressed:
+		name, value = "run_regressed", map[string]interface{}{
+			"baseline": v.Baseline,
+			"run":      v.Run,
+		}
+	case RunStatusChanged:
+		name, value = "run_status_changed", map[string]interface{}{
+			"base": v.Base,
+			"head": v.Head,
+		}
+	case AnyRunStatusChanged:
+		name, value = "any_run_status_changed", map[string]interface{}{
+			"bases": append([]int64(nil), v.Bases...),
+			"heads": append([]int64(nil), v.Heads...),
+		}
+	case RunsSubtestCountDelta:
+		name, value = "runs_subtest_count_delta", map[string]interface{}{
+			"from":    v.From,
+			"to":      v.To,
+			"changed": v.Changed,
+		}
+	case RunsSameRender:
+		name, value = "runs_same_render", map[string]interface{}{
+			"left":  v.Left,
+			"right": v.Right,
+		}
+	case RunsFlakinessRate:
+		name, value = "runs_flakiness_rate", map[string]interface{}{
+			"runs":  append([]int64(nil), v.Runs...),
+			"above": v.Above,
+		}
+	case AnyRunSubtestTotal:
+		params := map[string]interface{}{
+			"runs": append([]int64(nil), v.Runs...),
+			"min":  v.Min,
+		}
+		if v.Max >= 0 {
+			params["max"] = v.Max
+		}
+		name, value = "any_run_subtest_total", params
+	case AnyRunLongTimeout:
+		name, value = "any_run_long_timeout", map[string]interface{}{
+			"runs": append([]int64(nil), v.Runs...),
+		}
+	case AnyRunHasMetadataField:
+		name, value = "any_run_has_metadata_field", map[string]interface{}{
+			"runs":  append([]int64(nil), v.Runs...),
+			"field": v.Field,
+		}
+	case PresentInAllBrowsers:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		name, value = "present_in_all_browsers", map[string]interface{}{
+			"runs_by_browser": byBrowser,
+		}
+	case BrowsersFailingCount:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		name, value = "browsers_failing_count", map[string]interface{}{
+			"runs_by_browser": byBrowser,
+			v.Comparison:      v.Count,
+		}
+	case BrowserWorseThanMedian:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		name, value = "browser_worse_than_median", map[string]interface{}{
+			"browser":         v.Browser,
+			"runs_by_browser": byBrowser,
+		}
+	case BrowserSubtestOnlyIn:
+		byBrowser := make([][]int64, len(v.RunsByBrowser))
+		for i, runs := range v.RunsByBrowser {
+			byBrowser[i] = append([]int64(nil), runs...)
+		}
+		name, value = "browser_subtest_only_in", map[string]interface{}{
+			"browser":         v.Browser,
+			"runs_by_browser": byBrowser,
+			"subtest":         v.Subtest,
+		}
+	case AnyRunMatches:
+		name, value = "any_run_matches", map[string]interface{}{
+			"key":  v.Key,
+			"runs": append([]int64(nil), v.Runs...),
+		}
+	case RunsWithResults:
+		name, value = "runs_with_results", map[string]interface{}{
+			"runs": append([]int64(nil), v.Runs...),
+			"min":  v.Min,
+		}
+	case FirstSeenAfter:
+		name, value = "first_seen_after", map[string]interface{}{
+			"before": append([]int64(nil), v.Before...),
+			"after":  append([]int64(nil), v.After...),
+		}
+	default:
+		name, value = "unknown", fmt.Sprintf("%T", q)
+	}
+	return map[string]interface{}{name: value}
+}
+
+func toFilterMaps(qs []ConcreteQuery) []map[string]interface{} {
+	maps := make([]map[string]interface{}, len(qs))
+	for i := range qs {
+		maps[i] = ToFilterMap(qs[i])
+	}
+	return maps
+}
+
+func statusStrings(statuses []shared.TestStatus) []string {
+	strs := make([]string, len(statuses))
+	for i, status := range statuses {
+		strs[i] = status.String()
+	}
+	return strs
+}
@@ -0,0 +1,97 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestToFilterMap_nested(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "/css"},
+			Or{
+				Args: []ConcreteQuery{
+					RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+					Not{RunTestStatusIn{Run: 2, Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusCrash}}},
+				},
+			},
+			Count{
+				Count: 2,
+				Args: []ConcreteQuery{
+					RunSkipped{Run: 1},
+					AnyRunSubtestTotal{Runs: []int64{1, 2}, Min: 3, Max: -1},
+				},
+			},
+		},
+	}
+	expected := map[string]interface{}{
+		"and": []map[string]interface{}{
+			{
+				"test_name_pattern": map[string]interface{}{
+					"pattern":     "/css",
+					"match_all":   false,
+					"ignore_case": false,
+				},
+			},
+			{
+				"or": []map[string]interface{}{
+					{
+						"run_test_status_eq": map[string]interface{}{
+							"run":    int64(1),
+							"status": "PASS",
+						},
+					},
+					{
+						"not": map[string]interface{}{
+							"run_test_status_in": map[string]interface{}{
+								"run":      int64(2),
+								"statuses": []string{"FAIL", "CRASH"},
+							},
+						},
+					},
+				},
+			},
+			{
+				"count": map[string]interface{}{
+					"count": 2,
+					"where": []map[string]interface{}{
+						{"run_skipped": map[string]interface{}{"run": int64(1)}},
+						{
+							"any_run_subtest_total": map[string]interface{}{
+								"runs": []int64{1, 2},
+								"min":  3,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, ToFilterMap(q))
+}
+
+func TestToFilterMap_leaves(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"true": map[string]interface{}{}}, ToFilterMap(True{}))
+	assert.Equal(t, map[string]interface{}{"false": map[string]interface{}{}}, ToFilterMap(False{}))
+	assert.Equal(t, map[string]interface{}{
+		"test_name_pattern": map[string]interface{}{
+			"patterns":    []string{"a", "b"},
+			"match_all":   true,
+			"ignore_case": false,
+		},
+	}, ToFilterMap(TestNamePattern{Patterns: []string{"a", "b"}, MatchAll: true}))
+	assert.Equal(t, map[string]interface{}{
+		"any_run_test_status_eq": map[string]interface{}{
+			"runs":   []int64{1, 2},
+			"status": "TIMEOUT",
+		},
+	}, ToFilterMap(AnyRunTestStatusEq{Runs: []int64{1, 2}, Status: shared.TestStatusTimeout}))
+}
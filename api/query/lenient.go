@@ -0,0 +1,66 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+// stripLenientJSON returns a copy of b in which each line comment (from "//"
+// to the end of the line) and each trailing comma (a comma followed, possibly
+// after whitespace, by "}" or "]") outside of a string is replaced by spaces.
+// This accepts hand-edited queries that are not strictly JSON; as the result is
+// the same length as b, offsets in errors for the result apply to b too.
+func stripLenientJSON(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+
+	// Blank out comments first, so that a comment between a trailing comma and
+	// the closing bracket does not hide the comma.
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		} else if c == '/' && i+1 < len(out) && out[i+1] == '/' {
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+
+	inString = false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		} else if c == ',' {
+			j := i + 1
+			for j < len(out) && isJSONSpace(out[j]) {
+				j++
+			}
+			if j < len(out) && (out[j] == '}' || out[j] == ']') {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
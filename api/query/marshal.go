@@ -0,0 +1,507 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// MarshalJSON for RunQuery produces
+// {"run_ids": [<ints>], "runs": [<product specs>], "columns": [<browser names>],
+// "query": <query>}. The runs property is omitted when there are no run aliases
+// (and likewise run_ids when there are only run aliases), the columns property
+// when there are no columns, and the query property when the query is True
+// (i.e., unconstrained).
+func (rq RunQuery) MarshalJSON() ([]byte, error) {
+	var data struct {
+		RunIDs  []int64              `json:"run_ids,omitempty"`
+		Runs    []shared.ProductSpec `json:"runs,omitempty"`
+		Columns []string             `json:"columns,omitempty"`
+		Query   *AbstractQuery       `json:"query,omitempty"`
+	}
+	data.RunIDs = rq.RunIDs
+	if len(rq.RunIDs) == 0 && len(rq.RunAliases) == 0 {
+		data.RunIDs = []int64{}
+	}
+	data.Runs = rq.RunAliases
+	data.Columns = rq.Columns
+	if _, isTrue := rq.AbstractQuery.(True); rq.AbstractQuery != nil && !isTrue {
+		data.Query = &rq.AbstractQuery
+	}
+	return json.Marshal(data)
+}
+
+// MarshalJSON for TestNamePattern produces {"pattern": <string>}, or
+// {"pattern": [<strings>]} when Patterns is non-empty, with "match_all" and
+// "ignore_case" properties only when they are set.
+func (tnp TestNamePattern) MarshalJSON() ([]byte, error) {
+	var data struct {
+		Pattern    interface{} `json:"pattern"`
+		MatchAll   bool        `json:"match_all,omitempty"`
+		IgnoreCase bool        `json:"ignore_case,omitempty"`
+	}
+	if len(tnp.Patterns) > 0 {
+		data.Pattern = tnp.Patterns
+	} else {
+		data.Pattern = tnp.Pattern
+	}
+	data.MatchAll = tnp.MatchAll
+	data.IgnoreCase = tnp.IgnoreCase
+	return json.Marshal(data)
+}
+
+// MarshalJSON for TestPath produces {"path": <string>}.
+func (tp TestPath) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path string `json:"path"`
+	}{tp.Path})
+}
+
+// MarshalJSON for TestNameIn produces {"test_name_in": [<string>, ...]}.
+func (tni TestNameIn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Names []string `json:"test_name_in"`
+	}{tni.Names})
+}
+
+// MarshalJSON for ManifestPaths produces {"manifest_paths": [<string>, ...]},
+// with a "prefix" property only when it is set.
+func (mp ManifestPaths) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Paths  []string `json:"manifest_paths"`
+		Prefix bool     `json:"prefix,omitempty"`
+	}{mp.Paths, mp.Prefix})
+}
+
+// MarshalJSON for Shard produces {"shard": {"index": <int>, "total": <int>}}.
+func (s Shard) MarshalJSON() ([]byte, error) {
+	type shard struct {
+		Index int `json:"index"`
+		Total int `json:"total"`
+	}
+	return json.Marshal(struct {
+		Shard shard `json:"shard"`
+	}{shard{s.Index, s.Total}})
+}
+
+// MarshalJSON for FocusArea produces {"focus_area": <area name>}. Its paths are
+// resolved again when the query is parsed.
+func (fa FocusArea) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FocusArea string `json:"focus_area"`
+	}{fa.Area})
+}
+
+// MarshalJSON for InteropYear produces {"interop_year": <year>}. Its paths are
+// resolved again when the query is parsed.
+func (iy InteropYear) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		InteropYear int `json:"interop_year"`
+	}{iy.Year})
+}
+
+// MarshalJSON for Subtest produces {"subtest": <string>}, with an "exact"
+// property only when it is set.
+func (s Subtest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name  string `json:"subtest"`
+		Exact bool   `json:"exact,omitempty"`
+	}{s.Name, s.Exact})
+}
+
+// MarshalJSON for TestStatusEq produces
+// {"product": <product spec>, "status": <status string>}, omitting the product
+// when there is none, or with an array of product specs when Products is
+// non-empty. The "os", "revision" and "full_run" properties are included only
+// when set.
+func (tse TestStatusEq) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Product  interface{} `json:"product,omitempty"`
+		OS       string      `json:"os,omitempty"`
+		Revision string      `json:"revision,omitempty"`
+		FullRun  *bool       `json:"full_run,omitempty"`
+		Status   string      `json:"status"`
+	}{marshalProducts(tse.Product, tse.Products), tse.OS, tse.Revision, tse.FullRun, tse.Status.String()})
+}
+
+// MarshalJSON for TestStatusNeq produces
+// {"product": <product spec>, "status": {"not": <status string>}}, with the
+// product, OS, revision and full run flag as for TestStatusEq.
+func (tsn TestStatusNeq) MarshalJSON() ([]byte, error) {
+	type not struct {
+		Not string `json:"not"`
+	}
+	return json.Marshal(struct {
+		Product  interface{} `json:"product,omitempty"`
+		OS       string      `json:"os,omitempty"`
+		Revision string      `json:"revision,omitempty"`
+		FullRun  *bool       `json:"full_run,omitempty"`
+		Status   not         `json:"status"`
+	}{marshalProducts(tsn.Product, tsn.Products), tsn.OS, tsn.Revision, tsn.FullRun, not{tsn.Status.String()}})
+}
+
+// marshalProducts is the value of the product property of a status atom, or nil
+// when the property is to be omitted.
+func marshalProducts(product *shared.ProductSpec, products []shared.ProductSpec) interface{} {
+	if len(products) > 0 {
+		return products
+	} else if product != nil {
+		return product
+	}
+	return nil
+}
+
+// MarshalJSON for AnyStatus produces {"any_status": <status string>}.
+func (as AnyStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		AnyStatus string `json:"any_status"`
+	}{as.Status.String()})
+}
+
+// MarshalJSON for HasScreenshot produces {"has_screenshot": <browser name>}.
+func (hs HasScreenshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		HasScreenshot string `json:"has_screenshot"`
+	}{hs.BrowserName})
+}
+
+// MarshalJSON for Skipped produces {"skipped": <browser name>}.
+func (s Skipped) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Skipped string `json:"skipped"`
+	}{s.BrowserName})
+}
+
+// MarshalJSON for Intermittent produces {"intermittent": <browser name>}.
+func (i Intermittent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Intermittent string `json:"intermittent"`
+	}{i.BrowserName})
+}
+
+// MarshalJSON for AssertCount produces
+// {"asserts": {"browser_name": <browser name>, "eq": <int>}} when the bounds are
+// equal, and otherwise {"asserts": {"browser_name": <browser name>,
+// "gte": <int>, "lte": <int>}}, omitting "lte" when there is no upper bound.
+func (ac AssertCount) MarshalJSON() ([]byte, error) {
+	type props struct {
+		BrowserName string `json:"browser_name"`
+		Eq          *int   `json:"eq,omitempty"`
+		Gte         *int   `json:"gte,omitempty"`
+		Lte         *int   `json:"lte,omitempty"`
+	}
+	data := struct {
+		Asserts props `json:"asserts"`
+	}{props{BrowserName: ac.BrowserName}}
+	min, max := ac.Min, ac.Max
+	if min == max {
+		data.Asserts.Eq = &min
+	} else {
+		data.Asserts.Gte = &min
+		if max >= 0 {
+			data.Asserts.Lte = &max
+		}
+	}
+	return json.Marshal(data)
+}
+
+// MarshalJSON for Unexpected produces {"unexpected": <browser name>}.
+func (u Unexpected) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Unexpected string `json:"unexpected"`
+	}{u.BrowserName})
+}
+
+// MarshalJSON for SubtestMajority produces
+// {"subtest_majority": {"browser_name": <browser name>, "status": <status>}}.
+func (sm SubtestMajority) MarshalJSON() ([]byte, error) {
+	type props struct {
+		BrowserName string `json:"browser_name"`
+		Status      string `json:"status"`
+	}
+	return json.Marshal(struct {
+		SubtestMajority props `json:"subtest_majority"`
+	}{props{sm.BrowserName, sm.Status.String()}})
+}
+
+// MarshalJSON for HarnessMessage produces
+// {"harness_message": {"browser_name": <browser name>, "pattern": <string>}}.
+func (hm HarnessMessage) MarshalJSON() ([]byte, error) {
+	type props struct {
+		BrowserName string `json:"browser_name"`
+		Pattern     string `json:"pattern"`
+	}
+	return json.Marshal(struct {
+		HarnessMessage props `json:"harness_message"`
+	}{props{hm.BrowserName, hm.Pattern}})
+}
+
+// MarshalJSON for AllSubtestsPass produces {"all_subtests_pass": <browser name>}.
+func (asp AllSubtestsPass) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		AllSubtestsPass string `json:"all_subtests_pass"`
+	}{asp.BrowserName})
+}
+
+// MarshalJSON for SubtestPassRatio produces
+// {"subtest_pass_ratio": {"browser_name": <browser name>, "below": <ratio>,
+// "above": <ratio>}}, omitting negative (i.e., absent) ratios.
+func (spr SubtestPassRatio) MarshalJSON() ([]byte, error) {
+	type params struct {
+		BrowserName string   `json:"browser_name"`
+		Below       *float64 `json:"below,omitempty"`
+		Above       *float64 `json:"above,omitempty"`
+	}
+	p := params{BrowserName: spr.BrowserName}
+	if spr.Below >= 0 {
+		p.Below = &spr.Below
+	}
+	if spr.Above >= 0 {
+		p.Above = &spr.Above
+	}
+	return json.Marshal(struct {
+		SubtestPassRatio params `json:"subtest_pass_ratio"`
+	}{p})
+}
+
+// MarshalJSON for FlakinessRate produces
+// {"flakiness": {"browser_name": <browser name>, "above": <rate>}}.
+func (fr FlakinessRate) MarshalJSON() ([]byte, error) {
+	type params struct {
+		BrowserName string  `json:"browser_name"`
+		Above       float64 `json:"above"`
+	}
+	return json.Marshal(struct {
+		Flakiness params `json:"flakiness"`
+	}{params{fr.BrowserName, fr.Above}})
+}
+
+// MarshalJSON for RefMatch produces
+// {"ref": {"browser_name": <browser name>, "expect": <expectation>}}.
+func (rm RefMatch) MarshalJSON() ([]byte, error) {
+	type params struct {
+		BrowserName string `json:"browser_name"`
+		Expect      string `json:"expect"`
+	}
+	return json.Marshal(struct {
+		Ref params `json:"ref"`
+	}{params{rm.BrowserName, rm.Expected}})
+}
+
+// MarshalJSON for RegressedSince produces {"regressed_since": <label>}.
+func (rs RegressedSince) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RegressedSince string `json:"regressed_since"`
+	}{rs.Label})
+}
+
+// MarshalJSON for AnyChanged produces {"any_changed": <label>}, or
+// {"any_changed": <run ID>} when its baseline is a run.
+func (ac AnyChanged) MarshalJSON() ([]byte, error) {
+	var baseline interface{} = ac.Label
+	if ac.Run != 0 {
+		baseline = ac.Run
+	}
+	return json.Marshal(struct {
+		AnyChanged interface{} `json:"any_changed"`
+	}{baseline})
+}
+
+// MarshalJSON for SubtestCountDelta produces
+// {"subtest_delta": {"from": <browser name>, "to": <browser name>,
+// "changed": <bool>}}.
+func (scd SubtestCountDelta) MarshalJSON() ([]byte, error) {
+	type props struct {
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Changed bool   `json:"changed"`
+	}
+	return json.Marshal(struct {
+		SubtestDelta props `json:"subtest_delta"`
+	}{props{scd.From, scd.To, scd.Changed}})
+}
+
+// MarshalJSON for SameRender produces
+// {"same_render": {"a": <browser name>, "b": <browser name>}}.
+func (sr SameRender) MarshalJSON() ([]byte, error) {
+	type props struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	return json.Marshal(struct {
+		SameRender props `json:"same_render"`
+	}{props{sr.LeftBrowser, sr.RightBrowser}})
+}
+
+// MarshalJSON for ChangedInPR produces {"changed_in_pr": true}.
+func (ChangedInPR) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ChangedInPR bool `json:"changed_in_pr"`
+	}{true})
+}
+
+// MarshalJSON for SubtestTotal produces
+// {"subtest_total": {"gte": <int>, "lte": <int>}}, omitting "lte" when there is
+// no upper bound.
+func (st SubtestTotal) MarshalJSON() ([]byte, error) {
+	type bounds struct {
+		Gte int  `json:"gte"`
+		Lte *int `json:"lte,omitempty"`
+	}
+	data := struct {
+		SubtestTotal bounds `json:"subtest_total"`
+	}{bounds{Gte: st.Min}}
+	if st.Max >= 0 {
+		max := st.Max
+		data.SubtestTotal.Lte = &max
+	}
+	return json.Marshal(data)
+}
+
+// MarshalJSON for LongTimeout produces {"long_timeout": true}.
+func (lt LongTimeout) MarshalJSON() ([]byte, error) {
+	return []byte(`{"long_timeout":true}`), nil
+}
+
+// MarshalJSON for HasMetadataField produces {"has_metadata": <field name>}.
+func (hmf HasMetadataField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		HasMetadata string `json:"has_metadata"`
+	}{hmf.Field})
+}
+
+// MarshalJSON for PresentInAll produces {"present_in_all": true}.
+func (pia PresentInAll) MarshalJSON() ([]byte, error) {
+	return []byte(`{"present_in_all":true}`), nil
+}
+
+// MarshalJSON for BrowsersFailing produces
+// {"browsers_failing": {<comparison>: <int>}}.
+func (bf BrowsersFailing) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		BrowsersFailing map[string]int `json:"browsers_failing"`
+	}{map[string]int{bf.Comparison: bf.Count}})
+}
+
+// MarshalJSON for FirstSeen produces
+// {"first_seen": {"after": <RFC 3339 timestamp>}}.
+func (fs FirstSeen) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FirstSeen map[string]string `json:"first_seen"`
+	}{map[string]string{"after": fs.Since.Format(time.RFC3339Nano)}})
+}
+
+// MarshalJSON for CoverageCount produces {"coverage": {"gte": <int>}}.
+func (cc CoverageCount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Coverage map[string]int `json:"coverage"`
+	}{map[string]int{"gte": cc.Min}})
+}
+
+// MarshalJSON for WorseThanMedian produces {"worse_than_median": <browser name>}.
+func (wtm WorseThanMedian) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		WorseThanMedian string `json:"worse_than_median"`
+	}{wtm.BrowserName})
+}
+
+// MarshalJSON for SubtestOnlyIn produces
+// {"subtest_only_in": {"browser_name": <browser name>, "subtest": <string>}}.
+func (soi SubtestOnlyIn) MarshalJSON() ([]byte, error) {
+	type props struct {
+		BrowserName string `json:"browser_name"`
+		Subtest     string `json:"subtest"`
+	}
+	return json.Marshal(struct {
+		SubtestOnlyIn props `json:"subtest_only_in"`
+	}{props{soi.BrowserName, soi.Subtest}})
+}
+
+// MarshalJSON for AbstractNot produces {"not": <abstract query>}.
+func (n AbstractNot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Not AbstractQuery `json:"not"`
+	}{n.Arg})
+}
+
+// MarshalJSON for AbstractOr produces {"or": [<abstract queries>]}.
+func (o AbstractOr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Or []AbstractQuery `json:"or"`
+	}{o.Args})
+}
+
+// MarshalJSON for AbstractAnd produces {"and": [<abstract queries>]}.
+func (a AbstractAnd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		And []AbstractQuery `json:"and"`
+	}{a.Args})
+}
+
+// MarshalJSON for AbstractExists produces {"exists": [<abstract queries>]}.
+func (e AbstractExists) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Exists []AbstractQuery `json:"exists"`
+	}{e.Args})
+}
+
+// MarshalJSON for AbstractAll produces
+// {"quantifier": "all", "where": [<abstract queries>]}.
+func (a AbstractAll) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Quantifier string          `json:"quantifier"`
+		Where      []AbstractQuery `json:"where"`
+	}{"all", a.Args})
+}
+
+// MarshalJSON for AbstractSequential produces
+// {"sequential": [<abstract queries>]}.
+func (e AbstractSequential) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Sequential []AbstractQuery `json:"sequential"`
+	}{e.Args})
+}
+
+// MarshalJSON for AbstractCount produces {"count": <int>, "where": <query>}.
+func (c AbstractCount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count int           `json:"count"`
+		Where AbstractQuery `json:"where"`
+	}{c.Count, c.Where})
+}
+
+// MarshalJSON for RunTestStatusIn produces the same representation as the
+// default encoding, {"Run": <int>, "Statuses": [<ints>]}, but with the statuses
+// in canonical order (see CanonicalStatuses), so that equal status sets
+// serialize identically.
+func (rtsi RunTestStatusIn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Run      int64
+		Statuses []shared.TestStatus
+	}{rtsi.Run, CanonicalStatuses(rtsi.Statuses)})
+}
+
+// CanonicalStatuses returns the distinct statuses of a status set, sorted by
+// their numeric values, so that logically equal sets have the same
+// representation regardless of the order (or repetition) of their statuses.
+// The statuses passed are not modified.
+func CanonicalStatuses(statuses []shared.TestStatus) []shared.TestStatus {
+	if statuses == nil {
+		return nil
+	}
+	canonical := append([]shared.TestStatus(nil), statuses...)
+	sort.Slice(canonical, func(i, j int) bool { return canonical[i] < canonical[j] })
+	distinct := canonical[:0]
+	for i, status := range canonical {
+		if i == 0 || status != canonical[i-1] {
+			distinct = append(distinct, status)
+		}
+	}
+	return distinct
+}
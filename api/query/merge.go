@@ -0,0 +1,150 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"errors"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+var errDisjointRuns = errors.New("Cannot merge queries over disjoint sets of runs")
+
+var errNoQueries = errors.New("No queries to combine")
+
+var errDifferentRuns = errors.New("Cannot combine queries over different sets of runs")
+
+// MergeAnd combines two RunQuery instances such that results must satisfy both
+// queries. The merged query is over the union of both queries' runs (in order,
+// without duplicates), so the queries' run sets must be compatible: they must
+// share at least one run, unless either set is empty. Merging otherwise returns
+// an error, since each query's atoms would be applied to runs that its author
+// did not select. Run aliases, which cannot be compared with run IDs until they
+// are resolved, are likewise merged without duplicates, but are not checked. A
+// True (or missing) query is the identity for the merge.
+func MergeAnd(a, b RunQuery) (RunQuery, error) {
+	if len(a.RunIDs) > 0 && len(b.RunIDs) > 0 && !intersects(a.RunIDs, b.RunIDs) {
+		return RunQuery{}, errDisjointRuns
+	}
+
+	seen := make(map[int64]bool)
+	runIDs := make([]int64, 0, len(a.RunIDs)+len(b.RunIDs))
+	for _, ids := range [][]int64{a.RunIDs, b.RunIDs} {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				runIDs = append(runIDs, id)
+			}
+		}
+	}
+
+	var runAliases []shared.ProductSpec
+	seenAliases := make(map[string]bool)
+	for _, aliases := range [][]shared.ProductSpec{a.RunAliases, b.RunAliases} {
+		for _, alias := range aliases {
+			if key := alias.String(); !seenAliases[key] {
+				seenAliases[key] = true
+				runAliases = append(runAliases, alias)
+			}
+		}
+	}
+
+	var q AbstractQuery
+	if isTrueOrNil(a.AbstractQuery) {
+		q = b.AbstractQuery
+	} else if isTrueOrNil(b.AbstractQuery) {
+		q = a.AbstractQuery
+	} else {
+		q = AbstractAnd{Args: []AbstractQuery{a.AbstractQuery, b.AbstractQuery}}
+	}
+	if q == nil {
+		q = True{}
+	}
+	return RunQuery{RunIDs: runIDs, RunAliases: runAliases, AbstractQuery: q}, nil
+}
+
+// CombineOr combines RunQuery instances such that results must satisfy at least
+// one of the queries, e.g., to union several saved queries. Unlike MergeAnd, the
+// queries must be over identical sets of runs: the same run IDs and run aliases,
+// in any order. The combined query is over the runs of the first query. Since a
+// True (or missing) query matches every test, so does the combination of any
+// queries that include one.
+func CombineOr(queries ...RunQuery) (RunQuery, error) {
+	if len(queries) == 0 {
+		return RunQuery{}, errNoQueries
+	}
+	first := queries[0]
+	args := make([]AbstractQuery, 0, len(queries))
+	matchAll := false
+	for _, rq := range queries {
+		if !sameRunIDs(first.RunIDs, rq.RunIDs) || !sameRunAliases(first.RunAliases, rq.RunAliases) {
+			return RunQuery{}, errDifferentRuns
+		}
+		if isTrueOrNil(rq.AbstractQuery) {
+			matchAll = true
+		}
+		args = append(args, rq.AbstractQuery)
+	}
+
+	var q AbstractQuery
+	if matchAll {
+		q = True{}
+	} else if len(args) == 1 {
+		q = args[0]
+	} else {
+		q = AbstractOr{Args: args}
+	}
+	return RunQuery{RunIDs: first.RunIDs, RunAliases: first.RunAliases, AbstractQuery: q}, nil
+}
+
+// sameRunIDs reports whether a and b contain the same run IDs, ignoring order
+// and duplicates.
+func sameRunIDs(a, b []int64) bool {
+	as := make(map[int64]bool)
+	for _, id := range a {
+		as[id] = true
+	}
+	bs := make(map[int64]bool)
+	for _, id := range b {
+		if !as[id] {
+			return false
+		}
+		bs[id] = true
+	}
+	return len(as) == len(bs)
+}
+
+// sameRunAliases reports whether a and b contain the same run aliases, as for
+// sameRunIDs.
+func sameRunAliases(a, b []shared.ProductSpec) bool {
+	as := make(map[string]bool)
+	for _, alias := range a {
+		as[alias.String()] = true
+	}
+	bs := make(map[string]bool)
+	for _, alias := range b {
+		if !as[alias.String()] {
+			return false
+		}
+		bs[alias.String()] = true
+	}
+	return len(as) == len(bs)
+}
+
+func intersects(a, b []int64) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTrueOrNil(q AbstractQuery) bool {
+	_, isTrue := q.(True)
+	return q == nil || isTrue
+}
@@ -0,0 +1,131 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestMergeAnd_overlapping(t *testing.T) {
+	a := RunQuery{RunIDs: []int64{1, 2, 3}, AbstractQuery: TestNamePattern{Pattern: "/dom/"}}
+	b := RunQuery{RunIDs: []int64{3, 2, 4}, AbstractQuery: AnyStatus{Status: shared.TestStatusFail}}
+	merged, err := MergeAnd(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs: []int64{1, 2, 3, 4},
+		AbstractQuery: AbstractAnd{
+			Args: []AbstractQuery{
+				TestNamePattern{Pattern: "/dom/"},
+				AnyStatus{Status: shared.TestStatusFail},
+			},
+		},
+	}, merged)
+
+	// Inputs are not modified.
+	assert.Equal(t, []int64{1, 2, 3}, a.RunIDs)
+	assert.Equal(t, []int64{3, 2, 4}, b.RunIDs)
+}
+
+func TestMergeAnd_disjoint(t *testing.T) {
+	a := RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: TestNamePattern{Pattern: "/dom/"}}
+	b := RunQuery{RunIDs: []int64{3}, AbstractQuery: TestPath{Path: "/css/"}}
+	_, err := MergeAnd(a, b)
+	assert.NotNil(t, err)
+
+	// A query without runs is compatible with any set of runs.
+	merged, err := MergeAnd(a, RunQuery{AbstractQuery: TestPath{Path: "/css/"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2}, merged.RunIDs)
+}
+
+func TestMergeAnd_runAliases(t *testing.T) {
+	chrome := shared.ParseProductSpecUnsafe("chrome[stable]")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	a := RunQuery{RunAliases: []shared.ProductSpec{chrome}, AbstractQuery: True{}}
+	b := RunQuery{RunIDs: []int64{1}, RunAliases: []shared.ProductSpec{firefox, chrome}, AbstractQuery: True{}}
+	merged, err := MergeAnd(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{1},
+		RunAliases:    []shared.ProductSpec{chrome, firefox},
+		AbstractQuery: True{},
+	}, merged)
+}
+
+func TestMergeAnd_true(t *testing.T) {
+	a := RunQuery{RunIDs: []int64{1}, AbstractQuery: TestNamePattern{Pattern: "/dom/"}}
+	merged, err := MergeAnd(a, RunQuery{RunIDs: []int64{1}, AbstractQuery: True{}})
+	assert.Nil(t, err)
+	assert.Equal(t, a, merged)
+
+	merged, err = MergeAnd(RunQuery{RunIDs: []int64{1}}, a)
+	assert.Nil(t, err)
+	assert.Equal(t, a, merged)
+
+	merged, err = MergeAnd(RunQuery{RunIDs: []int64{1}}, RunQuery{RunIDs: []int64{1}})
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{1}, AbstractQuery: True{}}, merged)
+}
+
+func TestCombineOr_sameRuns(t *testing.T) {
+	a := RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: TestNamePattern{Pattern: "/dom/"}}
+	b := RunQuery{RunIDs: []int64{2, 1}, AbstractQuery: AnyStatus{Status: shared.TestStatusFail}}
+	c := RunQuery{RunIDs: []int64{1, 2, 2}, AbstractQuery: AbstractOr{Args: []AbstractQuery{TestPath{Path: "/css/"}}}}
+	combined, err := CombineOr(a, b, c)
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs: []int64{1, 2},
+		AbstractQuery: AbstractOr{
+			Args: []AbstractQuery{
+				TestNamePattern{Pattern: "/dom/"},
+				AnyStatus{Status: shared.TestStatusFail},
+				AbstractOr{Args: []AbstractQuery{TestPath{Path: "/css/"}}},
+			},
+		},
+	}, combined)
+
+	// A single query is unchanged.
+	combined, err = CombineOr(a)
+	assert.Nil(t, err)
+	assert.Equal(t, a, combined)
+
+	// A True (or missing) query matches everything.
+	combined, err = CombineOr(a, RunQuery{RunIDs: []int64{1, 2}})
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: True{}}, combined)
+
+	chrome := shared.ParseProductSpecUnsafe("chrome[stable]")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+	combined, err = CombineOr(
+		RunQuery{RunAliases: []shared.ProductSpec{chrome, firefox}, AbstractQuery: TestPath{Path: "/css/"}},
+		RunQuery{RunAliases: []shared.ProductSpec{firefox, chrome}, AbstractQuery: TestPath{Path: "/dom/"}},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []shared.ProductSpec{chrome, firefox}, combined.RunAliases)
+}
+
+func TestCombineOr_differentRuns(t *testing.T) {
+	a := RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: TestNamePattern{Pattern: "/dom/"}}
+	for _, b := range []RunQuery{
+		RunQuery{RunIDs: []int64{1}, AbstractQuery: TestPath{Path: "/css/"}},
+		RunQuery{RunIDs: []int64{1, 2, 3}, AbstractQuery: TestPath{Path: "/css/"}},
+		RunQuery{RunIDs: []int64{1, 3}, AbstractQuery: TestPath{Path: "/css/"}},
+		RunQuery{AbstractQuery: TestPath{Path: "/css/"}},
+		RunQuery{RunIDs: []int64{1, 2}, RunAliases: []shared.ProductSpec{shared.ParseProductSpecUnsafe("chrome")}, AbstractQuery: TestPath{Path: "/css/"}},
+	} {
+		_, err := CombineOr(a, b)
+		assert.NotNil(t, err, "%v", b)
+		_, err = CombineOr(b, a)
+		assert.NotNil(t, err, "%v", b)
+	}
+
+	_, err := CombineOr()
+	assert.NotNil(t, err)
+}
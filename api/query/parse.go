@@ -0,0 +1,392 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// ParseOpts configures how a RunQuery is parsed.
+type ParseOpts struct {
+	// LenientStatus parses unrecognized test status strings as UNKNOWN, with a
+	// warning, rather than rejecting the query.
+	LenientStatus bool
+	// BrowserNames, when non-nil, replaces the set of browser names recognized
+	// in product specs and browser-valued atoms (by default, all browser names
+	// known to shared.IsBrowserName).
+	BrowserNames []string
+	// AllowUnknownBrowsers accepts unrecognized browser names, with a warning,
+	// rather than rejecting the query.
+	AllowUnknownBrowsers bool
+	// MaxDepth, when positive, rejects queries whose atoms are nested more than
+	// MaxDepth deep. The query of a RunQuery is at depth 1.
+	MaxDepth int
+	// FocusAreas, when non-nil, replaces DefaultFocusAreas as the mapping from
+	// focus area names to path prefixes recognized by focus_area atoms.
+	FocusAreas map[string][]string
+	// InteropYears, when non-nil, replaces DefaultInteropYears as the mapping
+	// from years to the names of the focus areas recognized by interop_year
+	// atoms. The focus areas are resolved with FocusAreas.
+	InteropYears map[int][]string
+	// RejectUnknownKeys rejects RunQuery properties other than "run_ids",
+	// "runs", "columns" and "query", rather than ignoring them with a warning.
+	RejectUnknownKeys bool
+	// LenientJSON accepts line comments ("//" to the end of the line) and
+	// trailing commas in arrays and objects, as found in hand-edited queries.
+	// Such input is not JSON; it is only accepted by Parse and ParseWithOpts,
+	// never by json.Unmarshal of a RunQuery.
+	LenientJSON bool
+}
+
+// DefaultFocusAreas is the default mapping from focus area names to the test
+// path prefixes that they comprise.
+var DefaultFocusAreas = map[string][]string{
+	"aspect-ratio":       {"/css/css-sizing/aspect-ratio/"},
+	"flexbox":            {"/css/css-flexbox/"},
+	"grid":               {"/css/css-grid/"},
+	"sticky-positioning": {"/css/css-position/sticky/"},
+	"transforms":         {"/css/css-transforms/"},
+}
+
+// DefaultInteropYears is the default mapping from years to the names of the
+// focus areas (see DefaultFocusAreas) of that year's Interop effort.
+var DefaultInteropYears = map[int][]string{
+	2021: {"aspect-ratio", "flexbox", "grid", "sticky-positioning", "transforms"},
+}
+
+// ParseOption is a functional option for Parse.
+type ParseOption func(*ParseOpts)
+
+// LenientStatus is a ParseOption that sets ParseOpts.LenientStatus.
+func LenientStatus() ParseOption {
+	return func(opts *ParseOpts) {
+		opts.LenientStatus = true
+	}
+}
+
+// WithBrowserNames is a ParseOption that sets ParseOpts.BrowserNames.
+func WithBrowserNames(names ...string) ParseOption {
+	return func(opts *ParseOpts) {
+		opts.BrowserNames = make([]string, len(names))
+		for i, name := range names {
+			opts.BrowserNames[i] = canonicalizeStr(name)
+		}
+	}
+}
+
+// AllowUnknownBrowsers is a ParseOption that sets
+// ParseOpts.AllowUnknownBrowsers.
+func AllowUnknownBrowsers() ParseOption {
+	return func(opts *ParseOpts) {
+		opts.AllowUnknownBrowsers = true
+	}
+}
+
+// WithMaxDepth is a ParseOption that sets ParseOpts.MaxDepth.
+func WithMaxDepth(depth int) ParseOption {
+	return func(opts *ParseOpts) {
+		opts.MaxDepth = depth
+	}
+}
+
+// WithFocusAreas is a ParseOption that sets ParseOpts.FocusAreas.
+func WithFocusAreas(areas map[string][]string) ParseOption {
+	return func(opts *ParseOpts) {
+		opts.FocusAreas = areas
+	}
+}
+
+// WithInteropYears is a ParseOption that sets ParseOpts.InteropYears.
+func WithInteropYears(years map[int][]string) ParseOption {
+	return func(opts *ParseOpts) {
+		opts.InteropYears = years
+	}
+}
+
+// RejectUnknownKeys is a ParseOption that sets ParseOpts.RejectUnknownKeys.
+func RejectUnknownKeys() ParseOption {
+	return func(opts *ParseOpts) {
+		opts.RejectUnknownKeys = true
+	}
+}
+
+// LenientJSON is a ParseOption that sets ParseOpts.LenientJSON.
+func LenientJSON() ParseOption {
+	return func(opts *ParseOpts) {
+		opts.LenientJSON = true
+	}
+}
+
+// Warning is a non-fatal condition encountered while parsing a RunQuery, such
+// as an unknown test status accepted under ParseOpts.LenientStatus.
+type Warning struct {
+	Message string `json:"message"`
+}
+
+// ParseResult is a RunQuery parsed by Parse, along with any warnings reported
+// while parsing it, so that they can be echoed to clients.
+type ParseResult struct {
+	RunQuery RunQuery  `json:"run_query"`
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// Parse parses the JSON representation of a RunQuery according to the given
+// options. Non-fatal conditions that the options permit are reported as
+// warnings in the ParseResult. json.Unmarshal of a RunQuery is equivalent to
+// Parse with no options, discarding warnings.
+func Parse(b []byte, opts ...ParseOption) (ParseResult, error) {
+	var parseOpts ParseOpts
+	for _, opt := range opts {
+		opt(&parseOpts)
+	}
+	rq, warnings, err := ParseWithOpts(b, parseOpts)
+	if err != nil {
+		return ParseResult{}, err
+	}
+	return ParseResult{RunQuery: rq, Warnings: warnings}, nil
+}
+
+// ParseWithOpts parses the JSON representation of a RunQuery according to the
+// given options. Non-fatal conditions that the options permit are reported as
+// warnings.
+func ParseWithOpts(b []byte, opts ParseOpts) (RunQuery, []Warning, error) {
+	p := newParser(opts)
+	if opts.LenientJSON {
+		b = stripLenientJSON(b)
+	}
+	var rq RunQuery
+	if err := rq.unmarshal(p, b); err != nil {
+		return RunQuery{}, nil, err
+	}
+	return rq, p.warnings, nil
+}
+
+// parser carries parse options, and accumulates warnings, while parsing the
+// atoms of a query. The UnmarshalJSON methods of atoms that depend on parse
+// options (directly, or via nested atoms) delegate to an unmarshal method that
+// takes a parser, using the default options.
+type parser struct {
+	opts ParseOpts
+	// browserNames is the set of opts.BrowserNames, when non-nil, built once
+	// per parse so that each atom's browser name is checked in constant time.
+	browserNames browserNameSet
+	warnings     []Warning
+	depth        int
+	// path holds the JSON path segments of the query currently being parsed.
+	path []string
+}
+
+func newParser(opts ParseOpts) *parser {
+	p := &parser{opts: opts}
+	if opts.BrowserNames != nil {
+		p.browserNames = newBrowserNameSet(opts.BrowserNames)
+	}
+	return p
+}
+
+// browserNameSet is a set of canonical browser names. A set is never modified
+// once built, so it can be read by concurrent parses without locking.
+type browserNameSet map[string]bool
+
+func newBrowserNameSet(names []string) browserNameSet {
+	set := make(browserNameSet, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// defaultBrowserNames is the set of the default browser names, built once from
+// shared.GetDefaultBrowserNames.
+var defaultBrowserNames = newBrowserNameSet(browsers)
+
+func (p *parser) warn(msg string) {
+	p.warnings = append(p.warnings, Warning{Message: msg})
+}
+
+// Errors returned when parsing a RunQuery, possibly with details of the
+// offending property or value; callers can distinguish them with
+// ParseErrorCause.
+var (
+	// ErrMissingRunIDs is the error returned when a RunQuery has neither run
+	// IDs nor run aliases.
+	ErrMissingRunIDs = errors.New(`Missing run query property: "run_ids"`)
+	// ErrMissingQuery is the error returned when a logical operator (e.g., "not"
+	// or "and") is missing its query (or queries).
+	ErrMissingQuery = errors.New("Missing query property")
+	// ErrInvalidBrowser is the error returned for an unrecognized browser name.
+	ErrInvalidBrowser = errors.New("Invalid browser name")
+	// ErrInvalidStatus is the error returned for an unrecognized test status.
+	ErrInvalidStatus = errors.New("Invalid test status")
+	// ErrUnknownProperty is the error returned for an unrecognized RunQuery
+	// property under ParseOpts.RejectUnknownKeys.
+	ErrUnknownProperty = errors.New("Unknown run query property")
+)
+
+// detailedError is one of the errors above, with details of the offending
+// property or value appended to its message.
+type detailedError struct {
+	err    error
+	detail string
+}
+
+func (e detailedError) Error() string {
+	return e.err.Error() + e.detail
+}
+
+// withDetail returns err with the formatted detail appended to its message.
+func withDetail(err error, format string, args ...interface{}) error {
+	return detailedError{err: err, detail: fmt.Sprintf(format, args...)}
+}
+
+// ParseErrorCause returns the error underlying an error returned when parsing a
+// RunQuery, e.g., ErrInvalidBrowser for an unrecognized browser name at any
+// depth of the query. Other errors are returned as is.
+func ParseErrorCause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *QueryParseError:
+			err = e.Err
+		case detailedError:
+			return e.err
+		default:
+			return err
+		}
+	}
+}
+
+// isSentinel reports whether err is (or wraps) one of the errors above.
+func isSentinel(err error) bool {
+	cause := ParseErrorCause(err)
+	for _, sentinel := range []error{ErrMissingRunIDs, ErrMissingQuery, ErrInvalidBrowser, ErrInvalidStatus, ErrUnknownProperty} {
+		if cause == sentinel {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryParseError is the error returned for a malformed query atom, positioned
+// by the JSON path of the innermost atom that failed to parse, e.g.,
+// "query.and[0].or[1]", or "query.and[0].pattern" when a property is
+// explicitly null. Its message is that of the underlying error, which may be
+// inspected with ParseErrorCause.
+type QueryParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *QueryParseError) Error() string {
+	return e.Err.Error()
+}
+
+// positionError wraps err in a QueryParseError at the current path, unless it
+// is already positioned by a more deeply nested atom.
+func (p *parser) positionError(err error) error {
+	if _, ok := err.(*QueryParseError); ok {
+		return err
+	}
+	path := strings.Join(p.path, ".")
+	if nullErr, ok := err.(errNullProperty); ok {
+		if path != "" {
+			path += "."
+		}
+		path += string(nullErr)
+	}
+	return &QueryParseError{Path: path, Err: err}
+}
+
+// errMaxDepth is the error returned when a query is nested more deeply than the
+// MaxDepth option allows.
+type errMaxDepth int
+
+func (e errMaxDepth) Error() string {
+	return fmt.Sprintf("Query exceeds maximum depth of %d", int(e))
+}
+
+// checkBrowserName checks that the (canonical) browser name is recognized, or
+// else that unknown browsers are allowed.
+func (p *parser) checkBrowserName(name string) error {
+	if p.isKnownBrowserName(name) {
+		return nil
+	}
+	if p.opts.AllowUnknownBrowsers {
+		p.warn(fmt.Sprintf(`Unknown browser name "%s"`, name))
+		return nil
+	}
+	return withDetail(ErrInvalidBrowser, `: "%s"`, name)
+}
+
+func (p *parser) isKnownBrowserName(name string) bool {
+	name = strings.TrimSuffix(name, "-"+shared.ExperimentalLabel)
+	if p.browserNames != nil {
+		return p.browserNames[name]
+	}
+	// Browsers other than the default ones are rare, so only they fall back to
+	// the (locking) set of all known browsers.
+	return defaultBrowserNames[name] || shared.IsStableBrowserName(name)
+}
+
+// focusAreas is the mapping of focus area names to path prefixes in effect.
+func (p *parser) focusAreas() map[string][]string {
+	if p.opts.FocusAreas == nil {
+		return DefaultFocusAreas
+	}
+	return p.opts.FocusAreas
+}
+
+// interopYears is the mapping of Interop years to focus area names in effect.
+func (p *parser) interopYears() map[int][]string {
+	if p.opts.InteropYears == nil {
+		return DefaultInteropYears
+	}
+	return p.opts.InteropYears
+}
+
+// parseProductSpec parses a product spec, checking its browser name with
+// checkBrowserName.
+func (p *parser) parseProductSpec(spec string) (shared.ProductSpec, error) {
+	product, err := shared.ParseProductSpec(spec)
+	if err == nil && p.opts.BrowserNames == nil {
+		return product, nil
+	}
+
+	nameLen := strings.IndexAny(spec, "-[@")
+	if nameLen < 0 {
+		nameLen = len(spec)
+	}
+	name := canonicalizeStr(spec[:nameLen])
+	if nameErr := p.checkBrowserName(name); nameErr != nil {
+		return shared.ProductSpec{}, nameErr
+	}
+	if err == nil || shared.IsBrowserName(name) {
+		return product, err
+	}
+
+	// shared.ParseProductSpec rejects browser names that it does not know, so
+	// parse the rest of the spec with a placeholder name.
+	product, err = shared.ParseProductSpec(browsers[0] + spec[nameLen:])
+	if err != nil {
+		return shared.ProductSpec{}, fmt.Errorf("invalid product spec: %s", spec)
+	}
+	product.BrowserName = name
+	return product, nil
+}
+
+// parserUnmarshaler is implemented by atoms that unmarshal with a parser.
+type parserUnmarshaler interface {
+	unmarshal(*parser, []byte) error
+}
+
+// unmarshalWith unmarshals b into u with the given parser. Dispatching through
+// the parserUnmarshaler interface (rather than calling unmarshal directly)
+// allows atomParsers to refer to atoms that recursively refer to atomParsers.
+func unmarshalWith(p *parser, b []byte, u parserUnmarshaler) error {
+	return u.unmarshal(p, b)
+}
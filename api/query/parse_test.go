@@ -0,0 +1,368 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestParse_defaults(t *testing.T) {
+	b := []byte(`{"run_ids": [1, 2], "query": {"product": "chrome-69", "status": "PASS"}}`)
+	res, err := Parse(b)
+	assert.Nil(t, err)
+	p := shared.ParseProductSpecUnsafe("chrome-69")
+	assert.Equal(t, RunQuery{
+		RunIDs:        []int64{1, 2},
+		AbstractQuery: TestStatusEq{Product: &p, Status: shared.TestStatusPass},
+	}, res.RunQuery)
+	assert.Nil(t, res.Warnings)
+
+	var unmarshaled RunQuery
+	assert.Nil(t, json.Unmarshal(b, &unmarshaled))
+	assert.Equal(t, res.RunQuery, unmarshaled)
+
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"status": "NEW_STATUS"}}`))
+	assert.NotNil(t, err)
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"product": "netscape", "status": "PASS"}}`))
+	assert.NotNil(t, err)
+}
+
+func TestParse_lenientStatus(t *testing.T) {
+	res, err := Parse([]byte(`{"run_ids": [1], "query": {"status": "NEW_STATUS"}}`), LenientStatus())
+	assert.Nil(t, err)
+	assert.Equal(t, TestStatusEq{Status: shared.TestStatusUnknown}, res.RunQuery.AbstractQuery)
+	assert.Equal(t, []Warning{
+		Warning{Message: `Unknown test status "NEW_STATUS" treated as UNKNOWN`},
+	}, res.Warnings)
+}
+
+func TestParse_withBrowserNames(t *testing.T) {
+	opt := WithBrowserNames("Chrome", "firefox")
+
+	_, err := Parse([]byte(`{"run_ids": [1], "query": {"product": "firefox-63", "status": "PASS"}}`), opt)
+	assert.Nil(t, err)
+	res, err := Parse([]byte(`{"run_ids": [1], "query": {"has_screenshot": "chrome"}}`), opt)
+	assert.Nil(t, err)
+	assert.Equal(t, HasScreenshot{BrowserName: "chrome"}, res.RunQuery.AbstractQuery)
+
+	// Browser names known to shared.IsBrowserName, but not in the given set, are
+	// rejected.
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"product": "safari", "status": "PASS"}}`), opt)
+	assert.NotNil(t, err)
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"skipped": "edge"}}`), opt)
+	assert.NotNil(t, err)
+
+	// Browser names in the given set need not be known to shared.IsBrowserName.
+	res, err = Parse([]byte(`{"run_ids": [1], "query": {"product": "servo-1.2", "status": "FAIL"}}`), WithBrowserNames("servo"))
+	assert.Nil(t, err)
+	product := shared.ParseProductSpecUnsafe("chrome-1.2")
+	product.BrowserName = "servo"
+	assert.Equal(t, TestStatusEq{Product: &product, Status: shared.TestStatusFail}, res.RunQuery.AbstractQuery)
+}
+
+func TestParse_browserNamesConcurrent(t *testing.T) {
+	// Parses with the default and custom browser names share no mutable state.
+	custom := WithBrowserNames("servo")
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, errs[i] = Parse([]byte(`{"run_ids": [1], "query": {"product": "chrome", "status": "PASS"}}`))
+			} else {
+				_, errs[i] = Parse([]byte(`{"run_ids": [1], "query": {"skipped": "servo"}}`), custom)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+}
+
+func TestParse_allowUnknownBrowsers(t *testing.T) {
+	b := []byte(`{
+		"run_ids": [1],
+		"query": {
+			"or": [
+				{"product": "netscape-4", "status": {"not": "PASS"}},
+				{"skipped": "Netscape"}
+			]
+		}
+	}`)
+	_, err := Parse(b)
+	assert.NotNil(t, err)
+
+	res, err := Parse(b, AllowUnknownBrowsers())
+	assert.Nil(t, err)
+	product := shared.ParseProductSpecUnsafe("chrome-4")
+	product.BrowserName = "netscape"
+	assert.Equal(t, AbstractOr{
+		Args: []AbstractQuery{
+			TestStatusNeq{Product: &product, Status: shared.TestStatusPass},
+			Skipped{BrowserName: "netscape"},
+		},
+	}, res.RunQuery.AbstractQuery)
+	assert.Equal(t, []Warning{
+		Warning{Message: `Unknown browser name "netscape"`},
+		Warning{Message: `Unknown browser name "netscape"`},
+	}, res.Warnings)
+
+	_, warnings, err := ParseWithOpts(b, ParseOpts{AllowUnknownBrowsers: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []Warning{
+		Warning{Message: `Unknown browser name "netscape"`},
+		Warning{Message: `Unknown browser name "netscape"`},
+	}, warnings)
+
+	// The rest of the product spec must still be valid.
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"product": "netscape-x", "status": "PASS"}}`), AllowUnknownBrowsers())
+	assert.NotNil(t, err)
+}
+
+func TestParse_withMaxDepth(t *testing.T) {
+	b := []byte(`{"run_ids": [1], "query": {"not": {"or": [{"pattern": "a"}, {"not": {"path": "/b/"}}]}}}`)
+	_, err := Parse(b)
+	assert.Nil(t, err)
+	_, err = Parse(b, WithMaxDepth(4))
+	assert.Nil(t, err)
+
+	_, err = Parse(b, WithMaxDepth(3))
+	assert.NotNil(t, err)
+	assert.Equal(t, "Query exceeds maximum depth of 3", err.Error())
+
+	_, err = Parse(b, WithMaxDepth(1))
+	assert.NotNil(t, err)
+	assert.Equal(t, "Query exceeds maximum depth of 1", err.Error())
+}
+
+func TestParse_composedOptions(t *testing.T) {
+	b := []byte(`{"run_ids": [1], "query": {"and": [{"skipped": "servo"}, {"any_status": "NEW_STATUS"}]}}`)
+	_, err := Parse(b, WithBrowserNames("servo"))
+	assert.NotNil(t, err)
+
+	res, err := Parse(b, WithBrowserNames("servo"), LenientStatus(), WithMaxDepth(2))
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractAnd{
+		Args: []AbstractQuery{
+			Skipped{BrowserName: "servo"},
+			AnyStatus{Status: shared.TestStatusUnknown},
+		},
+	}, res.RunQuery.AbstractQuery)
+	assert.Equal(t, []Warning{
+		Warning{Message: `Unknown test status "NEW_STATUS" treated as UNKNOWN`},
+	}, res.Warnings)
+}
+
+func TestParseResult_marshal(t *testing.T) {
+	res, err := Parse([]byte(`{"run_ids": [1], "query": {"any_status": "NEW_STATUS"}}`), LenientStatus())
+	assert.Nil(t, err)
+	b, err := json.Marshal(res)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"run_query":{"run_ids":[1],"query":{"any_status":"UNKNOWN"}},"warnings":[{"message":"Unknown test status \"NEW_STATUS\" treated as UNKNOWN"}]}`, string(b))
+
+	res, err = Parse([]byte(`{"run_ids": [1]}`))
+	assert.Nil(t, err)
+	b, err = json.Marshal(res)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"run_query":{"run_ids":[1]}}`, string(b))
+}
+
+func TestParse_sentinelErrors(t *testing.T) {
+	for _, c := range []struct {
+		query    string
+		sentinel error
+	}{
+		{`{"query": {"pattern": "a"}}`, ErrMissingRunIDs},
+		{`{"run_ids": [], "runs": []}`, ErrMissingRunIDs},
+		{`{"run_ids": [1], "query": {"exists": []}}`, ErrMissingQuery},
+		{`{"run_ids": [1], "query": {"or": []}}`, ErrMissingQuery},
+		{`{"run_ids": [1], "query": {"and": []}}`, ErrMissingQuery},
+		{`{"run_ids": [1], "query": {"count": 1}}`, ErrMissingQuery},
+		{`{"run_ids": [1], "query": {"skipped": "netscape"}}`, ErrInvalidBrowser},
+		{`{"run_ids": [1], "query": {"product": "netscape", "status": "PASS"}}`, ErrInvalidBrowser},
+		{`{"runs": ["netscape"]}`, ErrInvalidBrowser},
+		{`{"run_ids": [1], "query": {"status": "NEW_STATUS"}}`, ErrInvalidStatus},
+		// Nested in other atoms.
+		{`{"run_ids": [1], "query": {"not": {"or": [{"pattern": "a"}, {"any_status": "NEW_STATUS"}]}}}`, ErrInvalidStatus},
+		{`{"run_ids": [1], "query": {"and": [{"pattern": "a"}, {"not": {"has_screenshot": "netscape"}}]}}`, ErrInvalidBrowser},
+	} {
+		_, err := Parse([]byte(c.query))
+		assert.NotNil(t, err, c.query)
+		assert.Equal(t, c.sentinel, ParseErrorCause(err), c.query)
+	}
+
+	// Errors keep their details.
+	_, err := Parse([]byte(`{"run_ids": [1], "query": {"skipped": "netscape"}}`))
+	assert.EqualError(t, err, `Invalid browser name: "netscape"`)
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"or": []}}`))
+	assert.EqualError(t, err, `Missing query property: "or"`)
+
+	// Unrecognized fragments match no sentinel.
+	_, err = Parse([]byte(`{"run_ids": [1], "query": {"bogus": true}}`))
+	assert.NotNil(t, err)
+	assert.False(t, isSentinel(err))
+}
+
+func TestParse_errorPath(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		path  string
+	}{
+		{`{"run_ids": [1], "query": {"bogus": true}}`, "query"},
+		{`{"run_ids": [1], "query": {"and": [{"pattern": "a"}, {"or": [{"pattern": "b"}, {"bogus": true}]}]}}`, "query.and[1].or[1]"},
+		{`{"run_ids": [1], "query": {"not": {"exists": [{"status": "NEW_STATUS"}]}}}`, "query.not.exists[0]"},
+		{`{"run_ids": [1], "query": {"count": 1, "where": {"and": [{"pattern": null}]}}}`, "query.where.and[0].pattern"},
+		{`{"run_ids": [1], "query": {"or": [{"pattern": "a"}, {"and": [{"sequential": []}]}]}}`, "query.or[1].and[0]"},
+		{`{"run_ids": [1], "query": {"not": [{"pattern": "a"}, {"bogus": true}]}}`, "query.not[1]"},
+	} {
+		_, err := Parse([]byte(c.query))
+		parseErr, ok := err.(*QueryParseError)
+		if assert.True(t, ok, "%s: %v", c.query, err) {
+			assert.Equal(t, c.path, parseErr.Path, c.query)
+		}
+	}
+
+	// Positioned errors keep their messages and sentinels.
+	_, err := Parse([]byte(`{"run_ids": [1], "query": {"and": [{"pattern": "a"}, {"not": {"skipped": "netscape"}}]}}`))
+	assert.EqualError(t, err, `Invalid browser name: "netscape"`)
+	assert.Equal(t, ErrInvalidBrowser, ParseErrorCause(err))
+	parseErr, ok := err.(*QueryParseError)
+	assert.True(t, ok)
+	assert.Equal(t, "query.and[1].not", parseErr.Path)
+
+	// Errors outside of the query are not positioned.
+	_, err = Parse([]byte(`{"query": {"pattern": "a"}}`))
+	_, ok = err.(*QueryParseError)
+	assert.False(t, ok)
+}
+
+func TestParse_unknownKeys(t *testing.T) {
+	b := []byte(`{"run_ids": [1], "query": {"exists": [{"pattern": "a"}]}, "future_feature": {"x": 1}, "Runs": ["chrome"]}`)
+	res, err := Parse(b)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{Args: []AbstractQuery{TestNamePattern{Pattern: "a"}}}, res.RunQuery.AbstractQuery)
+	assert.Equal(t, []int64{1}, res.RunQuery.RunIDs)
+	assert.Equal(t, 1, len(res.RunQuery.RunAliases))
+	assert.Equal(t, []Warning{{Message: `Unknown run query property "future_feature" ignored`}}, res.Warnings)
+
+	// json.Unmarshal discards the warning.
+	var rq RunQuery
+	assert.Nil(t, json.Unmarshal(b, &rq))
+	assert.Equal(t, res.RunQuery, rq)
+
+	_, err = Parse(b, RejectUnknownKeys())
+	assert.EqualError(t, err, `Unknown run query property: "future_feature"`)
+	assert.Equal(t, ErrUnknownProperty, ParseErrorCause(err))
+
+	res, err = Parse([]byte(`{"run_ids": [1], "query": {"pattern": "a"}}`), RejectUnknownKeys())
+	assert.Nil(t, err)
+	assert.Nil(t, res.Warnings)
+}
+
+func TestParse_dedupRunIDs(t *testing.T) {
+	// The requested order is kept.
+	res, err := Parse([]byte(`{"run_ids": [3, 1, 2]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3, 1, 2}, res.RunQuery.RunIDs)
+	assert.Nil(t, res.Warnings)
+
+	res, err = Parse([]byte(`{"run_ids": [2, 1, 2, 2, 1]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{2, 1}, res.RunQuery.RunIDs)
+	assert.Equal(t, []Warning{{Message: `Duplicate run IDs in run query property "run_ids" ignored`}}, res.Warnings)
+}
+
+func TestRunQuery_Normalize(t *testing.T) {
+	// Queries that differ only in the order or repetition of their run IDs
+	// normalize equally, and marshal identically.
+	var a, b RunQuery
+	assert.Nil(t, json.Unmarshal([]byte(`{"run_ids": [2, 1], "query": {"pattern": "a"}}`), &a))
+	assert.Nil(t, json.Unmarshal([]byte(`{"run_ids": [1, 2, 1], "query": {"pattern": "a"}}`), &b))
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a.Normalize(), b.Normalize())
+	assert.Equal(t, []int64{1, 2}, a.Normalize().RunIDs)
+	aData, err := json.Marshal(a.Normalize())
+	assert.Nil(t, err)
+	bData, err := json.Marshal(b.Normalize())
+	assert.Nil(t, err)
+	assert.Equal(t, string(aData), string(bData))
+
+	// The query itself is not modified.
+	assert.Equal(t, []int64{2, 1}, a.RunIDs)
+}
+
+func TestNormalizeRunIDs(t *testing.T) {
+	ids := []int64{5, 3, 5, 1}
+	normalized, duplicates := NormalizeRunIDs(ids)
+	assert.Equal(t, []int64{1, 3, 5}, normalized)
+	assert.True(t, duplicates)
+	// The input is not modified.
+	assert.Equal(t, []int64{5, 3, 5, 1}, ids)
+
+	normalized, duplicates = NormalizeRunIDs([]int64{1, 2})
+	assert.Equal(t, []int64{1, 2}, normalized)
+	assert.False(t, duplicates)
+
+	normalized, duplicates = NormalizeRunIDs(nil)
+	assert.Nil(t, normalized)
+	assert.False(t, duplicates)
+}
+
+func TestParse_lenientJSON(t *testing.T) {
+	b := []byte(`{
+		// Hand-edited query.
+		"run_ids": [1, 2,],
+		"query": {
+			"or": [
+				{"pattern": "http://example.test/a,]"}, // URLs in strings are kept.
+				{"pattern": "b\"//,}"},
+			],
+		},
+	}`)
+	_, err := Parse(b)
+	assert.NotNil(t, err)
+	var rq RunQuery
+	assert.NotNil(t, json.Unmarshal(b, &rq))
+
+	res, err := Parse(b, LenientJSON())
+	assert.Nil(t, err)
+	assert.Equal(t, RunQuery{
+		RunIDs: []int64{1, 2},
+		AbstractQuery: AbstractOr{
+			Args: []AbstractQuery{
+				TestNamePattern{Pattern: "http://example.test/a,]"},
+				TestNamePattern{Pattern: `b"//,}`},
+			},
+		},
+	}, res.RunQuery)
+
+	// Only trailing commas are dropped.
+	for _, bad := range []string{
+		`{"run_ids": [1,, 2]}`,
+		`{"run_ids": [,1]}`,
+		`{, "run_ids": [1]}`,
+	} {
+		_, err := Parse([]byte(bad), LenientJSON())
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestStripLenientJSON(t *testing.T) {
+	// Stripped input keeps its length, so that error offsets still apply.
+	in := "{\"a\": [1, // one\n2, ], \"b\": \"//,]\",\n}"
+	out := string(stripLenientJSON([]byte(in)))
+	assert.Equal(t, "{\"a\": [1,       \n2  ], \"b\": \"//,]\" \n}", out)
+	assert.Equal(t, len(in), len(out))
+}
@@ -0,0 +1,503 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// maxDNFTerms is the maximum number of conjunctions that ToDNF will produce.
+var maxDNFTerms = 1024
+
+// CombineStatusDisjunctions rewrites a ConcreteQuery such that, within each Or,
+// all RunTestStatusEq (and RunTestStatusIn) arguments that constrain the same
+// run are merged into a single RunTestStatusIn. For example,
+// Or(RunTestStatusEq{1, FAIL}, RunTestStatusEq{1, ERROR}) becomes
+// RunTestStatusIn{1, [FAIL, ERROR]}. Status constraints over different runs are
+// left untouched.
+func CombineStatusDisjunctions(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case Or:
+		return combineOrStatuses(v)
+	case And:
+		args := make([]ConcreteQuery, len(v.Args))
+		for i := range v.Args {
+			args[i] = CombineStatusDisjunctions(v.Args[i])
+		}
+		return And{Args: args}
+	case Count:
+		args := make([]ConcreteQuery, len(v.Args))
+		for i := range v.Args {
+			args[i] = CombineStatusDisjunctions(v.Args[i])
+		}
+		return Count{Count: v.Count, Args: args}
+	case Not:
+		return Not{CombineStatusDisjunctions(v.Arg)}
+	default:
+		return q
+	}
+}
+
+func combineOrStatuses(o Or) ConcreteQuery {
+	args := make([]ConcreteQuery, 0, len(o.Args))
+	// Index into args of the (first) status constraint for each run.
+	byRun := make(map[int64]int)
+	for _, arg := range o.Args {
+		arg = CombineStatusDisjunctions(arg)
+
+		var run int64
+		var statuses []shared.TestStatus
+		switch v := arg.(type) {
+		case RunTestStatusEq:
+			run, statuses = v.Run, []shared.TestStatus{v.Status}
+		case RunTestStatusIn:
+			run, statuses = v.Run, v.Statuses
+		default:
+			args = append(args, arg)
+			continue
+		}
+
+		i, ok := byRun[run]
+		if !ok {
+			byRun[run] = len(args)
+			args = append(args, arg)
+			continue
+		}
+		args[i] = mergeRunStatuses(args[i], run, statuses)
+	}
+
+	if len(args) == 1 {
+		return args[0]
+	}
+	return Or{Args: args}
+}
+
+// mergeRunStatuses merges statuses into an existing RunTestStatusEq or
+// RunTestStatusIn over the same run, dropping duplicate status values.
+func mergeRunStatuses(existing ConcreteQuery, run int64, statuses []shared.TestStatus) RunTestStatusIn {
+	var merged []shared.TestStatus
+	switch v := existing.(type) {
+	case RunTestStatusEq:
+		merged = []shared.TestStatus{v.Status}
+	case RunTestStatusIn:
+		merged = append(merged, v.Statuses...)
+	}
+	for _, status := range statuses {
+		dup := false
+		for _, m := range merged {
+			if m == status {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			merged = append(merged, status)
+		}
+	}
+	return RunTestStatusIn{Run: run, Statuses: merged}
+}
+
+// AbsorbRedundantPatterns rewrites a ConcreteQuery such that, within each And,
+// a TestNamePattern implied by a stricter sibling is dropped. For example,
+// And(TestNamePattern{"/css"}, TestNamePattern{"/css/flexbox"}) becomes
+// TestNamePattern{"/css/flexbox"}. Only single-pattern atoms are considered, and
+// a case-sensitive pattern is never absorbed by a case-insensitive one.
+func AbsorbRedundantPatterns(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case And:
+		return absorbAndPatterns(v)
+	case Or:
+		return Or{Args: absorbRedundantPatternsAll(v.Args)}
+	case Count:
+		return Count{Count: v.Count, Args: absorbRedundantPatternsAll(v.Args)}
+	case Not:
+		return Not{AbsorbRedundantPatterns(v.Arg)}
+	default:
+		return q
+	}
+}
+
+func absorbAndPatterns(a And) ConcreteQuery {
+	args := absorbRedundantPatternsAll(a.Args)
+	kept := make([]ConcreteQuery, 0, len(args))
+	for i, arg := range args {
+		if tnp, ok := arg.(TestNamePattern); ok && isSinglePattern(tnp) {
+			redundant := false
+			for j, other := range args {
+				o, ok := other.(TestNamePattern)
+				if !ok || i == j || !isSinglePattern(o) {
+					continue
+				}
+				// Of equivalent patterns, only the first is kept.
+				if impliesPattern(o, tnp) && (!impliesPattern(tnp, o) || j < i) {
+					redundant = true
+					break
+				}
+			}
+			if redundant {
+				continue
+			}
+		}
+		kept = append(kept, arg)
+	}
+
+	if len(kept) == 1 {
+		return kept[0]
+	}
+	return And{Args: kept}
+}
+
+func isSinglePattern(tnp TestNamePattern) bool {
+	return len(tnp.Patterns) == 0
+}
+
+// impliesPattern reports whether every test name matched by a is also matched
+// by b, i.e., whether b's pattern contains a's, and a is at most as strict
+// about case as b.
+func impliesPattern(a, b TestNamePattern) bool {
+	return strings.Contains(a.Pattern, b.Pattern) && (b.IgnoreCase || !a.IgnoreCase)
+}
+
+func absorbRedundantPatternsAll(qs []ConcreteQuery) []ConcreteQuery {
+	args := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		args[i] = AbsorbRedundantPatterns(qs[i])
+	}
+	return args
+}
+
+// FactorCommonTerms rewrites a ConcreteQuery such that, within each Or, the
+// terms that are conjuncts of every argument are hoisted into an enclosing And.
+// For example, Or(And(a, b), And(a, c)) becomes And(a, Or(b, c)), so that a is
+// evaluated once for each test. A non-And argument is a conjunction of itself,
+// so Or(a, And(a, b)) becomes a. Disjunctions in which some argument does not
+// share a term are left untouched.
+func FactorCommonTerms(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case Or:
+		return factorOr(Or{Args: factorCommonTermsAll(v.Args)})
+	case And:
+		return And{Args: factorCommonTermsAll(v.Args)}
+	case Count:
+		return Count{Count: v.Count, Args: factorCommonTermsAll(v.Args)}
+	case Not:
+		return Not{FactorCommonTerms(v.Arg)}
+	default:
+		return q
+	}
+}
+
+func factorOr(o Or) ConcreteQuery {
+	if len(o.Args) < 2 {
+		return o
+	}
+	arms := make([][]ConcreteQuery, len(o.Args))
+	for i, arg := range o.Args {
+		arms[i] = conjuncts(arg)
+	}
+
+	var common []ConcreteQuery
+	for _, term := range arms[0] {
+		inAll := true
+		for _, arm := range arms[1:] {
+			if indexOfTerm(arm, term) < 0 {
+				inAll = false
+				break
+			}
+		}
+		if inAll && indexOfTerm(common, term) < 0 {
+			common = append(common, term)
+		}
+	}
+	if len(common) == 0 {
+		return o
+	}
+
+	residuals := make([]ConcreteQuery, len(arms))
+	trivial := false
+	for i, arm := range arms {
+		rest := append([]ConcreteQuery(nil), arm...)
+		for _, term := range common {
+			j := indexOfTerm(rest, term)
+			rest = append(rest[:j], rest[j+1:]...)
+		}
+		switch len(rest) {
+		case 0:
+			// The arm is implied by the common terms, as is the whole Or.
+			trivial = true
+		case 1:
+			residuals[i] = rest[0]
+		default:
+			residuals[i] = And{Args: rest}
+		}
+	}
+
+	args := common
+	if !trivial {
+		args = append(args, Or{Args: residuals})
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return And{Args: args}
+}
+
+// conjuncts are the arguments of an And, or the query itself for other
+// queries.
+func conjuncts(q ConcreteQuery) []ConcreteQuery {
+	if a, ok := q.(And); ok {
+		return a.Args
+	}
+	return []ConcreteQuery{q}
+}
+
+func indexOfTerm(qs []ConcreteQuery, q ConcreteQuery) int {
+	for i := range qs {
+		if reflect.DeepEqual(qs[i], q) {
+			return i
+		}
+	}
+	return -1
+}
+
+func factorCommonTermsAll(qs []ConcreteQuery) []ConcreteQuery {
+	args := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		args[i] = FactorCommonTerms(qs[i])
+	}
+	return args
+}
+
+// PushDownNot rewrites a ConcreteQuery such that negations apply only to leaves
+// (i.e., atoms and Count), using De Morgan's laws and eliminating double
+// negation. Negated status equality and inequality atoms are replaced by their
+// complements, and negated True and False are replaced by False and True.
+func PushDownNot(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case Not:
+		return negate(v.Arg)
+	case And:
+		return And{Args: pushDownNotAll(v.Args)}
+	case Or:
+		return Or{Args: pushDownNotAll(v.Args)}
+	case Count:
+		return Count{Count: v.Count, Args: pushDownNotAll(v.Args)}
+	default:
+		return q
+	}
+}
+
+// negate produces the equivalent of Not{q}, with negations pushed down.
+func negate(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case Not:
+		return PushDownNot(v.Arg)
+	case And:
+		args := make([]ConcreteQuery, len(v.Args))
+		for i := range v.Args {
+			args[i] = negate(v.Args[i])
+		}
+		return Or{Args: args}
+	case Or:
+		args := make([]ConcreteQuery, len(v.Args))
+		for i := range v.Args {
+			args[i] = negate(v.Args[i])
+		}
+		return And{Args: args}
+	case True:
+		return False{}
+	case False:
+		return True{}
+	case RunTestStatusEq:
+		return RunTestStatusNeq{Run: v.Run, Status: v.Status}
+	case RunTestStatusNeq:
+		return RunTestStatusEq{Run: v.Run, Status: v.Status}
+	default:
+		return Not{PushDownNot(q)}
+	}
+}
+
+func pushDownNotAll(qs []ConcreteQuery) []ConcreteQuery {
+	args := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		args[i] = PushDownNot(qs[i])
+	}
+	return args
+}
+
+// CollapseSingletons rewrites a ConcreteQuery such that each And or Or with a
+// single argument is replaced by that argument, after collapsing its own
+// descendants. For example, And(Or(And(a))) becomes a. Empty And and Or nodes,
+// and Count nodes (whose single argument is counted rather than equivalent),
+// are kept.
+func CollapseSingletons(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case And:
+		args := collapseSingletonsAll(v.Args)
+		if len(args) == 1 {
+			return args[0]
+		}
+		return And{Args: args}
+	case Or:
+		args := collapseSingletonsAll(v.Args)
+		if len(args) == 1 {
+			return args[0]
+		}
+		return Or{Args: args}
+	case Count:
+		return Count{Count: v.Count, Args: collapseSingletonsAll(v.Args)}
+	case Not:
+		return Not{CollapseSingletons(v.Arg)}
+	default:
+		return q
+	}
+}
+
+func collapseSingletonsAll(qs []ConcreteQuery) []ConcreteQuery {
+	if qs == nil {
+		return nil
+	}
+	args := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		args[i] = CollapseSingletons(qs[i])
+	}
+	return args
+}
+
+// DetectContradictions rewrites a ConcreteQuery such that each And with both
+// some argument x and a sibling Not{x} is replaced by False, and each Or with
+// both x and Not{x} is replaced by True. For example, And(a, b, Not(a)) becomes
+// False. Arguments are compared structurally, after their own descendants have
+// been rewritten.
+func DetectContradictions(q ConcreteQuery) ConcreteQuery {
+	switch v := q.(type) {
+	case And:
+		args := detectContradictionsAll(v.Args)
+		if hasComplement(args) {
+			return False{}
+		}
+		return And{Args: args}
+	case Or:
+		args := detectContradictionsAll(v.Args)
+		if hasComplement(args) {
+			return True{}
+		}
+		return Or{Args: args}
+	case Count:
+		return Count{Count: v.Count, Args: detectContradictionsAll(v.Args)}
+	case Not:
+		return Not{DetectContradictions(v.Arg)}
+	default:
+		return q
+	}
+}
+
+// hasComplement reports whether qs contains both some query x and Not{x}.
+func hasComplement(qs []ConcreteQuery) bool {
+	for _, q := range qs {
+		if n, ok := q.(Not); ok && indexOfTerm(qs, n.Arg) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func detectContradictionsAll(qs []ConcreteQuery) []ConcreteQuery {
+	if qs == nil {
+		return nil
+	}
+	args := make([]ConcreteQuery, len(qs))
+	for i := range qs {
+		args[i] = DetectContradictions(qs[i])
+	}
+	return args
+}
+
+// ToDNF rewrites a ConcreteQuery in disjunctive normal form: an Or of Ands of
+// (possibly negated) leaves. Negations are first pushed down to leaves with
+// PushDownNot, then And is distributed over Or. Since the DNF of a query may be
+// exponentially larger than the query itself, an error is returned if the
+// result would exceed maxDNFTerms conjunctions.
+func ToDNF(q ConcreteQuery) (ConcreteQuery, error) {
+	terms, err := dnfTerms(PushDownNot(q))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(terms) == 0 {
+		return False{}, nil
+	}
+	args := make([]ConcreteQuery, len(terms))
+	for i, term := range terms {
+		switch len(term) {
+		case 0:
+			// An empty conjunction is true, and so is the whole disjunction.
+			return True{}, nil
+		case 1:
+			args[i] = term[0]
+		default:
+			args[i] = And{Args: term}
+		}
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return Or{Args: args}, nil
+}
+
+// dnfTerms computes the conjunctions of leaves that make up the DNF of q, which
+// must already have negations pushed down to leaves.
+func dnfTerms(q ConcreteQuery) ([][]ConcreteQuery, error) {
+	switch v := q.(type) {
+	case True:
+		return [][]ConcreteQuery{{}}, nil
+	case False:
+		return nil, nil
+	case Or:
+		var terms [][]ConcreteQuery
+		for _, arg := range v.Args {
+			argTerms, err := dnfTerms(arg)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, argTerms...)
+			if len(terms) > maxDNFTerms {
+				return nil, errDNFTooLarge()
+			}
+		}
+		return terms, nil
+	case And:
+		terms := [][]ConcreteQuery{{}}
+		for _, arg := range v.Args {
+			argTerms, err := dnfTerms(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(terms)*len(argTerms) > maxDNFTerms {
+				return nil, errDNFTooLarge()
+			}
+			product := make([][]ConcreteQuery, 0, len(terms)*len(argTerms))
+			for _, term := range terms {
+				for _, argTerm := range argTerms {
+					conj := make([]ConcreteQuery, 0, len(term)+len(argTerm))
+					conj = append(conj, term...)
+					product = append(product, append(conj, argTerm...))
+				}
+			}
+			terms = product
+		}
+		return terms, nil
+	default:
+		return [][]ConcreteQuery{{q}}, nil
+	}
+}
+
+func errDNFTooLarge() error {
+	return fmt.Errorf("Disjunctive normal form of query exceeds %d terms", maxDNFTerms)
+}
@@ -0,0 +1,545 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestCombineStatusDisjunctions_sameRun(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusError},
+		},
+	}
+	expected := RunTestStatusIn{
+		Run:      1,
+		Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusError},
+	}
+	assert.Equal(t, expected, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_dedup(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusIn{Run: 1, Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusCrash}},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusError},
+		},
+	}
+	expected := RunTestStatusIn{
+		Run: 1,
+		Statuses: []shared.TestStatus{
+			shared.TestStatusFail,
+			shared.TestStatusCrash,
+			shared.TestStatusError,
+		},
+	}
+	assert.Equal(t, expected, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_mixedRuns(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+		},
+	}
+	assert.Equal(t, q, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_partial(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "css"},
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusTimeout},
+		},
+	}
+	expected := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusIn{
+				Run:      1,
+				Statuses: []shared.TestStatus{shared.TestStatusFail, shared.TestStatusTimeout},
+			},
+			TestNamePattern{Pattern: "css"},
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusFail},
+		},
+	}
+	assert.Equal(t, expected, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_neqUntouched(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusNeq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusNeq{Run: 1, Status: shared.TestStatusError},
+		},
+	}
+	assert.Equal(t, q, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_andNotUntouched(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusError},
+		},
+	}
+	assert.Equal(t, q, CombineStatusDisjunctions(q))
+}
+
+func TestCombineStatusDisjunctions_nested(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Not{
+				Arg: Or{
+					Args: []ConcreteQuery{
+						RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+						RunTestStatusEq{Run: 1, Status: shared.TestStatusOK},
+					},
+				},
+			},
+		},
+	}
+	expected := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "css"},
+			Not{
+				Arg: RunTestStatusIn{
+					Run:      1,
+					Statuses: []shared.TestStatus{shared.TestStatusPass, shared.TestStatusOK},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, CombineStatusDisjunctions(q))
+}
+
+func TestAbsorbRedundantPatterns_redundant(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "/css"},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "/css/flexbox"},
+		},
+	}
+	expected := And{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "/css/flexbox"},
+		},
+	}
+	assert.Equal(t, expected, AbsorbRedundantPatterns(q))
+
+	// A single remaining argument replaces the And.
+	q = And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "flex"},
+			TestNamePattern{Pattern: "/css/flexbox"},
+			TestNamePattern{Pattern: "css", IgnoreCase: true},
+		},
+	}
+	assert.Equal(t, TestNamePattern{Pattern: "/css/flexbox"}, AbsorbRedundantPatterns(q))
+}
+
+func TestAbsorbRedundantPatterns_duplicates(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "/css"},
+			TestNamePattern{Pattern: "/css"},
+		},
+	}
+	assert.Equal(t, TestNamePattern{Pattern: "/css"}, AbsorbRedundantPatterns(q))
+}
+
+func TestAbsorbRedundantPatterns_nonRedundant(t *testing.T) {
+	for _, q := range []ConcreteQuery{
+		// Neither pattern contains the other.
+		And{
+			Args: []ConcreteQuery{
+				TestNamePattern{Pattern: "/css/grid"},
+				TestNamePattern{Pattern: "/css/flexbox"},
+			},
+		},
+		// A case-insensitive pattern does not imply a case-sensitive one.
+		And{
+			Args: []ConcreteQuery{
+				TestNamePattern{Pattern: "/css"},
+				TestNamePattern{Pattern: "/css/flexbox", IgnoreCase: true},
+			},
+		},
+		// Multi-pattern atoms are left untouched.
+		And{
+			Args: []ConcreteQuery{
+				TestNamePattern{Pattern: "/css"},
+				TestNamePattern{Patterns: []string{"/css/flexbox", "/css/grid"}},
+			},
+		},
+		// Patterns under Or are not redundant.
+		Or{
+			Args: []ConcreteQuery{
+				TestNamePattern{Pattern: "/css"},
+				TestNamePattern{Pattern: "/css/flexbox"},
+			},
+		},
+		// Patterns in different conjunctions are not siblings.
+		And{
+			Args: []ConcreteQuery{
+				TestNamePattern{Pattern: "/css"},
+				Not{TestNamePattern{Pattern: "/css/flexbox"}},
+			},
+		},
+	} {
+		assert.Equal(t, q, AbsorbRedundantPatterns(q))
+	}
+}
+
+func TestAbsorbRedundantPatterns_nested(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			Not{
+				And{
+					Args: []ConcreteQuery{
+						TestNamePattern{Pattern: "/css/flexbox"},
+						TestNamePattern{Pattern: "/css"},
+					},
+				},
+			},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+		},
+	}
+	expected := Or{
+		Args: []ConcreteQuery{
+			Not{TestNamePattern{Pattern: "/css/flexbox"}},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+		},
+	}
+	assert.Equal(t, expected, AbsorbRedundantPatterns(q))
+}
+
+func TestFactorCommonTerms_factorable(t *testing.T) {
+	a := TestNamePattern{Pattern: "/css"}
+	b := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	c := RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}
+	q := Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{a, b}},
+			And{Args: []ConcreteQuery{c, a}},
+		},
+	}
+	expected := And{
+		Args: []ConcreteQuery{
+			a,
+			Or{Args: []ConcreteQuery{b, c}},
+		},
+	}
+	assert.Equal(t, expected, FactorCommonTerms(q))
+
+	// Multiple common terms are hoisted together.
+	d := RunSkipped{Run: 3}
+	q = Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{a, d, b}},
+			And{Args: []ConcreteQuery{d, a, c}},
+		},
+	}
+	expected = And{
+		Args: []ConcreteQuery{
+			a,
+			d,
+			Or{Args: []ConcreteQuery{b, c}},
+		},
+	}
+	assert.Equal(t, expected, FactorCommonTerms(q))
+
+	// An arm that is just the common term absorbs the others.
+	q = Or{
+		Args: []ConcreteQuery{
+			a,
+			And{Args: []ConcreteQuery{a, b}},
+		},
+	}
+	assert.Equal(t, a, FactorCommonTerms(q))
+}
+
+func TestFactorCommonTerms_nonFactorable(t *testing.T) {
+	a := TestNamePattern{Pattern: "/css"}
+	b := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	c := RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}
+	for _, q := range []ConcreteQuery{
+		// Only some of the arms share a.
+		Or{
+			Args: []ConcreteQuery{
+				And{Args: []ConcreteQuery{a, b}},
+				And{Args: []ConcreteQuery{a, c}},
+				c,
+			},
+		},
+		Or{Args: []ConcreteQuery{a, b}},
+		// Terms must be equal, not merely similar.
+		Or{
+			Args: []ConcreteQuery{
+				And{Args: []ConcreteQuery{a, b}},
+				And{Args: []ConcreteQuery{TestNamePattern{Pattern: "/css", IgnoreCase: true}, c}},
+			},
+		},
+		And{Args: []ConcreteQuery{a, b}},
+	} {
+		assert.Equal(t, q, FactorCommonTerms(q))
+	}
+}
+
+func TestFactorCommonTerms_nested(t *testing.T) {
+	a := TestNamePattern{Pattern: "/css"}
+	b := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	c := RunTestStatusEq{Run: 2, Status: shared.TestStatusFail}
+	q := Not{
+		Arg: Count{
+			Count: 1,
+			Args: []ConcreteQuery{
+				Or{
+					Args: []ConcreteQuery{
+						And{Args: []ConcreteQuery{a, b}},
+						And{Args: []ConcreteQuery{a, c}},
+					},
+				},
+			},
+		},
+	}
+	expected := Not{
+		Arg: Count{
+			Count: 1,
+			Args: []ConcreteQuery{
+				And{
+					Args: []ConcreteQuery{
+						a,
+						Or{Args: []ConcreteQuery{b, c}},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, FactorCommonTerms(q))
+}
+
+func TestPushDownNot(t *testing.T) {
+	q := Not{
+		Arg: And{
+			Args: []ConcreteQuery{
+				RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+				Or{
+					Args: []ConcreteQuery{
+						TestNamePattern{Pattern: "css"},
+						Not{Arg: TestPath{Path: "/dom/"}},
+					},
+				},
+				True{},
+			},
+		},
+	}
+	expected := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusNeq{Run: 1, Status: shared.TestStatusPass},
+			And{
+				Args: []ConcreteQuery{
+					Not{Arg: TestNamePattern{Pattern: "css"}},
+					TestPath{Path: "/dom/"},
+				},
+			},
+			False{},
+		},
+	}
+	assert.Equal(t, expected, PushDownNot(q))
+}
+
+func TestPushDownNot_count(t *testing.T) {
+	q := Not{
+		Arg: Count{
+			Count: 1,
+			Args: []ConcreteQuery{
+				Not{Arg: Not{Arg: TestNamePattern{Pattern: "css"}}},
+			},
+		},
+	}
+	expected := Not{
+		Arg: Count{
+			Count: 1,
+			Args:  []ConcreteQuery{TestNamePattern{Pattern: "css"}},
+		},
+	}
+	assert.Equal(t, expected, PushDownNot(q))
+}
+
+func TestCollapseSingletons_nested(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			Or{
+				Args: []ConcreteQuery{
+					And{Args: []ConcreteQuery{TestNamePattern{Pattern: "css"}}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, TestNamePattern{Pattern: "css"}, CollapseSingletons(q))
+}
+
+func TestCollapseSingletons_partial(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{RunTestStatusEq{Run: 1, Status: shared.TestStatusPass}}},
+			Not{Arg: Or{Args: []ConcreteQuery{TestPath{Path: "/dom/"}}}},
+			Count{
+				Count: 1,
+				Args: []ConcreteQuery{
+					And{Args: []ConcreteQuery{TestNamePattern{Pattern: "css"}}},
+				},
+			},
+			And{},
+		},
+	}
+	expected := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusPass},
+			Not{Arg: TestPath{Path: "/dom/"}},
+			// Count is kept, but its arguments are collapsed.
+			Count{
+				Count: 1,
+				Args:  []ConcreteQuery{TestNamePattern{Pattern: "css"}},
+			},
+			And{},
+		},
+	}
+	assert.Equal(t, expected, CollapseSingletons(q))
+}
+
+func TestDetectContradictions_contradiction(t *testing.T) {
+	a := TestNamePattern{Pattern: "a"}
+	b := TestPath{Path: "/dom/"}
+	q := And{Args: []ConcreteQuery{a, b, Not{Arg: a}}}
+	assert.Equal(t, False{}, DetectContradictions(q))
+}
+
+func TestDetectContradictions_tautology(t *testing.T) {
+	pass := RunTestStatusEq{Run: 1, Status: shared.TestStatusPass}
+	q := Or{Args: []ConcreteQuery{Not{Arg: pass}, pass}}
+	assert.Equal(t, True{}, DetectContradictions(q))
+}
+
+func TestDetectContradictions_nested(t *testing.T) {
+	a := TestNamePattern{Pattern: "a"}
+	b := TestNamePattern{Pattern: "b"}
+	pass := RunTestStatusEq{Run: 1, Status: shared.TestStatusPass}
+	fail := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	q := Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{a, Not{Arg: a}}},
+			Not{Arg: Or{Args: []ConcreteQuery{b, Not{Arg: b}}}},
+			Count{Count: 1, Args: []ConcreteQuery{And{Args: []ConcreteQuery{pass, Not{Arg: pass}}}}},
+			// Different atoms are not complements.
+			And{Args: []ConcreteQuery{pass, Not{Arg: fail}}},
+		},
+	}
+	expected := Or{
+		Args: []ConcreteQuery{
+			False{},
+			Not{Arg: True{}},
+			Count{Count: 1, Args: []ConcreteQuery{False{}}},
+			And{Args: []ConcreteQuery{pass, Not{Arg: fail}}},
+		},
+	}
+	assert.Equal(t, expected, DetectContradictions(q))
+}
+
+func TestToDNF_simple(t *testing.T) {
+	a := TestNamePattern{Pattern: "a"}
+	b := TestNamePattern{Pattern: "b"}
+	c := TestNamePattern{Pattern: "c"}
+
+	q, err := ToDNF(And{Args: []ConcreteQuery{a, Or{Args: []ConcreteQuery{b, c}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{a, b}},
+			And{Args: []ConcreteQuery{a, c}},
+		},
+	}, q)
+
+	q, err = ToDNF(a)
+	assert.Nil(t, err)
+	assert.Equal(t, a, q)
+
+	q, err = ToDNF(And{Args: []ConcreteQuery{a, True{}}})
+	assert.Nil(t, err)
+	assert.Equal(t, a, q)
+
+	q, err = ToDNF(And{Args: []ConcreteQuery{a, False{}}})
+	assert.Nil(t, err)
+	assert.Equal(t, False{}, q)
+
+	q, err = ToDNF(Or{Args: []ConcreteQuery{a, Not{Arg: False{}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, True{}, q)
+}
+
+func TestToDNF_nested(t *testing.T) {
+	a := TestNamePattern{Pattern: "a"}
+	b := TestNamePattern{Pattern: "b"}
+	c := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	d := TestPath{Path: "/d/"}
+
+	// Not(a AND (b OR c)) AND d  =>  (!a AND d) OR (!b AND !c AND d)
+	q, err := ToDNF(And{
+		Args: []ConcreteQuery{
+			Not{
+				Arg: And{
+					Args: []ConcreteQuery{a, Or{Args: []ConcreteQuery{b, c}}},
+				},
+			},
+			d,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, Or{
+		Args: []ConcreteQuery{
+			And{Args: []ConcreteQuery{Not{Arg: a}, d}},
+			And{
+				Args: []ConcreteQuery{
+					Not{Arg: b},
+					RunTestStatusNeq{Run: 1, Status: shared.TestStatusFail},
+					d,
+				},
+			},
+		},
+	}, q)
+}
+
+func TestToDNF_tooLarge(t *testing.T) {
+	// A conjunction of 11 binary disjunctions has 2^11 = 2048 DNF terms.
+	ands := make([]ConcreteQuery, 11)
+	for i := range ands {
+		ands[i] = Or{
+			Args: []ConcreteQuery{
+				RunTestStatusEq{Run: int64(i), Status: shared.TestStatusPass},
+				RunTestStatusEq{Run: int64(i), Status: shared.TestStatusOK},
+			},
+		}
+	}
+	_, err := ToDNF(And{Args: ands})
+	assert.NotNil(t, err)
+
+	// 10 binary disjunctions (1024 terms) are within the limit.
+	q, err := ToDNF(And{Args: ands[:10]})
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, len(q.(Or).Args))
+}
@@ -0,0 +1,28 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+// AtomSchema describes a query atom (or combinator) accepted in structured
+// search queries.
+type AtomSchema struct {
+	// Key is the JSON property that identifies the atom. Nested properties are
+	// dot-separated, e.g. "status.not".
+	Key string `json:"key"`
+	// Required is the JSON properties that must be present for the atom to parse.
+	Required []string `json:"required"`
+	// Description is a short, human-readable description of the atom.
+	Description string `json:"description"`
+}
+
+// SupportedAtoms returns the schema of every atom that the structured query
+// parser accepts, in the order in which the parser attempts to match them.
+func SupportedAtoms() []AtomSchema {
+	schemas := make([]AtomSchema, len(atomParsers))
+	for i, p := range atomParsers {
+		schemas[i] = p.schema
+		schemas[i].Required = append([]string(nil), p.schema.Required...)
+	}
+	return schemas
+}
@@ -0,0 +1,102 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Example query fragments, by atom key, for every atom unmarshalQ supports.
+var atomExamples = map[string]string{
+	"pattern":            `{"pattern":"cssom"}`,
+	"path":               `{"path":"/dom/"}`,
+	"test_name_in":       `{"test_name_in":["/a.html","/b.html"]}`,
+	"any_changed":        `{"any_changed":"last_stable"}`,
+	"subtest_delta":      `{"subtest_delta":{"from":"chrome","to":"firefox","changed":true}}`,
+	"same_render":        `{"same_render":{"a":"chrome","b":"firefox"}}`,
+	"manifest_paths":     `{"manifest_paths":["/css/a.html","/dom/"],"prefix":true}`,
+	"shard":              `{"shard":{"index":3,"total":8}}`,
+	"status":             `{"product":"chrome","status":"PASS"}`,
+	"status.not":         `{"status":{"not":"PASS"}}`,
+	"any_status":         `{"any_status":"CRASH"}`,
+	"has_screenshot":     `{"has_screenshot":"chrome"}`,
+	"subtest_total":      `{"subtest_total":{"gte":500}}`,
+	"regressed_since":    `{"regressed_since":"last_stable"}`,
+	"changed_in_pr":      `{"changed_in_pr":true}`,
+	"long_timeout":       `{"long_timeout":true}`,
+	"has_metadata":       `{"has_metadata":"bug"}`,
+	"present_in_all":     `{"present_in_all":true}`,
+	"browsers_failing":   `{"browsers_failing":{"eq":2}}`,
+	"worse_than_median":  `{"worse_than_median":"safari"}`,
+	"subtest_only_in":    `{"subtest_only_in":{"browser_name":"chrome","subtest":"foo"}}`,
+	"skipped":            `{"skipped":"firefox"}`,
+	"first_seen":         `{"first_seen":{"after":"2024-01-01T00:00:00Z"}}`,
+	"coverage":           `{"coverage":{"gte":3}}`,
+	"unexpected":         `{"unexpected":"chrome"}`,
+	"intermittent":       `{"intermittent":"chrome"}`,
+	"asserts":            `{"asserts":{"browser_name":"chrome","eq":0}}`,
+	"subtest_majority":   `{"subtest_majority":{"browser_name":"chrome","status":"FAIL"}}`,
+	"harness_message":    `{"harness_message":{"browser_name":"chrome","pattern":"uncaught"}}`,
+	"focus_area":         `{"focus_area":"flexbox"}`,
+	"interop_year":       `{"interop_year":2021}`,
+	"subtest":            `{"subtest":"foo","exact":true}`,
+	"all_subtests_pass":  `{"all_subtests_pass":"chrome"}`,
+	"subtest_pass_ratio": `{"subtest_pass_ratio":{"browser_name":"chrome","below":0.5}}`,
+	"flakiness":          `{"flakiness":{"browser_name":"chrome","above":0.2}}`,
+	"ref":                `{"ref":{"browser_name":"chrome","expect":"mismatch"}}`,
+	"not":                `{"not":{"pattern":"cssom"}}`,
+	"or":                 `{"or":[{"pattern":"a"},{"pattern":"b"}]}`,
+	"and":                `{"and":[{"pattern":"a"},{"pattern":"b"}]}`,
+	"exists":             `{"exists":[{"pattern":"a"}]}`,
+	"sequential":         `{"sequential":[{"status":"PASS"},{"status":"FAIL"}]}`,
+	"count":              `{"count":1,"where":{"status":"PASS"}}`,
+	"where":              `{"quantifier":"all","where":[{"status":"PASS"}]}`,
+}
+
+func TestSupportedAtoms_allRegistered(t *testing.T) {
+	schemas := SupportedAtoms()
+	assert.Equal(t, len(atomParsers), len(schemas))
+	keys := make(map[string]bool)
+	for i, p := range atomParsers {
+		assert.Equal(t, p.schema, schemas[i])
+		keys[schemas[i].Key] = true
+	}
+	for key := range atomExamples {
+		assert.True(t, keys[key], "Missing schema for atom %s", key)
+	}
+	for key := range keys {
+		_, ok := atomExamples[key]
+		assert.True(t, ok, "Missing example for atom %s", key)
+	}
+}
+
+func TestSupportedAtoms_examplesParse(t *testing.T) {
+	types := make(map[reflect.Type]string)
+	for _, schema := range SupportedAtoms() {
+		assert.NotEmpty(t, schema.Required)
+		assert.NotEmpty(t, schema.Description)
+
+		q, err := unmarshalQ([]byte(atomExamples[schema.Key]))
+		assert.Nil(t, err)
+		typ := reflect.TypeOf(q)
+		other, dup := types[typ]
+		assert.False(t, dup, "Atoms %s and %s parse to the same type %v", schema.Key, other, typ)
+		types[typ] = schema.Key
+	}
+	assert.Equal(t, len(atomParsers), len(types))
+}
+
+func TestSupportedAtoms_copy(t *testing.T) {
+	schemas := SupportedAtoms()
+	schemas[0].Key = "mutated"
+	schemas[0].Required[0] = "mutated"
+	assert.NotEqual(t, "mutated", atomParsers[0].schema.Key)
+	assert.NotEqual(t, "mutated", atomParsers[0].schema.Required[0])
+}
@@ -55,8 +55,19 @@ type SearchResult struct {
 
 	// Diff count of subtests which are included in the LegacyStatus summary.
 	Diff shared.TestDiff `json:"diff,omitempty"`
+
+	// Statuses of the test itself in each run, when requested (see
+	// AggregationOpts.IncludeStatuses). Runs without a result for the test have
+	// the status StatusMissing, rather than a zero-valued entry.
+	Statuses []string `json:"statuses,omitempty"`
 }
 
+// StatusMissing is the status in SearchResult.Statuses of a test in a run that
+// has no result for it. It is distinct from the names of all test statuses
+// (including UNKNOWN), so that clients need not interpret a gap in a result
+// vector.
+const StatusMissing = "MISSING"
+
 // SearchResponse contains a response to search API calls, including specific
 // runs whose results were searched and the search results themselves.
 type SearchResponse struct {
@@ -137,6 +148,21 @@ func (sh structuredSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Resolve run aliases here, and forward the resolved query, so that the
+	// searchcache searches the same runs as those reported by the simple path.
+	if len(rq.RunAliases) > 0 {
+		rq, err = rq.ResolveRuns(NewStoreRunResolver(sh.store))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err = json.Marshal(rq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Check if the query is a simple (empty/just True, or test name only) query
 	var simpleQ TestNamePattern
 	var isSimpleQ bool
@@ -145,12 +171,17 @@ func (sh structuredSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 			isSimpleQ = true
 		} else if exists, isExists := rq.AbstractQuery.(AbstractExists); isExists && len(exists.Args) == 1 {
 			simpleQ, isSimpleQ = exists.Args[0].(TestNamePattern)
+			// Only a single, plain pattern is supported by unstructured search.
+			isSimpleQ = isSimpleQ && len(simpleQ.Patterns) == 0 && !simpleQ.IgnoreCase
 		}
 		q := r.URL.Query()
 		_, interop := q["interop"]
 		_, subtests := q["subtests"]
 		_, diff := q["diff"]
-		isSimpleQ = isSimpleQ && !interop && !subtests && !diff
+		_, statuses := q["statuses"]
+		isSimpleQ = isSimpleQ && !interop && !subtests && !diff && !statuses
+		// Column selection and run aliases are only supported by the searchcache.
+		isSimpleQ = isSimpleQ && len(rq.Columns) == 0 && len(rq.RunAliases) == 0
 	}
 
 	if !isSimpleQ {
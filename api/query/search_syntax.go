@@ -0,0 +1,574 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// ParseSearch parses the compact syntax of the search box (for example,
+// `chrome:fail /css/ NOT firefox:pass`) into an AbstractQuery, following the
+// grammar in webapp/components/test-search.js. Space-separated root
+// expressions must each be satisfied by some run, so the result is an
+// AbstractExists. An empty search matches all tests.
+//
+// One deliberate difference from the frontend grammar is that the keywords
+// "and", "or" and "not" must be followed by a character that cannot continue
+// a test name pattern, so that patterns such as "orientation" are not split.
+func ParseSearch(input string) (AbstractQuery, error) {
+	p := searchParser{input: input}
+	var args []AbstractQuery
+	for {
+		p.skipSpace()
+		if p.done() {
+			break
+		}
+		q, err := p.parseRootExp()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, q)
+	}
+	if len(args) == 0 {
+		return emptySearchQuery(), nil
+	}
+	return AbstractExists{Args: args}, nil
+}
+
+// SearchToJSON parses the compact syntax of the search box with ParseSearch,
+// and produces the equivalent structured query in its canonical JSON form.
+func SearchToJSON(input string) (json.RawMessage, error) {
+	q, err := ParseSearch(input)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// emptySearchQuery is the query for an empty search (or sequence), which
+// matches all tests.
+func emptySearchQuery() AbstractQuery {
+	return AbstractExists{Args: []AbstractQuery{TestNamePattern{Pattern: ""}}}
+}
+
+// searchParser is a recursive descent parser for the search box syntax. pos
+// is a byte offset into input.
+type searchParser struct {
+	input string
+	pos   int
+}
+
+func (p *searchParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *searchParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return r
+}
+
+func (p *searchParser) skipSpace() {
+	for !p.done() {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+// consume advances past s, if the remaining input starts with it.
+func (p *searchParser) consume(s string) bool {
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// consumeKeyword advances past the (case-insensitive) keyword kw, if the
+// remaining input starts with it and it is not the prefix of a longer name.
+func (p *searchParser) consumeKeyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.input) {
+		if r, _ := utf8.DecodeRuneInString(p.input[end:]); isBasicNameChar(r) {
+			return false
+		}
+	}
+	p.pos = end
+	return true
+}
+
+func (p *searchParser) errUnexpected() error {
+	if p.done() {
+		return fmt.Errorf(`Unexpected end of search at position %d`, p.pos)
+	}
+	return fmt.Errorf(`Unexpected character "%c" in search at position %d`, p.peek(), p.pos)
+}
+
+func (p *searchParser) expect(s string) error {
+	p.skipSpace()
+	if !p.consume(s) {
+		return p.errUnexpected()
+	}
+	return nil
+}
+
+// parseRootExp parses a sequential query, a count query, or an expression.
+func (p *searchParser) parseRootExp() (AbstractQuery, error) {
+	if p.consume("seq(") {
+		return p.parseSequential()
+	}
+	start := p.pos
+	if count, ok := p.parseCountSpecifier(); ok {
+		p.skipSpace()
+		if p.consume("(") {
+			where, err := p.parseExp()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return AbstractCount{Count: count, Where: where}, nil
+		}
+	}
+	p.pos = start
+	return p.parseExp()
+}
+
+// parseSequential parses the space-separated expressions of a sequential
+// query, following "seq(", up to the closing parenthesis.
+func (p *searchParser) parseSequential() (AbstractQuery, error) {
+	var args []AbstractQuery
+	for {
+		p.skipSpace()
+		if p.consume(")") {
+			break
+		}
+		if p.done() {
+			return nil, p.errUnexpected()
+		}
+		q, err := p.parseExp()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, q)
+	}
+	if len(args) == 0 {
+		return emptySearchQuery(), nil
+	}
+	return AbstractSequential{Args: args}, nil
+}
+
+// parseCountSpecifier parses "count:<number>", "three", "two" or "one".
+func (p *searchParser) parseCountSpecifier() (int, bool) {
+	if p.consume("count:") {
+		start := p.pos
+		for !p.done() && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		count, err := strconv.Atoi(p.input[start:p.pos])
+		return count, err == nil
+	}
+	for i, word := range []string{"one", "two", "three"} {
+		if p.consume(word) {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// parseExp parses a disjunction of conjunctions.
+func (p *searchParser) parseExp() (AbstractQuery, error) {
+	return p.parseList(p.parseOrPart, "|", "or", func(args []AbstractQuery) AbstractQuery {
+		return AbstractOr{Args: args}
+	})
+}
+
+// parseOrPart parses a conjunction.
+func (p *searchParser) parseOrPart() (AbstractQuery, error) {
+	return p.parseList(p.parseAndPart, "&", "and", func(args []AbstractQuery) AbstractQuery {
+		return AbstractAnd{Args: args}
+	})
+}
+
+// parseList parses one or more parts separated by the given symbol or keyword,
+// combining them with combine when there is more than one.
+func (p *searchParser) parseList(
+	parsePart func() (AbstractQuery, error),
+	symbol, keyword string,
+	combine func([]AbstractQuery) AbstractQuery) (AbstractQuery, error) {
+	part, err := parsePart()
+	if err != nil {
+		return nil, err
+	}
+	args := []AbstractQuery{part}
+	for {
+		end := p.pos
+		p.skipSpace()
+		if !p.consume(symbol) && !p.consumeKeyword(keyword) {
+			p.pos = end
+			break
+		}
+		part, err := parsePart()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, part)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return combine(args), nil
+}
+
+// parseAndPart parses a parenthesized expression, a negation, or a fragment.
+func (p *searchParser) parseAndPart() (AbstractQuery, error) {
+	p.skipSpace()
+	if p.consume("(") {
+		q, err := p.parseExp()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+	if p.consume("!") || p.consumeKeyword("not") {
+		q, err := p.parseAndPart()
+		if err != nil {
+			return nil, err
+		}
+		return AbstractNot{Arg: q}, nil
+	}
+	return p.parseFragment()
+}
+
+// parseFragment parses a status constraint, a path, or a test name pattern.
+func (p *searchParser) parseFragment() (AbstractQuery, error) {
+	if q, ok, err := p.parseStatusExp(); ok || err != nil {
+		return q, err
+	}
+	if p.consumeKeywordPrefix("path:") {
+		path, err := p.parseNameFragment()
+		if err != nil {
+			return nil, err
+		}
+		return TestPath{Path: path}, nil
+	}
+	pattern, err := p.parseNameFragment()
+	if err != nil {
+		return nil, err
+	}
+	return TestNamePattern{Pattern: pattern}, nil
+}
+
+// consumeKeywordPrefix advances past the (case-insensitive) prefix s, if the
+// remaining input starts with it.
+func (p *searchParser) consumeKeywordPrefix(s string) bool {
+	end := p.pos + len(s)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], s) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// parseStatusExp parses "status:<status>", "<product>:<status>", or either
+// with ":!" in place of ":" for a status inequality. It rewinds, and reports
+// false, if the input is not a status constraint.
+func (p *searchParser) parseStatusExp() (q AbstractQuery, ok bool, err error) {
+	start := p.pos
+	for !p.done() && isProductSpecChar(p.input[p.pos]) {
+		p.pos++
+	}
+	head := strings.ToLower(p.input[start:p.pos])
+	if head == "" || !p.consume(":") {
+		p.pos = start
+		return nil, false, nil
+	}
+
+	var product *shared.ProductSpec
+	if head != "status" {
+		browserName := head
+		if i := strings.IndexByte(head, '-'); i >= 0 {
+			browserName = head[:i]
+			if !isBrowserVersion(head[i+1:]) {
+				p.pos = start
+				return nil, false, nil
+			}
+		}
+		if !isDefaultBrowserName(browserName) {
+			p.pos = start
+			return nil, false, nil
+		}
+		spec, err := shared.ParseProductSpec(head)
+		if err != nil {
+			return nil, false, err
+		}
+		product = &spec
+	}
+
+	neq := p.consume("!")
+	statusStart := p.pos
+	for !p.done() && isASCIILetter(p.input[p.pos]) {
+		p.pos++
+	}
+	statusStr := strings.ToUpper(p.input[statusStart:p.pos])
+	status := shared.TestStatusValueFromString(statusStr)
+	if statusStr == "" || statusStr != status.String() {
+		return nil, false, withDetail(ErrInvalidStatus, ` in search at position %d: "%s"`, statusStart, p.input[statusStart:p.pos])
+	}
+
+	if neq {
+		return TestStatusNeq{Product: product, Status: status}, true, nil
+	}
+	return TestStatusEq{Product: product, Status: status}, true, nil
+}
+
+// parseNameFragment parses a test name pattern, either as a run of letters,
+// digits and the characters "/.-_?", or as a quoted string of any characters
+// other than whitespace and quotation marks.
+func (p *searchParser) parseNameFragment() (string, error) {
+	if p.consume(`"`) {
+		start := p.pos
+		for !p.done() && p.peek() != '"' && !unicode.IsSpace(p.peek()) {
+			_, size := utf8.DecodeRuneInString(p.input[p.pos:])
+			p.pos += size
+		}
+		fragment := p.input[start:p.pos]
+		if fragment == "" || !p.consume(`"`) {
+			return "", p.errUnexpected()
+		}
+		return fragment, nil
+	}
+
+	start := p.pos
+	for !p.done() {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !isBasicNameChar(r) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errUnexpected()
+	}
+	return p.input[start:p.pos], nil
+}
+
+func isBasicNameChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("/.-_?", r)
+}
+
+func isProductSpecChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '-' || c == '.'
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isBrowserVersion reports whether s is a dot-separated sequence of numbers.
+func isBrowserVersion(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isDefaultBrowserName(name string) bool {
+	return defaultBrowserNames[name]
+}
+
+// QueryString is the inverse of ParseSearch: it produces the compact syntax of
+// the search box for q, such that ParseSearch(QueryString(q)) is equivalent to
+// q. This allows a query to be edited structurally and then shown in the search
+// box again. Only the atoms and combinators that the search syntax can express
+// are supported; others, such as status atoms for products with labels or
+// revisions, produce an error.
+func QueryString(q AbstractQuery) (string, error) {
+	exists, ok := q.(AbstractExists)
+	if !ok {
+		return searchRootString(q)
+	}
+	if reflect.DeepEqual(q, emptySearchQuery()) {
+		return "", nil
+	}
+	parts := make([]string, len(exists.Args))
+	for i, arg := range exists.Args {
+		part, err := searchRootString(arg)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// searchRootString produces the search syntax for a root expression: a
+// sequential query, a count query, or an expression.
+func searchRootString(q AbstractQuery) (string, error) {
+	switch v := q.(type) {
+	case AbstractSequential:
+		parts := make([]string, len(v.Args))
+		for i, arg := range v.Args {
+			part, err := searchExpString(arg)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+		return "seq(" + strings.Join(parts, " ") + ")", nil
+	case AbstractCount:
+		where, err := searchExpString(v.Where)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("count:%d(%s)", v.Count, where), nil
+	}
+	return searchExpString(q)
+}
+
+// searchExpString produces the search syntax for an expression. Operands are
+// parenthesized where the grammar would otherwise combine them differently.
+func searchExpString(q AbstractQuery) (string, error) {
+	switch v := q.(type) {
+	case AbstractOr:
+		return searchListString(v.Args, " | ", func(arg AbstractQuery) bool {
+			_, isOr := arg.(AbstractOr)
+			return isOr
+		})
+	case AbstractAnd:
+		return searchListString(v.Args, " & ", isSearchList)
+	case AbstractNot:
+		arg, err := searchExpString(v.Arg)
+		if err != nil {
+			return "", err
+		}
+		if isSearchList(v.Arg) {
+			arg = "(" + arg + ")"
+		}
+		return "!" + arg, nil
+	case TestStatusEq:
+		return searchStatusString(v.Product, v.Products, v.OS, v.Revision, v.FullRun, ":", v.Status)
+	case TestStatusNeq:
+		return searchStatusString(v.Product, v.Products, v.OS, v.Revision, v.FullRun, ":!", v.Status)
+	case TestPath:
+		path, err := searchNameString(v.Path)
+		if err != nil {
+			return "", err
+		}
+		return "path:" + path, nil
+	case TestNamePattern:
+		if len(v.Patterns) > 0 || v.MatchAll || v.IgnoreCase {
+			return "", errors.New("Test name patterns with multiple patterns or options have no search syntax")
+		}
+		return searchNameString(v.Pattern)
+	}
+	return "", fmt.Errorf("%T query has no search syntax", q)
+}
+
+func isSearchList(q AbstractQuery) bool {
+	switch q.(type) {
+	case AbstractOr, AbstractAnd:
+		return true
+	}
+	return false
+}
+
+// searchListString joins the search syntax for args with sep, parenthesizing
+// those for which needsParens holds.
+func searchListString(args []AbstractQuery, sep string, needsParens func(AbstractQuery) bool) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("Empty and/or queries have no search syntax")
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		part, err := searchExpString(arg)
+		if err != nil {
+			return "", err
+		}
+		if needsParens(arg) {
+			part = "(" + part + ")"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// searchStatusString produces "status<op><status>" or "<product><op><status>".
+// Only a default browser name and optional version can be expressed as the
+// product.
+func searchStatusString(product *shared.ProductSpec, products []shared.ProductSpec, os, revision string, fullRun *bool, op string, status shared.TestStatus) (string, error) {
+	if len(products) > 0 || os != "" || revision != "" || fullRun != nil {
+		return "", errors.New("Status queries over multiple products, an OS, a revision or a full run flag have no search syntax")
+	}
+	head := "status"
+	if product != nil {
+		if !isDefaultBrowserName(product.BrowserName) ||
+			(product.BrowserVersion != "" && !isBrowserVersion(product.BrowserVersion)) ||
+			product.OSName != "" ||
+			product.OSVersion != "" ||
+			(product.Revision != "" && product.Revision != "latest") ||
+			product.FullRevisionHash != "" ||
+			(product.Labels != nil && product.Labels.Cardinality() > 0) {
+			return "", fmt.Errorf(`Product "%s" has no search syntax`, product.String())
+		}
+		head = product.BrowserName
+		if product.BrowserVersion != "" {
+			head += "-" + product.BrowserVersion
+		}
+	}
+	return head + op + strings.ToLower(status.String()), nil
+}
+
+// searchNameString produces a test name fragment for name: bare when possible,
+// otherwise quoted. Names that are keywords of the syntax are quoted too.
+func searchNameString(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `"`) || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return "", fmt.Errorf(`Test name fragment "%s" has no search syntax`, name)
+	}
+	bare := true
+	for _, r := range name {
+		if !isBasicNameChar(r) {
+			bare = false
+			break
+		}
+	}
+	switch strings.ToLower(name) {
+	case "and", "or", "not", "one", "two", "three":
+		bare = false
+	}
+	if bare {
+		return name, nil
+	}
+	return `"` + name + `"`, nil
+}
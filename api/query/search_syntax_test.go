@@ -0,0 +1,253 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestParseSearch_empty(t *testing.T) {
+	q, err := ParseSearch("  ")
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{Args: []AbstractQuery{TestNamePattern{Pattern: ""}}}, q)
+}
+
+func TestParseSearch_atoms(t *testing.T) {
+	q, err := ParseSearch(`chrome:fail /css/ Firefox:!pass status:ok path:/dom/ "a:b"`)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: productPtr(shared.ParseProductSpecUnsafe("chrome")), Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "/css/"},
+			TestStatusNeq{Product: productPtr(shared.ParseProductSpecUnsafe("firefox")), Status: shared.TestStatusPass},
+			TestStatusEq{Status: shared.TestStatusOK},
+			TestPath{Path: "/dom/"},
+			TestNamePattern{Pattern: "a:b"},
+		},
+	}, q)
+}
+
+func TestParseSearch_operators(t *testing.T) {
+	q, err := ParseSearch(`a | b & !c or (d and not e)`)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{
+		Args: []AbstractQuery{
+			AbstractOr{
+				Args: []AbstractQuery{
+					TestNamePattern{Pattern: "a"},
+					AbstractAnd{
+						Args: []AbstractQuery{
+							TestNamePattern{Pattern: "b"},
+							AbstractNot{Arg: TestNamePattern{Pattern: "c"}},
+						},
+					},
+					AbstractAnd{
+						Args: []AbstractQuery{
+							TestNamePattern{Pattern: "d"},
+							AbstractNot{Arg: TestNamePattern{Pattern: "e"}},
+						},
+					},
+				},
+			},
+		},
+	}, q)
+}
+
+func TestParseSearch_keywordPrefixes(t *testing.T) {
+	q, err := ParseSearch(`orientation android nothing`)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{
+		Args: []AbstractQuery{
+			TestNamePattern{Pattern: "orientation"},
+			TestNamePattern{Pattern: "android"},
+			TestNamePattern{Pattern: "nothing"},
+		},
+	}, q)
+}
+
+func TestParseSearch_sequentialAndCount(t *testing.T) {
+	q, err := ParseSearch(`seq(status:pass status:fail) two(safari:crash) count:4(status:!ok)`)
+	assert.Nil(t, err)
+	assert.Equal(t, AbstractExists{
+		Args: []AbstractQuery{
+			AbstractSequential{
+				Args: []AbstractQuery{
+					TestStatusEq{Status: shared.TestStatusPass},
+					TestStatusEq{Status: shared.TestStatusFail},
+				},
+			},
+			AbstractCount{
+				Count: 2,
+				Where: TestStatusEq{
+					Product: productPtr(shared.ParseProductSpecUnsafe("safari")),
+					Status:  shared.TestStatusCrash,
+				},
+			},
+			AbstractCount{
+				Count: 4,
+				Where: TestStatusNeq{Status: shared.TestStatusOK},
+			},
+		},
+	}, q)
+}
+
+func TestParseSearch_invalid(t *testing.T) {
+	for _, input := range []string{
+		`chrome:bogus`,
+		`(a | b`,
+		`seq(a`,
+		`a |`,
+		`foo:bar`,
+		`"unterminated`,
+	} {
+		_, err := ParseSearch(input)
+		assert.NotNil(t, err, "Expected error parsing %s", input)
+	}
+}
+
+func TestSearchToJSON(t *testing.T) {
+	j, err := SearchToJSON(`chrome-69:fail !/css/`)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"exists":[{"product":"chrome-69","status":"FAIL"},{"not":{"pattern":"/css/"}}]}`, string(j))
+
+	_, err = SearchToJSON(`chrome:bogus`)
+	assert.NotNil(t, err)
+}
+
+func TestSearchToJSON_roundTrip(t *testing.T) {
+	for _, input := range []string{
+		``,
+		`cssom`,
+		`chrome:fail /css/ NOT firefox:pass`,
+		`edge-17:!timeout & (path:/dom/ | "a?b")`,
+		`seq(status:pass status:fail)`,
+		`one(chrome:pass | firefox:pass)`,
+		`count:3(status:!ok) safari:crash`,
+	} {
+		expected, err := ParseSearch(input)
+		assert.Nil(t, err, "Failed to parse %s", input)
+		j, err := SearchToJSON(input)
+		assert.Nil(t, err, "Failed to convert %s", input)
+		actual, err := unmarshalQ(j)
+		assert.Nil(t, err, "Failed to unmarshal %s", string(j))
+		assert.Equal(t, expected, actual, "Round trip of %s via %s", input, string(j))
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	s, err := QueryString(AbstractExists{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: productPtr(shared.ParseProductSpecUnsafe("chrome")), Status: shared.TestStatusFail},
+			TestNamePattern{Pattern: "/css/"},
+			AbstractNot{Arg: TestStatusEq{Product: productPtr(shared.ParseProductSpecUnsafe("firefox")), Status: shared.TestStatusPass}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `chrome:fail /css/ !firefox:pass`, s)
+
+	s, err = QueryString(AbstractExists{
+		Args: []AbstractQuery{
+			AbstractAnd{
+				Args: []AbstractQuery{
+					AbstractOr{Args: []AbstractQuery{TestNamePattern{Pattern: "a"}, TestNamePattern{Pattern: "b"}}},
+					AbstractNot{Arg: AbstractAnd{Args: []AbstractQuery{TestPath{Path: "/dom/"}, TestNamePattern{Pattern: "or"}}}},
+				},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `(a | b) & !(path:/dom/ & "or")`, s)
+
+	s, err = QueryString(emptySearchQuery())
+	assert.Nil(t, err)
+	assert.Equal(t, ``, s)
+}
+
+func TestQueryString_roundTrip(t *testing.T) {
+	for _, input := range []string{
+		`cssom`,
+		`chrome:fail /css/ NOT firefox:pass`,
+		`edge-17:!timeout & (path:/dom/ | "a?b")`,
+		`"a:b" "not" and_more`,
+		`seq(status:pass status:fail)`,
+		`one(chrome:pass | firefox:pass)`,
+		`count:3(status:!ok) safari:crash`,
+		`(a | b) | c`,
+		`a & (b & c)`,
+		`!(a | b) & !!c`,
+	} {
+		expected, err := ParseSearch(input)
+		assert.Nil(t, err, "Failed to parse %s", input)
+		s, err := QueryString(expected)
+		assert.Nil(t, err, "Failed to produce search for %s", input)
+		actual, err := ParseSearch(s)
+		assert.Nil(t, err, "Failed to parse %s", s)
+		assert.Equal(t, expected, actual, "Round trip of %s via %s", input, s)
+	}
+}
+
+func TestQueryString_unsupported(t *testing.T) {
+	for _, q := range []AbstractQuery{
+		Skipped{BrowserName: "chrome"},
+		TestNamePattern{Pattern: "a", IgnoreCase: true},
+		TestNamePattern{Pattern: "a b"},
+		TestStatusEq{Product: productPtr(shared.ParseProductSpecUnsafe("chrome[experimental]")), Status: shared.TestStatusPass},
+		TestStatusEq{Status: shared.TestStatusPass, OS: "linux"},
+		AbstractOr{},
+		AbstractExists{Args: []AbstractQuery{AbstractNot{Arg: TestNameIn{Names: []string{"/a.html"}}}}},
+	} {
+		_, err := QueryString(q)
+		assert.NotNil(t, err, "Expected error producing search for %v", q)
+	}
+}
+
+func TestMarshalJSON_roundTrip(t *testing.T) {
+	for _, example := range atomExamples {
+		q, err := unmarshalQ([]byte(example))
+		assert.Nil(t, err)
+		data, err := json.Marshal(q)
+		assert.Nil(t, err)
+		roundTrip, err := unmarshalQ(data)
+		assert.Nil(t, err, "Failed to unmarshal %s", string(data))
+		assert.Equal(t, q, roundTrip)
+	}
+
+	for _, example := range []string{
+		`{"pattern":["a","b"],"match_all":true,"ignore_case":true}`,
+		`{"subtest_total":{"gte":2,"lte":10}}`,
+		`{"product":["chrome","firefox"],"status":{"not":"PASS"}}`,
+		`{"product":"chrome","os":"linux","status":"FAIL"}`,
+	} {
+		q, err := unmarshalQ([]byte(example))
+		assert.Nil(t, err)
+		data, err := json.Marshal(q)
+		assert.Nil(t, err)
+		assert.Equal(t, example, string(data))
+	}
+}
+
+func TestRunQuery_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(RunQuery{RunIDs: []int64{1, 2}, AbstractQuery: True{}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"run_ids":[1,2]}`, string(data))
+
+	data, err = json.Marshal(RunQuery{RunIDs: []int64{1}, AbstractQuery: TestPath{Path: "/dom/"}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"run_ids":[1],"query":{"path":"/dom/"}}`, string(data))
+
+	var rq RunQuery
+	assert.Nil(t, json.Unmarshal(data, &rq))
+	assert.Equal(t, RunQuery{RunIDs: []int64{1}, AbstractQuery: TestPath{Path: "/dom/"}}, rq)
+}
+
+func productPtr(p shared.ProductSpec) *shared.ProductSpec {
+	return &p
+}
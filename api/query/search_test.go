@@ -8,6 +8,8 @@ package query
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -141,6 +143,125 @@ func TestStructuredSearchHandler_success(t *testing.T) {
 	assert.Equal(t, respBytes, w.Body.Bytes())
 }
 
+func TestStructuredSearchHandler_multiplePatterns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	respBytes := []byte(`{}`)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/search/cache", r.URL.Path)
+		w.Write(respBytes)
+	}))
+
+	serverURL, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	hostname := serverURL.Host
+
+	api := sharedtest.NewMockAppEngineAPI(ctrl)
+	r := httptest.NewRequest("POST", "https://example.com/api/query", bytes.NewBuffer([]byte(`{"run_ids":[1,2],"query":{"exists":[{"pattern":["flexbox","grid"],"match_all":true}]}}`)))
+
+	api.EXPECT().Context().Return(sharedtest.NewTestContext())
+	api.EXPECT().GetServiceHostname("searchcache").Return(hostname)
+	api.EXPECT().GetHTTPClient().Return(server.Client())
+	w := httptest.NewRecorder()
+	structuredSearchHandler{queryHandler{}, api}.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, respBytes, w.Body.Bytes())
+}
+
+func TestStructuredSearchHandler_columns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	respBytes := []byte(`{}`)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/search/cache", r.URL.Path)
+		w.Write(respBytes)
+	}))
+
+	serverURL, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	hostname := serverURL.Host
+
+	// A plain pattern with selected columns is forwarded rather than served by
+	// the simple path, which returns every column.
+	api := sharedtest.NewMockAppEngineAPI(ctrl)
+	r := httptest.NewRequest("POST", "https://example.com/api/query", bytes.NewBuffer([]byte(`{"run_ids":[1,2],"columns":["chrome"],"query":{"exists":[{"pattern":"flexbox"}]}}`)))
+
+	api.EXPECT().Context().Return(sharedtest.NewTestContext())
+	api.EXPECT().GetServiceHostname("searchcache").Return(hostname)
+	api.EXPECT().GetHTTPClient().Return(server.Client())
+	w := httptest.NewRecorder()
+	structuredSearchHandler{queryHandler{}, api}.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, respBytes, w.Body.Bytes())
+}
+
+func TestStructuredSearchHandler_runAliases(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	respBytes := []byte(`{}`)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		// Aliases are forwarded as the IDs of the runs that they resolve to.
+		var rq RunQuery
+		assert.Nil(t, json.Unmarshal(body, &rq))
+		assert.Equal(t, []int64{1, 2}, rq.RunIDs)
+		assert.Equal(t, 0, len(rq.RunAliases))
+		w.Write(respBytes)
+	}))
+
+	serverURL, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	hostname := serverURL.Host
+
+	chrome := shared.ParseProductSpecUnsafe("chrome[stable]")
+	store := sharedtest.NewMockDatastore(ctrl)
+	trq := sharedtest.NewMockTestRunQuery(ctrl)
+	store.EXPECT().TestRunQuery().Return(trq)
+	trq.EXPECT().LoadTestRuns([]shared.ProductSpec{chrome}, nil, []string{"latest"}, nil, nil, gomock.Any(), nil).Return(shared.TestRunsByProduct{
+		shared.ProductTestRuns{Product: chrome, TestRuns: shared.TestRuns{shared.TestRun{ID: 2}}},
+	}, nil)
+
+	api := sharedtest.NewMockAppEngineAPI(ctrl)
+	r := httptest.NewRequest("POST", "https://example.com/api/query", bytes.NewBuffer([]byte(`{"run_ids":[1],"runs":["chrome[stable]"],"query":{"browser_name":"chrome","status":"PASS"}}`)))
+
+	api.EXPECT().Context().Return(sharedtest.NewTestContext())
+	api.EXPECT().GetServiceHostname("searchcache").Return(hostname)
+	api.EXPECT().GetHTTPClient().Return(server.Client())
+	w := httptest.NewRecorder()
+	structuredSearchHandler{queryHandler{store: store}, api}.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, respBytes, w.Body.Bytes())
+}
+
+func TestStructuredSearchHandler_unknownRunAlias(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := sharedtest.NewMockDatastore(ctrl)
+	trq := sharedtest.NewMockTestRunQuery(ctrl)
+	store.EXPECT().TestRunQuery().Return(trq)
+	trq.EXPECT().LoadTestRuns(gomock.Any(), nil, gomock.Any(), nil, nil, gomock.Any(), nil).Return(shared.TestRunsByProduct{}, nil)
+
+	api := sharedtest.NewMockAppEngineAPI(ctrl)
+	r := httptest.NewRequest("POST", "https://example.com/api/query", bytes.NewBuffer([]byte(`{"runs":["safari[beta]"]}`)))
+	w := httptest.NewRecorder()
+	structuredSearchHandler{queryHandler{store: store}, api}.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestStructuredSearchHandler_failure(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
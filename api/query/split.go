@@ -0,0 +1,131 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import "github.com/web-platform-tests/wpt.fyi/shared"
+
+// SharedBrowserKey is the key under which SplitByBrowser returns the atoms of a
+// query that cannot be attributed to a single browser.
+const SharedBrowserKey = ""
+
+// SplitByBrowser partitions q by the browsers of the runs that its atoms
+// reference, returning, for each such browser, the restriction of q to the
+// atoms that touch only that browser's runs. Atoms that reference no runs
+// (e.g., TestNamePattern) are duplicated into every browser's subquery. Atoms
+// that reference runs of several browsers, or runs not among the given runs,
+// are returned, along with the run-independent atoms, under SharedBrowserKey;
+// a query that references no runs is returned whole under SharedBrowserKey.
+//
+// Only And, Or and Not nodes are partitioned: each keeps the restrictions of
+// its arguments that retain at least one atom, and is dropped when none do.
+// Other nodes (e.g., Count) are treated like atoms. Consequently a subquery
+// over a mixed-browser boolean node is not, in general, equivalent to (nor
+// bounded by) the original node; e.g., the chrome subquery of
+// Not(And(chrome atom, firefox atom)) is Not(chrome atom). Callers that need
+// the original semantics must recombine subquery results according to the
+// structure of q.
+func SplitByBrowser(q ConcreteQuery, runs []shared.TestRun) map[string]ConcreteQuery {
+	browsers := make(map[int64]string, len(runs))
+	for _, run := range runs {
+		browsers[run.ID] = canonicalizeStr(run.BrowserName)
+	}
+
+	keys := make(map[string]bool)
+	forEachSplitAtom(q, func(atom ConcreteQuery) {
+		if browser, ok := atomBrowser(atom, browsers); !ok {
+			keys[SharedBrowserKey] = true
+		} else if browser != "" {
+			keys[browser] = true
+		}
+	})
+	if len(keys) == 0 {
+		keys[SharedBrowserKey] = true
+	}
+
+	split := make(map[string]ConcreteQuery, len(keys))
+	for key := range keys {
+		key := key
+		split[key] = restrictQuery(q, func(atom ConcreteQuery) bool {
+			browser, ok := atomBrowser(atom, browsers)
+			if key == SharedBrowserKey {
+				return !ok || browser == ""
+			}
+			return ok && (browser == "" || browser == key)
+		})
+	}
+	return split
+}
+
+// forEachSplitAtom calls f for each atom of q, as partitioned by SplitByBrowser.
+func forEachSplitAtom(q ConcreteQuery, f func(ConcreteQuery)) {
+	switch v := q.(type) {
+	case And:
+		for _, arg := range v.Args {
+			forEachSplitAtom(arg, f)
+		}
+	case Or:
+		for _, arg := range v.Args {
+			forEachSplitAtom(arg, f)
+		}
+	case Not:
+		forEachSplitAtom(v.Arg, f)
+	default:
+		f(q)
+	}
+}
+
+// atomBrowser is the browser of the runs referenced by atom, or empty when atom
+// references no runs. It is not ok when atom references runs of more than one
+// browser, or runs whose browser is unknown.
+func atomBrowser(atom ConcreteQuery, browsers map[int64]string) (browser string, ok bool) {
+	for _, id := range ReferencedRuns(atom) {
+		b, known := browsers[id]
+		if !known || (browser != "" && b != browser) {
+			return "", false
+		}
+		browser = b
+	}
+	return browser, true
+}
+
+// restrictQuery is the restriction of q to the atoms for which keep is true, or
+// nil when no atom is kept.
+func restrictQuery(q ConcreteQuery, keep func(ConcreteQuery) bool) ConcreteQuery {
+	switch v := q.(type) {
+	case And:
+		args := restrictAll(v.Args, keep)
+		if len(args) == 0 {
+			return nil
+		}
+		return And{Args: args}
+	case Or:
+		args := restrictAll(v.Args, keep)
+		if len(args) == 0 {
+			return nil
+		}
+		return Or{Args: args}
+	case Not:
+		arg := restrictQuery(v.Arg, keep)
+		if arg == nil {
+			return nil
+		}
+		return Not{Arg: arg}
+	default:
+		if keep(q) {
+			return Clone(q)
+		}
+		return nil
+	}
+}
+
+func restrictAll(qs []ConcreteQuery, keep func(ConcreteQuery) bool) []ConcreteQuery {
+	var args []ConcreteQuery
+	for _, q := range qs {
+		if arg := restrictQuery(q, keep); arg != nil {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
@@ -0,0 +1,83 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func splitRuns() []shared.TestRun {
+	runs := []shared.TestRun{
+		shared.TestRun{ID: 1},
+		shared.TestRun{ID: 2},
+		shared.TestRun{ID: 3},
+	}
+	runs[0].BrowserName = "chrome"
+	runs[1].BrowserName = "firefox"
+	runs[2].BrowserName = "chrome"
+	return runs
+}
+
+func TestSplitByBrowser_singleBrowser(t *testing.T) {
+	q := And{
+		Args: []ConcreteQuery{
+			TestNamePattern{Pattern: "/dom/"},
+			RunTestStatusEq{Run: 1, Status: shared.TestStatusFail},
+			Not{Arg: RunSkipped{Run: 3}},
+		},
+	}
+	assert.Equal(t, map[string]ConcreteQuery{"chrome": q}, SplitByBrowser(q, splitRuns()))
+}
+
+func TestSplitByBrowser_multiBrowser(t *testing.T) {
+	pattern := TestNamePattern{Pattern: "/dom/"}
+	chromeFail := RunTestStatusEq{Run: 1, Status: shared.TestStatusFail}
+	firefoxPass := RunTestStatusEq{Run: 2, Status: shared.TestStatusPass}
+	mixed := AnyRunTestStatusEq{Runs: []int64{1, 2}, Status: shared.TestStatusTimeout}
+	q := And{
+		Args: []ConcreteQuery{
+			pattern,
+			Or{Args: []ConcreteQuery{chromeFail, firefoxPass}},
+			Not{Arg: mixed},
+		},
+	}
+	assert.Equal(t, map[string]ConcreteQuery{
+		"chrome": And{Args: []ConcreteQuery{
+			pattern,
+			Or{Args: []ConcreteQuery{chromeFail}},
+		}},
+		"firefox": And{Args: []ConcreteQuery{
+			pattern,
+			Or{Args: []ConcreteQuery{firefoxPass}},
+		}},
+		SharedBrowserKey: And{Args: []ConcreteQuery{
+			pattern,
+			Not{Arg: mixed},
+		}},
+	}, SplitByBrowser(q, splitRuns()))
+}
+
+func TestSplitByBrowser_unknownRun(t *testing.T) {
+	q := Or{
+		Args: []ConcreteQuery{
+			RunTestStatusEq{Run: 2, Status: shared.TestStatusPass},
+			RunTestStatusEq{Run: 4, Status: shared.TestStatusPass},
+		},
+	}
+	assert.Equal(t, map[string]ConcreteQuery{
+		"firefox":        Or{Args: []ConcreteQuery{RunTestStatusEq{Run: 2, Status: shared.TestStatusPass}}},
+		SharedBrowserKey: Or{Args: []ConcreteQuery{RunTestStatusEq{Run: 4, Status: shared.TestStatusPass}}},
+	}, SplitByBrowser(q, splitRuns()))
+}
+
+func TestSplitByBrowser_noRuns(t *testing.T) {
+	q := TestNamePattern{Pattern: "/dom/"}
+	assert.Equal(t, map[string]ConcreteQuery{SharedBrowserKey: q}, SplitByBrowser(q, splitRuns()))
+}
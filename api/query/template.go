@@ -0,0 +1,134 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// templateParamRegex matches a placeholder, "$name", in a string of a Template,
+// or the escape "$$" for a literal "$".
+var templateParamRegex = regexp.MustCompile(`\$(\$|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// Template is the JSON representation of a RunQuery whose string values may
+// contain named placeholders of the form "$name" (e.g.,
+// {"browser_name": "$browser", "status": "FAIL"}), so that a saved query shape
+// can be reused with, e.g., a different browser or pattern. "$$" stands for a
+// literal "$". Property names are not substituted.
+type Template struct {
+	value  interface{}
+	params []string
+}
+
+// ParseTemplate parses the JSON representation of a Template. The JSON must be
+// well-formed, but is only parsed as a RunQuery once instantiated.
+func ParseTemplate(b []byte) (Template, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// Preserve numbers (e.g., run IDs) exactly.
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return Template{}, fmt.Errorf("Invalid query template: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Template{}, errors.New("Invalid query template: data after the top-level value")
+	}
+
+	seen := make(map[string]bool)
+	var params []string
+	eachTemplateString(value, func(s string) {
+		for _, match := range templateParamRegex.FindAllStringSubmatch(s, -1) {
+			if name := match[1]; name != "$" && !seen[name] {
+				seen[name] = true
+				params = append(params, name)
+			}
+		}
+	})
+	sort.Strings(params)
+	return Template{value: value, params: params}, nil
+}
+
+// Params returns the names of the placeholders of the Template, in sorted order.
+func (t Template) Params() []string {
+	return append([]string(nil), t.params...)
+}
+
+// Instantiate substitutes the given values for the placeholders of the
+// Template, and parses the result as a RunQuery, as by json.Unmarshal. Every
+// placeholder must be given a value; values for names that are not placeholders
+// of the Template are ignored.
+func (t Template) Instantiate(params map[string]string) (RunQuery, error) {
+	var missing []string
+	for _, name := range t.params {
+		if _, ok := params[name]; !ok {
+			missing = append(missing, fmt.Sprintf(`"%s"`, name))
+		}
+	}
+	if len(missing) > 0 {
+		return RunQuery{}, fmt.Errorf("Missing query template parameters: %s", strings.Join(missing, ", "))
+	}
+
+	data, err := json.Marshal(instantiateTemplateValue(t.value, params))
+	if err != nil {
+		return RunQuery{}, err
+	}
+	var rq RunQuery
+	if err := json.Unmarshal(data, &rq); err != nil {
+		return RunQuery{}, err
+	}
+	return rq, nil
+}
+
+// eachTemplateString calls f with each string value (but not property name) in
+// a decoded JSON value.
+func eachTemplateString(v interface{}, f func(string)) {
+	switch v := v.(type) {
+	case string:
+		f(v)
+	case []interface{}:
+		for _, item := range v {
+			eachTemplateString(item, f)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			eachTemplateString(item, f)
+		}
+	}
+}
+
+// instantiateTemplateValue returns a copy of a decoded JSON value in which the
+// placeholders of each string value are replaced by their values in params.
+func instantiateTemplateValue(v interface{}, params map[string]string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return templateParamRegex.ReplaceAllStringFunc(v, func(match string) string {
+			if match == "$$" {
+				return "$"
+			}
+			return params[match[1:]]
+		})
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = instantiateTemplateValue(item, params)
+		}
+		return items
+	case map[string]interface{}:
+		items := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			items[key] = instantiateTemplateValue(item, params)
+		}
+		return items
+	default:
+		return v
+	}
+}
@@ -0,0 +1,81 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestTemplate_Instantiate(t *testing.T) {
+	tmpl, err := ParseTemplate([]byte(`{
+		"run_ids": [1, 2],
+		"query": {
+			"and": [
+				{"browser_name": "$browser", "status": "FAIL"},
+				{"pattern": "/$dir/"},
+				{"not": {"pattern": "$$dir"}}
+			]
+		}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"browser", "dir"}, tmpl.Params())
+
+	var expected RunQuery
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"run_ids": [1, 2],
+		"query": {
+			"and": [
+				{"browser_name": "safari", "status": "FAIL"},
+				{"pattern": "/css/"},
+				{"not": {"pattern": "$dir"}}
+			]
+		}
+	}`), &expected))
+	rq, err := tmpl.Instantiate(map[string]string{"browser": "Safari", "dir": "css", "unused": "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, rq)
+	assert.Equal(t, shared.TestStatusFail, rq.AbstractQuery.(AbstractAnd).Args[0].(TestStatusEq).Status)
+
+	// Instantiating with different values reuses the template.
+	rq, err = tmpl.Instantiate(map[string]string{"browser": "chrome", "dir": "dom"})
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2}, rq.RunIDs)
+	assert.Equal(t, TestNamePattern{Pattern: "/dom/"}, rq.AbstractQuery.(AbstractAnd).Args[1])
+}
+
+func TestTemplate_missingParams(t *testing.T) {
+	tmpl, err := ParseTemplate([]byte(`{"run_ids": [1], "query": {"and": [{"browser_name": "$browser", "status": "FAIL"}, {"pattern": "$pattern"}]}}`))
+	assert.Nil(t, err)
+
+	_, err = tmpl.Instantiate(nil)
+	assert.EqualError(t, err, `Missing query template parameters: "browser", "pattern"`)
+	_, err = tmpl.Instantiate(map[string]string{"browser": "chrome"})
+	assert.EqualError(t, err, `Missing query template parameters: "pattern"`)
+
+	// Substituted values are parsed normally.
+	_, err = tmpl.Instantiate(map[string]string{"browser": "netscape", "pattern": "a"})
+	assert.NotNil(t, err)
+}
+
+func TestParseTemplate_invalid(t *testing.T) {
+	_, err := ParseTemplate([]byte(`{"run_ids": [1], "query": {"pattern": "$p"}`))
+	assert.NotNil(t, err)
+	_, err = ParseTemplate([]byte(`{"run_ids": [1]} {}`))
+	assert.NotNil(t, err)
+
+	// A template without placeholders is a plain query.
+	tmpl, err := ParseTemplate([]byte(`{"run_ids": [1], "query": {"pattern": "a"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string(nil), tmpl.Params())
+	rq, err := tmpl.Instantiate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, TestNamePattern{Pattern: "a"}, rq.AbstractQuery)
+}
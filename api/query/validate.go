@@ -0,0 +1,172 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"fmt"
+
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+// Validate checks that an AbstractQuery can be meaningfully bound to the given
+// runs. A conjunction of status atoms for different product specs of the same
+// browser, such as And(chrome[stable]:FAIL, chrome[experimental]:PASS),
+// requires a distinct run matching each spec; binding it when one of the specs
+// matches none of the runs would silently match nothing, so an error is
+// returned instead. Likewise, a RegressedSince requires a run with its baseline
+// label, a ChangedInPR requires both a pr_head and a pr_base run, an AnyChanged
+// requires its baseline run (or a run with its baseline label), a
+// SubtestCountDelta or SameRender requires runs of both of its browsers, and a
+// status atom with a revision requires a run at that revision.
+func Validate(q AbstractQuery, runs []shared.TestRun) error {
+	switch v := q.(type) {
+	case AbstractAnd:
+		if err := validateConjunction(v, runs); err != nil {
+			return err
+		}
+		return validateAll(v.Args, runs)
+	case AbstractOr:
+		return validateAll(v.Args, runs)
+	case AbstractNot:
+		return Validate(v.Arg, runs)
+	case AbstractExists:
+		return validateAll(v.Args, runs)
+	case AbstractAll:
+		return validateAll(v.Args, runs)
+	case AbstractSequential:
+		return validateAll(v.Args, runs)
+	case AbstractCount:
+		return Validate(v.Where, runs)
+	case RegressedSince:
+		if _, ok := baselineRun(v.Label, runs); !ok {
+			return fmt.Errorf(`Query requires a baseline run labeled "%s", but none is available`, v.Label)
+		}
+		return nil
+	case AnyChanged:
+		if v.Run != 0 {
+			for _, run := range runs {
+				if run.ID == v.Run {
+					return nil
+				}
+			}
+			return fmt.Errorf(`Query requires baseline run %d, but it is not available`, v.Run)
+		}
+		if _, ok := baselineRun(v.Label, runs); !ok {
+			return fmt.Errorf(`Query requires a baseline run labeled "%s", but none is available`, v.Label)
+		}
+		return nil
+	case SubtestCountDelta:
+		if _, _, ok := resolveRunPair(runs, v.From, v.To); !ok {
+			return fmt.Errorf(`Query requires a %s run and another %s run, but they are not available`, v.From, v.To)
+		}
+		return nil
+	case SameRender:
+		if _, _, ok := resolveRunPair(runs, v.LeftBrowser, v.RightBrowser); !ok {
+			return fmt.Errorf(`Query requires a %s run and another %s run, but they are not available`, v.LeftBrowser, v.RightBrowser)
+		}
+		return nil
+	case TestStatusEq:
+		return validateRevision(v.Revision, runs)
+	case TestStatusNeq:
+		return validateRevision(v.Revision, runs)
+	case ChangedInPR:
+		for _, label := range []string{shared.PRHeadLabel, shared.PRBaseLabel} {
+			if _, ok := baselineRun(label, runs); !ok {
+				return fmt.Errorf(`Query requires a run labeled "%s", but none is available`, label)
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// validateRevision checks that, when a status atom has a revision, at least one
+// of the runs is at that revision.
+func validateRevision(revision string, runs []shared.TestRun) error {
+	if revision == "" {
+		return nil
+	}
+	for _, run := range runs {
+		if matchesRevision(revision, run) {
+			return nil
+		}
+	}
+	return fmt.Errorf(`Query requires a run at revision "%s", but none is available`, revision)
+}
+
+func validateAll(qs []AbstractQuery, runs []shared.TestRun) error {
+	for _, q := range qs {
+		if err := Validate(q, runs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateConjunction checks that, for each browser constrained by the status
+// atoms of a conjunction (including nested conjunctions) under more than one
+// product spec, each of the specs matches at least one run.
+func validateConjunction(a AbstractAnd, runs []shared.TestRun) error {
+	var browsers []string
+	specs := make(map[string][]shared.ProductSpec)
+	for _, product := range conjunctionProducts(a, nil) {
+		name := product.BrowserName
+		if _, ok := specs[name]; !ok {
+			browsers = append(browsers, name)
+		}
+		dup := false
+		for _, spec := range specs[name] {
+			if spec.String() == product.String() {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			specs[name] = append(specs[name], product)
+		}
+	}
+
+	for _, name := range browsers {
+		if len(specs[name]) < 2 {
+			continue
+		}
+		for _, spec := range specs[name] {
+			if !matchesAnyRun(spec, runs) {
+				return fmt.Errorf(`Query requires a run matching "%s", in addition to other %s runs, but none is available`, spec.String(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// conjunctionProducts appends the (single) products of the status atoms that
+// are arguments of a conjunction, or of conjunctions nested in it, to products.
+func conjunctionProducts(a AbstractAnd, products []shared.ProductSpec) []shared.ProductSpec {
+	for _, arg := range a.Args {
+		switch v := arg.(type) {
+		case TestStatusEq:
+			if v.Product != nil && len(v.Products) == 0 {
+				products = append(products, *v.Product)
+			}
+		case TestStatusNeq:
+			if v.Product != nil && len(v.Products) == 0 {
+				products = append(products, *v.Product)
+			}
+		case AbstractAnd:
+			products = conjunctionProducts(v, products)
+		}
+	}
+	return products
+}
+
+func matchesAnyRun(spec shared.ProductSpec, runs []shared.TestRun) bool {
+	for _, run := range runs {
+		if spec.Matches(run) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func channelRun(id int64, spec string, labels ...string) shared.TestRun {
+	return shared.TestRun{
+		ID:                id,
+		ProductAtRevision: shared.ParseProductSpecUnsafe(spec).ProductAtRevision,
+		Labels:            labels,
+	}
+}
+
+func channelQuery() AbstractQuery {
+	stable := shared.ParseProductSpecUnsafe("chrome[stable]")
+	experimental := shared.ParseProductSpecUnsafe("chrome[experimental]")
+	return AbstractAnd{
+		Args: []AbstractQuery{
+			TestStatusEq{Product: &stable, Status: shared.TestStatusFail},
+			TestStatusEq{Product: &experimental, Status: shared.TestStatusPass},
+		},
+	}
+}
+
+func TestValidate_available(t *testing.T) {
+	runs := []shared.TestRun{
+		channelRun(1, "chrome", "stable"),
+		channelRun(2, "chrome", "experimental"),
+		channelRun(3, "firefox", "stable"),
+	}
+	assert.Nil(t, Validate(channelQuery(), runs))
+
+	// Nested under other nodes.
+	assert.Nil(t, Validate(AbstractNot{Arg: AbstractOr{Args: []AbstractQuery{channelQuery()}}}, runs))
+}
+
+func TestValidate_unavailable(t *testing.T) {
+	runs := []shared.TestRun{
+		channelRun(1, "chrome", "stable"),
+		channelRun(3, "firefox", "experimental"),
+	}
+	assert.NotNil(t, Validate(channelQuery(), runs))
+	assert.NotNil(t, Validate(AbstractCount{Count: 1, Where: AbstractOr{Args: []AbstractQuery{channelQuery()}}}, runs))
+
+	// Specs of a conjunction nested in another are considered together.
+	stable := shared.ParseProductSpecUnsafe("chrome[stable]")
+	experimental := shared.ParseProductSpecUnsafe("chrome[experimental]")
+	nested := AbstractAnd{
+		Args: []AbstractQuery{
+			TestStatusNeq{Product: &stable, Status: shared.TestStatusPass},
+			AbstractAnd{
+				Args: []AbstractQuery{
+					TestNamePattern{Pattern: "css"},
+					TestStatusEq{Product: &experimental, Status: shared.TestStatusPass},
+				},
+			},
+		},
+	}
+	assert.NotNil(t, Validate(nested, runs))
+}
+
+func TestValidate_regressedSince(t *testing.T) {
+	q := AbstractAnd{
+		Args: []AbstractQuery{
+			TestPath{Path: "/css/"},
+			RegressedSince{Label: "last_stable"},
+		},
+	}
+	baseline := channelRun(1, "chrome", "stable", "last_stable")
+	assert.Nil(t, Validate(q, []shared.TestRun{baseline, channelRun(2, "chrome", "stable")}))
+	assert.NotNil(t, Validate(q, []shared.TestRun{channelRun(2, "chrome", "stable")}))
+	assert.NotNil(t, Validate(AbstractNot{Arg: q}, nil))
+}
+
+func TestValidate_changedInPR(t *testing.T) {
+	q := AbstractNot{Arg: ChangedInPR{}}
+	head := channelRun(1, "chrome", shared.PRHeadLabel)
+	base := channelRun(2, "chrome", shared.PRBaseLabel)
+	assert.Nil(t, Validate(q, []shared.TestRun{head, base}))
+	assert.NotNil(t, Validate(q, []shared.TestRun{head}))
+	assert.NotNil(t, Validate(q, []shared.TestRun{base, channelRun(3, "chrome", "stable")}))
+}
+
+func TestValidate_anyChanged(t *testing.T) {
+	runs := []shared.TestRun{channelRun(1, "chrome", "last_stable"), channelRun(2, "chrome")}
+	assert.Nil(t, Validate(AnyChanged{Label: "last_stable"}, runs))
+	assert.NotNil(t, Validate(AnyChanged{Label: "last_stable"}, runs[1:]))
+	assert.Nil(t, Validate(AnyChanged{Run: 2}, runs))
+	assert.NotNil(t, Validate(AbstractNot{Arg: AnyChanged{Run: 3}}, runs))
+}
+
+func TestValidate_subtestDelta(t *testing.T) {
+	runs := []shared.TestRun{channelRun(1, "chrome"), channelRun(2, "firefox")}
+	q := SubtestCountDelta{From: "chrome", To: "firefox", Changed: true}
+	assert.Nil(t, Validate(q, runs))
+	assert.EqualError(t, Validate(AbstractNot{Arg: q}, runs[:1]), `Query requires a chrome run and another firefox run, but they are not available`)
+	assert.NotNil(t, Validate(SubtestCountDelta{From: "chrome", To: "chrome"}, runs))
+}
+
+func TestValidate_sameRender(t *testing.T) {
+	runs := []shared.TestRun{channelRun(1, "chrome"), channelRun(2, "firefox")}
+	q := SameRender{LeftBrowser: "chrome", RightBrowser: "firefox"}
+	assert.Nil(t, Validate(q, runs))
+	assert.EqualError(t, Validate(q, runs[1:]), `Query requires a chrome run and another firefox run, but they are not available`)
+}
+
+func TestValidate_revision(t *testing.T) {
+	run := channelRun(1, "chrome", "stable")
+	run.FullRevisionHash = "abc1234def567890abc1234def567890abc12345"
+	runs := []shared.TestRun{run, channelRun(2, "firefox")}
+
+	assert.Nil(t, Validate(TestStatusEq{Revision: "abc1234", Status: shared.TestStatusFail}, runs))
+	assert.Nil(t, Validate(AbstractNot{Arg: TestStatusNeq{Revision: run.FullRevisionHash, Status: shared.TestStatusPass}}, runs))
+	err := Validate(AbstractAnd{
+		Args: []AbstractQuery{
+			TestStatusEq{Status: shared.TestStatusFail},
+			TestStatusEq{Revision: "1234567", Status: shared.TestStatusPass},
+		},
+	}, runs)
+	assert.EqualError(t, err, `Query requires a run at revision "1234567", but none is available`)
+}
+
+func TestValidate_unconstrained(t *testing.T) {
+	runs := []shared.TestRun{channelRun(1, "chrome", "stable")}
+	stable := shared.ParseProductSpecUnsafe("chrome[stable]")
+	experimental := shared.ParseProductSpecUnsafe("chrome[experimental]")
+	firefox := shared.ParseProductSpecUnsafe("firefox")
+
+	for _, q := range []AbstractQuery{
+		// A single spec per browser need not match a run.
+		AbstractAnd{
+			Args: []AbstractQuery{
+				TestStatusEq{Product: &stable, Status: shared.TestStatusFail},
+				TestStatusEq{Product: &firefox, Status: shared.TestStatusPass},
+			},
+		},
+		// Alternatives do not each require a run.
+		AbstractOr{
+			Args: []AbstractQuery{
+				TestStatusEq{Product: &stable, Status: shared.TestStatusFail},
+				TestStatusEq{Product: &experimental, Status: shared.TestStatusPass},
+			},
+		},
+		// Repeated specs are a single requirement.
+		AbstractAnd{
+			Args: []AbstractQuery{
+				TestStatusEq{Product: &stable, Status: shared.TestStatusFail},
+				TestStatusNeq{Product: &stable, Status: shared.TestStatusPass},
+			},
+		},
+	} {
+		assert.Nil(t, Validate(q, runs))
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// ParseYAML parses the YAML representation of a RunQuery: a YAML document of the
+// same structure as the JSON representation (e.g., `query: {status: PASS}`). The
+// document is converted to JSON, and parsed as by json.Unmarshal of a RunQuery,
+// so that the same atoms are accepted by both. Errors in the YAML itself report
+// the line at which they occur; other errors are prefixed as being in a YAML
+// query.
+func ParseYAML(b []byte) (RunQuery, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return RunQuery{}, err
+	}
+	value, err := yamlToJSONValue(doc, nil)
+	if err != nil {
+		return RunQuery{}, fmt.Errorf("Invalid YAML query: %s", err)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return RunQuery{}, fmt.Errorf("Invalid YAML query: %s", err)
+	}
+	var rq RunQuery
+	if err := json.Unmarshal(data, &rq); err != nil {
+		return RunQuery{}, fmt.Errorf("Invalid YAML query: %s", err)
+	}
+	return rq, nil
+}
+
+// yamlToJSONValue converts a value decoded by yaml.Unmarshal, in which mappings are
+// map[interface{}]interface{}, to one that encoding/json can marshal, in which
+// they are map[string]interface{}. Mappings with keys other than strings are
+// rejected, naming the path of the mapping.
+func yamlToJSONValue(v interface{}, path []string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf(`Non-string key %v in "%s"`, key, yamlPath(path))
+			}
+			converted, err := yamlToJSONValue(value, append(path, name))
+			if err != nil {
+				return nil, err
+			}
+			m[name] = converted
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			converted, err := yamlToJSONValue(value, append(path, fmt.Sprintf("[%d]", i)))
+			if err != nil {
+				return nil, err
+			}
+			s[i] = converted
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+func yamlPath(path []string) string {
+	if len(path) == 0 {
+		return "."
+	}
+	return strings.Replace(strings.Join(path, "."), ".[", "[", -1)
+}
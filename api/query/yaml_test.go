@@ -0,0 +1,59 @@
+// +build small
+
+// Copyright 2018 The WPT Dashboard Project. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/web-platform-tests/wpt.fyi/shared"
+)
+
+func TestParseYAML(t *testing.T) {
+	var expected RunQuery
+	err := json.Unmarshal([]byte(`{
+		"run_ids": [1, 2],
+		"query": {
+			"and": [
+				{"pattern": "/dom/"},
+				{"browser_name": "chrome", "status": "FAIL"},
+				{"not": {"status": "PASS"}}
+			]
+		}
+	}`), &expected)
+	assert.Nil(t, err)
+
+	rq, err := ParseYAML([]byte(`
+run_ids: [1, 2]
+query:
+  and:
+    - pattern: /dom/
+    - browser_name: chrome
+      status: FAIL
+    - not:
+        status: PASS
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, rq)
+	assert.Equal(t, []int64{1, 2}, rq.RunIDs)
+	assert.Equal(t, shared.TestStatusFail, rq.AbstractQuery.(AbstractAnd).Args[1].(TestStatusEq).Status)
+}
+
+func TestParseYAML_errors(t *testing.T) {
+	_, err := ParseYAML([]byte("run_ids: [1, 2]\nquery:\n  status: [PASS\n"))
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "yaml: line "), err.Error())
+
+	_, err = ParseYAML([]byte("run_ids: [1]\nquery:\n  or:\n    - 1: PASS\n"))
+	assert.EqualError(t, err, `Invalid YAML query: Non-string key 1 in "query.or[0]"`)
+
+	_, err = ParseYAML([]byte("run_ids: [1]\nquery:\n  status: NOT_A_STATUS\n"))
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "Invalid YAML query: "), err.Error())
+}